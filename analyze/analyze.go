@@ -0,0 +1,414 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package analyze
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bobguo/mysql-replay/mask"
+	"github.com/bobguo/mysql-replay/parse"
+	"github.com/bobguo/mysql-replay/stats"
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+)
+
+const (
+	ReportName     = "analyze.json"
+	TextReportName = "analyze.txt"
+)
+
+//Interval is the number of queries and errors of the queries started in
+//the interval
+type Interval struct {
+	Time    int64   `json:"time"`
+	Queries uint64  `json:"queries"`
+	Errors  uint64  `json:"errors"`
+	QPS     float64 `json:"qps"`
+}
+
+//Count is the number of connections and queries of a client , user or
+//database
+type Count struct {
+	Name        string `json:"name"`
+	Connections uint64 `json:"connections"`
+	Queries     uint64 `json:"queries"`
+	Errors      uint64 `json:"errors"`
+}
+
+//ErrorCount is the number of errors with the same error code , Message
+//is the first one seen
+type ErrorCount struct {
+	ErrNo    uint16 `json:"errno"`
+	SQLState string `json:"sql-state,omitempty"`
+	Count    uint64 `json:"count"`
+	Message  string `json:"message"`
+}
+
+//Report of the workload , times are in nanoseconds and latencies are in
+//microseconds , read from the request and response packets
+type Report struct {
+	Begin             int64               `json:"begin"`
+	End               int64               `json:"end"`
+	Connections       uint64              `json:"connections"`
+	FailedConnections uint64              `json:"failed-connections"`
+	Commands          map[string]uint64   `json:"commands"`
+	Queries           uint64              `json:"queries"`
+	Errors            uint64              `json:"errors"`
+	ErrorRate         float64             `json:"error-rate"`
+	QPS               float64             `json:"qps"`
+	Latency           stats.Histogram     `json:"latency"`
+	Timeline          []Interval          `json:"timeline"`
+	Digests           []stats.DigestStats `json:"digests"`
+	Clients           []Count             `json:"clients"`
+	Users             []Count             `json:"users"`
+	DBs               []Count             `json:"dbs"`
+	ErrorCodes        []ErrorCount        `json:"error-codes"`
+}
+
+//Analyzer aggregates the events of all connections , nothing is sent to
+//a database
+type Analyzer struct {
+	interval time.Duration
+
+	mu                sync.Mutex
+	begin             int64
+	end               int64
+	connections       uint64
+	failedConnections uint64
+	commands          map[string]uint64
+	queries           uint64
+	errors            uint64
+	latency           stats.Histogram
+	timeline          map[int64]*Interval
+	clients           map[string]*Count
+	users             map[string]*Count
+	dbs               map[string]*Count
+	errorCodes        map[uint16]*ErrorCount
+	digests           *stats.Digests
+}
+
+//New returns an analyzer counting QPS per interval
+func New(interval time.Duration) *Analyzer {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return &Analyzer{
+		interval:   interval,
+		commands:   make(map[string]uint64),
+		timeline:   make(map[int64]*Interval),
+		clients:    make(map[string]*Count),
+		users:      make(map[string]*Count),
+		dbs:        make(map[string]*Count),
+		errorCodes: make(map[uint16]*ErrorCount),
+		digests:    stats.NewDigests(),
+	}
+}
+
+type digest struct {
+	normalized string
+	digest     string
+}
+
+//Handler returns the event handler of a connection
+func (a *Analyzer) Handler(conn stream.ConnID) stream.MySQLEventHandler {
+	client := conn[0].Src().String()
+	a.mu.Lock()
+	a.connections++
+	getCount(a.clients, client).Connections++
+	a.mu.Unlock()
+	return &connHandler{a: a, client: client, stmts: make(map[string]digest)}
+}
+
+type connHandler struct {
+	a      *Analyzer
+	client string
+	user   string
+	db     string
+	stmts  map[string]digest
+}
+
+func (h *connHandler) OnEvent(e stream.MySQLEvent) {
+	var d digest
+	switch e.Type {
+	case util.EventHandshake:
+		h.user, h.db = e.Username, e.DB
+		h.a.addHandshake(h, &e)
+	case util.EventQuery:
+		if db, ok := parse.UseDB(e.Query); ok {
+			h.db = db
+		}
+		d.normalized, d.digest = parse.Digest(e.Query)
+	case util.EventStmtPrepare:
+		d.normalized, d.digest = parse.Digest(e.Query)
+		h.stmts[e.StmtID] = d
+	case util.EventStmtExecute:
+		d = h.stmts[e.StmtID]
+	case util.EventStmtClose:
+		delete(h.stmts, e.StmtID)
+	}
+	h.a.addEvent(h, &e, d)
+}
+
+func (h *connHandler) OnClose() {}
+
+func getCount(m map[string]*Count, name string) *Count {
+	c, ok := m[name]
+	if !ok {
+		c = &Count{Name: name}
+		m[name] = c
+	}
+	return c
+}
+
+func (a *Analyzer) addHandshake(h *connHandler, e *stream.MySQLEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if e.Pr != nil && e.Pr.HasError() {
+		a.failedConnections++
+		a.addError(e.Pr)
+	}
+	if len(h.user) > 0 {
+		getCount(a.users, h.user).Connections++
+	}
+	if len(h.db) > 0 {
+		getCount(a.dbs, h.db).Connections++
+	}
+}
+
+//addError is called with the lock held
+func (a *Analyzer) addError(pr *stream.PacketRes) {
+	ec, ok := a.errorCodes[pr.GetErrNo()]
+	if !ok {
+		ec = &ErrorCount{ErrNo: pr.GetErrNo(), SQLState: pr.GetSQLState(), Message: mask.Default.Text(pr.GetErrDesc())}
+		a.errorCodes[pr.GetErrNo()] = ec
+	}
+	ec.Count++
+}
+
+func (a *Analyzer) addEvent(h *connHandler, e *stream.MySQLEvent, d digest) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if e.Time > 0 && (a.begin == 0 || e.Time < a.begin) {
+		a.begin = e.Time
+	}
+	if e.Time > a.end {
+		a.end = e.Time
+	}
+	a.commands[e.Type.String()]++
+	if e.Type != util.EventQuery && e.Type != util.EventStmtExecute {
+		return
+	}
+
+	failed := e.Pr != nil && e.Pr.HasError()
+	s := stats.DigestSample{Digest: d.digest, Normalized: d.normalized, PrError: failed}
+	if e.Pr != nil {
+		s.PrBegin = e.Pr.GetSqlBeginTime()
+		s.PrEnd = e.Pr.GetSqlEndTime()
+		s.PrRows = uint64(len(e.Pr.GetColumnVal()))
+		if s.PrBegin > 0 && s.PrEnd >= s.PrBegin {
+			a.latency.Add((s.PrEnd - s.PrBegin) / 1000)
+		}
+	}
+	a.digests.Add(s)
+
+	a.queries++
+	ts := e.Time - e.Time%int64(a.interval)
+	i, ok := a.timeline[ts]
+	if !ok {
+		i = &Interval{Time: ts}
+		a.timeline[ts] = i
+	}
+	i.Queries++
+	counts := []*Count{getCount(a.clients, h.client)}
+	if len(h.user) > 0 {
+		counts = append(counts, getCount(a.users, h.user))
+	}
+	if len(h.db) > 0 {
+		counts = append(counts, getCount(a.dbs, h.db))
+	}
+	for _, c := range counts {
+		c.Queries++
+	}
+	if !failed {
+		return
+	}
+	a.errors++
+	i.Errors++
+	for _, c := range counts {
+		c.Errors++
+	}
+	a.addError(e.Pr)
+}
+
+func sortCounts(m map[string]*Count) []Count {
+	res := make([]Count, 0, len(m))
+	for _, c := range m {
+		res = append(res, *c)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Queries != res[j].Queries {
+			return res[i].Queries > res[j].Queries
+		}
+		if res[i].Connections != res[j].Connections {
+			return res[i].Connections > res[j].Connections
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+//Report returns the report of the events analyzed , at most top digests
+//are reported and all of them if top is 0
+func (a *Analyzer) Report(top int) *Report {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r := &Report{
+		Begin:             a.begin,
+		End:               a.end,
+		Connections:       a.connections,
+		FailedConnections: a.failedConnections,
+		Commands:          make(map[string]uint64, len(a.commands)),
+		Queries:           a.queries,
+		Errors:            a.errors,
+		Latency:           a.latency,
+		Timeline:          []Interval{},
+		Clients:           sortCounts(a.clients),
+		Users:             sortCounts(a.users),
+		DBs:               sortCounts(a.dbs),
+		ErrorCodes:        []ErrorCount{},
+	}
+	for k, v := range a.commands {
+		r.Commands[k] = v
+	}
+	if r.Queries > 0 {
+		r.ErrorRate = float64(r.Errors) / float64(r.Queries)
+	}
+	if d := r.End - r.Begin; d > 0 {
+		r.QPS = float64(r.Queries) / (float64(d) / float64(time.Second))
+	}
+
+	//intervals without queries are reported too
+	if len(a.timeline) > 0 {
+		first, last := int64(-1), int64(0)
+		for ts := range a.timeline {
+			if first < 0 || ts < first {
+				first = ts
+			}
+			if ts > last {
+				last = ts
+			}
+		}
+		seconds := float64(a.interval) / float64(time.Second)
+		for ts := first; ts <= last; ts += int64(a.interval) {
+			i := Interval{Time: ts}
+			if x, ok := a.timeline[ts]; ok {
+				i = *x
+			}
+			i.QPS = float64(i.Queries) / seconds
+			r.Timeline = append(r.Timeline, i)
+		}
+	}
+
+	r.Digests = a.digests.Dump()
+	if top > 0 && len(r.Digests) > top {
+		r.Digests = r.Digests[:top]
+	}
+
+	for _, ec := range a.errorCodes {
+		r.ErrorCodes = append(r.ErrorCodes, *ec)
+	}
+	sort.Slice(r.ErrorCodes, func(i, j int) bool {
+		if r.ErrorCodes[i].Count != r.ErrorCodes[j].Count {
+			return r.ErrorCodes[i].Count > r.ErrorCodes[j].Count
+		}
+		return r.ErrorCodes[i].ErrNo < r.ErrorCodes[j].ErrNo
+	})
+	return r
+}
+
+func formatTime(ts int64) string {
+	return time.Unix(0, ts).UTC().Format("2006-01-02T15:04:05.000000Z")
+}
+
+func avg(h *stats.Histogram) uint64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / h.Count
+}
+
+//WriteText writes the report for humans , like pt-query-digest
+func (r *Report) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Overall: %d queries , %d connections (%d failed) , %.2f QPS\n",
+		r.Queries, r.Connections, r.FailedConnections, r.QPS)
+	if r.Begin > 0 {
+		fmt.Fprintf(&b, "# Time range: %s to %s (%.3fs)\n", formatTime(r.Begin), formatTime(r.End),
+			float64(r.End-r.Begin)/float64(time.Second))
+	}
+	fmt.Fprintf(&b, "# Errors: %d (%.2f%%)\n", r.Errors, r.ErrorRate*100)
+	fmt.Fprintf(&b, "# Latency (us): min %d , avg %d , p50 %d , p95 %d , p99 %d , max %d\n",
+		r.Latency.Min, avg(&r.Latency), r.Latency.Percentile(0.5), r.Latency.Percentile(0.95),
+		r.Latency.Percentile(0.99), r.Latency.Max)
+
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "\n# Commands\n")
+	commands := make([]string, 0, len(r.Commands))
+	for k := range r.Commands {
+		commands = append(commands, k)
+	}
+	sort.Strings(commands)
+	for _, k := range commands {
+		fmt.Fprintf(tw, "#  %s\t%d\n", k, r.Commands[k])
+	}
+
+	fmt.Fprintf(tw, "\n# Top digests\n# Rank\tCount\tAvg(us)\tP95(us)\tErrors\tRows\tQuery\n")
+	for i, d := range r.Digests {
+		fmt.Fprintf(tw, "# %d\t%d\t%d\t%d\t%d\t%d\t%s\n", i+1, d.Count, avg(&d.PrLatency),
+			d.PrLatency.Percentile(0.95), d.PrErrors, d.PrRows, d.Query)
+	}
+
+	for _, g := range []struct {
+		name   string
+		counts []Count
+	}{{"Clients", r.Clients}, {"Users", r.Users}, {"Databases", r.DBs}} {
+		fmt.Fprintf(tw, "\n# %s\n# Name\tConnections\tQueries\tErrors\n", g.name)
+		for _, c := range g.counts {
+			fmt.Fprintf(tw, "# %s\t%d\t%d\t%d\n", c.Name, c.Connections, c.Queries, c.Errors)
+		}
+	}
+
+	fmt.Fprintf(tw, "\n# Error codes\n# Errno\tSQLState\tCount\tMessage\n")
+	for _, ec := range r.ErrorCodes {
+		fmt.Fprintf(tw, "# %d\t%s\t%d\t%s\n", ec.ErrNo, ec.SQLState, ec.Count, ec.Message)
+	}
+
+	fmt.Fprintf(tw, "\n# QPS over time\n# Time\tQueries\tErrors\tQPS\n")
+	for _, i := range r.Timeline {
+		fmt.Fprintf(tw, "# %s\t%d\t%d\t%.2f\n", formatTime(i.Time), i.Queries, i.Errors, i.QPS)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,123 @@
+package analyze
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/stretchr/testify/assert"
+)
+
+//2021-11-29T12:49:10Z
+const ts = int64(1638190150) * 1000000000
+
+func result(begin, us int64, rows int) *stream.PacketRes {
+	r := &stream.PacketResRecord{BeginTime: uint64(begin), EndTime: uint64(begin + us*1000)}
+	for i := 0; i < rows; i++ {
+		r.Rows = append(r.Rows, []stream.Value{{Kind: stream.KindInt, Data: "1"}})
+	}
+	return stream.NewPacketResFromRecord(r)
+}
+
+func failed(errNo uint16, desc string) *stream.PacketRes {
+	return stream.NewPacketResFromRecord(&stream.PacketResRecord{ErrNo: errNo, ErrDesc: desc, SQLState: "42S02"})
+}
+
+func analyze(t *testing.T) *Report {
+	a := New(time.Second)
+	c1, err := stream.ParseConnID("10.0.0.1:1000", "10.0.0.9:3306")
+	assert.Nil(t, err)
+	c2, err := stream.ParseConnID("10.0.0.2:1000", "10.0.0.9:3306")
+	assert.Nil(t, err)
+
+	h := a.Handler(c1)
+	for _, e := range []stream.MySQLEvent{
+		{Time: ts, Type: util.EventHandshake, DB: "test", Username: "app"},
+		{Time: ts + 1000, Type: util.EventQuery, Query: "select * from t where id = 1", Pr: result(ts+1000, 100, 1)},
+		{Time: ts + 2000, Type: util.EventQuery, Query: "select * from t where id = 2", Pr: result(ts+2000, 300, 1)},
+		{Time: ts + 3000, Type: util.EventStmtPrepare, StmtID: "1", Query: "select * from t where id = ?"},
+		{Time: ts + 2*int64(time.Second), Type: util.EventStmtExecute, StmtID: "1", Params: []interface{}{int64(3)},
+			Pr: result(ts+2*int64(time.Second), 200, 2)},
+		{Time: ts + 2*int64(time.Second) + 1000, Type: util.EventQuery, Query: "use db2"},
+		{Time: ts + 2*int64(time.Second) + 2000, Type: util.EventQuery, Query: "select * from x", Pr: failed(1146, "Table 'db2.x' doesn't exist")},
+		{Time: ts + 2*int64(time.Second) + 3000, Type: util.EventQuit},
+	} {
+		h.OnEvent(e)
+	}
+	h.OnClose()
+
+	h = a.Handler(c2)
+	h.OnEvent(stream.MySQLEvent{Time: ts + 500, Type: util.EventHandshake, Username: "bad", Pr: failed(1045, "Access denied")})
+	h.OnClose()
+	return a.Report(2)
+}
+
+func TestAnalyzer_Report(t *testing.T) {
+	r := analyze(t)
+	assert.Equal(t, ts, r.Begin)
+	assert.Equal(t, ts+2*int64(time.Second)+3000, r.End)
+	assert.Equal(t, uint64(2), r.Connections)
+	assert.Equal(t, uint64(1), r.FailedConnections)
+	assert.Equal(t, map[string]uint64{"handshake": 2, "query": 4, "stmt_prepare": 1, "stmt_execute": 1, "quit": 1}, r.Commands)
+	assert.Equal(t, uint64(5), r.Queries)
+	assert.Equal(t, uint64(1), r.Errors)
+	assert.Equal(t, 0.2, r.ErrorRate)
+	assert.Equal(t, uint64(3), r.Latency.Count)
+	assert.Equal(t, uint64(100), r.Latency.Min)
+	assert.Equal(t, uint64(300), r.Latency.Max)
+
+	assert.Equal(t, []Interval{
+		{Time: ts, Queries: 2, QPS: 2},
+		{Time: ts + int64(time.Second)},
+		{Time: ts + 2*int64(time.Second), Queries: 3, Errors: 1, QPS: 3},
+	}, r.Timeline)
+
+	//the query and the prepared statement have the same digest
+	assert.Equal(t, 2, len(r.Digests))
+	assert.Equal(t, uint64(3), r.Digests[0].Count)
+	assert.Equal(t, "select * from `t` where `id` = ?", r.Digests[0].Query)
+	assert.Equal(t, uint64(4), r.Digests[0].PrRows)
+	assert.Equal(t, uint64(1), r.Digests[1].PrErrors)
+
+	assert.Equal(t, []Count{
+		{Name: "10.0.0.1", Connections: 1, Queries: 5, Errors: 1},
+		{Name: "10.0.0.2", Connections: 1},
+	}, r.Clients)
+	assert.Equal(t, []Count{{Name: "app", Connections: 1, Queries: 5, Errors: 1}, {Name: "bad", Connections: 1}}, r.Users)
+	assert.Equal(t, []Count{{Name: "test", Connections: 1, Queries: 3}, {Name: "db2", Queries: 2, Errors: 1}}, r.DBs)
+	assert.Equal(t, []ErrorCount{
+		{ErrNo: 1045, SQLState: "42S02", Count: 1, Message: "Access denied"},
+		{ErrNo: 1146, SQLState: "42S02", Count: 1, Message: "Table 'db2.x' doesn't exist"},
+	}, r.ErrorCodes)
+}
+
+func TestReport_Write(t *testing.T) {
+	r := analyze(t)
+	b, err := json.Marshal(r)
+	assert.Nil(t, err)
+	var m map[string]interface{}
+	assert.Nil(t, json.Unmarshal(b, &m))
+	assert.Equal(t, float64(5), m["queries"])
+	assert.Equal(t, float64(300), m["latency"].(map[string]interface{})["p99"])
+
+	var sb strings.Builder
+	assert.Nil(t, r.WriteText(&sb))
+	text := sb.String()
+	assert.True(t, strings.HasPrefix(text, "# Overall: 5 queries , 2 connections (1 failed) , 2.50 QPS\n"), text)
+	assert.True(t, strings.Contains(text, "# Time range: 2021-11-29T12:49:10.000000Z to 2021-11-29T12:49:12.000003Z (2.000s)\n"), text)
+	assert.True(t, strings.Contains(text, "# Errors: 1 (20.00%)\n"), text)
+	assert.True(t, strings.Contains(text, "select * from `t` where `id` = ?\n"), text)
+	assert.True(t, strings.Contains(text, "# 1146   42S02     1      Table 'db2.x' doesn't exist\n"), text)
+}
+
+func TestAnalyzer_Empty(t *testing.T) {
+	r := New(0).Report(0)
+	assert.Equal(t, uint64(0), r.Queries)
+	assert.Equal(t, []Interval{}, r.Timeline)
+	var sb strings.Builder
+	assert.Nil(t, r.WriteText(&sb))
+	assert.True(t, strings.HasPrefix(sb.String(), "# Overall: 0 queries"))
+}
@@ -0,0 +1,144 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bobguo/mysql-replay/analyze"
+	"github.com/bobguo/mysql-replay/eventlog"
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func NewTextAnalyzeCommand() *cobra.Command {
+	//Analyze the workload of pcap files , no sql is replayed
+	var (
+		options = stream.FactoryOptions{Synchronized: true}
+		cfg     = &util.Config{RunType: util.RunText}
+	)
+	cmd := &cobra.Command{
+		Use:   "analyze",
+		Short: "Analyze the workload of pcap files",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.Log = zap.L().Named("text-analyze")
+			cfg.Log.Info("process begin run at " + time.Now().String())
+			if len(args) == 0 {
+				return cmd.Help()
+			}
+
+			err := cfg.CheckParamValid()
+			if err != nil {
+				cfg.Log.Error("parse param error , " + err.Error())
+				return nil
+			}
+			if err = loadMask(cfg); err != nil {
+				return err
+			}
+			options.Protocol = cfg.Protocol
+
+			a := analyze.New(cfg.AnalyzeInterval)
+			err = handlePcapFiles(context.Background(), args, cfg, options, a.Handler)
+			if err != nil {
+				return err
+			}
+			if err = writeReport(cfg, a.Report(cfg.AnalyzeTop)); err != nil {
+				return err
+			}
+			cfg.Log.Info("process end run at " + time.Now().String())
+			return nil
+		},
+	}
+
+	cfg.ParseFlagForAnalyzeText(cmd.Flags())
+	cmd.Flags().BoolVar(&options.ForceStart, "force-start", false, "accept streams even if no SYN have been seen")
+	return cmd
+}
+
+func NewLogAnalyzeCommand() *cobra.Command {
+	//Analyze the workload of event logs written by capture
+	cfg := &util.Config{RunType: util.RunLog}
+	cmd := &cobra.Command{
+		Use:   "analyze [event log or dir ...]",
+		Short: "Analyze the workload of event logs",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.Log = zap.L().Named("log-analyze")
+			cfg.Log.Info("process begin run at " + time.Now().String())
+			if len(args) == 0 {
+				return cmd.Help()
+			}
+
+			err := cfg.CheckParamValid()
+			if err != nil {
+				cfg.Log.Error("parse param error , " + err.Error())
+				return nil
+			}
+			if err = loadMask(cfg); err != nil {
+				return err
+			}
+
+			srcs, closeAll, err := openEventLogs(args, cfg.Protocol, cfg.StartTime)
+			if err != nil {
+				return err
+			}
+			defer closeAll()
+
+			a := analyze.New(cfg.AnalyzeInterval)
+			num := eventlog.Dispatch(srcs, a.Handler, cfg.Log)
+			cfg.Log.Info(fmt.Sprintf("analyze %d events from %d event logs", num, len(srcs)))
+			if err = writeReport(cfg, a.Report(cfg.AnalyzeTop)); err != nil {
+				return err
+			}
+			cfg.Log.Info("process end run at " + time.Now().String())
+			return nil
+		},
+	}
+
+	cfg.ParseFlagForAnalyzeLog(cmd.Flags())
+	return cmd
+}
+
+//writeReport writes the report to <output>/analyze.json and
+//<output>/analyze.txt , the text report is printed too
+func writeReport(cfg *util.Config, r *analyze.Report) error {
+	js, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(cfg.OutputDir, analyze.ReportName)
+	if err = ioutil.WriteFile(name, js, 0644); err != nil {
+		return err
+	}
+	var sb strings.Builder
+	if err = r.WriteText(&sb); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(filepath.Join(cfg.OutputDir, analyze.TextReportName), []byte(sb.String()), 0644); err != nil {
+		return err
+	}
+	fmt.Fprint(os.Stdout, sb.String())
+	cfg.Log.Info("write report to " + name)
+	return nil
+}
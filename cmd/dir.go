@@ -49,6 +49,7 @@ func NewDirTextCommand() *cobra.Command {
 		Short: "dir Text format utilities",
 	}
 	cmd.AddCommand(NewDirTextDumpReplayCommand())
+	cmd.AddCommand(NewDirTextCaptureCommand())
 	return cmd
 }
 
@@ -63,8 +64,6 @@ func NewDirTextDumpReplayCommand() *cobra.Command {
 		Short: "Replay dir packet",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			var err error
-			ts := time.Now()
-			var exit bool
 			cfg.PreFileSize = cfg.PreFileSize * 1024 * 1024
 			cfg.Log = zap.L().Named("dir-text-replay")
 			cfg.Log.Info("process begin run at " + time.Now().String())
@@ -73,88 +72,154 @@ func NewDirTextDumpReplayCommand() *cobra.Command {
 				cfg.Log.Error("check param fail , " + err.Error())
 				return err
 			}
-
-			mu := new(sync.Mutex)
-			files := make(map[string]int, 0)
-			err = util.GetDataFile(cfg.DataDir, files, mu)
-			if err != nil {
-				cfg.Log.Error("get file from dataDir fail , " + err.Error())
+			if err = loadMask(cfg); err != nil {
+				return err
+			}
+			if err = loadRewrite(cfg); err != nil {
 				return err
 			}
+			options.Protocol = cfg.Protocol
 
 			go printTime()
-
-			ctx, cancel := context.WithCancel(context.Background())
-			go util.WatchDirCreateFile(ctx, cfg.DataDir, files, mu, cfg.Log)
 			go AddPortListenAndServer(cfg.ListenPort, cfg.OutputDir, cfg.StoreDir)
 
-			ticker := time.NewTicker(3 * time.Second)
-			defer ticker.Stop()
-
-			factory := stream.NewFactoryFromEventHandler(func(conn stream.ConnID) stream.MySQLEventHandler {
+			err = handlePcapDir(cfg, options, false, func(conn stream.ConnID) stream.MySQLEventHandler {
 				logger := conn.Logger("replay")
-				return sqlreplay.NewReplayEventHandler(conn, logger, cfg)
-			}, options)
-
-			pool := reassembly.NewStreamPool(factory)
-			assembler := reassembly.NewAssembler(pool)
-			lastFlushTime := time.Time{}
-
-			fileName := ""
-			errChan := make(chan error, 1)
-			sigs := make(chan os.Signal, 1)
-			exitChan := make(chan bool, 1)
-			signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-			go HandleSigs(sigs, exitChan)
-
-			handleFileNum := int32(0)
-
-			for {
-				if atomic.LoadInt32(&handleFileNum) == 0 {
-
-					mu.Lock()
-					fileName = getFirstFileName(files)
-					if len(fileName) > 0 {
-						go HandlePcapFileByDir(ctx, cfg.DataDir+"/"+fileName, cfg,
-							assembler, &lastFlushTime, errChan, &handleFileNum)
-						atomic.AddInt32(&handleFileNum, 1)
-					}
-					mu.Unlock()
-				}
-
-				select {
-				case err = <-errChan:
-					if err != nil {
-						cfg.Log.Error(fmt.Sprintf("handle file %s fail ,%v",
-							cfg.DataDir+"/"+fileName, err))
-						cancel()
-						goto LOOP
-					}
-				case <-ticker.C:
-					if time.Since(ts).Seconds() > float64(cfg.RunTime*60) {
-						cancel()
-						goto LOOP
-					}
-				case exit = <-exitChan:
-					cancel()
-					goto LOOP
-				}
+				return sqlreplay.NewEventHandler(conn, logger, cfg)
+			})
+			aggregateJournal(cfg)
+			writeDigests(cfg)
+			cfg.Log.Info("process end run at " + time.Now().String())
+			return err
+		},
+	}
+
+	cfg.ParseFlagForRunDir(cmd.Flags())
+	cmd.Flags().BoolVar(&options.ForceStart, "force-start", false, "accept streams even if no SYN have been seen")
+	return cmd
+}
+
+func NewDirTextCaptureCommand() *cobra.Command {
+	//Parse packets of dir once and write the events of every connection
+	//to event logs
+	var (
+		options = stream.FactoryOptions{Synchronized: true}
+		cfg     = &util.Config{RunType: util.RunDir}
+	)
+	cmd := &cobra.Command{
+		Use:   "capture",
+		Short: "Write events parsed from dir packet to event logs",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.Log = zap.L().Named("dir-text-capture")
+			cfg.Log.Info("process begin run at " + time.Now().String())
+			err := cfg.CheckParamValid()
+			if err != nil {
+				cfg.Log.Error("check param fail , " + err.Error())
+				return err
 			}
-		LOOP:
-			//sleep 200ms and wait for other go routine to exit
-			time.Sleep(time.Millisecond * 200)
-			cfg.Log.Info("read packet end ,begin close all goroutine")
-			if !exit {
-				i := assembler.FlushAll()
-				cfg.Log.Info(fmt.Sprintf("read packet end ,end close all goroutine , %v groutine", i))
+			options.Protocol = cfg.Protocol
+
+			go printTime()
+
+			newHandler, closeHandler, err := newCaptureHandler(cfg)
+			if err != nil {
+				cfg.Log.Error("create event writer fail , " + err.Error())
+				return err
 			}
-			cfg.Log.Info(stats.DumpStatic())
+			err = handlePcapDir(cfg, options, true, newHandler)
+			closeHandler()
 			cfg.Log.Info("process end run at " + time.Now().String())
 			return err
 		},
 	}
 
-	cfg.ParseFlagForRunDir(cmd.Flags())
+	cfg.ParseFlagForCaptureDir(cmd.Flags())
 	cmd.Flags().BoolVar(&options.ForceStart, "force-start", false, "accept streams even if no SYN have been seen")
 	return cmd
 }
+
+//handlePcapDir reads pcap files of data dir in name order , including
+//files created later , until runtime is out or the process is signaled ,
+//the connections are closed on exit signal only if flushOnExit is set
+func handlePcapDir(cfg *util.Config, options stream.FactoryOptions, flushOnExit bool,
+	newHandler func(conn stream.ConnID) stream.MySQLEventHandler) error {
+	var err error
+	var exit bool
+	ts := time.Now()
+
+	mu := new(sync.Mutex)
+	files := make(map[string]int, 0)
+	err = util.GetDataFile(cfg.DataDir, files, mu)
+	if err != nil {
+		cfg.Log.Error("get file from dataDir fail , " + err.Error())
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go util.WatchDirCreateFile(ctx, cfg.DataDir, files, mu, cfg.Log)
+
+	ticker := time.NewTicker(3 * time.Second)
+	defer ticker.Stop()
+
+	factory := stream.NewFactoryFromEventHandler(newHandler, options)
+
+	pool := reassembly.NewStreamPool(factory)
+	assembler := reassembly.NewAssembler(pool)
+	lastFlushTime := time.Time{}
+
+	fileName := ""
+	errChan := make(chan error, 1)
+	sigs := make(chan os.Signal, 1)
+	exitChan := make(chan bool, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go HandleSigs(sigs, exitChan)
+
+	handleFileNum := int32(0)
+
+	for {
+		if atomic.LoadInt32(&handleFileNum) == 0 {
+
+			mu.Lock()
+			fileName = getFirstFileName(files)
+			if len(fileName) > 0 {
+				go HandlePcapFileByDir(ctx, cfg.DataDir+"/"+fileName, cfg,
+					assembler, &lastFlushTime, errChan, &handleFileNum)
+				atomic.AddInt32(&handleFileNum, 1)
+			}
+			mu.Unlock()
+		}
+
+		select {
+		case err = <-errChan:
+			if err != nil {
+				cfg.Log.Error(fmt.Sprintf("handle file %s fail ,%v",
+					cfg.DataDir+"/"+fileName, err))
+				cancel()
+				goto LOOP
+			}
+		case <-ticker.C:
+			if time.Since(ts).Seconds() > float64(cfg.RunTime*60) {
+				cancel()
+				goto LOOP
+			}
+		case exit = <-exitChan:
+			cancel()
+			goto LOOP
+		}
+	}
+LOOP:
+	//sleep 200ms and wait for other go routine to exit
+	time.Sleep(time.Millisecond * 200)
+	cfg.Log.Info("read packet end ,begin close all goroutine")
+	//capture closes the connections on exit signal too , so that the
+	//handlers flush what they buffered , replay exits at once
+	if !exit || flushOnExit {
+		i := assembler.FlushAll()
+		cfg.Log.Info(fmt.Sprintf("read packet end ,end close all goroutine , %v groutine", i))
+	}
+	if exit {
+		cfg.Log.Info("exit by signal")
+	}
+	cfg.Log.Info(stats.DumpStatic())
+	return err
+}
@@ -0,0 +1,128 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bobguo/mysql-replay/eventlog"
+	"github.com/bobguo/mysql-replay/export"
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/pingcap/errors"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func NewTextExportCommand() *cobra.Command {
+	//Convert pcap files to SQL scripts , general log or slow log
+	var (
+		options = stream.FactoryOptions{Synchronized: true}
+		cfg     = &util.Config{RunType: util.RunText}
+	)
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export pcap files as SQL script , general log or slow log",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.Log = zap.L().Named("text-export")
+			cfg.Log.Info("process begin run at " + time.Now().String())
+			if len(args) == 0 {
+				return cmd.Help()
+			}
+
+			err := cfg.CheckParamValid()
+			if err != nil {
+				cfg.Log.Error("parse param error , " + err.Error())
+				return nil
+			}
+			if err = loadMask(cfg); err != nil {
+				return err
+			}
+			if cfg.Protocol == util.ProtocolPostgres {
+				return errors.New("export supports mysql and mysqlx only")
+			}
+			options.Protocol = cfg.Protocol
+
+			x, err := export.NewExporter(cfg.ExportFormat, cfg.OutputDir, cfg.SrcPort, cfg.Log)
+			if err != nil {
+				return err
+			}
+			err = handlePcapFiles(context.Background(), args, cfg, options, x.Handler)
+			if cerr := x.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+			cfg.Log.Info("process end run at " + time.Now().String())
+			return nil
+		},
+	}
+
+	cfg.ParseFlagForExportText(cmd.Flags())
+	cmd.Flags().BoolVar(&options.ForceStart, "force-start", false, "accept streams even if no SYN have been seen")
+	return cmd
+}
+
+func NewLogExportCommand() *cobra.Command {
+	//Convert event logs written by capture to SQL scripts , general log
+	//or slow log
+	cfg := &util.Config{RunType: util.RunLog}
+	cmd := &cobra.Command{
+		Use:   "export [event log or dir ...]",
+		Short: "Export event logs as SQL script , general log or slow log",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.Log = zap.L().Named("log-export")
+			cfg.Log.Info("process begin run at " + time.Now().String())
+			if len(args) == 0 {
+				return cmd.Help()
+			}
+
+			err := cfg.CheckParamValid()
+			if err != nil {
+				cfg.Log.Error("parse param error , " + err.Error())
+				return nil
+			}
+			if err = loadMask(cfg); err != nil {
+				return err
+			}
+
+			//events of postgres can not be written as MySQL statements
+			srcs, closeAll, err := openEventLogs(args, util.ProtocolMySQL, cfg.StartTime)
+			if err != nil {
+				return err
+			}
+			defer closeAll()
+
+			x, err := export.NewExporter(cfg.ExportFormat, cfg.OutputDir, 0, cfg.Log)
+			if err != nil {
+				return err
+			}
+			num := eventlog.Dispatch(srcs, x.Handler, cfg.Log)
+			if err = x.Close(); err != nil {
+				return err
+			}
+			cfg.Log.Info(fmt.Sprintf("export %d events from %d event logs", num, len(srcs)))
+			cfg.Log.Info("process end run at " + time.Now().String())
+			return nil
+		},
+	}
+
+	cfg.ParseFlagForExportLog(cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,100 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bobguo/mysql-replay/inspect"
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func NewTextInspectCommand() *cobra.Command {
+	//Print the conversations decoded from pcap files , to check what
+	//the FSM decoded when replay results look wrong
+	var (
+		options = stream.FactoryOptions{Synchronized: true}
+		cfg     = &util.Config{RunType: util.RunText}
+	)
+	cmd := &cobra.Command{
+		Use:   "inspect",
+		Short: "Print commands , responses and protocol states decoded from pcap files",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.Log = zap.L().Named("text-inspect")
+			cfg.Log.Info("process begin run at " + time.Now().String())
+			if len(args) == 0 {
+				return cmd.Help()
+			}
+
+			err := cfg.CheckParamValid()
+			if err != nil {
+				cfg.Log.Error("parse param error , " + err.Error())
+				return nil
+			}
+			if err = loadMask(cfg); err != nil {
+				return err
+			}
+			options.Protocol = cfg.Protocol
+
+			opts := inspect.Options{
+				Conn:    cfg.InspectConn,
+				From:    cfg.StartTime,
+				To:      cfg.EndTime,
+				Hex:     cfg.InspectHex,
+				MaxRows: cfg.InspectMaxRows,
+			}
+			if len(cfg.InspectTypes) > 0 {
+				opts.Types = strings.Split(cfg.InspectTypes, ",")
+			}
+			name := filepath.Join(cfg.OutputDir, inspect.OutputName)
+			f, err := os.Create(name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w := bufio.NewWriterSize(f, 64*1024)
+			x, err := inspect.New(w, opts)
+			if err != nil {
+				return err
+			}
+			err = handlePcapFiles(context.Background(), args, cfg, options, x.Handler)
+			if err == nil {
+				err = x.Err()
+			}
+			if ferr := w.Flush(); err == nil {
+				err = ferr
+			}
+			if err != nil {
+				return err
+			}
+			cfg.Log.Info("write conversations to " + name)
+			cfg.Log.Info("process end run at " + time.Now().String())
+			return nil
+		},
+	}
+
+	cfg.ParseFlagForInspectText(cmd.Flags())
+	cmd.Flags().BoolVar(&options.ForceStart, "force-start", false, "accept streams even if no SYN have been seen")
+	return cmd
+}
@@ -0,0 +1,188 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bobguo/mysql-replay/eventlog"
+	"github.com/bobguo/mysql-replay/sqlreplay"
+	"github.com/bobguo/mysql-replay/stats"
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func NewLogCommand() *cobra.Command {
+	//add sub command replay
+	cmd := &cobra.Command{
+		Use:   "log",
+		Short: "Event log utilities",
+	}
+	cmd.AddCommand(NewLogReplayCommand())
+	cmd.AddCommand(NewLogExportCommand())
+	cmd.AddCommand(NewLogAnalyzeCommand())
+	return cmd
+}
+
+func NewLogReplayCommand() *cobra.Command {
+	//Replay sql from event logs written by capture , no packet is read
+	cfg := &util.Config{RunType: util.RunLog}
+	cmd := &cobra.Command{
+		Use:   "replay [event log or dir ...]",
+		Short: "Replay event logs",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.PreFileSize = cfg.PreFileSize * 1024 * 1024
+			cfg.Log = zap.L().Named("log-replay")
+			cfg.Log.Info("process begin run at " + time.Now().String())
+			if len(args) == 0 {
+				return cmd.Help()
+			}
+
+			err := cfg.CheckParamValid()
+			if err != nil {
+				cfg.Log.Error("parse param error , " + err.Error())
+				return nil
+			}
+			if err = loadMask(cfg); err != nil {
+				return err
+			}
+			if err = loadRewrite(cfg); err != nil {
+				return err
+			}
+
+			srcs, closeAll, err := openEventLogs(args, cfg.Protocol, cfg.StartTime)
+			if err != nil {
+				return err
+			}
+			defer closeAll()
+
+			go printTime()
+			go AddPortListenAndServer(cfg.ListenPort, cfg.OutputDir, cfg.StoreDir)
+
+			num := eventlog.Dispatch(srcs, func(conn stream.ConnID) stream.MySQLEventHandler {
+				logger := conn.Logger("replay")
+				return sqlreplay.NewEventHandler(conn, logger, cfg)
+			}, cfg.Log)
+			cfg.Log.Info(fmt.Sprintf("read %d events from %d event logs", num, len(srcs)))
+			aggregateJournal(cfg)
+			writeDigests(cfg)
+			cfg.Log.Info(stats.DumpStatic())
+			cfg.Log.Info("process end run at " + time.Now().String())
+			return nil
+		},
+	}
+
+	cfg.ParseFlagForRunLog(cmd.Flags())
+	return cmd
+}
+
+//openEventLogs opens the event logs and segments of args , a dir means
+//all of them in it . Events before from are skipped
+func openEventLogs(args []string, protocol string, from int64) ([]eventlog.Source, func(), error) {
+	var names []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !info.IsDir() {
+			names = append(names, arg)
+			continue
+		}
+		files, err := eventlog.ListFiles(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		names = append(names, files...)
+	}
+
+	var closers []io.Closer
+	closeAll := func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}
+	srcs := make([]eventlog.Source, 0, len(names))
+	for _, name := range names {
+		src, captured, closer, err := openEventLog(name, from)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		closers = append(closers, closer)
+		if !replayable(captured, protocol) {
+			closeAll()
+			return nil, nil, fmt.Errorf("%s is captured from %s , can not be replayed by %s",
+				name, captured, protocol)
+		}
+		srcs = append(srcs, src)
+	}
+	return srcs, closeAll, nil
+}
+
+func openEventLog(name string, from int64) (eventlog.Source, string, io.Closer, error) {
+	if strings.HasSuffix(name, eventlog.SegmentExt) {
+		s, err := eventlog.OpenSegment(name)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return s.Events(from), s.Protocol, s, nil
+	}
+	r, err := eventlog.OpenReader(name)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if from > 0 {
+		if err = r.SeekTime(from); err != nil {
+			r.Close()
+			return nil, "", nil, err
+		}
+	}
+	return r, r.Header.Protocol, r, nil
+}
+
+//X Protocol is replayed as SQL on the classic protocol
+func replayable(captured string, protocol string) bool {
+	return (captured == util.ProtocolPostgres) == (protocol == util.ProtocolPostgres)
+}
+
+//newCaptureHandler returns the event handlers of capture and the function
+//called after all connections are closed , the capture commands call it on
+//every exit , also on exit signal , so that segments get their footer
+func newCaptureHandler(cfg *util.Config) (func(conn stream.ConnID) stream.MySQLEventHandler, func(), error) {
+	if cfg.EventFormat != util.EventFormatSegment {
+		return func(conn stream.ConnID) stream.MySQLEventHandler {
+			return eventlog.NewWriter(conn, conn.Logger("capture"), cfg.OutputDir, cfg.Protocol)
+		}, func() {}, nil
+	}
+	name := filepath.Join(cfg.OutputDir, eventlog.SegmentName(time.Now().UnixNano()))
+	w, err := eventlog.CreateSegment(name, cfg.Protocol, cfg.BlockSize, cfg.Log)
+	if err != nil {
+		return nil, nil, err
+	}
+	return w.Handler, func() {
+		if err := w.Close(); err != nil {
+			cfg.Log.Error("close segment fail , " + err.Error())
+		}
+	}, nil
+}
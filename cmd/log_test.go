@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bobguo/mysql-replay/eventlog"
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func Test_replayable(t *testing.T) {
+	tests := []struct {
+		captured string
+		protocol string
+		want     bool
+	}{
+		{util.ProtocolMySQL, util.ProtocolMySQL, true},
+		{util.ProtocolMySQLX, util.ProtocolMySQL, true},
+		{util.ProtocolMySQLX, util.ProtocolMySQLX, true},
+		{util.ProtocolPostgres, util.ProtocolPostgres, true},
+		{util.ProtocolPostgres, util.ProtocolMySQL, false},
+		{util.ProtocolMySQL, util.ProtocolPostgres, false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, replayable(tt.captured, tt.protocol), tt.captured+" "+tt.protocol)
+	}
+}
+
+func Test_openEventLogs(t *testing.T) {
+	dir := t.TempDir()
+	for _, src := range []string{"10.0.0.1:1000", "10.0.0.1:1001"} {
+		conn, err := stream.ParseConnID(src, "10.0.0.2:3306")
+		assert.Nil(t, err)
+		w := eventlog.NewWriter(conn, zap.NewNop(), dir, util.ProtocolMySQL)
+		w.OnEvent(stream.MySQLEvent{Time: 1, Type: util.EventQuit})
+		w.OnClose()
+	}
+	names, err := eventlog.ListFiles(dir)
+	assert.Nil(t, err)
+
+	cfg := &util.Config{OutputDir: dir, Protocol: util.ProtocolMySQL, EventFormat: util.EventFormatSegment, Log: zap.NewNop()}
+	newHandler, closeHandler, err := newCaptureHandler(cfg)
+	assert.Nil(t, err)
+	conn, err := stream.ParseConnID("10.0.0.1:1002", "10.0.0.2:3306")
+	assert.Nil(t, err)
+	h := newHandler(conn)
+	h.OnEvent(stream.MySQLEvent{Time: 1, Type: util.EventQuit})
+	h.OnClose()
+	closeHandler()
+
+	srcs, closeAll, err := openEventLogs([]string{dir, names[0]}, util.ProtocolMySQL, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(srcs))
+	num := eventlog.Dispatch(srcs, func(conn stream.ConnID) stream.MySQLEventHandler {
+		return eventlog.NewWriter(conn, zap.NewNop(), t.TempDir(), util.ProtocolMySQL)
+	}, zap.NewNop())
+	assert.Equal(t, 4, num)
+	closeAll()
+
+	_, _, err = openEventLogs([]string{dir}, util.ProtocolPostgres, 0)
+	assert.NotNil(t, err)
+	_, _, err = openEventLogs([]string{filepath.Join(dir, "not-exist")}, util.ProtocolMySQL, 0)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,49 @@
+//go:build nopcap
+// +build nopcap
+
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/google/gopacket/reassembly"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+//built without libpcap , only commands reading event logs work
+
+var errNoPcap = errors.New("built without libpcap (nopcap) , pcap files and devices can not be read")
+
+func trafficCapture(ctx context.Context, cfg *util.Config, options stream.FactoryOptions,
+	newHandler func(conn stream.ConnID) stream.MySQLEventHandler) error {
+	return errNoPcap
+}
+
+func HandlePcapFileByDir(ctx context.Context, name string, cfg *util.Config, assembler *reassembly.Assembler,
+	lastFlushTime *time.Time, errChan chan error, handleFileNum *int32) {
+	errChan <- errNoPcap
+}
+
+func HandlePcapFileByText(ctx context.Context, name string, cfg *util.Config, assembler *reassembly.Assembler, lastFlushTime *time.Time,
+	flushInterval time.Duration, log *zap.Logger) error {
+	return errNoPcap
+}
@@ -24,16 +24,13 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	"github.com/bobguo/mysql-replay/sqlreplay"
 	"github.com/bobguo/mysql-replay/stream"
 	"github.com/bobguo/mysql-replay/util"
-	"github.com/google/gopacket"
-	"github.com/google/gopacket/layers"
-	"github.com/google/gopacket/pcap"
-	"github.com/google/gopacket/reassembly"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 )
@@ -47,6 +44,7 @@ func NewOnlineCommand() *cobra.Command {
 		Short: "traffic capture online",
 	}
 	cmd.AddCommand(NewOnlineReplayCommand())
+	cmd.AddCommand(NewOnlineCaptureCommand())
 	return cmd
 }
 
@@ -59,90 +57,71 @@ func getFilter(port uint16) string {
 	return filter
 }
 
-func trafficCapture(cfg *util.Config, options stream.FactoryOptions) error {
-
-	var packetNum uint64
-	//var InvalidMsgPktNum uint64
-	ts := time.Now()
-	handle, err := pcap.OpenLive(cfg.DeviceName, 65535, false, pcap.BlockForever)
-	if err != nil {
-		return err
-	}
-	defer handle.Close()
-
-	//set filter
-	filter := getFilter(cfg.SrcPort)
-	cfg.Log.Info("SetBPFFilter " + filter)
-	err = handle.SetBPFFilter(filter)
-	if err != nil {
-		return err
-	}
-
-	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
-
-	// Process packet here
-	factory := stream.NewFactoryFromEventHandler(func(conn stream.ConnID) stream.MySQLEventHandler {
-		logger := conn.Logger("replay")
-		return sqlreplay.NewReplayEventHandler(conn, logger, cfg)
-	}, options)
-
-	pool := reassembly.NewStreamPool(factory)
-	assembler := reassembly.NewAssembler(pool)
-
-	packets := packetSource.Packets()
+func NewOnlineReplayCommand() *cobra.Command {
+	//Replay sql from online net packet
+	var (
+		options = stream.FactoryOptions{Synchronized: true}
+		cfg     = &util.Config{RunType: util.RunOnline}
+	)
+	cmd := &cobra.Command{
+		Use:   "replay",
+		Short: "Replay online packet",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.PreFileSize = cfg.PreFileSize * 1024 * 1024
+			logName := generateLogName(cfg.DeviceName, cfg.SrcPort)
+			cfg.Log = zap.L().Named(logName)
+			cfg.Log.Info("process begin run at " + time.Now().String())
 
-	ticker := time.NewTicker(time.Minute)
-	defer ticker.Stop()
+			ts := time.Now()
 
-	for {
-		select {
-		case pkt := <-packets:
-			if pkt.NetworkLayer() == nil || pkt.TransportLayer() == nil {
-				continue
+			err := cfg.CheckParamValid()
+			if err != nil {
+				cfg.Log.Error("parse param error , " + err.Error())
+				return nil
 			}
-			layer := pkt.Layer(layers.LayerTypeTCP)
-			if layer == nil {
-				cfg.Log.Error("pkt.Layer is nil")
-				continue
+			if err = loadMask(cfg); err != nil {
+				return err
 			}
-			tcp := layer.(*layers.TCP)
-
-			packetNum++
-			if packetNum%100000 == 0 {
-				cfg.Log.Warn("receive packet num : " + fmt.Sprintf("%v", packetNum))
+			if err = loadRewrite(cfg); err != nil {
+				return err
 			}
-			assembler.AssembleWithContext(pkt.NetworkLayer().NetworkFlow(), tcp,
-				captureContext(pkt.Metadata().CaptureInfo))
+			options.Protocol = cfg.Protocol
 
-		case <-ticker.C:
-			if time.Since(ts).Seconds() > float64(cfg.RunTime*60) {
-				cfg.Log.Warn("program run timeout , " + fmt.Sprintf("%v", int64(cfg.RunTime*60)))
-				return ERRORTIMEOUT
+			go printTime()
+			go AddPortListenAndServer(cfg.ListenPort, cfg.OutputDir, cfg.StoreDir)
+			//handle online packet
+			err = trafficCapture(context.Background(), cfg, options, func(conn stream.ConnID) stream.MySQLEventHandler {
+				logger := conn.Logger("replay")
+				return sqlreplay.NewEventHandler(conn, logger, cfg)
+			})
+			aggregateJournal(cfg)
+			writeDigests(cfg)
+			if err != nil && err != ERRORTIMEOUT {
+				return err
+			} else if err == ERRORTIMEOUT {
+				cfg.Log.Info("process time to stop ,start at :" + ts.String() + " end at :" + time.Now().String() +
+					" specify running time : " + fmt.Sprintf("%vs", cfg.RunTime*60))
+				return nil
 			}
-
-			stats, err := handle.Stats()
-			cfg.Log.Warn(fmt.Sprintf("flushing all streams that haven't seen"+
-				" packets in the last 2 minutes, pcap stats: %+v %v", stats, err))
-			flushed, closed := assembler.FlushCloseOlderThan(time.Now().Add(-2 * time.Minute))
-			cfg.Log.Warn(fmt.Sprintf("flushed old connect %v-%v", flushed, closed))
-		}
-
+			return nil
+		},
 	}
 
-	//return nil
+	cfg.ParseFlagForRunOnline(cmd.Flags())
+	cmd.Flags().BoolVar(&options.ForceStart, "force-start", false, "accept streams even if no SYN have been seen")
+	return cmd
 }
 
-func NewOnlineReplayCommand() *cobra.Command {
-	//Replay sql from online net packet
+func NewOnlineCaptureCommand() *cobra.Command {
+	//Write events of online net packet to event logs
 	var (
 		options = stream.FactoryOptions{Synchronized: true}
 		cfg     = &util.Config{RunType: util.RunOnline}
 	)
 	cmd := &cobra.Command{
-		Use:   "replay",
-		Short: "Replay online packet",
+		Use:   "capture",
+		Short: "Write events parsed from online packet to event logs",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			cfg.PreFileSize = cfg.PreFileSize * 1024 * 1024
 			logName := generateLogName(cfg.DeviceName, cfg.SrcPort)
 			cfg.Log = zap.L().Named(logName)
 			cfg.Log.Info("process begin run at " + time.Now().String())
@@ -154,23 +133,31 @@ func NewOnlineReplayCommand() *cobra.Command {
 				cfg.Log.Error("parse param error , " + err.Error())
 				return nil
 			}
+			options.Protocol = cfg.Protocol
 
 			go printTime()
-			go AddPortListenAndServer(cfg.ListenPort, cfg.OutputDir, cfg.StoreDir)
-			//handle online packet
-			err = trafficCapture(cfg, options)
+			newHandler, closeHandler, err := newCaptureHandler(cfg)
+			if err != nil {
+				cfg.Log.Error("create event writer fail , " + err.Error())
+				return err
+			}
+			//the connections are closed on exit signal too , so that the
+			//event logs are complete
+			ctx, stop := exitContext(cfg.Log)
+			err = trafficCapture(ctx, cfg, options, newHandler)
+			stop()
+			closeHandler()
 			if err != nil && err != ERRORTIMEOUT {
 				return err
 			} else if err == ERRORTIMEOUT {
 				cfg.Log.Info("process time to stop ,start at :" + ts.String() + " end at :" + time.Now().String() +
 					" specify running time : " + fmt.Sprintf("%vs", cfg.RunTime*60))
-				return nil
 			}
 			return nil
 		},
 	}
 
-	cfg.ParseFlagForRunOnline(cmd.Flags())
+	cfg.ParseFlagForCaptureOnline(cmd.Flags())
 	cmd.Flags().BoolVar(&options.ForceStart, "force-start", false, "accept streams even if no SYN have been seen")
 	return cmd
 }
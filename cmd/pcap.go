@@ -0,0 +1,217 @@
+//go:build !nopcap
+// +build !nopcap
+
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/reassembly"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+//trafficCapture reads packets of the device until runtime is out or ctx
+//is done , the connections are closed on both
+func trafficCapture(ctx context.Context, cfg *util.Config, options stream.FactoryOptions,
+	newHandler func(conn stream.ConnID) stream.MySQLEventHandler) error {
+
+	var packetNum uint64
+	//var InvalidMsgPktNum uint64
+	ts := time.Now()
+	handle, err := pcap.OpenLive(cfg.DeviceName, 65535, false, pcap.BlockForever)
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	//set filter
+	filter := getFilter(cfg.SrcPort)
+	cfg.Log.Info("SetBPFFilter " + filter)
+	err = handle.SetBPFFilter(filter)
+	if err != nil {
+		return err
+	}
+
+	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
+
+	// Process packet here
+	factory := stream.NewFactoryFromEventHandler(newHandler, options)
+
+	pool := reassembly.NewStreamPool(factory)
+	assembler := reassembly.NewAssembler(pool)
+
+	packets := packetSource.Packets()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case pkt := <-packets:
+			if pkt.NetworkLayer() == nil || pkt.TransportLayer() == nil {
+				continue
+			}
+			layer := pkt.Layer(layers.LayerTypeTCP)
+			if layer == nil {
+				cfg.Log.Error("pkt.Layer is nil")
+				continue
+			}
+			tcp := layer.(*layers.TCP)
+
+			packetNum++
+			if packetNum%100000 == 0 {
+				cfg.Log.Warn("receive packet num : " + fmt.Sprintf("%v", packetNum))
+			}
+			assembler.AssembleWithContext(pkt.NetworkLayer().NetworkFlow(), tcp,
+				captureContext(pkt.Metadata().CaptureInfo))
+
+		case <-ticker.C:
+			if time.Since(ts).Seconds() > float64(cfg.RunTime*60) {
+				cfg.Log.Warn("program run timeout , " + fmt.Sprintf("%v", int64(cfg.RunTime*60)))
+				//close all connections , so the handlers can flush what they hold
+				i := assembler.FlushAll()
+				cfg.Log.Info(fmt.Sprintf("end close all goroutine , %v groutine", i))
+				return ERRORTIMEOUT
+			}
+
+			stats, err := handle.Stats()
+			cfg.Log.Warn(fmt.Sprintf("flushing all streams that haven't seen"+
+				" packets in the last 2 minutes, pcap stats: %+v %v", stats, err))
+			flushed, closed := assembler.FlushCloseOlderThan(time.Now().Add(-2 * time.Minute))
+			cfg.Log.Warn(fmt.Sprintf("flushed old connect %v-%v", flushed, closed))
+
+		case <-ctx.Done():
+			i := assembler.FlushAll()
+			cfg.Log.Info(fmt.Sprintf("end close all goroutine , %v groutine", i))
+			return nil
+		}
+
+	}
+
+	//return nil
+}
+
+func HandlePcapFileByDir(ctx context.Context, name string, cfg *util.Config, assembler *reassembly.Assembler,
+	lastFlushTime *time.Time, errChan chan error, handleFileNum *int32) {
+	cfg.Log.Info("process file " + name)
+	var handle *pcap.Handle
+	var err error
+	handle, err = pcap.OpenOffline(name)
+	if err != nil {
+		cfg.Log.Error("open pcap file fail " + err.Error())
+		errChan <- err
+		return
+	}
+
+	//set filter
+	filter := getFilter(cfg.SrcPort)
+	err = handle.SetBPFFilter(filter)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	defer handle.Close()
+	defer atomic.AddInt32(handleFileNum, -1)
+	pkts := gopacket.NewPacketSource(handle, handle.LinkType()).Packets()
+	for {
+		select {
+		case pkt, ok := <-pkts:
+			if !ok {
+				errChan <- nil
+				return
+			}
+			if meta := pkt.Metadata(); meta != nil && meta.Timestamp.Sub(*lastFlushTime) > cfg.FlushInterval {
+				flushed, closed := assembler.FlushCloseOlderThan(*lastFlushTime)
+				cfg.Log.Info(fmt.Sprintf("flush old connect fulshed:%v,closed:%v", flushed, closed))
+				*lastFlushTime = meta.Timestamp
+			}
+
+			layer := pkt.Layer(layers.LayerTypeTCP)
+			if layer != nil {
+				tcp := layer.(*layers.TCP)
+				assembler.AssembleWithContext(pkt.NetworkLayer().NetworkFlow(), tcp, captureContext(pkt.Metadata().CaptureInfo))
+			}
+
+		case <-ctx.Done():
+			cfg.Log.Info("the program will exit ")
+			errChan <- nil
+			return
+		}
+	}
+}
+
+//HandlePcapFileByText reads the packets of the pcap file until its end or
+//ctx is done
+func HandlePcapFileByText(ctx context.Context, name string, cfg *util.Config, assembler *reassembly.Assembler, lastFlushTime *time.Time,
+	flushInterval time.Duration, log *zap.Logger) error {
+	fmt.Println("process file ", name)
+
+	var handle *pcap.Handle
+	var err error
+	handle, err = pcap.OpenOffline(name)
+	if err != nil {
+		log.Error("open pcap file fail " + err.Error())
+		return errors.Annotate(err, "open "+name)
+	}
+
+	//set filter
+	filter := getFilter(cfg.SrcPort)
+	cfg.Log.Info("SetBPFFilter " + filter)
+	err = handle.SetBPFFilter(filter)
+	if err != nil {
+		return errors.Annotate(err, "SetBPFFilter "+name)
+	}
+
+	defer handle.Close()
+
+	src := gopacket.NewPacketSource(handle, handle.LinkType())
+	pkts := src.Packets()
+	for {
+		var pkt gopacket.Packet
+		select {
+		case p, ok := <-pkts:
+			if !ok {
+				return nil
+			}
+			pkt = p
+		case <-ctx.Done():
+			return nil
+		}
+		if meta := pkt.Metadata(); meta != nil && meta.Timestamp.Sub(*lastFlushTime) > cfg.FlushInterval {
+			flushed, closed := assembler.FlushCloseOlderThan(*lastFlushTime)
+			cfg.Log.Info(fmt.Sprintf("flush old connect fulshed:%v,closed:%v", flushed, closed))
+			*lastFlushTime = meta.Timestamp
+		}
+
+		layer := pkt.Layer(layers.LayerTypeTCP)
+		if layer != nil {
+			tcp := layer.(*layers.TCP)
+			assembler.AssembleWithContext(pkt.NetworkLayer().NetworkFlow(), tcp, captureContext(pkt.Metadata().CaptureInfo))
+		}
+	}
+}
@@ -68,6 +68,7 @@ func NewRootCmd() *cobra.Command {
 	cmd.AddCommand(NewTextCommand())
 	cmd.AddCommand(NewOnlineCommand())
 	cmd.AddCommand(NewDirTextCommand())
+	cmd.AddCommand(NewLogCommand())
 	return cmd
 }
 
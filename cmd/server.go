@@ -68,6 +68,20 @@ type QueryStats struct {
 	ExecSQLFail  uint64 `json:"exec_sql_fail"`
 	WriteResFileFail uint64 `json:"write_res_file_fail"`
 	FormatJsonFail uint64 `json:"format_json_fail"`
+	ReplayLag uint64 `json:"replay_lag_ms"`
+	ReplayMaxLag uint64 `json:"replay_max_lag_ms"`
+	LateEvents uint64 `json:"late_events"`
+	CommitOrderTimeout uint64 `json:"commit_order_timeout"`
+	SkipSQL uint64 `json:"skip_sql"`
+	SessionWait uint64 `json:"session_wait_ms"`
+	SessionWaits uint64 `json:"session_waits"`
+	SessionMaxWait uint64 `json:"session_max_wait_ms"`
+	StmtWait uint64 `json:"stmt_wait_ms"`
+	StmtWaits uint64 `json:"stmt_waits"`
+	StmtMaxWait uint64 `json:"stmt_max_wait_ms"`
+	SessionIdleReleases uint64 `json:"session_idle_releases"`
+	Retries uint64 `json:"retries"`
+	RetryGiveUp uint64 `json:"retry_give_ups"`
 }
 
 
@@ -84,6 +98,20 @@ func getStatic(qs *QueryStats){
 	qs.ExecSQLFail =stats.GetValue("ExecSQLFail")
 	qs.WriteResFileFail =stats.GetValue("WriteResFileFail")
 	qs.FormatJsonFail =stats.GetValue("FormatJsonFail")
+	qs.ReplayLag = stats.GetValue("ReplayLag")
+	qs.ReplayMaxLag = stats.GetValue("ReplayMaxLag")
+	qs.LateEvents = stats.GetValue("LateEvents")
+	qs.CommitOrderTimeout = stats.GetValue("CommitOrderTimeout")
+	qs.SkipSQL = stats.GetValue("SkipSQL")
+	qs.SessionWait = stats.GetValue("SessionWait")
+	qs.SessionWaits = stats.GetValue("SessionWaits")
+	qs.SessionMaxWait = stats.GetValue("SessionMaxWait")
+	qs.StmtWait = stats.GetValue("StmtWait")
+	qs.StmtWaits = stats.GetValue("StmtWaits")
+	qs.StmtMaxWait = stats.GetValue("StmtMaxWait")
+	qs.SessionIdleReleases = stats.GetValue("SessionIdleReleases")
+	qs.Retries = stats.GetValue("Retries")
+	qs.RetryGiveUp = stats.GetValue("RetryGiveUp")
 }
 
 func HandleQueryStats(w http.ResponseWriter, r *http.Request) {
@@ -141,6 +169,20 @@ func HandleQueryStats(w http.ResponseWriter, r *http.Request) {
 
 
 
+}
+
+//HandleDigests responds the stats of every query digest
+func HandleDigests(w http.ResponseWriter, r *http.Request) {
+	logger.Info("request digests from " + r.Host)
+	defer logger.Info("response digests to " + r.Host)
+	js, err := json.Marshal(stats.DumpDigests())
+	if err != nil {
+		js = []byte(err.Error())
+	}
+	_, err = w.Write(js)
+	if err != nil {
+		logger.Warn("write response file," + err.Error())
+	}
 }
 
 func HandleExit(w http.ResponseWriter, r *http.Request){
@@ -160,6 +202,7 @@ func AddPortListenAndServer(port uint16,outputDir ,storeDir string ){
 	strDir = storeDir
 
 	http.HandleFunc("/stats", HandleQueryStats)
+	http.HandleFunc("/digests", HandleDigests)
 	http.HandleFunc("/exit", HandleExit)
 
 	err:= http.ListenAndServe(generateListenStr(port), nil)
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -44,30 +45,26 @@ func NewTextDumpReplayCommand() *cobra.Command {
 				cfg.Log.Error("parse param error , " + err.Error())
 				return nil
 			}
+			if err = loadMask(cfg); err != nil {
+				return err
+			}
+			if err = loadRewrite(cfg); err != nil {
+				return err
+			}
+			options.Protocol = cfg.Protocol
 
 			go printTime()
 			go AddPortListenAndServer(cfg.ListenPort, cfg.OutputDir, cfg.StoreDir)
 
-			factory := stream.NewFactoryFromEventHandler(func(conn stream.ConnID) stream.MySQLEventHandler {
+			err = handlePcapFiles(context.Background(), args, cfg, options, func(conn stream.ConnID) stream.MySQLEventHandler {
 				logger := conn.Logger("replay")
-				return sqlreplay.NewReplayEventHandler(conn, logger, cfg)
-			}, options)
-			pool := reassembly.NewStreamPool(factory)
-			assembler := reassembly.NewAssembler(pool)
-
-			lastFlushTime := time.Time{}
-
-			for _, in := range args {
-				zap.L().Info("processing " + in)
-				err = HandlePcapFileByText(in, cfg, assembler, &lastFlushTime, cfg.FlushInterval, cfg.Log)
-				if err != nil {
-					return err
-				}
+				return sqlreplay.NewEventHandler(conn, logger, cfg)
+			})
+			if err != nil {
+				return err
 			}
-
-			cfg.Log.Info("read packet end ,begin close all goroutine")
-			i := assembler.FlushAll()
-			cfg.Log.Info(fmt.Sprintf("read packet end ,end close all goroutine , %v groutine", i))
+			aggregateJournal(cfg)
+			writeDigests(cfg)
 			cfg.Log.Info("process end run at " + time.Now().String())
 			return nil
 		},
@@ -78,6 +75,82 @@ func NewTextDumpReplayCommand() *cobra.Command {
 	return cmd
 }
 
+func NewTextCaptureCommand() *cobra.Command {
+	//Parse pcap files once and write the events of every connection
+	//to event logs , the logs can be replayed many times
+	var (
+		options = stream.FactoryOptions{Synchronized: true}
+		cfg     = &util.Config{RunType: util.RunText}
+	)
+	cmd := &cobra.Command{
+		Use:   "capture",
+		Short: "Write events parsed from pcap files to event logs",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.Log = zap.L().Named("text-capture")
+			cfg.Log.Info("process begin run at " + time.Now().String())
+			if len(args) == 0 {
+				return cmd.Help()
+			}
+
+			err := cfg.CheckParamValid()
+			if err != nil {
+				cfg.Log.Error("parse param error , " + err.Error())
+				return nil
+			}
+			options.Protocol = cfg.Protocol
+
+			newHandler, closeHandler, err := newCaptureHandler(cfg)
+			if err != nil {
+				cfg.Log.Error("create event writer fail , " + err.Error())
+				return err
+			}
+			//the connections are closed on exit signal too , so that the
+			//event logs are complete
+			ctx, stop := exitContext(cfg.Log)
+			err = handlePcapFiles(ctx, args, cfg, options, newHandler)
+			stop()
+			closeHandler()
+			if err != nil {
+				return err
+			}
+			cfg.Log.Info("process end run at " + time.Now().String())
+			return nil
+		},
+	}
+
+	cfg.ParseFlagForCaptureText(cmd.Flags())
+	cmd.Flags().BoolVar(&options.ForceStart, "force-start", false, "accept streams even if no SYN have been seen")
+	return cmd
+}
+
+//handlePcapFiles reads pcap files in order and passes the events of
+//every connection to the handler created by newHandler , it stops reading
+//when ctx is done . The connections are closed at the end
+func handlePcapFiles(ctx context.Context, files []string, cfg *util.Config, options stream.FactoryOptions,
+	newHandler func(conn stream.ConnID) stream.MySQLEventHandler) error {
+	factory := stream.NewFactoryFromEventHandler(newHandler, options)
+	pool := reassembly.NewStreamPool(factory)
+	assembler := reassembly.NewAssembler(pool)
+
+	lastFlushTime := time.Time{}
+
+	for _, in := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		zap.L().Info("processing " + in)
+		err := HandlePcapFileByText(ctx, in, cfg, assembler, &lastFlushTime, cfg.FlushInterval, cfg.Log)
+		if err != nil {
+			return err
+		}
+	}
+
+	cfg.Log.Info("read packet end ,begin close all goroutine")
+	i := assembler.FlushAll()
+	cfg.Log.Info(fmt.Sprintf("read packet end ,end close all goroutine , %v groutine", i))
+	return nil
+}
+
 func NewTextCommand() *cobra.Command {
 	//add sub command replay
 	cmd := &cobra.Command{
@@ -85,5 +158,9 @@ func NewTextCommand() *cobra.Command {
 		Short: "Text format utilities",
 	}
 	cmd.AddCommand(NewTextDumpReplayCommand())
+	cmd.AddCommand(NewTextCaptureCommand())
+	cmd.AddCommand(NewTextExportCommand())
+	cmd.AddCommand(NewTextAnalyzeCommand())
+	cmd.AddCommand(NewTextInspectCommand())
 	return cmd
 }
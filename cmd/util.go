@@ -2,17 +2,21 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
-	"sync/atomic"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 
+	"github.com/bobguo/mysql-replay/mask"
+	"github.com/bobguo/mysql-replay/rewrite"
+	"github.com/bobguo/mysql-replay/sqlreplay"
+	"github.com/bobguo/mysql-replay/stats"
 	"github.com/bobguo/mysql-replay/util"
 	"github.com/google/gopacket"
-	"github.com/google/gopacket/layers"
-	"github.com/google/gopacket/pcap"
-	"github.com/google/gopacket/reassembly"
-	"github.com/pingcap/errors"
 	"go.uber.org/zap"
 )
 
@@ -28,95 +32,6 @@ func (c *Context) GetCaptureInfo() gopacket.CaptureInfo {
 	return c.CaptureInfo
 }
 
-func HandlePcapFileByDir(ctx context.Context, name string, cfg *util.Config, assembler *reassembly.Assembler,
-	lastFlushTime *time.Time, errChan chan error, handleFileNum *int32) {
-	cfg.Log.Info("process file " + name)
-	var handle *pcap.Handle
-	var err error
-	handle, err = pcap.OpenOffline(name)
-	if err != nil {
-		cfg.Log.Error("open pcap file fail " + err.Error())
-		errChan <- err
-		return
-	}
-
-	//set filter
-	filter := getFilter(cfg.SrcPort)
-	err = handle.SetBPFFilter(filter)
-	if err != nil {
-		errChan <- err
-		return
-	}
-
-	defer handle.Close()
-	defer atomic.AddInt32(handleFileNum, -1)
-	pkts := gopacket.NewPacketSource(handle, handle.LinkType()).Packets()
-	for {
-		select {
-		case pkt, ok := <-pkts:
-			if !ok {
-				errChan <- nil
-				return
-			}
-			if meta := pkt.Metadata(); meta != nil && meta.Timestamp.Sub(*lastFlushTime) > cfg.FlushInterval {
-				flushed, closed := assembler.FlushCloseOlderThan(*lastFlushTime)
-				cfg.Log.Info(fmt.Sprintf("flush old connect fulshed:%v,closed:%v", flushed, closed))
-				*lastFlushTime = meta.Timestamp
-			}
-
-			layer := pkt.Layer(layers.LayerTypeTCP)
-			if layer != nil {
-				tcp := layer.(*layers.TCP)
-				assembler.AssembleWithContext(pkt.NetworkLayer().NetworkFlow(), tcp, captureContext(pkt.Metadata().CaptureInfo))
-			}
-
-		case <-ctx.Done():
-			cfg.Log.Info("the program will exit ")
-			errChan <- nil
-			return
-		}
-	}
-}
-
-func HandlePcapFileByText(name string, cfg *util.Config, assembler *reassembly.Assembler, lastFlushTime *time.Time,
-	flushInterval time.Duration, log *zap.Logger) error {
-	fmt.Println("process file ", name)
-
-	var handle *pcap.Handle
-	var err error
-	handle, err = pcap.OpenOffline(name)
-	if err != nil {
-		log.Error("open pcap file fail " + err.Error())
-		return errors.Annotate(err, "open "+name)
-	}
-
-	//set filter
-	filter := getFilter(cfg.SrcPort)
-	cfg.Log.Info("SetBPFFilter " + filter)
-	err = handle.SetBPFFilter(filter)
-	if err != nil {
-		return errors.Annotate(err, "SetBPFFilter "+name)
-	}
-
-	defer handle.Close()
-
-	src := gopacket.NewPacketSource(handle, handle.LinkType())
-	for pkt := range src.Packets() {
-		if meta := pkt.Metadata(); meta != nil && meta.Timestamp.Sub(*lastFlushTime) > cfg.FlushInterval {
-			flushed, closed := assembler.FlushCloseOlderThan(*lastFlushTime)
-			cfg.Log.Info(fmt.Sprintf("flush old connect fulshed:%v,closed:%v", flushed, closed))
-			*lastFlushTime = meta.Timestamp
-		}
-
-		layer := pkt.Layer(layers.LayerTypeTCP)
-		if layer != nil {
-			tcp := layer.(*layers.TCP)
-			assembler.AssembleWithContext(pkt.NetworkLayer().NetworkFlow(), tcp, captureContext(pkt.Metadata().CaptureInfo))
-		}
-	}
-	return nil
-}
-
 func printTime() {
 	t := time.NewTicker(time.Second * 60)
 	ts := time.Now()
@@ -161,3 +76,83 @@ func HandleSigs(sigs chan os.Signal, exits chan bool) {
 	<-sigs
 	exits <- true
 }
+
+//exitContext returns a context canceled when the process gets SIGINT or
+//SIGTERM , capture commands stop reading packets by it and close their
+//handlers , so that what they buffered is written . stop restores the
+//default handling of the signals
+func exitContext(log *zap.Logger) (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigs:
+			log.Info("exit by signal")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sigs)
+		cancel()
+	}
+}
+
+//aggregateJournal writes the aggregated view of journals once all
+//connections are closed
+func aggregateJournal(cfg *util.Config) {
+	if !cfg.Journal || !cfg.JournalAggregate {
+		return
+	}
+	name := filepath.Join(cfg.OutputDir, "mysql_events.json")
+	err := sqlreplay.AggregateJournal(cfg.OutputDir, name)
+	if err != nil {
+		cfg.Log.Warn("aggregate journal fail , " + err.Error())
+		return
+	}
+	cfg.Log.Info("aggregate journal to " + name)
+}
+
+//loadMask loads the masking rules , sensitive values are masked in all
+//outputs once the rules are loaded
+func loadMask(cfg *util.Config) error {
+	if err := mask.Init(cfg.MaskConfig); err != nil {
+		cfg.Log.Error("load mask config fail , " + err.Error())
+		return err
+	}
+	if len(cfg.MaskConfig) > 0 {
+		cfg.Log.Info("mask sensitive values with rules in " + cfg.MaskConfig)
+	}
+	return nil
+}
+
+//loadRewrite loads the rules rewriting queries before they are replayed
+func loadRewrite(cfg *util.Config) error {
+	if err := rewrite.Init(cfg.RewriteConfig); err != nil {
+		cfg.Log.Error("load rewrite config fail , " + err.Error())
+		return err
+	}
+	if len(cfg.RewriteConfig) > 0 {
+		cfg.Log.Info("rewrite queries with rules in " + cfg.RewriteConfig)
+	}
+	return nil
+}
+
+//writeDigests writes the stats of query digests to <output>/digests.json
+//once all connections are closed
+func writeDigests(cfg *util.Config) {
+	digests := stats.DumpDigests()
+	if len(digests) == 0 {
+		return
+	}
+	js, err := json.MarshalIndent(digests, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(cfg.OutputDir, "digests.json"), js, 0644)
+	}
+	if err != nil {
+		cfg.Log.Warn("write digests fail , " + err.Error())
+		return
+	}
+	cfg.Log.Info(fmt.Sprintf("write stats of %d digests", len(digests)))
+}
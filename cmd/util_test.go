@@ -11,8 +11,11 @@ package cmd
 import (
 	"github.com/google/gopacket"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 	"os"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func Test_getFirstFileName(t *testing.T) {
@@ -88,4 +91,19 @@ func Test_HandleSigs ( t *testing.T){
 	sigs <- f
 
 	HandleSigs(sigs,exits)
-}
\ No newline at end of file
+}
+
+func Test_exitContext(t *testing.T) {
+	ctx, stop := exitContext(zap.NewNop())
+	defer stop()
+	assert.Nil(t, syscall.Kill(os.Getpid(), syscall.SIGINT))
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("ctx is not done by SIGINT")
+	}
+
+	ctx, stop = exitContext(zap.NewNop())
+	stop()
+	assert.NotNil(t, ctx.Err())
+}
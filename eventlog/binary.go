@@ -0,0 +1,353 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package eventlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+)
+
+//binary encoding of an event in a block of segment , integers are
+//varints and strings are prefixed by their length:
+//
+//	conn  time-delta  type  stmt-id  query  db  username  auth-plugin
+//	params (count+1 , 0 is nil)  result (0 is nil)
+//
+//time-delta is the difference from the previous event of the block
+
+//tags of stmt params
+const (
+	binNil byte = iota
+	binI64
+	binU64
+	binF32
+	binF64
+	binStr
+	binBin
+	binBool
+	binTime
+)
+
+var errBinTruncated = errors.New("truncated binary event")
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	return append(buf, b[:n]...)
+}
+
+func appendVarint(buf []byte, v int64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], v)
+	return append(buf, b[:n]...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = appendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendBinaryEvent(buf []byte, conn int, prev int64, e *stream.MySQLEvent) ([]byte, error) {
+	buf = appendUvarint(buf, uint64(conn))
+	buf = appendVarint(buf, e.Time-prev)
+	buf = append(buf, byte(e.Type))
+	buf = appendString(buf, e.StmtID)
+	buf = appendString(buf, e.Query)
+	buf = appendString(buf, e.DB)
+	buf = appendString(buf, e.Username)
+	buf = appendString(buf, e.AuthPlugin)
+	var err error
+	buf, err = appendBinaryParams(buf, e.Params)
+	if err != nil {
+		return nil, err
+	}
+	if e.Pr == nil {
+		return append(buf, 0), nil
+	}
+	r, err := e.Pr.Record()
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, 1)
+	return appendBinaryResult(buf, r), nil
+}
+
+func appendBinaryParams(buf []byte, params []interface{}) ([]byte, error) {
+	if params == nil {
+		return append(buf, 0), nil
+	}
+	buf = appendUvarint(buf, uint64(len(params))+1)
+	for _, param := range params {
+		switch x := param.(type) {
+		case nil:
+			buf = append(buf, binNil)
+		case int64:
+			buf = append(buf, binI64)
+			buf = appendVarint(buf, x)
+		case uint64:
+			buf = append(buf, binU64)
+			buf = appendUvarint(buf, x)
+		case float32:
+			buf = append(buf, binF32)
+			buf = appendUvarint(buf, uint64(math.Float32bits(x)))
+		case float64:
+			buf = append(buf, binF64)
+			buf = appendUvarint(buf, math.Float64bits(x))
+		case string:
+			buf = append(buf, binStr)
+			buf = appendString(buf, x)
+		case []byte:
+			buf = append(buf, binBin)
+			buf = appendString(buf, string(x))
+		case bool:
+			buf = append(buf, binBool)
+			if x {
+				buf = append(buf, 1)
+			} else {
+				buf = append(buf, 0)
+			}
+		case time.Time:
+			b, err := x.MarshalBinary()
+			if err != nil {
+				return nil, err
+			}
+			buf = append(buf, binTime)
+			buf = appendString(buf, string(b))
+		default:
+			return nil, fmt.Errorf("unsupported param type: %T", param)
+		}
+	}
+	return buf, nil
+}
+
+func appendBinaryResult(buf []byte, r *stream.PacketResRecord) []byte {
+	buf = appendUvarint(buf, uint64(r.ErrNo))
+	buf = appendString(buf, r.ErrDesc)
+	buf = appendString(buf, r.SQLState)
+	buf = appendUvarint(buf, uint64(r.ErrCategory))
+	buf = appendUvarint(buf, r.AffectedRows)
+	buf = appendUvarint(buf, r.InsertID)
+	buf = appendUvarint(buf, r.BeginTime)
+	buf = appendUvarint(buf, r.EndTime)
+	buf = appendUvarint(buf, uint64(len(r.Columns)))
+	for _, c := range r.Columns {
+		buf = appendString(buf, c.Name)
+		buf = appendString(buf, c.Type)
+		buf = appendUvarint(buf, uint64(c.Length))
+		buf = appendUvarint(buf, uint64(c.Flags))
+		buf = append(buf, c.Decimals, c.Charset)
+	}
+	if r.Rows == nil {
+		return append(buf, 0)
+	}
+	buf = appendUvarint(buf, uint64(len(r.Rows))+1)
+	for _, row := range r.Rows {
+		buf = appendUvarint(buf, uint64(len(row)))
+		for _, v := range row {
+			buf = append(buf, byte(v.Kind))
+			if v.Kind != stream.KindNull {
+				buf = appendString(buf, v.Data)
+			}
+		}
+	}
+	return buf
+}
+
+//binDecoder reads a block , the first error is kept and the following
+//reads return zero values
+type binDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *binDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.err = errBinTruncated
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *binDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = errBinTruncated
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *binDecoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.b) == 0 {
+		d.err = errBinTruncated
+		return 0
+	}
+	v := d.b[0]
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *binDecoder) string() string {
+	l := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if uint64(len(d.b)) < l {
+		d.err = errBinTruncated
+		return ""
+	}
+	s := string(d.b[:l])
+	d.b = d.b[l:]
+	return s
+}
+
+//event decodes an event and returns its connection
+func (d *binDecoder) event(prev int64, e *stream.MySQLEvent) int {
+	conn := int(d.uvarint())
+	e.Time = prev + d.varint()
+	e.Type = util.MysqlEventType(d.byte())
+	e.StmtID = d.string()
+	e.Query = d.string()
+	e.DB = d.string()
+	e.Username = d.string()
+	e.AuthPlugin = d.string()
+	e.Params = d.params()
+	e.Pr = nil
+	if d.byte() != 0 {
+		r := d.result()
+		if d.err == nil {
+			e.Pr = stream.NewPacketResFromRecord(r)
+		}
+	}
+	return conn
+}
+
+func (d *binDecoder) params() []interface{} {
+	n := d.uvarint()
+	if n == 0 || d.err != nil {
+		return nil
+	}
+	n--
+	if n > uint64(len(d.b)) {
+		d.err = errBinTruncated
+		return nil
+	}
+	params := make([]interface{}, 0, n)
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		switch tag := d.byte(); tag {
+		case binNil:
+			params = append(params, nil)
+		case binI64:
+			params = append(params, d.varint())
+		case binU64:
+			params = append(params, d.uvarint())
+		case binF32:
+			params = append(params, math.Float32frombits(uint32(d.uvarint())))
+		case binF64:
+			params = append(params, math.Float64frombits(d.uvarint()))
+		case binStr:
+			params = append(params, d.string())
+		case binBin:
+			params = append(params, []byte(d.string()))
+		case binBool:
+			params = append(params, d.byte() != 0)
+		case binTime:
+			var t time.Time
+			if err := t.UnmarshalBinary([]byte(d.string())); err != nil && d.err == nil {
+				d.err = err
+			}
+			params = append(params, t)
+		default:
+			if d.err == nil {
+				d.err = fmt.Errorf("unknown param tag %d", tag)
+			}
+		}
+	}
+	return params
+}
+
+func (d *binDecoder) result() *stream.PacketResRecord {
+	r := &stream.PacketResRecord{
+		ErrNo:        uint16(d.uvarint()),
+		ErrDesc:      d.string(),
+		SQLState:     d.string(),
+		ErrCategory:  util.ErrCategory(d.uvarint()),
+		AffectedRows: d.uvarint(),
+		InsertID:     d.uvarint(),
+		BeginTime:    d.uvarint(),
+		EndTime:      d.uvarint(),
+	}
+	n := d.uvarint()
+	if n > uint64(len(d.b)) {
+		d.err = errBinTruncated
+		return nil
+	}
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		r.Columns = append(r.Columns, stream.ColumnInfo{
+			Name:     d.string(),
+			Type:     d.string(),
+			Length:   uint32(d.uvarint()),
+			Flags:    uint16(d.uvarint()),
+			Decimals: d.byte(),
+			Charset:  d.byte(),
+		})
+	}
+	n = d.uvarint()
+	if n == 0 || d.err != nil {
+		return r
+	}
+	n--
+	if n > uint64(len(d.b)) {
+		d.err = errBinTruncated
+		return nil
+	}
+	r.Rows = make([][]stream.Value, 0, n)
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		l := d.uvarint()
+		if l > uint64(len(d.b)) {
+			d.err = errBinTruncated
+			return nil
+		}
+		row := make([]stream.Value, 0, l)
+		for j := uint64(0); j < l && d.err == nil; j++ {
+			v := stream.Value{Kind: stream.ValueKind(d.byte())}
+			if v.Kind != stream.KindNull {
+				v.Data = d.string()
+			}
+			row = append(row, v)
+		}
+		r.Rows = append(r.Rows, row)
+	}
+	return r
+}
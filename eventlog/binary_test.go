@@ -0,0 +1,104 @@
+package eventlog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBinaryEvent(t *testing.T) {
+	ts := time.Date(2021, 6, 1, 8, 0, 0, 123456789, time.FixedZone("CST", 8*3600))
+	res := &stream.PacketResRecord{
+		ErrNo:        1062,
+		ErrDesc:      "Duplicate entry",
+		SQLState:     "23000",
+		ErrCategory:  util.ErrCategoryServer,
+		AffectedRows: 2,
+		InsertID:     3,
+		BeginTime:    100,
+		EndTime:      200,
+		Columns:      []stream.ColumnInfo{{Name: "a", Type: "DECIMAL", Length: 10, Flags: 1, Decimals: 2, Charset: 63}},
+		Rows: [][]stream.Value{
+			{{Kind: stream.KindDecimal, Data: "1.50"}},
+			{{Kind: stream.KindNull}},
+			{{Kind: stream.KindBinary, Data: "\x00\xff"}},
+		},
+	}
+	events := []stream.MySQLEvent{
+		{Time: 1000, Type: util.EventHandshake, DB: "test", Username: "root", AuthPlugin: "caching_sha2_password"},
+		{Time: 900, Type: util.EventStmtExecute, StmtID: "7",
+			Params: []interface{}{nil, int64(-5), uint64(1 << 63), float32(1.5), 2.25, "s", []byte{1, 2}, true, false, ts},
+			Pr:     stream.NewPacketResFromRecord(res)},
+		{Time: 2000, Type: util.EventQuery, Query: "select 1", Params: []interface{}{},
+			Pr: stream.NewPacketResFromRecord(&stream.PacketResRecord{})},
+	}
+
+	var buf []byte
+	var prev int64
+	for i, e := range events {
+		var err error
+		buf, err = appendBinaryEvent(buf, i, prev, &e)
+		assert.Nil(t, err)
+		prev = e.Time
+	}
+
+	d := binDecoder{b: buf}
+	prev = 0
+	for i, want := range events {
+		var e stream.MySQLEvent
+		conn := d.event(prev, &e)
+		assert.Nil(t, d.err)
+		assert.Equal(t, i, conn)
+		prev = e.Time
+		assert.Equal(t, want.Time, e.Time)
+		assert.Equal(t, want.Type, e.Type)
+		assert.Equal(t, want.StmtID, e.StmtID)
+		assert.Equal(t, want.Query, e.Query)
+		assert.Equal(t, want.DB, e.DB)
+		assert.Equal(t, want.Username, e.Username)
+		assert.Equal(t, want.AuthPlugin, e.AuthPlugin)
+		if want.Params == nil {
+			assert.Nil(t, e.Params)
+		} else {
+			assert.Equal(t, len(want.Params), len(e.Params))
+		}
+		if want.Pr == nil {
+			assert.Nil(t, e.Pr)
+			continue
+		}
+		wr, _ := want.Pr.Record()
+		gr, err := e.Pr.Record()
+		assert.Nil(t, err)
+		assert.Equal(t, wr, gr)
+	}
+	assert.Equal(t, 0, len(d.b))
+
+	params := events[1].Params
+	var e stream.MySQLEvent
+	d = binDecoder{b: buf}
+	d.event(0, &e)
+	d.event(e.Time, &e)
+	assert.Equal(t, params[:9], e.Params[:9])
+	assert.True(t, ts.Equal(e.Params[9].(time.Time)))
+	_, offset := e.Params[9].(time.Time).Zone()
+	assert.Equal(t, 8*3600, offset)
+
+	//every truncated event must fail instead of panic
+	for i := 0; i < len(buf); i++ {
+		d = binDecoder{b: buf[:i]}
+		prev = 0
+		for d.err == nil && len(d.b) > 0 {
+			d.event(prev, &e)
+			prev = e.Time
+		}
+	}
+}
+
+func TestBinaryEvent_UnsupportedParam(t *testing.T) {
+	e := stream.MySQLEvent{Type: util.EventStmtExecute, Params: []interface{}{struct{}{}}}
+	_, err := appendBinaryEvent(nil, 0, 0, &e)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,110 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package eventlog
+
+import (
+	"container/heap"
+	"io"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"go.uber.org/zap"
+)
+
+//Source is a stream of events of one or more connections , an event log
+//or a segment
+type Source interface {
+	//NextEvent reads the next event , conn identifies the connection of the
+	//event in the source and last is true if it is the last event of the
+	//connection . io.EOF is returned after the last event
+	NextEvent(e *stream.MySQLEvent) (conn int, last bool, err error)
+}
+
+type source struct {
+	src      Source
+	handlers map[int]stream.MySQLEventHandler
+	next     stream.MySQLEvent
+	conn     int
+	last     bool
+}
+
+//sources ordered by the time of their next event
+type sourceHeap []*source
+
+func (h sourceHeap) Len() int            { return len(h) }
+func (h sourceHeap) Less(i, j int) bool  { return h[i].next.Time < h[j].next.Time }
+func (h sourceHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *sourceHeap) Push(x interface{}) { *h = append(*h, x.(*source)) }
+func (h *sourceHeap) Pop() interface{} {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
+
+//Dispatch passes the events of every source to the handler of their
+//connection , events of all sources are merged in time order as they were
+//read from packets . A handler is created by the first event of the
+//connection and closed after its last event , or at the end of the source.
+//The sources are not closed by Dispatch
+func Dispatch(srcs []Source, newHandler func(conn stream.ConnID) stream.MySQLEventHandler, log *zap.Logger) int {
+	h := make(sourceHeap, 0, len(srcs))
+	for _, src := range srcs {
+		s := &source{src: src, handlers: make(map[int]stream.MySQLEventHandler)}
+		if s.read(log) {
+			h = append(h, s)
+		}
+	}
+	heap.Init(&h)
+
+	var num int
+	for len(h) > 0 {
+		s := h[0]
+		handler, ok := s.handlers[s.conn]
+		if !ok {
+			handler = newHandler(s.next.Conn)
+			s.handlers[s.conn] = handler
+		}
+		handler.OnEvent(s.next)
+		num++
+		if s.last {
+			handler.OnClose()
+			delete(s.handlers, s.conn)
+		}
+		if s.read(log) {
+			heap.Fix(&h, 0)
+			continue
+		}
+		heap.Pop(&h)
+		for _, handler := range s.handlers {
+			handler.OnClose()
+		}
+	}
+	return num
+}
+
+//read the next event of source , false if there is no more event
+func (s *source) read(log *zap.Logger) bool {
+	var err error
+	s.conn, s.last, err = s.src.NextEvent(&s.next)
+	if err == nil {
+		return true
+	}
+	if err != io.EOF {
+		//the events after a broken record can not be trusted
+		log.Error("read events fail , " + err.Error())
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package eventlog
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type recordHandler struct {
+	conn stream.ConnID
+	res  *[]string
+}
+
+func (h *recordHandler) OnEvent(e stream.MySQLEvent) {
+	*h.res = append(*h.res, fmt.Sprintf("%s %d", h.conn.SrcAddr(), e.Time))
+}
+
+func (h *recordHandler) OnClose() {
+	*h.res = append(*h.res, h.conn.SrcAddr()+" close")
+}
+
+func TestDispatch(t *testing.T) {
+	dir := t.TempDir()
+	writeLog(t, dir, "10.0.0.1:1000",
+		stream.MySQLEvent{Time: 1, Type: util.EventHandshake},
+		stream.MySQLEvent{Time: 4, Type: util.EventQuery, Query: "select 1"},
+		stream.MySQLEvent{Time: 5, Type: util.EventQuit},
+	)
+	writeLog(t, dir, "10.0.0.1:1001",
+		stream.MySQLEvent{Time: 2, Type: util.EventHandshake},
+		stream.MySQLEvent{Time: 3, Type: util.EventQuit},
+	)
+	names, err := ListFiles(dir)
+	assert.Nil(t, err)
+	var srcs []Source
+	for _, name := range names {
+		r, err := OpenReader(name)
+		assert.Nil(t, err)
+		defer r.Close()
+		srcs = append(srcs, r)
+	}
+
+	var res []string
+	num := Dispatch(srcs, func(conn stream.ConnID) stream.MySQLEventHandler {
+		return &recordHandler{conn: conn, res: &res}
+	}, zap.NewNop())
+	assert.Equal(t, 5, num)
+	assert.Equal(t, []string{
+		"10.0.0.1:1000 1",
+		"10.0.0.1:1001 2",
+		"10.0.0.1:1001 3",
+		"10.0.0.1:1001 close",
+		"10.0.0.1:1000 4",
+		"10.0.0.1:1000 5",
+		"10.0.0.1:1000 close",
+	}, res)
+}
@@ -0,0 +1,140 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package eventlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bobguo/mysql-replay/stream"
+)
+
+//Format of the event log , version 1.
+//
+//An event log holds the events of one connection , a record a line.
+//The first line is the header , fields are separated by TAB:
+//
+//	#mysql-replay-events  1  protocol  "src ip:port"  "dst ip:port"
+//
+//The other lines are events , the fields written by stream.AppendEvent
+//followed by the result read from packets (stream.PacketResRecord) as JSON:
+//
+//	handshake      time  1  "db"  "username"  "auth plugin"  {result}
+//	quit           time  2                                   {result}
+//	query          time  3  "query"                          {result}
+//	stmt prepare   time  4  "stmt-id"  "query"               {result}
+//	stmt execute   time  5  "stmt-id"  [types  params ...    {result}
+//	stmt close     time  6  "stmt-id"                        {result}
+//
+//Strings are quoted by strconv.Quote and JSON never holds a raw TAB or
+//newline , so a record is always one line
+const (
+	Magic   = "#mysql-replay-events"
+	Version = 1
+	//suffix of event log files
+	Ext = ".events"
+)
+
+type Header struct {
+	Version  int
+	Protocol string
+	Conn     stream.ConnID
+}
+
+func AppendHeader(buf []byte, h Header) []byte {
+	buf = append(buf, Magic...)
+	buf = append(buf, '\t')
+	buf = strconv.AppendInt(buf, int64(h.Version), 10)
+	buf = append(buf, '\t')
+	buf = append(buf, h.Protocol...)
+	buf = append(buf, '\t')
+	buf = strconv.AppendQuote(buf, h.Conn.SrcAddr())
+	buf = append(buf, '\t')
+	buf = strconv.AppendQuote(buf, h.Conn.DstAddr())
+	return buf
+}
+
+func ParseHeader(line string) (Header, error) {
+	var h Header
+	fields := strings.Split(strings.TrimRight(line, "\r\n"), "\t")
+	if len(fields) < 5 || fields[0] != Magic {
+		return h, fmt.Errorf("not an event log header (%s)", line)
+	}
+	var err error
+	h.Version, err = strconv.Atoi(fields[1])
+	if err != nil {
+		return h, fmt.Errorf("parse version of event log from (%s): %v", fields[1], err)
+	}
+	if h.Version < 1 || h.Version > Version {
+		return h, fmt.Errorf("unsupported event log version %d", h.Version)
+	}
+	h.Protocol = fields[2]
+	src, err := strconv.Unquote(fields[3])
+	if err != nil {
+		return h, fmt.Errorf("parse src of event log from (%s): %v", fields[3], err)
+	}
+	dst, err := strconv.Unquote(fields[4])
+	if err != nil {
+		return h, fmt.Errorf("parse dst of event log from (%s): %v", fields[4], err)
+	}
+	h.Conn, err = stream.ParseConnID(src, dst)
+	return h, err
+}
+
+//AppendRecord encodes an event and its captured result as a record
+func AppendRecord(buf []byte, e stream.MySQLEvent) ([]byte, error) {
+	buf, err := stream.AppendEvent(buf, e)
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, '\t')
+	if e.Pr == nil {
+		return append(buf, "null"...), nil
+	}
+	r, err := e.Pr.Record()
+	if err != nil {
+		return nil, err
+	}
+	res, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, res...), nil
+}
+
+//ParseRecord decodes a record , the connection of the event is not set
+func ParseRecord(line string, e *stream.MySQLEvent) error {
+	line = strings.TrimRight(line, "\r\n")
+	pos, err := stream.ScanEvent(line, 0, e)
+	if err != nil {
+		return err
+	}
+	if pos >= len(line) || line[pos] != '\t' {
+		return fmt.Errorf("no result in record (%s)", line)
+	}
+	var r *stream.PacketResRecord
+	err = json.Unmarshal([]byte(line[pos+1:]), &r)
+	if err != nil {
+		return fmt.Errorf("parse result of record: %v", err)
+	}
+	e.Pr = nil
+	if r != nil {
+		e.Pr = stream.NewPacketResFromRecord(r)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package eventlog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeader(t *testing.T) {
+	conn, err := stream.ParseConnID("10.0.0.1:51234", "10.0.0.2:4000")
+	assert.Nil(t, err)
+	h := Header{Version: Version, Protocol: util.ProtocolMySQL, Conn: conn}
+	line := string(AppendHeader(nil, h))
+	assert.Equal(t, "#mysql-replay-events\t1\tmysql\t\"10.0.0.1:51234\"\t\"10.0.0.2:4000\"", line)
+
+	res, err := ParseHeader(line + "\n")
+	assert.Nil(t, err)
+	assert.Equal(t, h.Version, res.Version)
+	assert.Equal(t, h.Protocol, res.Protocol)
+	assert.Equal(t, conn.String(), res.Conn.String())
+	assert.Equal(t, conn.Hash(), res.Conn.Hash())
+
+	conn6, err := stream.ParseConnID("::1:51234", "::1:4000")
+	assert.Nil(t, err)
+	res, err = ParseHeader(string(AppendHeader(nil, Header{Version: 1, Protocol: "postgres", Conn: conn6})))
+	assert.Nil(t, err)
+	assert.Equal(t, conn6.String(), res.Conn.String())
+
+	for _, line := range []string{
+		"",
+		"1\tmysql",
+		"#mysql-replay-events\tx\tmysql\t\"10.0.0.1:1\"\t\"10.0.0.2:2\"",
+		"#mysql-replay-events\t2\tmysql\t\"10.0.0.1:1\"\t\"10.0.0.2:2\"",
+		"#mysql-replay-events\t1\tmysql\t10.0.0.1:1\t\"10.0.0.2:2\"",
+		"#mysql-replay-events\t1\tmysql\t\"10.0.0.1\"\t\"10.0.0.2:2\"",
+	} {
+		_, err = ParseHeader(line)
+		assert.NotNil(t, err, line)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	ts := time.Date(2021, 6, 1, 8, 0, 0, 123456789, time.UTC)
+	rows := &stream.PacketResRecord{
+		BeginTime: 100,
+		EndTime:   200,
+		Columns: []stream.ColumnInfo{
+			{Name: "id", Type: "BIGINT", Length: 20},
+			{Name: "name", Type: "VARCHAR", Length: 255, Charset: 33},
+		},
+		Rows: [][]stream.Value{
+			{{Kind: stream.KindInt, Data: "1"}, {Kind: stream.KindString, Data: "a\tb\nc"}},
+			{{Kind: stream.KindInt, Data: "2"}, {Kind: stream.KindNull}},
+		},
+	}
+	failed := &stream.PacketResRecord{
+		ErrNo:       1146,
+		ErrDesc:     "Table 'test.t' doesn't exist",
+		SQLState:    "42S02",
+		ErrCategory: util.ErrCategoryServer,
+		BeginTime:   300,
+		EndTime:     301,
+	}
+	ok := &stream.PacketResRecord{AffectedRows: 3, InsertID: 7, BeginTime: 5, EndTime: 6}
+
+	events := []stream.MySQLEvent{
+		{Time: 1, Type: util.EventHandshake, DB: "test", Username: "root", AuthPlugin: "mysql_native_password",
+			Pr: stream.NewPacketResFromRecord(ok)},
+		{Time: 2, Type: util.EventQuery, Query: "select id ,\tname from t", Pr: stream.NewPacketResFromRecord(rows)},
+		{Time: 3, Type: util.EventQuery, Query: "select * from t", Pr: stream.NewPacketResFromRecord(failed)},
+		{Time: 4, Type: util.EventStmtPrepare, StmtID: "1", Query: "insert into t values (?, ?, ?, ?, ?)",
+			Pr: stream.NewPacketResFromRecord(ok)},
+		{Time: 5, Type: util.EventStmtExecute, StmtID: "1",
+			Params: []interface{}{int64(-1), uint64(2), 1.5, "x\ty", []byte{0, 1}, nil, true, ts},
+			Pr:     stream.NewPacketResFromRecord(ok)},
+		{Time: 6, Type: util.EventStmtClose, StmtID: "1"},
+		{Time: 7, Type: util.EventQuit},
+	}
+	for _, e := range events {
+		buf, err := AppendRecord(nil, e)
+		assert.Nil(t, err)
+		assert.NotContains(t, string(buf), "\n")
+
+		var res stream.MySQLEvent
+		err = ParseRecord(string(buf)+"\n", &res)
+		assert.Nil(t, err, string(buf))
+		assert.Equal(t, e.Time, res.Time)
+		assert.Equal(t, e.Type, res.Type)
+		assert.Equal(t, e.StmtID, res.StmtID)
+		assert.Equal(t, e.DB, res.DB)
+		assert.Equal(t, e.Username, res.Username)
+		assert.Equal(t, e.AuthPlugin, res.AuthPlugin)
+		assert.Equal(t, e.Query, res.Query)
+		if e.Type == util.EventStmtExecute {
+			assert.Equal(t, len(e.Params), len(res.Params))
+			assert.Equal(t, e.Params[:6], res.Params[:6])
+			assert.Equal(t, true, res.Params[6])
+			assert.True(t, ts.Equal(res.Params[7].(time.Time)))
+		}
+		if e.Pr == nil {
+			assert.Nil(t, res.Pr)
+			continue
+		}
+		want, err := e.Pr.Record()
+		assert.Nil(t, err)
+		got, err := res.Pr.Record()
+		assert.Nil(t, err)
+		assert.Equal(t, want, got)
+		assert.Equal(t, e.Pr.HasError(), res.Pr.HasError())
+	}
+}
+
+func TestRecordFail(t *testing.T) {
+	var e stream.MySQLEvent
+	for _, line := range []string{
+		"",
+		"1\t3\t\"select 1\"",
+		"1\t3\t\"select 1\"\t{",
+		"1\t9\t\"select 1\"\tnull",
+	} {
+		assert.NotNil(t, ParseRecord(line, &e), line)
+	}
+}
@@ -0,0 +1,154 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package eventlog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+)
+
+//Reader reads the events of an event log in order
+type Reader struct {
+	Header Header
+	name   string
+	f      *os.File
+	r      *bufio.Reader
+	line   int
+	//events read ahead by SeekTime
+	pending []stream.MySQLEvent
+}
+
+func OpenReader(name string) (*Reader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reader{name: name, f: f, r: bufio.NewReaderSize(f, 64*1024)}
+	line, err := r.readLine()
+	if err != nil {
+		f.Close()
+		if err == io.EOF {
+			err = fmt.Errorf("%s is empty", name)
+		}
+		return nil, err
+	}
+	r.Header, err = ParseHeader(line)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return r, nil
+}
+
+func (r *Reader) Name() string {
+	return r.name
+}
+
+//records may be longer than any fixed buffer , so lines are not read
+//by bufio.Scanner
+func (r *Reader) readLine() (string, error) {
+	line, err := r.r.ReadString('\n')
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	r.line++
+	return line, nil
+}
+
+//Next reads the next event , io.EOF is returned at the end of the log
+func (r *Reader) Next(e *stream.MySQLEvent) error {
+	if len(r.pending) > 0 {
+		*e = r.pending[0]
+		r.pending = r.pending[1:]
+		return nil
+	}
+	line, err := r.readLine()
+	if err != nil {
+		return err
+	}
+	*e = stream.MySQLEvent{}
+	err = ParseRecord(line, e)
+	if err != nil {
+		return fmt.Errorf("%s:%d: %v", r.name, r.line, err)
+	}
+	e.Conn = r.Header.Conn
+	return nil
+}
+
+//NextEvent implements Source , an event log holds one connection
+func (r *Reader) NextEvent(e *stream.MySQLEvent) (int, bool, error) {
+	return 0, false, r.Next(e)
+}
+
+//SeekTime skips the events before from , the handshake before from is kept
+//and moved to from so the replay uses the same login
+func (r *Reader) SeekTime(from int64) error {
+	var handshake *stream.MySQLEvent
+	for {
+		var e stream.MySQLEvent
+		err := r.Next(&e)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if e.Time >= from {
+			if handshake != nil {
+				handshake.Time = from
+				r.pending = append(r.pending, *handshake)
+			}
+			r.pending = append(r.pending, e)
+			return nil
+		}
+		if e.Type == util.EventHandshake {
+			handshake = &e
+		}
+	}
+}
+
+func (r *Reader) Close() error {
+	return r.f.Close()
+}
+
+//ListFiles returns the event logs and segments of dir sorted by name
+func ListFiles(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if info.IsDir() || !(strings.HasSuffix(info.Name(), Ext) || strings.HasSuffix(info.Name(), SegmentExt)) {
+			continue
+		}
+		names = append(names, filepath.Join(dir, info.Name()))
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,124 @@
+package eventlog
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func writeLog(t *testing.T, dir string, src string, events ...stream.MySQLEvent) stream.ConnID {
+	conn, err := stream.ParseConnID(src, "10.0.0.2:4000")
+	assert.Nil(t, err)
+	w := NewWriter(conn, zap.NewNop(), dir, util.ProtocolMySQL)
+	for _, e := range events {
+		w.OnEvent(e)
+	}
+	w.OnClose()
+	return conn
+}
+
+func TestReader(t *testing.T) {
+	dir := t.TempDir()
+	conn := writeLog(t, dir, "10.0.0.1:1000",
+		stream.MySQLEvent{Time: 1, Type: util.EventHandshake, DB: "test"},
+		stream.MySQLEvent{Time: 2, Type: util.EventQuery, Query: "select 1"},
+		stream.MySQLEvent{Time: 3, Type: util.EventQuit},
+	)
+	ioutil.WriteFile(filepath.Join(dir, "other.txt"), []byte("x"), 0644)
+
+	names, err := ListFiles(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, FileName(conn, 1))}, names)
+
+	r, err := OpenReader(names[0])
+	assert.Nil(t, err)
+	defer r.Close()
+	assert.Equal(t, conn.String(), r.Header.Conn.String())
+
+	var types []util.MysqlEventType
+	for {
+		var e stream.MySQLEvent
+		err = r.Next(&e)
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, conn.Hash(), e.Conn.Hash())
+		types = append(types, e.Type)
+	}
+	assert.Equal(t, []util.MysqlEventType{util.EventHandshake, util.EventQuery, util.EventQuit}, types)
+}
+
+func TestReader_Fail(t *testing.T) {
+	dir := t.TempDir()
+	_, err := OpenReader(filepath.Join(dir, "not-exist"))
+	assert.NotNil(t, err)
+
+	name := filepath.Join(dir, "empty"+Ext)
+	assert.Nil(t, ioutil.WriteFile(name, nil, 0644))
+	_, err = OpenReader(name)
+	assert.NotNil(t, err)
+
+	name = filepath.Join(dir, "bad"+Ext)
+	assert.Nil(t, ioutil.WriteFile(name, []byte("select 1\n"), 0644))
+	_, err = OpenReader(name)
+	assert.NotNil(t, err)
+
+	_, err = ListFiles(filepath.Join(dir, "not-exist"))
+	assert.NotNil(t, err)
+}
+
+func TestReader_BrokenRecord(t *testing.T) {
+	dir := t.TempDir()
+	conn := writeLog(t, dir, "10.0.0.1:1000", stream.MySQLEvent{Time: 1, Type: util.EventQuit})
+	name := filepath.Join(dir, FileName(conn, 1))
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	//a record cut by a crash has no newline
+	f.WriteString("2\t3\t\"select")
+	f.Close()
+
+	r, err := OpenReader(name)
+	assert.Nil(t, err)
+	defer r.Close()
+	var e stream.MySQLEvent
+	assert.Nil(t, r.Next(&e))
+	assert.NotNil(t, r.Next(&e))
+}
+
+func TestReader_SeekTime(t *testing.T) {
+	dir := t.TempDir()
+	conn := writeLog(t, dir, "10.0.0.1:1000",
+		stream.MySQLEvent{Time: 1, Type: util.EventHandshake, DB: "test", Username: "root"},
+		stream.MySQLEvent{Time: 2, Type: util.EventQuery, Query: "select 1"},
+		stream.MySQLEvent{Time: 5, Type: util.EventQuery, Query: "select 2"},
+		stream.MySQLEvent{Time: 6, Type: util.EventQuit},
+	)
+	r, err := OpenReader(filepath.Join(dir, FileName(conn, 1)))
+	assert.Nil(t, err)
+	defer r.Close()
+	assert.Nil(t, r.SeekTime(3))
+
+	var res []stream.MySQLEvent
+	for {
+		var e stream.MySQLEvent
+		if err = r.Next(&e); err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		res = append(res, e)
+	}
+	assert.Equal(t, 3, len(res))
+	assert.Equal(t, util.EventHandshake, res[0].Type)
+	assert.Equal(t, int64(3), res[0].Time)
+	assert.Equal(t, "root", res[0].Username)
+	assert.Equal(t, "select 2", res[1].Query)
+	assert.Equal(t, util.EventQuit, res[2].Type)
+}
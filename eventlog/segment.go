@@ -0,0 +1,288 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package eventlog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/klauspost/compress/zstd"
+	"go.uber.org/zap"
+)
+
+//Format of the segment , version 1.
+//
+//A segment holds the events of all connections of a capture in the order
+//they were parsed , it is much smaller and faster to scan than event logs.
+//
+//	header   magic(8)  protocol
+//	blocks   zstd frame of binary events (see binary.go) ...
+//	footer   block index  connection index
+//	trailer  footer offset(8 , little endian)  magic(8)
+//
+//The block index holds the offset , size , number of events , time range
+//and connections of every block , so a reader can seek to a time or read
+//the blocks of a connection only. The connection index holds the address ,
+//time range and login of every connection , a connection is identified by
+//its position in the index . The footer is written when the segment is
+//closed , a segment without footer can not be read , the connections are
+//only known by the footer
+const (
+	SegmentMagic   = "MRSEG\x00\x00\x01"
+	SegmentVersion = 1
+	//suffix of segment files
+	SegmentExt = ".seg"
+	//size of a block before compression
+	DefaultBlockSize = 1 << 20
+)
+
+type BlockIndex struct {
+	Offset  int64
+	Size    int64
+	Events  uint64
+	MinTime int64
+	MaxTime int64
+	Conns   []int
+	//sequence number of the first event of the block
+	first uint64
+}
+
+type ConnIndex struct {
+	Conn   stream.ConnID
+	First  int64
+	Last   int64
+	Events uint64
+	//login of the connection , replayed when the reader seeks to a time
+	//after the handshake
+	DB         string
+	Username   string
+	AuthPlugin string
+	lastSeq    uint64
+}
+
+//SegmentName is the name of the segment of a capture started at ts
+func SegmentName(ts int64) string {
+	return fmt.Sprintf("events-%d%s", ts, SegmentExt)
+}
+
+//SegmentWriter writes events of many connections to a segment , it is
+//safe for concurrent use
+type SegmentWriter struct {
+	mu        sync.Mutex
+	f         *os.File
+	enc       *zstd.Encoder
+	log       *zap.Logger
+	blockSize int
+	off       int64
+	seq       uint64
+
+	block      []byte
+	blockIndex BlockIndex
+	blockConns map[int]bool
+	prev       int64
+
+	blocks []BlockIndex
+	conns  []ConnIndex
+	err    error
+}
+
+func CreateSegment(name string, protocol string, blockSize int, log *zap.Logger) (*SegmentWriter, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
+	w := &SegmentWriter{
+		f:          f,
+		enc:        enc,
+		log:        log,
+		blockSize:  blockSize,
+		block:      make([]byte, 0, blockSize+4096),
+		blockConns: make(map[int]bool),
+	}
+	header := append([]byte(SegmentMagic), appendString(nil, protocol)...)
+	if err = w.write(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *SegmentWriter) write(b []byte) error {
+	n, err := w.f.Write(b)
+	w.off += int64(n)
+	return err
+}
+
+//Handler returns the event handler of a new connection
+func (w *SegmentWriter) Handler(conn stream.ConnID) stream.MySQLEventHandler {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.conns = append(w.conns, ConnIndex{Conn: conn})
+	return &segmentHandler{w: w, id: len(w.conns) - 1}
+}
+
+type segmentHandler struct {
+	w  *SegmentWriter
+	id int
+}
+
+func (h *segmentHandler) OnEvent(e stream.MySQLEvent) {
+	h.w.append(h.id, &e)
+}
+
+func (h *segmentHandler) OnClose() {}
+
+func (w *SegmentWriter) append(id int, e *stream.MySQLEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return
+	}
+	n := len(w.block)
+	var err error
+	w.block, err = appendBinaryEvent(w.block, id, w.prev, e)
+	if err != nil {
+		w.block = w.block[:n]
+		w.log.Warn("encode event fail , "+err.Error(), zap.String("event", e.String()))
+		return
+	}
+	w.prev = e.Time
+
+	b := &w.blockIndex
+	if b.Events == 0 || e.Time < b.MinTime {
+		b.MinTime = e.Time
+	}
+	if b.Events == 0 || e.Time > b.MaxTime {
+		b.MaxTime = e.Time
+	}
+	b.Events++
+	if !w.blockConns[id] {
+		w.blockConns[id] = true
+		b.Conns = append(b.Conns, id)
+	}
+
+	c := &w.conns[id]
+	if c.Events == 0 {
+		c.First = e.Time
+	}
+	c.Last = e.Time
+	c.Events++
+	c.lastSeq = w.seq
+	w.seq++
+	if e.Type == util.EventHandshake {
+		c.DB, c.Username, c.AuthPlugin = e.DB, e.Username, e.AuthPlugin
+	}
+
+	if len(w.block) >= w.blockSize {
+		w.err = w.flushBlock()
+		if w.err != nil {
+			w.log.Error("write segment fail , " + w.err.Error())
+		}
+	}
+}
+
+func (w *SegmentWriter) flushBlock() error {
+	if w.blockIndex.Events == 0 {
+		return nil
+	}
+	sort.Ints(w.blockIndex.Conns)
+	data := w.enc.EncodeAll(w.block, nil)
+	w.blockIndex.Offset = w.off
+	w.blockIndex.Size = int64(len(data))
+	if err := w.write(data); err != nil {
+		return err
+	}
+	w.blocks = append(w.blocks, w.blockIndex)
+	w.blockIndex = BlockIndex{}
+	w.blockConns = make(map[int]bool)
+	w.block = w.block[:0]
+	w.prev = 0
+	return nil
+}
+
+//Close writes the last block and the index , the handlers must not be
+//used after Close . The capture commands close the segment on every exit ,
+//also on SIGINT and SIGTERM , only a killed capture leaves a segment which
+//can not be read
+func (w *SegmentWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.err
+	if err == nil {
+		err = w.flushBlock()
+	}
+	if err == nil {
+		footer := w.off
+		buf := appendFooter(nil, w.blocks, w.conns)
+		buf = append(buf, make([]byte, 8)...)
+		binary.LittleEndian.PutUint64(buf[len(buf)-8:], uint64(footer))
+		buf = append(buf, SegmentMagic...)
+		err = w.write(buf)
+	}
+	if err == nil {
+		err = w.f.Sync()
+	}
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	w.enc.Close()
+	if err == nil {
+		w.log.Info(fmt.Sprintf("write %d events of %d connections to %s", w.seq, len(w.conns), w.f.Name()))
+	}
+	w.err = os.ErrClosed
+	return err
+}
+
+func appendFooter(buf []byte, blocks []BlockIndex, conns []ConnIndex) []byte {
+	buf = appendUvarint(buf, uint64(len(blocks)))
+	for _, b := range blocks {
+		buf = appendUvarint(buf, uint64(b.Offset))
+		buf = appendUvarint(buf, uint64(b.Size))
+		buf = appendUvarint(buf, b.Events)
+		buf = appendVarint(buf, b.MinTime)
+		buf = appendVarint(buf, b.MaxTime)
+		buf = appendUvarint(buf, uint64(len(b.Conns)))
+		for _, c := range b.Conns {
+			buf = appendUvarint(buf, uint64(c))
+		}
+	}
+	buf = appendUvarint(buf, uint64(len(conns)))
+	for _, c := range conns {
+		buf = appendString(buf, c.Conn.SrcAddr())
+		buf = appendString(buf, c.Conn.DstAddr())
+		buf = appendVarint(buf, c.First)
+		buf = appendVarint(buf, c.Last)
+		buf = appendUvarint(buf, c.Events)
+		buf = appendUvarint(buf, c.lastSeq)
+		buf = appendString(buf, c.DB)
+		buf = appendString(buf, c.Username)
+		buf = appendString(buf, c.AuthPlugin)
+	}
+	return buf
+}
@@ -0,0 +1,301 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package eventlog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/klauspost/compress/zstd"
+)
+
+//Segment is a segment opened for reading
+type Segment struct {
+	Protocol string
+	Blocks   []BlockIndex
+	Conns    []ConnIndex
+	name     string
+	f        *os.File
+	dec      *zstd.Decoder
+}
+
+func OpenSegment(name string) (*Segment, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	s := &Segment{name: name, f: f}
+	if err = s.readIndex(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	s.dec, err = zstd.NewReader(nil)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Segment) Name() string {
+	return s.name
+}
+
+func (s *Segment) readIndex() error {
+	info, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	head := make([]byte, len(SegmentMagic)+binary.MaxVarintLen64+256)
+	n, err := s.f.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	head = head[:n]
+	if len(head) < len(SegmentMagic) || string(head[:len(SegmentMagic)]) != SegmentMagic {
+		return fmt.Errorf("not a segment of version %d", SegmentVersion)
+	}
+	d := binDecoder{b: head[len(SegmentMagic):]}
+	s.Protocol = d.string()
+	if d.err != nil {
+		return d.err
+	}
+
+	trailer := make([]byte, 8+len(SegmentMagic))
+	if size < int64(len(SegmentMagic)+len(trailer)) {
+		return fmt.Errorf("segment is not closed")
+	}
+	if _, err = s.f.ReadAt(trailer, size-int64(len(trailer))); err != nil {
+		return err
+	}
+	if string(trailer[8:]) != SegmentMagic {
+		return fmt.Errorf("segment is not closed")
+	}
+	footer := int64(binary.LittleEndian.Uint64(trailer))
+	if footer < int64(len(SegmentMagic)) || footer > size-int64(len(trailer)) {
+		return fmt.Errorf("invalid footer offset %d", footer)
+	}
+	buf := make([]byte, size-int64(len(trailer))-footer)
+	if _, err = s.f.ReadAt(buf, footer); err != nil {
+		return err
+	}
+	return s.parseFooter(buf, footer)
+}
+
+//parseFooter parses the index written at offset end , the blocks must lie
+//between the header and end and hold as many events as the connections
+func (s *Segment) parseFooter(buf []byte, end int64) error {
+	d := binDecoder{b: buf}
+	n := d.uvarint()
+	if n > uint64(len(buf)) {
+		return errBinTruncated
+	}
+	var seq uint64
+	s.Blocks = make([]BlockIndex, 0, n)
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		b := BlockIndex{
+			Offset:  int64(d.uvarint()),
+			Size:    int64(d.uvarint()),
+			Events:  d.uvarint(),
+			MinTime: d.varint(),
+			MaxTime: d.varint(),
+			first:   seq,
+		}
+		if d.err != nil {
+			break
+		}
+		if b.Offset < 0 || b.Size <= 0 || b.Size > end-b.Offset {
+			return fmt.Errorf("invalid block %d {offset:%d,size:%d}", i, b.Offset, b.Size)
+		}
+		if b.Events == 0 || seq+b.Events < seq {
+			return fmt.Errorf("invalid block %d {events:%d}", i, b.Events)
+		}
+		seq += b.Events
+		m := d.uvarint()
+		if m > uint64(len(d.b)) {
+			return errBinTruncated
+		}
+		for j := uint64(0); j < m && d.err == nil; j++ {
+			b.Conns = append(b.Conns, int(d.uvarint()))
+		}
+		s.Blocks = append(s.Blocks, b)
+	}
+	n = d.uvarint()
+	if n > uint64(len(d.b)) {
+		return errBinTruncated
+	}
+	s.Conns = make([]ConnIndex, 0, n)
+	var events uint64
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		src, dst := d.string(), d.string()
+		c := ConnIndex{
+			First:      d.varint(),
+			Last:       d.varint(),
+			Events:     d.uvarint(),
+			lastSeq:    d.uvarint(),
+			DB:         d.string(),
+			Username:   d.string(),
+			AuthPlugin: d.string(),
+		}
+		if d.err != nil {
+			break
+		}
+		if c.Events > seq-events || (c.Events > 0 && c.lastSeq >= seq) {
+			return fmt.Errorf("invalid connection %d {events:%d}", i, c.Events)
+		}
+		events += c.Events
+		var err error
+		c.Conn, err = stream.ParseConnID(src, dst)
+		if err != nil {
+			return err
+		}
+		s.Conns = append(s.Conns, c)
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if events != seq {
+		return fmt.Errorf("blocks hold %d events , connections %d", seq, events)
+	}
+	return nil
+}
+
+func (s *Segment) readBlock(i int, buf []byte) ([]byte, error) {
+	b := s.Blocks[i]
+	data := make([]byte, b.Size)
+	if _, err := s.f.ReadAt(data, b.Offset); err != nil {
+		return nil, err
+	}
+	return s.dec.DecodeAll(data, buf[:0])
+}
+
+func (s *Segment) Close() error {
+	s.dec.Close()
+	return s.f.Close()
+}
+
+//Events returns the events at or after from , 0 means all events.
+//Blocks ending before from are not read , a connection that logged in
+//before from gets its handshake first so the replay uses the same login
+func (s *Segment) Events(from int64) *SegmentIterator {
+	it := &SegmentIterator{s: s, from: from, conn: -1}
+	for i, b := range s.Blocks {
+		if b.MaxTime >= from {
+			it.blocks = append(it.blocks, i)
+		}
+	}
+	if from == 0 {
+		return it
+	}
+	for i, c := range s.Conns {
+		if c.Events > 0 && c.First < from && c.Last >= from && len(c.Username) > 0 {
+			it.handshakes = append(it.handshakes, i)
+		}
+	}
+	return it
+}
+
+//ConnEvents returns the events of a connection , only the blocks holding
+//the connection are read
+func (s *Segment) ConnEvents(conn int) *SegmentIterator {
+	it := &SegmentIterator{s: s, conn: conn}
+	for i, b := range s.Blocks {
+		j := sort.SearchInts(b.Conns, conn)
+		if j < len(b.Conns) && b.Conns[j] == conn {
+			it.blocks = append(it.blocks, i)
+		}
+	}
+	return it
+}
+
+//SegmentIterator reads events of a segment in the order they were written
+type SegmentIterator struct {
+	s          *Segment
+	from       int64
+	conn       int
+	blocks     []int
+	handshakes []int
+
+	buf  []byte
+	dec  binDecoder
+	left uint64
+	seq  uint64
+	prev int64
+	//the event read by Next is the last one of its connection
+	last bool
+}
+
+//Next reads the next event and returns its connection , io.EOF is
+//returned after the last event
+func (it *SegmentIterator) Next(e *stream.MySQLEvent) (int, error) {
+	if len(it.handshakes) > 0 {
+		id := it.handshakes[0]
+		it.handshakes = it.handshakes[1:]
+		c := &it.s.Conns[id]
+		*e = stream.MySQLEvent{Conn: c.Conn, Time: it.from, Type: util.EventHandshake,
+			DB: c.DB, Username: c.Username, AuthPlugin: c.AuthPlugin}
+		//the connection has events after from
+		it.last = false
+		return id, nil
+	}
+	for {
+		for it.left == 0 {
+			if len(it.blocks) == 0 {
+				return 0, io.EOF
+			}
+			i := it.blocks[0]
+			it.blocks = it.blocks[1:]
+			var err error
+			it.buf, err = it.s.readBlock(i, it.buf)
+			if err != nil {
+				return 0, fmt.Errorf("%s: read block %d: %v", it.s.name, i, err)
+			}
+			it.dec = binDecoder{b: it.buf}
+			it.left = it.s.Blocks[i].Events
+			it.seq = it.s.Blocks[i].first
+			it.prev = 0
+		}
+		*e = stream.MySQLEvent{}
+		id := it.dec.event(it.prev, e)
+		if it.dec.err != nil {
+			return 0, fmt.Errorf("%s: event %d: %v", it.s.name, it.seq, it.dec.err)
+		}
+		if id < 0 || id >= len(it.s.Conns) {
+			return 0, fmt.Errorf("%s: event %d: unknown connection %d", it.s.name, it.seq, id)
+		}
+		it.prev = e.Time
+		it.left--
+		it.seq++
+		if (it.conn >= 0 && id != it.conn) || e.Time < it.from {
+			continue
+		}
+		e.Conn = it.s.Conns[id].Conn
+		it.last = it.seq-1 == it.s.Conns[id].lastSeq
+		return id, nil
+	}
+}
+
+//NextEvent implements Source
+func (it *SegmentIterator) NextEvent(e *stream.MySQLEvent) (int, bool, error) {
+	id, err := it.Next(e)
+	return id, it.last, err
+}
@@ -0,0 +1,186 @@
+package eventlog
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+//writeSegment writes conns connections of n events , the events of all
+//connections are interleaved and event i of a connection is at 10*i+conn
+func writeSegment(t *testing.T, dir string, conns int, n int, blockSize int) string {
+	name := filepath.Join(dir, SegmentName(1))
+	w, err := CreateSegment(name, util.ProtocolMySQL, blockSize, zap.NewNop())
+	assert.Nil(t, err)
+	var handlers []stream.MySQLEventHandler
+	for c := 0; c < conns; c++ {
+		conn, err := stream.ParseConnID(fmt.Sprintf("10.0.0.1:%d", 1000+c), "10.0.0.2:3306")
+		assert.Nil(t, err)
+		handlers = append(handlers, w.Handler(conn))
+	}
+	for i := 0; i < n; i++ {
+		for c, h := range handlers {
+			e := stream.MySQLEvent{Time: int64(10*i + c), Type: util.EventQuery, Query: fmt.Sprintf("select %d", i)}
+			if i == 0 {
+				e = stream.MySQLEvent{Time: int64(c), Type: util.EventHandshake, DB: "db", Username: fmt.Sprintf("u%d", c)}
+			}
+			h.OnEvent(e)
+		}
+	}
+	for _, h := range handlers {
+		h.OnClose()
+	}
+	assert.Nil(t, w.Close())
+	return name
+}
+
+func readAll(t *testing.T, it *SegmentIterator) ([]stream.MySQLEvent, []int, []bool) {
+	var events []stream.MySQLEvent
+	var conns []int
+	var lasts []bool
+	for {
+		var e stream.MySQLEvent
+		conn, last, err := it.NextEvent(&e)
+		if err == io.EOF {
+			return events, conns, lasts
+		}
+		assert.Nil(t, err)
+		events = append(events, e)
+		conns = append(conns, conn)
+		lasts = append(lasts, last)
+	}
+}
+
+func TestSegment(t *testing.T) {
+	name := writeSegment(t, t.TempDir(), 3, 100, 512)
+	s, err := OpenSegment(name)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	assert.Equal(t, util.ProtocolMySQL, s.Protocol)
+	assert.True(t, len(s.Blocks) > 1)
+	assert.Equal(t, 3, len(s.Conns))
+	c := s.Conns[1]
+	assert.Equal(t, "10.0.0.1:1001", c.Conn.SrcAddr())
+	assert.Equal(t, int64(1), c.First)
+	assert.Equal(t, int64(991), c.Last)
+	assert.Equal(t, uint64(100), c.Events)
+	assert.Equal(t, "u1", c.Username)
+
+	events, conns, lasts := readAll(t, s.Events(0))
+	assert.Equal(t, 300, len(events))
+	for i, e := range events {
+		assert.Equal(t, i%3, conns[i])
+		assert.Equal(t, int64(10*(i/3)+i%3), e.Time)
+		assert.Equal(t, s.Conns[conns[i]].Conn.String(), e.Conn.String())
+		assert.Equal(t, i >= 297, lasts[i])
+	}
+	assert.Equal(t, "select 99", events[299].Query)
+
+	events, conns, _ = readAll(t, s.ConnEvents(2))
+	assert.Equal(t, 100, len(events))
+	for i, e := range events {
+		assert.Equal(t, 2, conns[i])
+		assert.Equal(t, int64(10*i+2), e.Time)
+	}
+}
+
+func TestSegment_SeekTime(t *testing.T) {
+	name := writeSegment(t, t.TempDir(), 2, 100, 256)
+	s, err := OpenSegment(name)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	events, conns, _ := readAll(t, s.Events(505))
+	//login of both connections , then the events from 505
+	assert.Equal(t, util.EventHandshake, events[0].Type)
+	assert.Equal(t, "u0", events[0].Username)
+	assert.Equal(t, int64(505), events[0].Time)
+	assert.Equal(t, "u1", events[1].Username)
+	assert.Equal(t, []int{0, 1, 0, 1}, conns[:4])
+	assert.Equal(t, int64(510), events[2].Time)
+	assert.Equal(t, int64(511), events[3].Time)
+	assert.Equal(t, 2+49*2, len(events))
+
+	events, _, _ = readAll(t, s.Events(10000))
+	assert.Equal(t, 0, len(events))
+}
+
+func TestSegment_Fail(t *testing.T) {
+	dir := t.TempDir()
+	_, err := OpenSegment(filepath.Join(dir, "not-exist"))
+	assert.NotNil(t, err)
+
+	name := filepath.Join(dir, "bad"+SegmentExt)
+	assert.Nil(t, ioutil.WriteFile(name, []byte("select 1"), 0644))
+	_, err = OpenSegment(name)
+	assert.NotNil(t, err)
+
+	//a segment is not readable before it is closed
+	name = filepath.Join(dir, "open"+SegmentExt)
+	w, err := CreateSegment(name, util.ProtocolMySQL, 0, zap.NewNop())
+	assert.Nil(t, err)
+	_, err = OpenSegment(name)
+	assert.NotNil(t, err)
+	assert.Nil(t, w.Close())
+	s, err := OpenSegment(name)
+	assert.Nil(t, err)
+	s.Close()
+
+	_, err = CreateSegment(name, util.ProtocolMySQL, 0, zap.NewNop())
+	assert.NotNil(t, err)
+}
+
+func TestSegment_ParseFooter(t *testing.T) {
+	conn, err := stream.ParseConnID("10.0.0.1:1000", "10.0.0.2:3306")
+	assert.Nil(t, err)
+	conns := []ConnIndex{{Conn: conn, Events: 2, lastSeq: 1}}
+	for _, tt := range []struct {
+		block BlockIndex
+		ok    bool
+	}{
+		{BlockIndex{Offset: 16, Size: 84, Events: 2}, true},
+		{BlockIndex{Offset: -1, Size: 84, Events: 2}, false},
+		{BlockIndex{Offset: 16, Size: -1, Events: 2}, false},
+		{BlockIndex{Offset: 16, Size: 0, Events: 2}, false},
+		{BlockIndex{Offset: 16, Size: 85, Events: 2}, false},
+		{BlockIndex{Offset: 16, Size: 1 << 62, Events: 2}, false},
+		{BlockIndex{Offset: 16, Size: 84, Events: 0}, false},
+		{BlockIndex{Offset: 16, Size: 84, Events: 3}, false},
+		{BlockIndex{Offset: 16, Size: 84, Events: 1 << 62}, false},
+	} {
+		s := &Segment{}
+		err := s.parseFooter(appendFooter(nil, []BlockIndex{tt.block}, conns), 100)
+		assert.Equal(t, tt.ok, err == nil, "%+v", tt.block)
+	}
+}
+
+func TestSegment_Dispatch(t *testing.T) {
+	name := writeSegment(t, t.TempDir(), 2, 3, 64)
+	s, err := OpenSegment(name)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	var res []string
+	num := Dispatch([]Source{s.Events(0)}, func(conn stream.ConnID) stream.MySQLEventHandler {
+		return &recordHandler{conn: conn, res: &res}
+	}, zap.NewNop())
+	assert.Equal(t, 6, num)
+	assert.Equal(t, []string{
+		"10.0.0.1:1000 0",
+		"10.0.0.1:1001 1",
+		"10.0.0.1:1000 10",
+		"10.0.0.1:1001 11",
+		"10.0.0.1:1000 20",
+		"10.0.0.1:1000 close",
+		"10.0.0.1:1001 21",
+		"10.0.0.1:1001 close",
+	}, res)
+}
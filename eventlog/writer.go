@@ -0,0 +1,116 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package eventlog
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"go.uber.org/zap"
+)
+
+//FileName is the name of the event log of a connection , ts is the time
+//of the first event , the same address may be reused by a later connection
+func FileName(conn stream.ConnID, ts int64) string {
+	return fmt.Sprintf("%s-%d%s", conn.HashStr(), ts, Ext)
+}
+
+//Writer writes the events of a connection to an event log , it is the
+//event handler of capture
+type Writer struct {
+	conn     stream.ConnID
+	log      *zap.Logger
+	dir      string
+	protocol string
+
+	file *os.File
+	w    *bufio.Writer
+	buf  []byte
+	num  uint64
+	//do not retry to create a file for every event
+	failed bool
+}
+
+func NewWriter(conn stream.ConnID, log *zap.Logger, dir string, protocol string) *Writer {
+	return &Writer{
+		conn:     conn,
+		log:      log,
+		dir:      dir,
+		protocol: protocol,
+		buf:      make([]byte, 0, 4096),
+	}
+}
+
+//the file is created by the first event , a connection without events
+//leaves no file
+func (w *Writer) open(ts int64) error {
+	name := filepath.Join(w.dir, FileName(w.conn, ts))
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	w.w = bufio.NewWriterSize(f, 64*1024)
+	w.buf = AppendHeader(w.buf[:0], Header{Version: Version, Protocol: w.protocol, Conn: w.conn})
+	w.buf = append(w.buf, '\n')
+	_, err = w.w.Write(w.buf)
+	return err
+}
+
+func (w *Writer) OnEvent(e stream.MySQLEvent) {
+	if w.failed {
+		return
+	}
+	var err error
+	if w.file == nil {
+		err = w.open(e.Time)
+		if err != nil {
+			w.log.Error("create event log fail , " + err.Error())
+			w.failed = true
+			return
+		}
+	}
+	w.buf, err = AppendRecord(w.buf[:0], e)
+	if err != nil {
+		w.log.Warn("encode event fail , "+err.Error(), zap.String("event", e.String()))
+		return
+	}
+	w.buf = append(w.buf, '\n')
+	_, err = w.w.Write(w.buf)
+	if err != nil {
+		w.log.Error("write event log fail , " + err.Error())
+		w.failed = true
+		return
+	}
+	w.num++
+}
+
+func (w *Writer) OnClose() {
+	if w.file == nil {
+		return
+	}
+	if err := w.w.Flush(); err != nil {
+		w.log.Error("flush event log fail , " + err.Error())
+	}
+	if err := w.file.Close(); err != nil {
+		w.log.Error("close event log fail , " + err.Error())
+	}
+	w.log.Info(fmt.Sprintf("write %d events to %s", w.num, w.file.Name()))
+	w.file = nil
+}
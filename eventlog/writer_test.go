@@ -0,0 +1,74 @@
+package eventlog
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestWriter(t *testing.T) {
+	dir := t.TempDir()
+	conn, err := stream.ParseConnID("10.0.0.1:51234", "10.0.0.2:4000")
+	assert.Nil(t, err)
+
+	w := NewWriter(conn, zap.NewNop(), dir, util.ProtocolMySQL)
+	events := []stream.MySQLEvent{
+		{Time: 10, Type: util.EventHandshake, DB: "test", Username: "root"},
+		{Time: 11, Type: util.EventQuery, Query: "select 1",
+			Pr: stream.NewPacketResFromRecord(&stream.PacketResRecord{
+				Columns: []stream.ColumnInfo{{Name: "1", Type: "BIGINT"}},
+				Rows:    [][]stream.Value{{{Kind: stream.KindInt, Data: "1"}}},
+			})},
+		{Time: 12, Type: util.EventQuit},
+	}
+	for _, e := range events {
+		w.OnEvent(e)
+	}
+	w.OnClose()
+
+	f, err := os.Open(filepath.Join(dir, FileName(conn, 10)))
+	assert.Nil(t, err)
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	assert.True(t, s.Scan())
+	h, err := ParseHeader(s.Text())
+	assert.Nil(t, err)
+	assert.Equal(t, util.ProtocolMySQL, h.Protocol)
+	assert.Equal(t, conn.String(), h.Conn.String())
+
+	var res []stream.MySQLEvent
+	for s.Scan() {
+		var e stream.MySQLEvent
+		assert.Nil(t, ParseRecord(s.Text(), &e))
+		res = append(res, e)
+	}
+	assert.Equal(t, len(events), len(res))
+	assert.Equal(t, "select 1", res[1].Query)
+	assert.Equal(t, []stream.ColumnInfo{{Name: "1", Type: "BIGINT"}}, res[1].Pr.GetColumns())
+}
+
+func TestWriter_NoEvent(t *testing.T) {
+	dir := t.TempDir()
+	conn, err := stream.ParseConnID("10.0.0.1:51234", "10.0.0.2:4000")
+	assert.Nil(t, err)
+	w := NewWriter(conn, zap.NewNop(), dir, util.ProtocolMySQL)
+	w.OnClose()
+	files, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(files))
+}
+
+func TestWriter_CreateFail(t *testing.T) {
+	conn, err := stream.ParseConnID("10.0.0.1:51234", "10.0.0.2:4000")
+	assert.Nil(t, err)
+	w := NewWriter(conn, zap.NewNop(), filepath.Join(t.TempDir(), "not-exist"), util.ProtocolMySQL)
+	w.OnEvent(stream.MySQLEvent{Time: 1, Type: util.EventQuit})
+	assert.True(t, w.failed)
+	w.OnClose()
+}
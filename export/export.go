@@ -0,0 +1,298 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bobguo/mysql-replay/mask"
+	"github.com/bobguo/mysql-replay/parse"
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"go.uber.org/zap"
+)
+
+const (
+	GeneralLogName = "general.log"
+	SlowLogName    = "slow.log"
+	ScriptExt      = ".sql"
+)
+
+//ScriptName is the name of the SQL script of a connection , ts is the
+//time of the first event
+func ScriptName(conn stream.ConnID, ts int64) string {
+	return fmt.Sprintf("%s-%d%s", conn.HashStr(), ts, ScriptExt)
+}
+
+//Exporter converts the events of all connections to one of the formats ,
+//a SQL script per connection , or a general log or slow log of MySQL
+//shared by all connections
+type Exporter struct {
+	format string
+	dir    string
+	log    *zap.Logger
+
+	mu   sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+	//MySQL thread id is unknown , connections are numbered from 1
+	id     uint64
+	failed bool
+}
+
+func NewExporter(format string, dir string, port uint16, log *zap.Logger) (*Exporter, error) {
+	x := &Exporter{format: format, dir: dir, log: log}
+	var name string
+	switch format {
+	case util.ExportFormatSQL:
+		return x, nil
+	case util.ExportFormatGeneralLog:
+		name = GeneralLogName
+	case util.ExportFormatSlowLog:
+		name = SlowLogName
+	default:
+		return nil, fmt.Errorf("unsupported export format %s", format)
+	}
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	x.file = f
+	x.w = bufio.NewWriterSize(f, 64*1024)
+	x.write([]byte(fmt.Sprintf("/usr/sbin/mysqld, Version: 5.7.0 (mysql-replay export). started with:\n"+
+		"Tcp port: %d  Unix socket: (null)\nTime                 Id Command    Argument\n", port)))
+	return x, nil
+}
+
+//Handler returns the event handler of a connection
+func (x *Exporter) Handler(conn stream.ConnID) stream.MySQLEventHandler {
+	x.mu.Lock()
+	x.id++
+	id := x.id
+	x.mu.Unlock()
+	return &connHandler{
+		x:     x,
+		conn:  conn,
+		log:   conn.Logger("export"),
+		id:    id,
+		stmts: make(map[string]string),
+	}
+}
+
+func (x *Exporter) write(b []byte) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.failed {
+		return
+	}
+	if _, err := x.w.Write(b); err != nil {
+		x.log.Error("write " + x.file.Name() + " fail , " + err.Error())
+		x.failed = true
+	}
+}
+
+//Close flushes the shared log , it is called after all connections
+//are closed
+func (x *Exporter) Close() error {
+	if x.file == nil {
+		return nil
+	}
+	err := x.w.Flush()
+	if cerr := x.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+type connHandler struct {
+	x     *Exporter
+	conn  stream.ConnID
+	log   *zap.Logger
+	id    uint64
+	user  string
+	db    string
+	stmts map[string]string
+	buf   []byte
+
+	//SQL script of the connection
+	file   *os.File
+	w      *bufio.Writer
+	failed bool
+}
+
+func (h *connHandler) OnEvent(e stream.MySQLEvent) {
+	var query string
+	switch e.Type {
+	case util.EventHandshake:
+		h.user, h.db = e.Username, e.DB
+	case util.EventQuery:
+		if db, ok := parse.UseDB(e.Query); ok {
+			h.db = db
+		}
+		query = mask.Default.Query(h.db, e.Query)
+	case util.EventStmtPrepare:
+		h.stmts[e.StmtID] = e.Query
+		query = mask.Default.Query(h.db, e.Query)
+	case util.EventStmtExecute:
+		stmt, ok := h.stmts[e.StmtID]
+		if !ok {
+			h.log.Warn("execute unknown prepared statement " + e.StmtID)
+			return
+		}
+		var err error
+		query, err = stream.BindParams(stmt, mask.Default.Params(h.db, stmt, e.Params))
+		if err != nil {
+			h.log.Warn("bind params fail , " + err.Error())
+			return
+		}
+	case util.EventStmtClose:
+		delete(h.stmts, e.StmtID)
+	}
+
+	switch h.x.format {
+	case util.ExportFormatSQL:
+		h.writeScript(&e, query)
+	case util.ExportFormatGeneralLog:
+		h.buf = h.appendGeneral(h.buf[:0], &e, query)
+		h.x.write(h.buf)
+	case util.ExportFormatSlowLog:
+		if e.Type != util.EventQuery && e.Type != util.EventStmtExecute {
+			return
+		}
+		h.buf = h.appendSlow(h.buf[:0], &e, query)
+		h.x.write(h.buf)
+	}
+}
+
+func (h *connHandler) writeScript(e *stream.MySQLEvent, query string) {
+	if h.failed {
+		return
+	}
+	buf := h.buf[:0]
+	switch e.Type {
+	case util.EventHandshake:
+		buf = append(buf, fmt.Sprintf("-- connect %s@%s\n", h.user, h.conn.SrcAddr())...)
+		if len(h.db) > 0 {
+			buf = append(buf, "USE `"+strings.ReplaceAll(h.db, "`", "``")+"`;\n"...)
+		}
+	case util.EventQuery, util.EventStmtExecute:
+		buf = appendStatement(buf, query)
+	default:
+		return
+	}
+	h.buf = buf
+	if h.file == nil {
+		f, err := os.Create(filepath.Join(h.x.dir, ScriptName(h.conn, e.Time)))
+		if err != nil {
+			h.log.Error("create sql script fail , " + err.Error())
+			h.failed = true
+			return
+		}
+		h.file = f
+		h.w = bufio.NewWriterSize(f, 64*1024)
+	}
+	if _, err := h.w.Write(buf); err != nil {
+		h.log.Error("write sql script fail , " + err.Error())
+		h.failed = true
+	}
+}
+
+//general log of MySQL 5.7 , log_timestamps is UTC
+func (h *connHandler) appendGeneral(buf []byte, e *stream.MySQLEvent, query string) []byte {
+	var command, arg string
+	switch e.Type {
+	case util.EventHandshake:
+		command = "Connect"
+		arg = fmt.Sprintf("%s@%s on %s using TCP/IP", h.user, h.conn[0].Src().String(), h.db)
+		if e.Pr != nil && e.Pr.HasError() {
+			arg = e.Pr.GetErrDesc()
+		}
+	case util.EventQuery:
+		command, arg = "Query", query
+	case util.EventStmtPrepare:
+		command, arg = "Prepare", query
+	case util.EventStmtExecute:
+		command, arg = "Execute", query
+	case util.EventStmtClose:
+		command = "Close stmt"
+	case util.EventQuit:
+		command = "Quit"
+	default:
+		return buf
+	}
+	buf = append(buf, formatTime(e.Time)...)
+	buf = append(buf, fmt.Sprintf("\t%6d %s\t%s\n", h.id, command, arg)...)
+	return buf
+}
+
+//slow log of MySQL 5.7 , the time of the query is read from the packets
+//of the request and the response
+func (h *connHandler) appendSlow(buf []byte, e *stream.MySQLEvent, query string) []byte {
+	begin, end := e.Time, e.Time
+	var rowsSent, rowsAffected uint64
+	if e.Pr != nil {
+		if e.Pr.GetSqlBeginTime() > 0 {
+			begin = int64(e.Pr.GetSqlBeginTime())
+		}
+		if e.Pr.GetSqlEndTime() >= uint64(begin) {
+			end = int64(e.Pr.GetSqlEndTime())
+		}
+		rowsSent = uint64(len(e.Pr.GetColumnVal()))
+		rowsAffected = e.Pr.GetAffectedRows()
+	}
+	if end < begin {
+		end = begin
+	}
+	buf = append(buf, "# Time: "...)
+	buf = append(buf, formatTime(end)...)
+	buf = append(buf, fmt.Sprintf("\n# User@Host: %s[%s] @  [%s]  Id: %5d\n", h.user, h.user, h.conn[0].Src().String(), h.id)...)
+	buf = append(buf, fmt.Sprintf("# Query_time: %.6f  Lock_time: 0.000000 Rows_sent: %d  Rows_examined: 0 Rows_affected: %d\n",
+		float64(end-begin)/float64(time.Second), rowsSent, rowsAffected)...)
+	if len(h.db) > 0 {
+		buf = append(buf, "use "+h.db+";\n"...)
+	}
+	buf = append(buf, fmt.Sprintf("SET timestamp=%d;\n", begin/int64(time.Second))...)
+	return appendStatement(buf, query)
+}
+
+func (h *connHandler) OnClose() {
+	if h.file == nil {
+		return
+	}
+	if err := h.w.Flush(); err != nil {
+		h.log.Error("flush sql script fail , " + err.Error())
+	}
+	if err := h.file.Close(); err != nil {
+		h.log.Error("close sql script fail , " + err.Error())
+	}
+	h.file = nil
+}
+
+func formatTime(ts int64) string {
+	return time.Unix(0, ts).UTC().Format("2006-01-02T15:04:05.000000Z")
+}
+
+func appendStatement(buf []byte, query string) []byte {
+	query = strings.TrimRight(query, " \t\r\n;")
+	buf = append(buf, query...)
+	return append(buf, ";\n"...)
+}
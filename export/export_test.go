@@ -0,0 +1,109 @@
+package export
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+//2021-11-29T12:49:10Z
+const ts = int64(1638190150) * 1000000000
+
+func exportEvents(t *testing.T, format string) (string, stream.ConnID) {
+	dir := t.TempDir()
+	x, err := NewExporter(format, dir, 3306, zap.NewNop())
+	assert.Nil(t, err)
+	conn, err := stream.ParseConnID("10.0.0.1:1000", "10.0.0.2:3306")
+	assert.Nil(t, err)
+
+	res := stream.NewPacketResFromRecord(&stream.PacketResRecord{
+		BeginTime: uint64(ts + 1000),
+		EndTime:   uint64(ts + 2500000),
+		Rows:      [][]stream.Value{{{Kind: stream.KindString, Data: "1"}}, {{Kind: stream.KindString, Data: "2"}}},
+	})
+	h := x.Handler(conn)
+	for _, e := range []stream.MySQLEvent{
+		{Time: ts, Type: util.EventHandshake, DB: "test", Username: "root"},
+		{Time: ts + 1000, Type: util.EventQuery, Query: "select a from t;", Pr: res},
+		{Time: ts + 3000000, Type: util.EventStmtPrepare, StmtID: "1", Query: "insert into t values (?, ?)"},
+		{Time: ts + 4000000, Type: util.EventStmtExecute, StmtID: "1", Params: []interface{}{int64(1), "it's"}},
+		{Time: ts + 5000000, Type: util.EventStmtExecute, StmtID: "2", Params: []interface{}{int64(1)}},
+		{Time: ts + 6000000, Type: util.EventStmtClose, StmtID: "1"},
+		{Time: ts + 7000000, Type: util.EventQuery, Query: "use db2"},
+		{Time: ts + 8000000, Type: util.EventQuit},
+	} {
+		h.OnEvent(e)
+	}
+	h.OnClose()
+	assert.Nil(t, x.Close())
+	return dir, conn
+}
+
+func readFile(t *testing.T, name string) string {
+	b, err := ioutil.ReadFile(name)
+	assert.Nil(t, err)
+	return string(b)
+}
+
+func TestExporter_SQL(t *testing.T) {
+	dir, conn := exportEvents(t, util.ExportFormatSQL)
+	assert.Equal(t, "-- connect root@10.0.0.1:1000\n"+
+		"USE `test`;\n"+
+		"select a from t;\n"+
+		"insert into t values (1, 'it\\'s');\n"+
+		"use db2;\n",
+		readFile(t, filepath.Join(dir, ScriptName(conn, ts))))
+}
+
+func TestExporter_GeneralLog(t *testing.T) {
+	dir, _ := exportEvents(t, util.ExportFormatGeneralLog)
+	assert.Equal(t, "/usr/sbin/mysqld, Version: 5.7.0 (mysql-replay export). started with:\n"+
+		"Tcp port: 3306  Unix socket: (null)\n"+
+		"Time                 Id Command    Argument\n"+
+		"2021-11-29T12:49:10.000000Z\t     1 Connect\troot@10.0.0.1 on test using TCP/IP\n"+
+		"2021-11-29T12:49:10.000001Z\t     1 Query\tselect a from t;\n"+
+		"2021-11-29T12:49:10.003000Z\t     1 Prepare\tinsert into t values (?, ?)\n"+
+		"2021-11-29T12:49:10.004000Z\t     1 Execute\tinsert into t values (1, 'it\\'s')\n"+
+		"2021-11-29T12:49:10.006000Z\t     1 Close stmt\t\n"+
+		"2021-11-29T12:49:10.007000Z\t     1 Query\tuse db2\n"+
+		"2021-11-29T12:49:10.008000Z\t     1 Quit\t\n",
+		readFile(t, filepath.Join(dir, GeneralLogName)))
+}
+
+func TestExporter_SlowLog(t *testing.T) {
+	dir, _ := exportEvents(t, util.ExportFormatSlowLog)
+	assert.Equal(t, "/usr/sbin/mysqld, Version: 5.7.0 (mysql-replay export). started with:\n"+
+		"Tcp port: 3306  Unix socket: (null)\n"+
+		"Time                 Id Command    Argument\n"+
+		"# Time: 2021-11-29T12:49:10.002500Z\n"+
+		"# User@Host: root[root] @  [10.0.0.1]  Id:     1\n"+
+		"# Query_time: 0.002499  Lock_time: 0.000000 Rows_sent: 2  Rows_examined: 0 Rows_affected: 0\n"+
+		"use test;\n"+
+		"SET timestamp=1638190150;\n"+
+		"select a from t;\n"+
+		"# Time: 2021-11-29T12:49:10.004000Z\n"+
+		"# User@Host: root[root] @  [10.0.0.1]  Id:     1\n"+
+		"# Query_time: 0.000000  Lock_time: 0.000000 Rows_sent: 0  Rows_examined: 0 Rows_affected: 0\n"+
+		"use test;\n"+
+		"SET timestamp=1638190150;\n"+
+		"insert into t values (1, 'it\\'s');\n"+
+		"# Time: 2021-11-29T12:49:10.007000Z\n"+
+		"# User@Host: root[root] @  [10.0.0.1]  Id:     1\n"+
+		"# Query_time: 0.000000  Lock_time: 0.000000 Rows_sent: 0  Rows_examined: 0 Rows_affected: 0\n"+
+		"use db2;\n"+
+		"SET timestamp=1638190150;\n"+
+		"use db2;\n",
+		readFile(t, filepath.Join(dir, SlowLogName)))
+}
+
+func TestExporter_Fail(t *testing.T) {
+	_, err := NewExporter("csv", t.TempDir(), 0, zap.NewNop())
+	assert.NotNil(t, err)
+	_, err = NewExporter(util.ExportFormatSlowLog, filepath.Join(t.TempDir(), "not-exist"), 0, zap.NewNop())
+	assert.NotNil(t, err)
+}
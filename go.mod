@@ -5,8 +5,10 @@ go 1.16
 require (
 	github.com/DATA-DOG/go-sqlmock v1.5.0
 	github.com/agiledragon/gomonkey v2.0.2+incompatible
-	github.com/go-sql-driver/mysql v1.6.0
+	github.com/go-sql-driver/mysql v1.7.0
 	github.com/google/gopacket v1.1.19
+	github.com/klauspost/compress v1.13.6
+	github.com/lib/pq v1.10.9
 	github.com/pingcap/errors v0.11.5-0.20210425183316-da1aaba5fb63
 	github.com/pingcap/tidb/parser v0.0.0-20211129063751-df113a124204
 	github.com/pkg/profile v1.6.0
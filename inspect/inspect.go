@@ -0,0 +1,288 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package inspect
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bobguo/mysql-replay/mask"
+	"github.com/bobguo/mysql-replay/parse"
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+)
+
+//OutputName is the file written in the output dir
+const OutputName = "inspect.txt"
+
+//Options filter the connections , packets and commands printed , times
+//are in nanoseconds and zero means no limit
+type Options struct {
+	//client address ip:port or hash of the connection
+	Conn string
+	//names of event types , e.g. query and stmt_execute
+	Types   []string
+	From    int64
+	To      int64
+	Hex     bool
+	MaxRows int
+}
+
+//Inspector prints the conversations decoded from packets , the output
+//of a connection is written once the connection is closed
+type Inspector struct {
+	opts  Options
+	types map[util.MysqlEventType]bool
+
+	mu sync.Mutex
+	w  io.Writer
+	//first error writing the output
+	err error
+}
+
+func New(w io.Writer, opts Options) (*Inspector, error) {
+	x := &Inspector{opts: opts, w: w}
+	if len(opts.Types) == 0 {
+		return x, nil
+	}
+	x.types = make(map[util.MysqlEventType]bool)
+	for _, name := range opts.Types {
+		t, ok := eventType(strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("unknown command type %s", name)
+		}
+		x.types[t] = true
+	}
+	return x, nil
+}
+
+func eventType(name string) (util.MysqlEventType, bool) {
+	for t := util.EventHandshake; t <= util.EventStmtClose; t++ {
+		if t.String() == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+//Match tells if the connection is inspected
+func (x *Inspector) Match(conn stream.ConnID) bool {
+	c := x.opts.Conn
+	return len(c) == 0 || c == conn.HashStr() || c == conn.SrcAddr() || c == conn.DstAddr()
+}
+
+//Handler returns the event handler of a connection , nil if the
+//connection is not inspected
+func (x *Inspector) Handler(conn stream.ConnID) stream.MySQLEventHandler {
+	if !x.Match(conn) {
+		return nil
+	}
+	return &connHandler{x: x, conn: conn, stmts: make(map[string]string)}
+}
+
+//Err returns the first error writing the output
+func (x *Inspector) Err() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.err
+}
+
+func (x *Inspector) inTime(ts int64) bool {
+	return (x.opts.From == 0 || ts >= x.opts.From) && (x.opts.To == 0 || ts <= x.opts.To)
+}
+
+type connHandler struct {
+	x     *Inspector
+	conn  stream.ConnID
+	db    string
+	stmts map[string]string
+	//states of the decoder since the last event
+	states []string
+	buf    bytes.Buffer
+}
+
+func formatTime(ts int64) string {
+	return time.Unix(0, ts).UTC().Format("2006-01-02T15:04:05.000000Z")
+}
+
+func (h *connHandler) printf(format string, args ...interface{}) {
+	if h.buf.Len() == 0 {
+		fmt.Fprintf(&h.buf, "# conn %s (%s)\n", h.conn.String(), h.conn.HashStr())
+	}
+	fmt.Fprintf(&h.buf, format, args...)
+}
+
+func (h *connHandler) OnPacket(t stream.PacketTrace) {
+	if t.From != t.To {
+		if len(h.states) == 0 {
+			h.states = append(h.states, t.From)
+		}
+		h.states = append(h.states, t.To)
+	}
+	if !t.Unparsed || !h.x.inTime(t.Packet.Time.UnixNano()) {
+		return
+	}
+	pkt := t.Packet
+	h.printf("%s unparsed packet {seq:%d,dir:%s,len:%d} in state %s : %s\n",
+		formatTime(pkt.Time.UnixNano()), pkt.Seq, pkt.Dir.String(), pkt.Len, t.To, t.Reason)
+	if !h.x.opts.Hex {
+		return
+	}
+	if mask.Default != nil {
+		h.printf("  hex dump is not printed as values are masked\n")
+		return
+	}
+	for _, line := range strings.SplitAfter(hex.Dump(pkt.Data), "\n") {
+		if len(line) > 0 {
+			h.printf("  %s", line)
+		}
+	}
+}
+
+func (h *connHandler) OnEvent(e stream.MySQLEvent) {
+	states := h.states
+	h.states = nil
+	var stmt string
+	switch e.Type {
+	case util.EventHandshake:
+		h.db = e.DB
+	case util.EventQuery:
+		if db, ok := parse.UseDB(e.Query); ok {
+			h.db = db
+		}
+	case util.EventStmtPrepare:
+		h.stmts[e.StmtID] = e.Query
+	case util.EventStmtExecute:
+		stmt = h.stmts[e.StmtID]
+	case util.EventStmtClose:
+		stmt = h.stmts[e.StmtID]
+		delete(h.stmts, e.StmtID)
+	}
+	if !h.x.inTime(e.Time) || (h.x.types != nil && !h.x.types[e.Type]) {
+		return
+	}
+
+	h.printf("%s %s", formatTime(e.Time), e.Type.String())
+	switch e.Type {
+	case util.EventHandshake:
+		h.printf(" {username:%q,db:%q,plugin:%q}\n", e.Username, e.DB, e.AuthPlugin)
+	case util.EventQuery:
+		h.printf("\n  query: %s\n", mask.Default.Query(h.db, e.Query))
+	case util.EventStmtPrepare:
+		h.printf(" {id:%s}\n  query: %s\n", e.StmtID, mask.Default.Query(h.db, e.Query))
+	case util.EventStmtExecute:
+		h.printf(" {id:%s}\n", e.StmtID)
+		if len(stmt) > 0 {
+			h.printf("  query: %s\n", mask.Default.Query(h.db, stmt))
+		}
+		h.printf("  params: %s\n", formatParams(mask.Default.Params(h.db, stmt, e.Params)))
+	case util.EventStmtClose:
+		h.printf(" {id:%s}\n", e.StmtID)
+	default:
+		h.printf("\n")
+	}
+	if len(states) > 0 {
+		h.printf("  states: %s\n", strings.Join(states, " -> "))
+	}
+	if e.Pr != nil {
+		h.printResult(mask.Default.PacketRes(e.Pr))
+	}
+}
+
+func (h *connHandler) printResult(pr *stream.PacketRes) {
+	r, err := pr.Record()
+	if err != nil {
+		h.printf("  response: can not convert rows , %s\n", err.Error())
+	} else {
+		switch {
+		case r.ErrNo != 0:
+			h.printf("  response: ERR {errno:%d,sqlstate:%q,message:%q}\n", r.ErrNo, r.SQLState, r.ErrDesc)
+		case r.Rows == nil:
+			h.printf("  response: OK {affected-rows:%d,insert-id:%d}\n", r.AffectedRows, r.InsertID)
+		default:
+			h.printResultSet(r)
+		}
+	}
+	begin, end := pr.GetSqlBeginTime(), pr.GetSqlEndTime()
+	if begin > 0 && end >= begin {
+		h.printf("  timing: %s -> %s (%s)\n", formatTime(int64(begin)), formatTime(int64(end)),
+			time.Duration(end-begin).String())
+	}
+}
+
+func (h *connHandler) printResultSet(r *stream.PacketResRecord) {
+	columns := make([]string, len(r.Columns))
+	for i, c := range r.Columns {
+		columns[i] = c.Name + " " + c.Type
+	}
+	h.printf("  response: %d rows {columns:[%s]}\n", len(r.Rows), strings.Join(columns, ", "))
+	n := len(r.Rows)
+	if max := h.x.opts.MaxRows; max >= 0 && n > max {
+		n = max
+	}
+	for _, row := range r.Rows[:n] {
+		values := make([]string, len(row))
+		for i, v := range row {
+			values[i] = v.String()
+		}
+		h.printf("    %s\n", strings.Join(values, " | "))
+	}
+	if n < len(r.Rows) {
+		h.printf("    ... %d more rows\n", len(r.Rows)-n)
+	}
+}
+
+func formatParams(params []interface{}) string {
+	values := make([]string, len(params))
+	for i, p := range params {
+		switch x := p.(type) {
+		case nil:
+			values[i] = "NULL"
+		case string:
+			values[i] = strconv.Quote(x)
+		case []byte:
+			values[i] = "0x" + hex.EncodeToString(x)
+		case time.Time:
+			values[i] = x.Format(time.RFC3339Nano)
+		default:
+			values[i] = fmt.Sprintf("%v", x)
+		}
+		if p != nil {
+			values[i] += fmt.Sprintf("(%T)", p)
+		}
+	}
+	return "[" + strings.Join(values, ", ") + "]"
+}
+
+func (h *connHandler) OnClose() {
+	if h.buf.Len() == 0 {
+		return
+	}
+	h.x.mu.Lock()
+	defer h.x.mu.Unlock()
+	if h.x.err != nil {
+		return
+	}
+	h.buf.WriteByte('\n')
+	_, h.x.err = h.x.w.Write(h.buf.Bytes())
+	h.buf.Reset()
+}
@@ -0,0 +1,115 @@
+package inspect
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/google/gopacket/reassembly"
+	"github.com/stretchr/testify/assert"
+)
+
+//2021-11-29T12:49:10Z
+const ts = int64(1638190150) * 1000000000
+
+func newConn(t *testing.T, src string) stream.ConnID {
+	conn, err := stream.ParseConnID(src, "10.0.0.2:3306")
+	assert.Nil(t, err)
+	return conn
+}
+
+func packet(ts int64, seq int, data []byte) stream.MySQLPacket {
+	return stream.MySQLPacket{Time: time.Unix(0, ts), Dir: reassembly.TCPDirClientToServer, Len: len(data), Seq: seq, Data: data}
+}
+
+func inspect(t *testing.T, opts Options) string {
+	var sb strings.Builder
+	x, err := New(&sb, opts)
+	assert.Nil(t, err)
+	conn := newConn(t, "10.0.0.1:1000")
+	h := x.Handler(conn)
+	if h == nil {
+		return ""
+	}
+	tracer := h.(stream.PacketTracer)
+
+	rows := stream.NewPacketResFromRecord(&stream.PacketResRecord{
+		BeginTime: uint64(ts + 1000),
+		EndTime:   uint64(ts + 2500000),
+		Columns:   []stream.ColumnInfo{{Name: "a", Type: "INT"}, {Name: "b", Type: "VARCHAR"}},
+		Rows: [][]stream.Value{
+			{{Kind: stream.KindInt, Data: "1"}, {Kind: stream.KindString, Data: "x"}},
+			{{Kind: stream.KindInt, Data: "2"}, {Kind: stream.KindNull}},
+		},
+	})
+	tracer.OnPacket(stream.PacketTrace{Packet: packet(ts, 0, nil), From: "Init", To: "Handshake0"})
+	h.OnEvent(stream.MySQLEvent{Time: ts, Type: util.EventHandshake, DB: "test", Username: "root", AuthPlugin: "mysql_native_password"})
+	tracer.OnPacket(stream.PacketTrace{Packet: packet(ts+1000, 0, []byte{3, 's'}), From: "Handshake2", To: "ComQuery"})
+	tracer.OnPacket(stream.PacketTrace{Packet: packet(ts+2000, 1, nil), From: "ComQuery", To: "ReadingComQueryRes"})
+	tracer.OnPacket(stream.PacketTrace{Packet: packet(ts+3000, 2, nil), From: "ReadingComQueryRes", To: "ReadingComQueryRes"})
+	tracer.OnPacket(stream.PacketTrace{Packet: packet(ts+4000, 3, nil), From: "ReadingComQueryRes", To: "ReadComQueryResEnd"})
+	h.OnEvent(stream.MySQLEvent{Time: ts + 1000, Type: util.EventQuery, Query: "select a, b from t", Pr: rows})
+	tracer.OnPacket(stream.PacketTrace{Packet: packet(ts+3000000, 0, []byte{0x0e}), From: "ReadComQueryResEnd", To: "Unknown",
+		Unparsed: true, Reason: "init: skip client command(0x0e)"})
+	h.OnEvent(stream.MySQLEvent{Time: ts + 4000000, Type: util.EventStmtPrepare, StmtID: "1", Query: "insert into t values (?, ?)"})
+	h.OnEvent(stream.MySQLEvent{Time: ts + 5000000, Type: util.EventStmtExecute, StmtID: "1",
+		Params: []interface{}{int64(1), nil, "it's"},
+		Pr:     stream.NewPacketResFromRecord(&stream.PacketResRecord{AffectedRows: 1, InsertID: 7})})
+	h.OnEvent(stream.MySQLEvent{Time: ts + 6000000, Type: util.EventQuery, Query: "select * from x",
+		Pr: stream.NewPacketResFromRecord(&stream.PacketResRecord{ErrNo: 1146, SQLState: "42S02", ErrDesc: "Table 'test.x' doesn't exist"})})
+	h.OnEvent(stream.MySQLEvent{Time: ts + 7000000, Type: util.EventQuit})
+	h.OnClose()
+	assert.Nil(t, x.Err())
+	return sb.String()
+}
+
+func TestInspector(t *testing.T) {
+	assert.Equal(t, "# conn 10.0.0.1:1000->10.0.0.2:3306 ("+newConn(t, "10.0.0.1:1000").HashStr()+")\n"+
+		"2021-11-29T12:49:10.000000Z handshake {username:\"root\",db:\"test\",plugin:\"mysql_native_password\"}\n"+
+		"  states: Init -> Handshake0\n"+
+		"2021-11-29T12:49:10.000001Z query\n"+
+		"  query: select a, b from t\n"+
+		"  states: Handshake2 -> ComQuery -> ReadingComQueryRes -> ReadComQueryResEnd\n"+
+		"  response: 2 rows {columns:[a INT, b VARCHAR]}\n"+
+		"    1 | x\n"+
+		"    2 | NULL\n"+
+		"  timing: 2021-11-29T12:49:10.000001Z -> 2021-11-29T12:49:10.002500Z (2.499ms)\n"+
+		"2021-11-29T12:49:10.003000Z unparsed packet {seq:0,dir:client->server,len:1} in state Unknown : init: skip client command(0x0e)\n"+
+		"2021-11-29T12:49:10.004000Z stmt_prepare {id:1}\n"+
+		"  query: insert into t values (?, ?)\n"+
+		"  states: ReadComQueryResEnd -> Unknown\n"+
+		"2021-11-29T12:49:10.005000Z stmt_execute {id:1}\n"+
+		"  query: insert into t values (?, ?)\n"+
+		"  params: [1(int64), NULL, \"it's\"(string)]\n"+
+		"  response: OK {affected-rows:1,insert-id:7}\n"+
+		"2021-11-29T12:49:10.006000Z query\n"+
+		"  query: select * from x\n"+
+		"  response: ERR {errno:1146,sqlstate:\"42S02\",message:\"Table 'test.x' doesn't exist\"}\n"+
+		"2021-11-29T12:49:10.007000Z quit\n\n",
+		inspect(t, Options{MaxRows: -1}))
+}
+
+func TestInspector_Filter(t *testing.T) {
+	out := inspect(t, Options{Types: []string{"stmt_execute"}, MaxRows: 1, Hex: true})
+	assert.Equal(t, "# conn 10.0.0.1:1000->10.0.0.2:3306 ("+newConn(t, "10.0.0.1:1000").HashStr()+")\n"+
+		"2021-11-29T12:49:10.003000Z unparsed packet {seq:0,dir:client->server,len:1} in state Unknown : init: skip client command(0x0e)\n"+
+		"  00000000  0e                                                |.|\n"+
+		"2021-11-29T12:49:10.005000Z stmt_execute {id:1}\n"+
+		"  query: insert into t values (?, ?)\n"+
+		"  params: [1(int64), NULL, \"it's\"(string)]\n"+
+		"  response: OK {affected-rows:1,insert-id:7}\n\n", out)
+
+	out = inspect(t, Options{MaxRows: 1, From: ts + 1000, To: ts + 1000})
+	assert.True(t, strings.Contains(out, "    1 | x\n    ... 1 more rows\n"), out)
+	assert.False(t, strings.Contains(out, "handshake"), out)
+	assert.False(t, strings.Contains(out, "unparsed"), out)
+
+	assert.Equal(t, "", inspect(t, Options{Conn: "10.0.0.1:1001"}))
+	assert.NotEqual(t, "", inspect(t, Options{Conn: "10.0.0.1:1000"}))
+	assert.NotEqual(t, "", inspect(t, Options{Conn: newConn(t, "10.0.0.1:1000").HashStr()}))
+
+	_, err := New(nil, Options{Types: []string{"ping"}})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,572 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package mask
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/format"
+	"github.com/pingcap/tidb/parser/test_driver"
+)
+
+//Prefix of masked values
+const Prefix = "mask_"
+
+//Rules is the masking config , a JSON file like
+//
+//	{
+//		"salt": "secret",
+//		"columns": ["email", "users.phone", "crm.users.ssn"],
+//		"patterns": ["[0-9]{3}-[0-9]{2}-[0-9]{4}"],
+//		"all": false
+//	}
+//
+//a literal compared with or assigned to a column of columns is masked ,
+//the column is column , table.column or db.table.column and * matches
+//any name . Values of result sets are matched by column name only , the
+//table of a column of the replay server is unknown . The parts of string
+//literals , params and values matched by patterns are masked . All
+//masks every literal , param and value
+type Rules struct {
+	Salt     string   `json:"salt"`
+	Columns  []string `json:"columns"`
+	Patterns []string `json:"patterns"`
+	All      bool     `json:"all"`
+}
+
+type column struct {
+	db, table, name string
+}
+
+func (c column) match(db, table, name string) bool {
+	return matchName(c.name, name) && matchName(c.table, table) && matchName(c.db, db)
+}
+
+//an empty name of a rule or of a column whose table is unknown matches
+func matchName(rule, name string) bool {
+	return rule == "" || rule == "*" || name == "" || rule == name
+}
+
+//Masker masks values with the HMAC-SHA256 of the salt , equal values have
+//equal masks so that results of production and replay still compare . A
+//nil Masker masks nothing
+type Masker struct {
+	salt     []byte
+	columns  []column
+	patterns []*regexp.Regexp
+	all      bool
+}
+
+func New(r Rules) (*Masker, error) {
+	m := &Masker{salt: []byte(r.Salt), all: r.All}
+	for _, c := range r.Columns {
+		fields := strings.Split(strings.ToLower(c), ".")
+		switch len(fields) {
+		case 1:
+			m.columns = append(m.columns, column{name: fields[0]})
+		case 2:
+			m.columns = append(m.columns, column{table: fields[0], name: fields[1]})
+		case 3:
+			m.columns = append(m.columns, column{db: fields[0], table: fields[1], name: fields[2]})
+		default:
+			return nil, fmt.Errorf("invalid mask column %s", c)
+		}
+	}
+	for _, p := range r.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mask pattern %s , %v", p, err)
+		}
+		m.patterns = append(m.patterns, re)
+	}
+	return m, nil
+}
+
+//Load reads the rules from a JSON file
+func Load(name string) (*Masker, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var r Rules
+	if err = json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("parse mask rules %s fail , %v", name, err)
+	}
+	return New(r)
+}
+
+//Default masks all outputs of the process , it is set by commands before
+//events are handled
+var Default *Masker
+
+func Init(name string) error {
+	if len(name) == 0 {
+		Default = nil
+		return nil
+	}
+	m, err := Load(name)
+	if err != nil {
+		return err
+	}
+	Default = m
+	return nil
+}
+
+//Value returns the mask of a value
+func (m *Masker) Value(s string) string {
+	mac := hmac.New(sha256.New, m.salt)
+	mac.Write([]byte(s))
+	return Prefix + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+//Text masks the parts of s matched by patterns , or all of s
+func (m *Masker) Text(s string) string {
+	if m == nil {
+		return s
+	}
+	if m.all {
+		return m.Value(s)
+	}
+	for _, re := range m.patterns {
+		s = re.ReplaceAllStringFunc(s, m.Value)
+	}
+	return s
+}
+
+//Column reports whether the values of a column are masked
+func (m *Masker) Column(db, table, name string) bool {
+	if m == nil {
+		return false
+	}
+	if m.all {
+		return true
+	}
+	db, table, name = strings.ToLower(db), strings.ToLower(table), strings.ToLower(name)
+	for _, c := range m.columns {
+		if c.match(db, table, name) {
+			return true
+		}
+	}
+	return false
+}
+
+//Query masks the literals of a query , the query is restored from the AST
+//if any literal is masked . A query that can not be parsed is masked by
+//patterns , or normalized if all literals are masked
+func (m *Masker) Query(db string, query string) string {
+	if m == nil || len(query) == 0 {
+		return query
+	}
+	stmts, _, err := parser.New().Parse(query, "", "")
+	if err != nil {
+		if m.all {
+			return parser.Normalize(query)
+		}
+		return m.Text(query)
+	}
+	v := newVisitor(m, db)
+	for _, stmt := range stmts {
+		v.reset(stmt)
+		stmt.Accept(v)
+	}
+	if !v.changed {
+		return query
+	}
+	var sb strings.Builder
+	for i, stmt := range stmts {
+		if i > 0 {
+			sb.WriteString("; ")
+		}
+		err = stmt.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, &sb))
+		if err != nil {
+			return parser.Normalize(query)
+		}
+	}
+	return sb.String()
+}
+
+//Params masks the params of a prepared statement , a param is masked if it
+//is compared with or assigned to a masked column of query
+func (m *Masker) Params(db string, query string, params []interface{}) []interface{} {
+	if m == nil || len(params) == 0 {
+		return params
+	}
+	var masked map[int]bool
+	if len(query) > 0 {
+		stmts, _, err := parser.New().Parse(query, "", "")
+		if err == nil {
+			v := newVisitor(m, db)
+			for _, stmt := range stmts {
+				v.reset(stmt)
+				stmt.Accept(v)
+			}
+			masked = v.params
+		}
+	}
+	res := make([]interface{}, len(params))
+	for i, p := range params {
+		switch {
+		case p == nil:
+			res[i] = nil
+		case m.all || masked[i]:
+			res[i] = m.Value(paramString(p))
+		default:
+			switch x := p.(type) {
+			case string:
+				res[i] = m.Text(x)
+			case []byte:
+				res[i] = m.Text(string(x))
+			default:
+				res[i] = p
+			}
+		}
+	}
+	return res
+}
+
+func paramString(p interface{}) string {
+	switch x := p.(type) {
+	case string:
+		return x
+	case []byte:
+		return string(x)
+	case float32:
+		return strconv.FormatFloat(float64(x), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	}
+	return fmt.Sprint(p)
+}
+
+//Rows masks the values of masked columns and the parts of other values
+//matched by patterns
+func (m *Masker) Rows(columns []stream.ColumnInfo, rows [][]stream.Value) [][]stream.Value {
+	if m == nil || len(rows) == 0 {
+		return rows
+	}
+	maskColumn := make([]bool, len(columns))
+	for i, c := range columns {
+		maskColumn[i] = m.Column("", "", c.Name)
+	}
+	res := make([][]stream.Value, len(rows))
+	for i, row := range rows {
+		res[i] = make([]stream.Value, len(row))
+		for j, v := range row {
+			switch {
+			case v.Kind == stream.KindNull:
+			case j >= len(maskColumn) || !maskColumn[j]:
+				if v.Kind == stream.KindString || v.Kind == stream.KindBinary {
+					v.Data = m.Text(v.Data)
+				}
+			default:
+				v = stream.Value{Kind: stream.KindString, Data: m.Value(v.Data)}
+			}
+			res[i][j] = v
+		}
+	}
+	return res
+}
+
+//Event returns a copy of e whose query , params and results are masked
+func (m *Masker) Event(e stream.MySQLEvent) stream.MySQLEvent {
+	if m == nil {
+		return e
+	}
+	switch e.Type {
+	case util.EventQuery, util.EventStmtPrepare:
+		e.Query = m.Query(e.DB, e.Query)
+	case util.EventStmtExecute:
+		//the query is known once the statement is executed on the
+		//replay server , otherwise only patterns are applied
+		query := ""
+		if e.Rr != nil {
+			query = e.Rr.SqlStatment
+		}
+		e.Params = m.Params(e.DB, query, e.Params)
+	}
+	if e.Pr != nil {
+		e.Pr = m.PacketRes(e.Pr)
+	}
+	if e.Rr != nil {
+		e.Rr = m.ReplayRes(e.DB, e.Rr)
+	}
+	return e
+}
+
+//PacketRes returns a copy of the result read from packets whose rows and
+//error message are masked
+func (m *Masker) PacketRes(pr *stream.PacketRes) *stream.PacketRes {
+	if m == nil {
+		return pr
+	}
+	r, err := pr.Record()
+	if err != nil {
+		//rows can not be converted , drop them
+		return stream.NewPacketResFromRecord(&stream.PacketResRecord{
+			ErrNo: pr.GetErrNo(), ErrDesc: m.Text(pr.GetErrDesc()), SQLState: pr.GetSQLState(),
+			ErrCategory: pr.GetErrCategory(), BeginTime: pr.GetSqlBeginTime(), EndTime: pr.GetSqlEndTime(),
+		})
+	}
+	r.ErrDesc = m.Text(r.ErrDesc)
+	r.Rows = m.Rows(r.Columns, r.Rows)
+	return stream.NewPacketResFromRecord(r)
+}
+
+//ReplayRes returns a copy of the result of the replay server whose
+//statement , params , rows and error message are masked
+func (m *Masker) ReplayRes(db string, rr *stream.ReplayRes) *stream.ReplayRes {
+	if m == nil {
+		return rr
+	}
+	res := *rr
+	res.ErrDesc = m.Text(rr.ErrDesc)
+	res.SqlStatment = m.Query(db, rr.SqlStatment)
+	res.OrigSqlStatment = m.Query(db, rr.OrigSqlStatment)
+	res.Values = m.Params(db, rr.SqlStatment, rr.Values)
+	if len(rr.Targets) > 0 {
+		res.Targets = make([]*stream.ReplayRes, len(rr.Targets))
+		for i, t := range rr.Targets {
+			res.Targets[i] = m.ReplayRes(db, t)
+		}
+	}
+	if len(rr.ColValues) == 0 {
+		return &res
+	}
+	maskColumn := make([]bool, len(rr.ColNames))
+	for i, name := range rr.ColNames {
+		maskColumn[i] = m.Column("", "", name)
+	}
+	res.ColValues = make([][]driver.Value, len(rr.ColValues))
+	for i, row := range rr.ColValues {
+		res.ColValues[i] = make([]driver.Value, len(row))
+		for j, v := range row {
+			switch {
+			case v == nil:
+			case j < len(maskColumn) && maskColumn[j]:
+				v = m.Value(paramString(v))
+			default:
+				switch x := v.(type) {
+				case string:
+					v = m.Text(x)
+				case []byte:
+					v = []byte(m.Text(string(x)))
+				}
+			}
+			res.ColValues[i][j] = v
+		}
+	}
+	return &res
+}
+
+//visitor masks the literals of a statement and records the params
+//related to masked columns
+type visitor struct {
+	m       *Masker
+	db      string
+	tables  []string
+	params  map[int]bool
+	changed bool
+	//position of param markers in the statement by offset
+	markers map[int]int
+	base    int
+	//literals already masked or kept as they are
+	done map[*test_driver.ValueExpr]bool
+}
+
+func newVisitor(m *Masker, db string) *visitor {
+	return &visitor{m: m, db: db, params: make(map[int]bool)}
+}
+
+//reset collects the tables of a statement , an unqualified column may be
+//a column of any of them
+func (v *visitor) reset(stmt ast.StmtNode) {
+	v.tables = v.tables[:0]
+	v.done = make(map[*test_driver.ValueExpr]bool)
+	c := &tableCollector{v: v}
+	stmt.Accept(c)
+	sort.Ints(c.offsets)
+	v.markers = make(map[int]int, len(c.offsets))
+	for i, offset := range c.offsets {
+		v.markers[offset] = v.base + i
+	}
+	v.base += len(c.offsets)
+}
+
+//tableCollector collects the tables and the offsets of param markers
+type tableCollector struct {
+	v       *visitor
+	offsets []int
+}
+
+func (c *tableCollector) Enter(n ast.Node) (ast.Node, bool) {
+	switch x := n.(type) {
+	case *ast.TableName:
+		c.v.tables = append(c.v.tables, x.Name.L)
+	case *test_driver.ParamMarkerExpr:
+		c.offsets = append(c.offsets, x.Offset)
+	}
+	return n, false
+}
+
+func (c *tableCollector) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+func (v *visitor) maskedColumn(c *ast.ColumnName) bool {
+	if c == nil {
+		return false
+	}
+	db := c.Schema.L
+	if db == "" {
+		db = strings.ToLower(v.db)
+	}
+	if c.Table.L != "" {
+		return v.m.Column(db, c.Table.L, c.Name.L)
+	}
+	if len(v.tables) == 0 {
+		return v.m.Column(db, "", c.Name.L)
+	}
+	for _, t := range v.tables {
+		if v.m.Column(db, t, c.Name.L) {
+			return true
+		}
+	}
+	return false
+}
+
+func columnOf(n ast.ExprNode) *ast.ColumnName {
+	if c, ok := n.(*ast.ColumnNameExpr); ok {
+		return c.Name
+	}
+	return nil
+}
+
+//maskExpr masks a literal or records a param related to a masked column
+func (v *visitor) maskExpr(n ast.ExprNode) {
+	switch x := n.(type) {
+	case *test_driver.ParamMarkerExpr:
+		v.params[v.markers[x.Offset]] = true
+	case *test_driver.ValueExpr:
+		v.maskValue(x, true)
+	case *ast.ParenthesesExpr:
+		v.maskExpr(x.Expr)
+	case *ast.UnaryOperationExpr:
+		v.maskExpr(x.V)
+	}
+}
+
+func (v *visitor) maskValue(x *test_driver.ValueExpr, all bool) {
+	if v.done[x] {
+		return
+	}
+	v.done[x] = true
+	var s string
+	switch x.Kind() {
+	case test_driver.KindString, test_driver.KindBytes:
+		s = x.GetString()
+		if !all {
+			masked := v.m.Text(s)
+			if masked == s {
+				return
+			}
+			x.SetString(masked)
+			x.Type.Charset = ""
+			v.changed = true
+			return
+		}
+	case test_driver.KindInt64:
+		s = strconv.FormatInt(x.GetInt64(), 10)
+	case test_driver.KindUint64:
+		s = strconv.FormatUint(x.GetUint64(), 10)
+	case test_driver.KindFloat32, test_driver.KindFloat64:
+		s = strconv.FormatFloat(x.GetFloat64(), 'g', -1, 64)
+	case test_driver.KindMysqlDecimal:
+		s = x.GetMysqlDecimal().String()
+	default:
+		return
+	}
+	if !all {
+		return
+	}
+	x.SetString(v.m.Value(s))
+	x.Type.Charset = ""
+	v.changed = true
+}
+
+func (v *visitor) Enter(n ast.Node) (ast.Node, bool) {
+	switch x := n.(type) {
+	case *ast.BinaryOperationExpr:
+		if v.maskedColumn(columnOf(x.L)) {
+			v.maskExpr(x.R)
+		}
+		if v.maskedColumn(columnOf(x.R)) {
+			v.maskExpr(x.L)
+		}
+	case *ast.PatternInExpr:
+		if v.maskedColumn(columnOf(x.Expr)) {
+			for _, e := range x.List {
+				v.maskExpr(e)
+			}
+		}
+	case *ast.PatternLikeExpr:
+		if v.maskedColumn(columnOf(x.Expr)) {
+			v.maskExpr(x.Pattern)
+		}
+	case *ast.BetweenExpr:
+		if v.maskedColumn(columnOf(x.Expr)) {
+			v.maskExpr(x.Left)
+			v.maskExpr(x.Right)
+		}
+	case *ast.Assignment:
+		if v.maskedColumn(x.Column) {
+			v.maskExpr(x.Expr)
+		}
+	case *ast.InsertStmt:
+		for _, list := range x.Lists {
+			for i, e := range list {
+				if i < len(x.Columns) && v.maskedColumn(x.Columns[i]) {
+					v.maskExpr(e)
+				}
+			}
+		}
+	case *test_driver.ParamMarkerExpr:
+		if v.m.all {
+			v.params[v.markers[x.Offset]] = true
+		}
+		return n, true
+	case *test_driver.ValueExpr:
+		v.maskValue(x, v.m.all)
+	}
+	return n, false
+}
+
+func (v *visitor) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
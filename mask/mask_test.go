@@ -0,0 +1,161 @@
+package mask
+
+import (
+	"database/sql/driver"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMasker(t *testing.T, r Rules) *Masker {
+	r.Salt = "salt"
+	m, err := New(r)
+	assert.Nil(t, err)
+	return m
+}
+
+func TestMasker_Query(t *testing.T) {
+	m := newMasker(t, Rules{
+		Columns:  []string{"email", "users.phone", "crm.users.ssn"},
+		Patterns: []string{`[0-9]{4}-[0-9]{4}-[0-9]{4}`},
+	})
+	a, b, c := m.Value("a@b.c"), m.Value("123"), m.Value("1")
+	tests := []struct {
+		name  string
+		db    string
+		query string
+		want  string
+	}{
+		{"no literal masked", "", "select * from users where id = 1", "select * from users where id = 1"},
+		{"where", "", "select * from users where email = 'a@b.c' and id = 1",
+			"SELECT * FROM `users` WHERE `email`='" + a + "' AND `id`=1"},
+		{"reverse and in", "", "select * from t where 'a@b.c' = email or email in ('a@b.c', 1)",
+			"SELECT * FROM `t` WHERE '" + a + "'=`email` OR `email` IN ('" + a + "','" + c + "')"},
+		{"table", "", "select * from users where phone = 123", "SELECT * FROM `users` WHERE `phone`='" + b + "'"},
+		{"other table", "", "select * from orders where phone = 123", "select * from orders where phone = 123"},
+		{"qualified", "", "select * from users u join orders o on u.id = o.uid where o.phone = 123",
+			"select * from users u join orders o on u.id = o.uid where o.phone = 123"},
+		{"db", "crm", "update users set ssn = 1 where id = 1", "UPDATE `users` SET `ssn`='" + c + "' WHERE `id`=1"},
+		{"other db", "erp", "update users set ssn = 1 where id = 1", "update users set ssn = 1 where id = 1"},
+		{"insert", "", "insert into users (id, email) values (1, 'a@b.c'), (2, null)",
+			"INSERT INTO `users` (`id`,`email`) VALUES (1,'" + a + "'),(2,NULL)"},
+		{"pattern", "", "select 'card 1234-5678-9012 ok'",
+			"SELECT 'card " + m.Value("1234-5678-9012") + " ok'"},
+		{"not parsed", "", "selec 'card 1234-5678-9012'", "selec 'card " + m.Value("1234-5678-9012") + "'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, m.Query(tt.db, tt.query))
+		})
+	}
+}
+
+func TestMasker_All(t *testing.T) {
+	m := newMasker(t, Rules{All: true})
+	assert.Equal(t, "SELECT '"+m.Value("1")+"','"+m.Value("a")+"',NULL", m.Query("", "select 1, 'a', null"))
+	assert.Equal(t, "`selec` ?", m.Query("", "selec 1"))
+	assert.Equal(t, []interface{}{m.Value("1"), nil, m.Value("1.5")},
+		m.Params("", "select ?", []interface{}{int64(1), nil, 1.5}))
+	assert.Equal(t, m.Value("x"), m.Text("x"))
+}
+
+func TestMasker_Params(t *testing.T) {
+	m := newMasker(t, Rules{Columns: []string{"email"}, Patterns: []string{`secret`}})
+	params := []interface{}{int64(1), "a@b.c", []byte("my secret"), 1.5}
+	res := m.Params("", "select * from t where id = ? and email = ? and note = ? and v > ?", params)
+	assert.Equal(t, []interface{}{int64(1), m.Value("a@b.c"), "my " + m.Value("secret"), 1.5}, res)
+	//params are copied
+	assert.Equal(t, "a@b.c", params[1])
+
+	res = m.Params("", "insert into t (id, email) values (?, ?)", params[:2])
+	assert.Equal(t, []interface{}{int64(1), m.Value("a@b.c")}, res)
+
+	//without query only patterns are applied
+	res = m.Params("", "", params)
+	assert.Equal(t, "a@b.c", res[1])
+	assert.Equal(t, "my "+m.Value("secret"), res[2])
+}
+
+func TestMasker_Rows(t *testing.T) {
+	m := newMasker(t, Rules{Columns: []string{"users.email"}, Patterns: []string{`secret`}})
+	columns := []stream.ColumnInfo{{Name: "id"}, {Name: "EMAIL"}, {Name: "note"}}
+	rows := [][]stream.Value{
+		{{Kind: stream.KindInt, Data: "1"}, {Kind: stream.KindString, Data: "a@b.c"}, {Kind: stream.KindString, Data: "secret"}},
+		{{Kind: stream.KindInt, Data: "2"}, {Kind: stream.KindNull}, {Kind: stream.KindString, Data: "x"}},
+	}
+	res := m.Rows(columns, rows)
+	assert.Equal(t, [][]stream.Value{
+		{{Kind: stream.KindInt, Data: "1"}, {Kind: stream.KindString, Data: m.Value("a@b.c")}, {Kind: stream.KindString, Data: m.Value("secret")}},
+		{{Kind: stream.KindInt, Data: "2"}, {Kind: stream.KindNull}, {Kind: stream.KindString, Data: "x"}},
+	}, res)
+	assert.Equal(t, "a@b.c", rows[0][1].Data)
+}
+
+func TestMasker_Nil(t *testing.T) {
+	var m *Masker
+	assert.Equal(t, "select 1", m.Query("", "select 1"))
+	assert.Equal(t, "x", m.Text("x"))
+	assert.False(t, m.Column("", "", "x"))
+	params := []interface{}{"x"}
+	assert.Equal(t, params, m.Params("", "select ?", params))
+	e := stream.MySQLEvent{Type: util.EventQuery, Query: "select 1"}
+	assert.Equal(t, e, m.Event(e))
+}
+
+func TestMasker_Event(t *testing.T) {
+	m := newMasker(t, Rules{Columns: []string{"email"}})
+	e := m.Event(stream.MySQLEvent{Type: util.EventQuery, Query: "select * from t where email = 'a@b.c'"})
+	assert.True(t, strings.Contains(e.Query, m.Value("a@b.c")))
+	e = m.Event(stream.MySQLEvent{Type: util.EventStmtExecute, Params: []interface{}{"a@b.c"}})
+	assert.Equal(t, []interface{}{"a@b.c"}, e.Params)
+
+	//results and the statement executed on the replay server
+	pr := stream.NewPacketResFromRecord(&stream.PacketResRecord{
+		Columns: []stream.ColumnInfo{{Name: "email"}},
+		Rows:    [][]stream.Value{{{Kind: stream.KindString, Data: "a@b.c"}}},
+	})
+	rr := &stream.ReplayRes{
+		SqlStatment: "select * from t where email = ?",
+		Values:      []interface{}{"a@b.c"},
+		ColNames:    []string{"email"},
+		ColValues:   [][]driver.Value{{[]byte("a@b.c")}},
+	}
+	rr.Targets = []*stream.ReplayRes{{Target: "dsn2", ColNames: []string{"email"}, ColValues: [][]driver.Value{{[]byte("a@b.c")}}}}
+	e = m.Event(stream.MySQLEvent{Type: util.EventStmtExecute, Params: []interface{}{"a@b.c"}, Pr: pr, Rr: rr})
+	assert.Equal(t, []interface{}{m.Value("a@b.c")}, e.Params)
+	assert.Equal(t, []interface{}{m.Value("a@b.c")}, e.Rr.Values)
+	assert.Equal(t, [][]driver.Value{{m.Value("a@b.c")}}, e.Rr.ColValues)
+	assert.Equal(t, [][]driver.Value{{m.Value("a@b.c")}}, e.Rr.Targets[0].ColValues)
+	assert.Equal(t, [][]driver.Value{{[]byte("a@b.c")}}, rr.Targets[0].ColValues)
+	r, err := e.Pr.Record()
+	assert.Nil(t, err)
+	assert.Equal(t, m.Value("a@b.c"), r.Rows[0][0].Data)
+	//the original results are not changed
+	assert.Equal(t, []interface{}{"a@b.c"}, rr.Values)
+	r, err = pr.Record()
+	assert.Nil(t, err)
+	assert.Equal(t, "a@b.c", r.Rows[0][0].Data)
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "mask.json")
+	assert.Nil(t, ioutil.WriteFile(name, []byte(`{"salt":"s","columns":["email"],"patterns":["[0-9]+"]}`), 0644))
+	assert.Nil(t, Init(name))
+	assert.NotNil(t, Default)
+	assert.True(t, Default.Column("", "t", "email"))
+	assert.Nil(t, Init(""))
+	assert.Nil(t, Default)
+
+	tests := []string{`{"columns":["a.b.c.d"]}`, `{"patterns":["("]}`, `{`}
+	for _, tt := range tests {
+		assert.Nil(t, ioutil.WriteFile(name, []byte(tt), 0644))
+		assert.NotNil(t, Init(name), tt)
+	}
+	assert.NotNil(t, Init(filepath.Join(dir, "not-exist")))
+}
@@ -25,9 +25,11 @@ package parse
 
 import (
 	"errors"
+	"strings"
 	"github.com/bobguo/mysql-replay/util"
 	"github.com/pingcap/tidb/parser"
 	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/format"
 	_ "github.com/pingcap/tidb/parser/test_driver"
 	"go.uber.org/zap"
 )
@@ -83,6 +85,12 @@ func Extract(rootNode *ast.StmtNode) uint16 {
 			*ast.RenameTableStmt, *ast.TruncateTableStmt,
 			*ast.RepairTableStmt:
 			return util.DDLStmt
+		case *ast.BeginStmt:
+			return util.BeginStmt
+		case *ast.CommitStmt:
+			return util.CommitStmt
+		case *ast.RollbackStmt:
+			return util.RollbackStmt
 		default:
 			return util.UnknownStmt
 		}
@@ -107,6 +115,56 @@ func Parse(sql string) (*ast.StmtNode, error) {
 }
 
 
+//Digest returns the normalized sql and its digest , literals are replaced
+//by ? so that queries of the same shape have the same digest
+func Digest(sql string) (string, string) {
+	normalized, digest := parser.NormalizeDigest(sql)
+	return normalized, digest.String()
+}
+
+//UseDB returns the database of a USE statement
+func UseDB(sql string) (string, bool) {
+	fields := strings.Fields(strings.TrimRight(sql, " \t\r\n;"))
+	if len(fields) != 2 || !strings.EqualFold(fields[0], "use") {
+		return "", false
+	}
+	return strings.Trim(fields[1], "`"), true
+}
+
+//Autocommit returns the value of autocommit assigned by a SET statement
+func Autocommit(sql string) (on bool, ok bool) {
+	stmt, err := Parse(sql)
+	if err != nil {
+		return false, false
+	}
+	set, isSet := (*stmt).(*ast.SetStmt)
+	if !isSet {
+		return false, false
+	}
+	for _, v := range set.Variables {
+		if !strings.EqualFold(v.Name, "autocommit") || v.IsGlobal || v.Value == nil {
+			continue
+		}
+		var sb strings.Builder
+		flags := format.RestoreStringSingleQuotes | format.RestoreKeyWordLowercase | format.RestoreNameLowercase
+		if v.Value.Restore(format.NewRestoreCtx(flags, &sb)) != nil {
+			continue
+		}
+		value := strings.ToLower(sb.String())
+		//strip the charset introducer of strings , e.g. _utf8mb4'on'
+		if i := strings.IndexByte(value, '\''); i > 0 && value[0] == '_' {
+			value = value[i:]
+		}
+		switch strings.Trim(value, "'`") {
+		case "1", "on", "true":
+			on, ok = true, true
+		case "0", "off", "false":
+			on, ok = false, true
+		}
+	}
+	return on, ok
+}
+
 func GetSQLStmtType(sql string) (uint16,error){
 	stmtNode,err := Parse(sql)
 	if err!=nil{
@@ -25,6 +25,7 @@ package parse
 
 import (
 	"github.com/agiledragon/gomonkey"
+	"github.com/bobguo/mysql-replay/util"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/tidb/parser"
 	"github.com/pingcap/tidb/parser/ast"
@@ -86,4 +87,73 @@ func Test_Extract(t *testing.T) {
 		_ = Extract(stmtNode)
 		//fmt.Printf("sql %s is %d\n", sql, kind)
 	}
-}
\ No newline at end of file
+}
+
+func Test_Digest(t *testing.T) {
+	n1, d1 := Digest("select * from t where id = 1 and name in ('a','b')")
+	n2, d2 := Digest("SELECT * FROM t WHERE id = 22 AND name IN ('c','d','e')")
+	_, d3 := Digest("select * from t2 where id = 1")
+	assert.Equal(t, n1, n2)
+	assert.Equal(t, d1, d2)
+	assert.NotEqual(t, d1, d3)
+	assert.Equal(t, "select * from `t` where `id` = ? and `name` in ( ... )", n1)
+}
+
+func Test_UseDB(t *testing.T) {
+	tests := []struct {
+		query string
+		db    string
+		ok    bool
+	}{
+		{"use test", "test", true},
+		{"USE `test`;", "test", true},
+		{"select 1", "", false},
+		{"use", "", false},
+	}
+	for _, tt := range tests {
+		db, ok := UseDB(tt.query)
+		assert.Equal(t, tt.db, db, tt.query)
+		assert.Equal(t, tt.ok, ok, tt.query)
+	}
+}
+
+func Test_GetSQLStmtType_txn(t *testing.T) {
+	tests := []struct {
+		query string
+		kind  uint16
+	}{
+		{"begin", util.BeginStmt},
+		{"start transaction", util.BeginStmt},
+		{"COMMIT", util.CommitStmt},
+		{"rollback", util.RollbackStmt},
+		{"replace into t values (1)", util.InsertStmt},
+	}
+	for _, tt := range tests {
+		kind, err := GetSQLStmtType(tt.query)
+		assert.Nil(t, err, tt.query)
+		assert.Equal(t, tt.kind, kind, tt.query)
+	}
+}
+
+func Test_Autocommit(t *testing.T) {
+	tests := []struct {
+		query string
+		on    bool
+		ok    bool
+	}{
+		{"set autocommit=0", false, true},
+		{"SET autocommit = ON", true, true},
+		{"set @@session.autocommit=off", false, true},
+		{"set autocommit=true", true, true},
+		{"set names utf8mb4, autocommit=1", true, true},
+		{"set global autocommit=0", false, false},
+		{"set tidb_general_log=on", false, false},
+		{"select 1", false, false},
+		{"set autocommit=", false, false},
+	}
+	for _, tt := range tests {
+		on, ok := Autocommit(tt.query)
+		assert.Equal(t, tt.on, on, tt.query)
+		assert.Equal(t, tt.ok, ok, tt.query)
+	}
+}
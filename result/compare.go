@@ -0,0 +1,91 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package result
+
+import (
+	"fmt"
+
+	"github.com/bobguo/mysql-replay/stream"
+)
+
+//CompareColumns compares the column definitions of the captured result
+//and the replayed result, return a description for every difference.
+//A column read without the driver internals only has name and type,
+//the other attributes are compared only when charset is known
+func CompareColumns(pr, rr []stream.ColumnInfo) []string {
+	if pr == nil || rr == nil {
+		return nil
+	}
+	var diff []string
+	if len(pr) != len(rr) {
+		diff = append(diff, fmt.Sprintf("column count %d != %d", len(pr), len(rr)))
+		return diff
+	}
+	for i := range pr {
+		p, r := pr[i], rr[i]
+		if p.Name != r.Name {
+			diff = append(diff, fmt.Sprintf("column %d name %s != %s", i, p.Name, r.Name))
+		}
+		if p.Type != r.Type {
+			diff = append(diff, fmt.Sprintf("column %s type %s != %s", p.Name, p.Type, r.Type))
+		}
+		if r.Charset == 0 {
+			continue
+		}
+		if p.Length != r.Length {
+			diff = append(diff, fmt.Sprintf("column %s length %d != %d", p.Name, p.Length, r.Length))
+		}
+		if p.Decimals != r.Decimals {
+			diff = append(diff, fmt.Sprintf("column %s decimals %d != %d", p.Name, p.Decimals, r.Decimals))
+		}
+		if p.Charset != r.Charset {
+			diff = append(diff, fmt.Sprintf("column %s charset %d != %d", p.Name, p.Charset, r.Charset))
+		}
+		if p.TypeFlags() != r.TypeFlags() {
+			diff = append(diff, fmt.Sprintf("column %s flags %#x != %#x", p.Name, p.TypeFlags(), r.TypeFlags()))
+		}
+	}
+	return diff
+}
+
+//only the first differences of a result set are reported
+const maxResultDiff = 10
+
+//CompareRows compares the typed rows of the captured result and the
+//replayed result in order , NULL only equals NULL and values of
+//different kind are different even if their text is the same
+func CompareRows(pr, rr [][]stream.Value) []string {
+	var diff []string
+	if len(pr) != len(rr) {
+		diff = append(diff, fmt.Sprintf("row count %d != %d", len(pr), len(rr)))
+	}
+	for i := 0; i < len(pr) && i < len(rr); i++ {
+		if len(pr[i]) != len(rr[i]) {
+			diff = append(diff, fmt.Sprintf("row %d column count %d != %d", i, len(pr[i]), len(rr[i])))
+		} else {
+			for j := range pr[i] {
+				if !pr[i][j].Equal(rr[i][j]) {
+					diff = append(diff, fmt.Sprintf("row %d column %d %s(%s) != %s(%s)", i, j,
+						pr[i][j].Kind, pr[i][j], rr[i][j].Kind, rr[i][j]))
+				}
+			}
+		}
+		if len(diff) >= maxResultDiff {
+			return diff[:maxResultDiff]
+		}
+	}
+	return diff
+}
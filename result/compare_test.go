@@ -0,0 +1,106 @@
+package result
+
+import (
+	"testing"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareColumns(t *testing.T) {
+	id := stream.ColumnInfo{Name: "id", Type: "BIGINT", Length: 20, Flags: 1, Charset: 63}
+	name := stream.ColumnInfo{Name: "name", Type: "VARCHAR", Length: 80, Decimals: 31, Charset: 45}
+
+	tests := []struct {
+		name string
+		pr   []stream.ColumnInfo
+		rr   []stream.ColumnInfo
+		want []string
+	}{
+		{
+			name: "no columns",
+			pr:   nil,
+			rr:   []stream.ColumnInfo{id},
+			want: nil,
+		},
+		{
+			name: "same",
+			pr:   []stream.ColumnInfo{id, name},
+			rr:   []stream.ColumnInfo{id, name},
+			want: nil,
+		},
+		{
+			name: "count",
+			pr:   []stream.ColumnInfo{id, name},
+			rr:   []stream.ColumnInfo{id},
+			want: []string{"column count 2 != 1"},
+		},
+		{
+			name: "type and length",
+			pr:   []stream.ColumnInfo{id},
+			rr:   []stream.ColumnInfo{{Name: "id", Type: "INT", Length: 11, Flags: 1, Charset: 63}},
+			want: []string{"column id type BIGINT != INT", "column id length 20 != 11"},
+		},
+		{
+			name: "renamed and charset",
+			pr:   []stream.ColumnInfo{name},
+			rr:   []stream.ColumnInfo{{Name: "Name", Type: "VARCHAR", Length: 80, Decimals: 31, Charset: 33}},
+			want: []string{"column 0 name name != Name", "column name charset 45 != 33"},
+		},
+		{
+			name: "fallback only compares name and type",
+			pr:   []stream.ColumnInfo{name},
+			rr:   []stream.ColumnInfo{{Name: "name", Type: "VARCHAR"}},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, CompareColumns(tt.pr, tt.rr))
+		})
+	}
+}
+
+func TestCompareRows(t *testing.T) {
+	null := stream.Value{Kind: stream.KindNull}
+	empty := stream.Value{Kind: stream.KindString}
+	one := stream.Value{Kind: stream.KindInt, Data: "1"}
+	oneStr := stream.Value{Kind: stream.KindString, Data: "1"}
+
+	tests := []struct {
+		name string
+		pr   [][]stream.Value
+		rr   [][]stream.Value
+		want []string
+	}{
+		{
+			name: "same",
+			pr:   [][]stream.Value{{one, null}},
+			rr:   [][]stream.Value{{one, null}},
+			want: nil,
+		},
+		{
+			name: "null and empty string",
+			pr:   [][]stream.Value{{null}},
+			rr:   [][]stream.Value{{empty}},
+			want: []string{"row 0 column 0 null(NULL) != string()"},
+		},
+		{
+			name: "kind",
+			pr:   [][]stream.Value{{one}},
+			rr:   [][]stream.Value{{oneStr}},
+			want: []string{"row 0 column 0 int(1) != string(1)"},
+		},
+		{
+			name: "row count",
+			pr:   [][]stream.Value{{one}, {one}},
+			rr:   [][]stream.Value{{one}},
+			want: []string{"row count 2 != 1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, CompareRows(tt.pr, tt.rr))
+		})
+	}
+}
@@ -1,34 +1,51 @@
 package result
 
 import (
-	"database/sql/driver"
 	"encoding/binary"
 	"encoding/json"
 	"os"
 
+	"github.com/bobguo/mysql-replay/mask"
 	"github.com/bobguo/mysql-replay/stream"
 	"github.com/bobguo/mysql-replay/util"
 	"go.uber.org/zap"
 )
 
 type ResForWriteFile struct {
-	Type   util.MysqlEventType `json:"type"`
-	StmtID string              `json:"stmtID,omitempty"`
-	Params []interface{}       `json:"params,omitempty"`
-	DB     string              `json:"db,omitempty"`
-	Query  string              `json:"query,omitempty"`
+	Type       util.MysqlEventType `json:"type"`
+	Clone      int                 `json:"clone,omitempty"`
+	StmtID     string              `json:"stmtID,omitempty"`
+	Params     []interface{}       `json:"params,omitempty"`
+	DB         string              `json:"db,omitempty"`
+	Query      string              `json:"query,omitempty"`
+	RrQuery    string              `json:"rr-query,omitempty"`
+	Digest     string              `json:"digest,omitempty"`
+	AuthPlugin string              `json:"auth-plugin,omitempty"`
 	//read from packet
-	PrBeginTime uint64     `json:"pr-begin-time"`
-	PrEndTime   uint64     `json:"pr-end-time"`
-	PrErrorNo   uint16     `json:"pr-error-no"`
-	PrErrorDesc string     `json:"pr-error-desc"`
-	PrResult    [][]string `json:"pr-result"`
+	PrBeginTime   uint64              `json:"pr-begin-time"`
+	PrEndTime     uint64              `json:"pr-end-time"`
+	PrErrorNo     uint16              `json:"pr-error-no"`
+	PrErrorDesc   string              `json:"pr-error-desc"`
+	PrSQLState    string              `json:"pr-sql-state,omitempty"`
+	PrErrCategory string              `json:"pr-error-category,omitempty"`
+	PrResult      [][]stream.Value    `json:"pr-result"`
+	PrColumns     []stream.ColumnInfo `json:"pr-columns,omitempty"`
 	//read from replay server
-	RrBeginTime uint64     `json:"rr-begin-time"`
-	RrEndTime   uint64     `json:"rr-end-time"`
-	RrErrorNo   uint16     `json:"rr-error-no"`
-	RrErrorDesc string     `json:"rr-error-desc"`
-	RrResult    [][]string `json:"rr-result"`
+	RrBeginTime   uint64              `json:"rr-begin-time"`
+	RrEndTime     uint64              `json:"rr-end-time"`
+	RrErrorNo     uint16              `json:"rr-error-no"`
+	RrErrorDesc   string              `json:"rr-error-desc"`
+	RrSQLState    string              `json:"rr-sql-state,omitempty"`
+	RrErrCategory string              `json:"rr-error-category,omitempty"`
+	RrResult      [][]stream.Value    `json:"rr-result"`
+	RrColumns     []stream.ColumnInfo `json:"rr-columns,omitempty"`
+	RrSkipped     string              `json:"rr-skipped,omitempty"`
+	RrAttempts    int                 `json:"rr-attempts,omitempty"`
+	//difference of column definitions and rows
+	ColumnDiff []string `json:"column-diff,omitempty"`
+	ResultDiff []string `json:"result-diff,omitempty"`
+	//read from the other replay servers
+	Targets []TargetRes `json:"targets,omitempty"`
 	//log and result file
 	Logger         *zap.Logger
 	File           *os.File
@@ -37,50 +54,35 @@ type ResForWriteFile struct {
 	Pos            uint64
 }
 
-func ConvertResToStr(v [][]driver.Value, log *zap.Logger) ([][]string, error) {
-	resSet := make([][]string, 0)
-	for a := range v {
-		rowStr := make([]string, 0)
-		for b := range v[a] {
-			var c string
-			if v[a][b] == nil {
-				//pay attention on the following logic
-				//If driver.Value is nil, we convert it to a string of length 0,
-				//but then we can't compare nil to a string of length 0
-				c = ""
-				rowStr = append(rowStr, c)
-				continue
-			}
-			err := stream.ConvertAssignRows(v[a][b], &c)
-			if err != nil {
-				if log != nil {
-					log.Warn("convert driver.Value to string fail , " + err.Error())
-				}
-				return nil, err
-			} else {
-				rowStr = append(rowStr, c)
-			}
-		}
-		resSet = append(resSet, rowStr)
-	}
-	return resSet, nil
-}
-
 func NewResForWriteFile(pr *stream.PacketRes, rr *stream.ReplayRes, e *stream.MySQLEvent,
 	filePath, fileNamePrefix string, file *os.File, pos uint64) (*ResForWriteFile, error) {
 	var err error
 	rs := new(ResForWriteFile)
 	rs.DB = e.DB
 	rs.Type = e.Type
+	rs.Digest = e.Digest
+	rs.Clone = rr.Clone
 
+	//sensitive values are masked before the results are compared , so
+	//equal values still compare equal
 	if rs.Type == util.EventQuery {
-		rs.Query = e.Query
+		rs.Query = mask.Default.Query(e.DB, e.Query)
+	}
+
+	if rs.Type == util.EventHandshake {
+		rs.AuthPlugin = e.AuthPlugin
 	}
 
 	if rs.Type == util.EventStmtExecute {
 		rs.StmtID = e.StmtID
-		rs.Params = rr.Values
-		rs.Query = rr.SqlStatment
+		rs.Params = mask.Default.Params(e.DB, rr.SqlStatment, rr.Values)
+		rs.Query = mask.Default.Query(e.DB, rr.SqlStatment)
+	}
+
+	//the statement executed by the replay server if it is rewritten
+	if len(rr.OrigSqlStatment) > 0 {
+		rs.Query = mask.Default.Query(e.DB, rr.OrigSqlStatment)
+		rs.RrQuery = mask.Default.Query(e.DB, rr.SqlStatment)
 	}
 
 	rs.File = file
@@ -88,32 +90,114 @@ func NewResForWriteFile(pr *stream.PacketRes, rr *stream.ReplayRes, e *stream.My
 	rs.PrBeginTime = pr.GetSqlBeginTime()
 	rs.PrEndTime = pr.GetSqlEndTime()
 	rs.PrErrorNo = pr.GetErrNo()
-	rs.PrErrorDesc = pr.GetErrDesc()
+	rs.PrErrorDesc = mask.Default.Text(pr.GetErrDesc())
+	rs.PrSQLState = pr.GetSQLState()
+	rs.PrErrCategory = pr.GetErrCategory().String()
 
 	rs.Logger = zap.L().With(zap.String("conn", "write-data"))
 
+	//column definitions
+	rs.PrColumns = pr.GetColumns()
+	rs.RrColumns = rr.Columns
+	rs.RrSkipped = rr.Skipped
+	rs.RrAttempts = rr.Attempts
+	//statements not replayed are not compared
+	if len(rs.RrSkipped) == 0 {
+		rs.ColumnDiff = CompareColumns(rs.PrColumns, rs.RrColumns)
+	}
+
 	val := pr.GetColumnVal()
 	if val != nil {
-		rs.PrResult, err = ConvertResToStr(val, rs.Logger)
+		rs.PrResult, err = stream.ConvertRows(val, rs.PrColumns)
 		if err != nil {
+			rs.Logger.Warn("convert packet result fail , " + err.Error())
 			return rs, err
 		}
+		rs.PrResult = mask.Default.Rows(rs.PrColumns, rs.PrResult)
 	}
 
 	//replay server result
 	rs.RrBeginTime = rr.SqlBeginTime
 	rs.RrEndTime = rr.SqlEndTime
 	rs.RrErrorNo = rr.ErrNO
-	rs.RrErrorDesc = rr.ErrDesc
-	rs.RrResult, err = ConvertResToStr(rr.ColValues, rs.Logger)
+	rs.RrErrorDesc = mask.Default.Text(rr.ErrDesc)
+	rs.RrSQLState = rr.SQLState
+	rs.RrErrCategory = rr.ErrCategory.String()
+	rs.RrResult, err = stream.ConvertRows(rr.ColValues, rs.RrColumns)
 	if err != nil {
+		rs.Logger.Warn("convert replay result fail , " + err.Error())
 		return rs, err
 	}
+	rs.RrResult = mask.Default.Rows(rs.RrColumns, rs.RrResult)
+	if len(rs.RrSkipped) == 0 && !pr.HasError() && rr.ErrCategory == util.ErrCategoryNone && rr.ErrNO == 0 {
+		rs.ResultDiff = CompareRows(rs.PrResult, rs.RrResult)
+	}
+
+	for _, t := range rr.Targets {
+		tr, err := newTargetRes(pr, rs.PrColumns, rs.PrResult, t, e.DB)
+		if err != nil {
+			rs.Logger.Warn("convert replay result of " + t.Target + " fail , " + err.Error())
+			return rs, err
+		}
+		rs.Targets = append(rs.Targets, tr)
+	}
+
 	rs.FilePath = filePath
 	rs.FileNamePrefix = fileNamePrefix
 	return rs, nil
 }
 
+//TargetRes is the result of a replay server given by --dsn after the first
+//one , it is compared with the captured result like the rr- fields
+type TargetRes struct {
+	Name          string              `json:"name"`
+	RrQuery       string              `json:"rr-query,omitempty"`
+	RrBeginTime   uint64              `json:"rr-begin-time"`
+	RrEndTime     uint64              `json:"rr-end-time"`
+	RrErrorNo     uint16              `json:"rr-error-no"`
+	RrErrorDesc   string              `json:"rr-error-desc"`
+	RrSQLState    string              `json:"rr-sql-state,omitempty"`
+	RrErrCategory string              `json:"rr-error-category,omitempty"`
+	RrResult      [][]stream.Value    `json:"rr-result"`
+	RrColumns     []stream.ColumnInfo `json:"rr-columns,omitempty"`
+	RrSkipped     string              `json:"rr-skipped,omitempty"`
+	RrAttempts    int                 `json:"rr-attempts,omitempty"`
+	ColumnDiff    []string            `json:"column-diff,omitempty"`
+	ResultDiff    []string            `json:"result-diff,omitempty"`
+}
+
+func newTargetRes(pr *stream.PacketRes, prColumns []stream.ColumnInfo, prResult [][]stream.Value,
+	rr *stream.ReplayRes, db string) (TargetRes, error) {
+	var err error
+	tr := TargetRes{
+		Name:          rr.Target,
+		RrBeginTime:   rr.SqlBeginTime,
+		RrEndTime:     rr.SqlEndTime,
+		RrErrorNo:     rr.ErrNO,
+		RrErrorDesc:   mask.Default.Text(rr.ErrDesc),
+		RrSQLState:    rr.SQLState,
+		RrErrCategory: rr.ErrCategory.String(),
+		RrColumns:     rr.Columns,
+		RrSkipped:     rr.Skipped,
+		RrAttempts:    rr.Attempts,
+	}
+	if len(rr.OrigSqlStatment) > 0 {
+		tr.RrQuery = mask.Default.Query(db, rr.SqlStatment)
+	}
+	if len(tr.RrSkipped) == 0 {
+		tr.ColumnDiff = CompareColumns(prColumns, tr.RrColumns)
+	}
+	tr.RrResult, err = stream.ConvertRows(rr.ColValues, tr.RrColumns)
+	if err != nil {
+		return tr, err
+	}
+	tr.RrResult = mask.Default.Rows(tr.RrColumns, tr.RrResult)
+	if len(tr.RrSkipped) == 0 && !pr.HasError() && rr.ErrCategory == util.ErrCategoryNone && rr.ErrNO == 0 {
+		tr.ResultDiff = CompareRows(prResult, tr.RrResult)
+	}
+	return tr, nil
+}
+
 //formate struct as json and write to file
 //if write file fail ,file will truncate and seek to last write pos
 //func return write pos end ,and error
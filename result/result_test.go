@@ -4,6 +4,7 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
 	"github.com/pingcap/errors"
 	"reflect"
 
@@ -371,3 +372,113 @@ func TestStream_WriteData_Fail(t *testing.T) {
 	ast := assert.New(t)
 	ast.Equal(err2, err1)
 }
+
+func TestStream_NewResForWriteFile_Skipped(t *testing.T) {
+	pr := stream.NewPacketResFromRecord(&stream.PacketResRecord{
+		Columns: []stream.ColumnInfo{{Name: "a", Type: "VARCHAR"}},
+		Rows:    [][]stream.Value{{{Kind: stream.KindString, Data: "x"}}},
+	})
+	e := &stream.MySQLEvent{Type: util.EventQuery, Query: "select a from t"}
+
+	rr := &stream.ReplayRes{Columns: []stream.ColumnInfo{{Name: "a", Type: "INT"}}, ColValues: [][]driver.Value{{int64(1)}}}
+	rs, err := NewResForWriteFile(pr, rr, e, "./", "192.16.8.1.1:8000", new(os.File), 0)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, rs.ColumnDiff)
+	assert.NotEmpty(t, rs.ResultDiff)
+
+	rr.Skipped = "select statement in writes mode"
+	rs, err = NewResForWriteFile(pr, rr, e, "./", "192.16.8.1.1:8000", new(os.File), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "select statement in writes mode", rs.RrSkipped)
+	assert.Empty(t, rs.ColumnDiff)
+	assert.Empty(t, rs.ResultDiff)
+}
+
+func TestStream_NewResForWriteFile_Rewritten(t *testing.T) {
+	pr := stream.NewPacketResFromRecord(&stream.PacketResRecord{})
+	e := &stream.MySQLEvent{Type: util.EventQuery, Query: "select a from t for update"}
+
+	rs, err := NewResForWriteFile(pr, &stream.ReplayRes{SqlStatment: e.Query}, e, "./", "192.16.8.1.1:8000", new(os.File), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "select a from t for update", rs.Query)
+	assert.Empty(t, rs.RrQuery)
+
+	rr := &stream.ReplayRes{SqlStatment: "SELECT `a` FROM `t`", OrigSqlStatment: e.Query}
+	rs, err = NewResForWriteFile(pr, rr, e, "./", "192.16.8.1.1:8000", new(os.File), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "select a from t for update", rs.Query)
+	assert.Equal(t, "SELECT `a` FROM `t`", rs.RrQuery)
+
+	e = &stream.MySQLEvent{Type: util.EventStmtExecute, StmtID: "1"}
+	rr = &stream.ReplayRes{SqlStatment: "SELECT `a` FROM `t` WHERE `b`=?", OrigSqlStatment: "select a from t where b = ? for update", Values: []interface{}{int64(1)}}
+	rs, err = NewResForWriteFile(pr, rr, e, "./", "192.16.8.1.1:8000", new(os.File), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "select a from t where b = ? for update", rs.Query)
+	assert.Equal(t, "SELECT `a` FROM `t` WHERE `b`=?", rs.RrQuery)
+	assert.Equal(t, []interface{}{int64(1)}, rs.Params)
+}
+
+func TestStream_NewResForWriteFile_Targets(t *testing.T) {
+	pr := stream.NewPacketResFromRecord(&stream.PacketResRecord{
+		Columns: []stream.ColumnInfo{{Name: "a", Type: "INT"}},
+		Rows:    [][]stream.Value{{{Kind: stream.KindInt, Data: "1"}}},
+	})
+	e := &stream.MySQLEvent{Type: util.EventQuery, Query: "select a from t"}
+
+	columns := []stream.ColumnInfo{{Name: "a", Type: "INT"}}
+	rr := &stream.ReplayRes{SqlStatment: e.Query, Columns: columns, ColValues: [][]driver.Value{{int64(1)}}}
+	rr.Targets = []*stream.ReplayRes{
+		{Target: "dsn2", SqlStatment: e.Query, Columns: columns, ColValues: [][]driver.Value{{int64(2)}}},
+		{Target: "dsn3", SqlStatment: e.Query, ErrNO: 1146, ErrDesc: "Table 't' doesn't exist"},
+	}
+	rs, err := NewResForWriteFile(pr, rr, e, "./", "192.16.8.1.1:8000", new(os.File), 0)
+	assert.Nil(t, err)
+	assert.Empty(t, rs.ResultDiff)
+	assert.Equal(t, 2, len(rs.Targets))
+
+	assert.Equal(t, "dsn2", rs.Targets[0].Name)
+	assert.Empty(t, rs.Targets[0].ColumnDiff)
+	assert.Equal(t, []string{"row 0 column 0 int(1) != int(2)"}, rs.Targets[0].ResultDiff)
+
+	assert.Equal(t, "dsn3", rs.Targets[1].Name)
+	assert.Equal(t, uint16(1146), rs.Targets[1].RrErrorNo)
+	assert.Empty(t, rs.Targets[1].ResultDiff)
+
+	b, err := json.Marshal(rs)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), `"targets":[{"name":"dsn2"`)
+}
+
+func TestStream_NewResForWriteFile_Clone(t *testing.T) {
+	pr := stream.NewPacketResFromRecord(&stream.PacketResRecord{})
+	e := &stream.MySQLEvent{Type: util.EventQuery, Query: "select 1"}
+
+	rs, err := NewResForWriteFile(pr, &stream.ReplayRes{SqlStatment: e.Query, Clone: 2}, e, "./", "192.16.8.1.1:8000", new(os.File), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, rs.Clone)
+	b, err := json.Marshal(rs)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), `"clone":2`)
+
+	rs, err = NewResForWriteFile(pr, &stream.ReplayRes{SqlStatment: e.Query}, e, "./", "192.16.8.1.1:8000", new(os.File), 0)
+	assert.Nil(t, err)
+	b, err = json.Marshal(rs)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(b), `"clone"`)
+}
+
+func TestStream_NewResForWriteFile_Attempts(t *testing.T) {
+	pr := stream.NewPacketResFromRecord(&stream.PacketResRecord{})
+	e := &stream.MySQLEvent{Type: util.EventQuery, Query: "update t set a = 1"}
+	rr := &stream.ReplayRes{SqlStatment: e.Query, Attempts: 3}
+	rr.Targets = []*stream.ReplayRes{{SqlStatment: e.Query, Target: "dsn2", Attempts: 1}}
+
+	rs, err := NewResForWriteFile(pr, rr, e, "./", "192.16.8.1.1:8000", new(os.File), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, rs.RrAttempts)
+	assert.Equal(t, 1, rs.Targets[0].RrAttempts)
+	b, err := json.Marshal(rs)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), `"rr-attempts":3`)
+	assert.Contains(t, string(b), `"rr-attempts":1`)
+}
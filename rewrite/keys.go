@@ -0,0 +1,138 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package rewrite
+
+import (
+	"sort"
+
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/test_driver"
+)
+
+//OffsetKeys adds offset to the integer literals inserted into the columns
+//of keys , keys are lower case names . It returns the query and the
+//positions of the params inserted into the columns , the query itself is
+//returned if no literal is changed
+func OffsetKeys(query string, keys map[string]bool, offset int64) (string, []int) {
+	if len(keys) == 0 || offset == 0 {
+		return query, nil
+	}
+	stmts, _, err := parser.New().Parse(query, "", "")
+	if err != nil {
+		return query, nil
+	}
+	var (
+		changed bool
+		params  []int
+		base    int
+	)
+	for _, stmt := range stmts {
+		c := &markerCollector{}
+		stmt.Accept(c)
+		sort.Ints(c.offsets)
+		markers := make(map[int]int, len(c.offsets))
+		for i, offset := range c.offsets {
+			markers[offset] = base + i
+		}
+		base += len(c.offsets)
+
+		ins, ok := stmt.(*ast.InsertStmt)
+		if !ok {
+			continue
+		}
+		for _, expr := range insertedKeys(ins, keys) {
+			switch x := expr.(type) {
+			case *test_driver.ParamMarkerExpr:
+				params = append(params, markers[x.Offset])
+			case *test_driver.ValueExpr:
+				switch x.Kind() {
+				case test_driver.KindInt64:
+					x.SetInt64(x.GetInt64() + offset)
+					changed = true
+				case test_driver.KindUint64:
+					x.SetUint64(x.GetUint64() + uint64(offset))
+					changed = true
+				}
+			}
+		}
+	}
+	sort.Ints(params)
+	if !changed {
+		return query, params
+	}
+	s, err := restoreStmts(stmts)
+	if err != nil {
+		return query, params
+	}
+	return s, params
+}
+
+//insertedKeys returns the values inserted into the columns of keys
+func insertedKeys(ins *ast.InsertStmt, keys map[string]bool) []ast.ExprNode {
+	var exprs []ast.ExprNode
+	for _, row := range ins.Lists {
+		for i, expr := range row {
+			if i < len(ins.Columns) && keys[ins.Columns[i].Name.L] {
+				exprs = append(exprs, expr)
+			}
+		}
+	}
+	for _, a := range ins.Setlist {
+		if keys[a.Column.Name.L] {
+			exprs = append(exprs, a.Expr)
+		}
+	}
+	return exprs
+}
+
+//markerCollector collects the offsets of param markers
+type markerCollector struct {
+	offsets []int
+}
+
+func (c *markerCollector) Enter(n ast.Node) (ast.Node, bool) {
+	if x, ok := n.(*test_driver.ParamMarkerExpr); ok {
+		c.offsets = append(c.offsets, x.Offset)
+	}
+	return n, false
+}
+
+func (c *markerCollector) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+//OffsetParams adds offset to the integer params at the positions , params
+//decoded from the binary protocol are int64 or uint64 , params is not changed
+func OffsetParams(params []interface{}, positions []int, offset int64) []interface{} {
+	if len(positions) == 0 || offset == 0 {
+		return params
+	}
+	res := make([]interface{}, len(params))
+	copy(res, params)
+	for _, i := range positions {
+		if i >= len(res) {
+			continue
+		}
+		switch x := res[i].(type) {
+		case int64:
+			res[i] = x + offset
+		case uint64:
+			res[i] = x + uint64(offset)
+		}
+	}
+	return res
+}
@@ -0,0 +1,43 @@
+package rewrite
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOffsetKeys(t *testing.T) {
+	keys := map[string]bool{"id": true, "order_id": true}
+	tests := []struct {
+		query  string
+		want   string
+		params []int
+	}{
+		{"insert into t (id, name) values (1, 'a'), (2, 'b')", "INSERT INTO `t` (`id`,`name`) VALUES (1001,_UTF8MB4'a'),(1002,_UTF8MB4'b')", nil},
+		{"insert into t set ID = 5, name = 'a'", "INSERT INTO `t` SET `ID`=1005,`name`=_UTF8MB4'a'", nil},
+		{"replace into t (name, order_id) values ('a', 18446744073709550000)", "REPLACE INTO `t` (`name`,`order_id`) VALUES (_UTF8MB4'a',18446744073709551000)", nil},
+		{"insert into t (name, id, order_id) values (?, ?, 7), (?, ?, ?)", "INSERT INTO `t` (`name`,`id`,`order_id`) VALUES (?,?,1007),(?,?,?)", []int{1, 3, 4}},
+		{"insert into t (name, id) values (?, ?)", "insert into t (name, id) values (?, ?)", []int{1}},
+		{"insert into t values (1, 'a')", "insert into t values (1, 'a')", nil},
+		{"insert into t (id) select id from s", "insert into t (id) select id from s", nil},
+		{"update t set id = 1 where id = 2", "update t set id = 1 where id = 2", nil},
+		{"not a statement", "not a statement", nil},
+	}
+	for _, tt := range tests {
+		query, params := OffsetKeys(tt.query, keys, 1000)
+		assert.Equal(t, tt.want, query, tt.query)
+		assert.Equal(t, tt.params, params, tt.query)
+	}
+
+	query, params := OffsetKeys("insert into t (id) values (1)", keys, 0)
+	assert.Equal(t, "insert into t (id) values (1)", query)
+	assert.Nil(t, params)
+}
+
+func TestOffsetParams(t *testing.T) {
+	params := []interface{}{"a", int64(1), uint64(2), 1.5, nil}
+	res := OffsetParams(params, []int{1, 2, 3, 4, 9}, 1000)
+	assert.Equal(t, []interface{}{"a", int64(1001), uint64(1002), 1.5, nil}, res)
+	assert.Equal(t, int64(1), params[1])
+	assert.Equal(t, params, OffsetParams(params, nil, 1000))
+}
@@ -0,0 +1,433 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package rewrite
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tidb/parser/ast"
+	"github.com/pingcap/tidb/parser/format"
+	"github.com/pingcap/tidb/parser/model"
+	_ "github.com/pingcap/tidb/parser/test_driver"
+)
+
+//types of rules
+const (
+	//replace the parts of the query matched by pattern with replace ,
+	//replace may refer to groups of pattern , e.g. $1
+	TypeRegex = "regex"
+	//rename the database from to database to
+	TypeRenameSchema = "rename-schema"
+	//rename the table from to table to , both are table or db.table ,
+	//tables without db are in the database of the connection
+	TypeRenameTable = "rename-table"
+	//add the optimizer hints of hint to select , insert , update and
+	//delete statements , e.g. use_index(t, idx) , max_execution_time(1000)
+	TypeAddHint = "add-hint"
+	//remove the optimizer hints named hint , or all hints if hint is empty
+	TypeRemoveHint = "remove-hint"
+	//call the function to instead of the function from
+	TypeReplaceFunction = "replace-function"
+	//remove for update , for share and lock in share mode of selects
+	TypeRemoveForUpdate = "remove-for-update"
+)
+
+//Rules is the rewriting config , a JSON file like
+//
+//	{
+//		"rules": [
+//			{"type": "rename-schema", "from": "prod", "to": "staging"},
+//			{"type": "rename-table", "from": "shop.orders", "to": "shop.orders_0"},
+//			{"type": "add-hint", "hint": "use_index(orders, idx_user)", "match": "(?i)from orders"},
+//			{"type": "remove-hint", "hint": "use_index"},
+//			{"type": "replace-function", "from": "now", "to": "utc_timestamp"},
+//			{"type": "remove-for-update"},
+//			{"type": "regex", "pattern": "(?i)\\bsql_no_cache\\b", "replace": ""}
+//		]
+//	}
+//
+//rules are applied in order , a rule with match only applies to queries
+//matched by the regex . Queries changed by the other rules than regex are
+//restored from the AST , rules other than regex are not applied to queries
+//that can not be parsed
+type Rules struct {
+	Rules []Rule `json:"rules"`
+}
+
+type Rule struct {
+	Type    string `json:"type"`
+	Match   string `json:"match,omitempty"`
+	From    string `json:"from,omitempty"`
+	To      string `json:"to,omitempty"`
+	Hint    string `json:"hint,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+	Replace string `json:"replace,omitempty"`
+}
+
+type rule struct {
+	kind  string
+	match *regexp.Regexp
+	//regex
+	pattern *regexp.Regexp
+	replace string
+	//rename and replace , names to rename from are lower case
+	fromDB, from string
+	toDB, to     string
+	//hints
+	hints    []*ast.TableOptimizerHint
+	hintName string
+	//maps the databases instead of from and to of rename-schema
+	mapDB func(string) (string, bool)
+}
+
+//Rewriter rewrites queries before they are replayed . A nil Rewriter
+//rewrites nothing
+type Rewriter struct {
+	rules []rule
+}
+
+func New(r Rules) (*Rewriter, error) {
+	w := &Rewriter{}
+	for i, c := range r.Rules {
+		x, err := newRule(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rewrite rule %d , %v", i, err)
+		}
+		w.rules = append(w.rules, x)
+	}
+	return w, nil
+}
+
+func newRule(c Rule) (rule, error) {
+	var (
+		x   = rule{kind: c.Type}
+		err error
+	)
+	if len(c.Match) > 0 {
+		if x.match, err = regexp.Compile(c.Match); err != nil {
+			return x, err
+		}
+	}
+	switch c.Type {
+	case TypeRegex:
+		if len(c.Pattern) == 0 {
+			return x, fmt.Errorf("pattern of %s is empty", c.Type)
+		}
+		x.pattern, err = regexp.Compile(c.Pattern)
+		x.replace = c.Replace
+	case TypeRenameSchema:
+		if len(c.From) == 0 || len(c.To) == 0 {
+			return x, fmt.Errorf("from or to of %s is empty", c.Type)
+		}
+		x.from, x.to = strings.ToLower(c.From), c.To
+	case TypeRenameTable:
+		x.fromDB, x.from, err = splitTable(c.From)
+		if err != nil {
+			return x, err
+		}
+		x.fromDB, x.from = strings.ToLower(x.fromDB), strings.ToLower(x.from)
+		x.toDB, x.to, err = splitTable(c.To)
+	case TypeAddHint:
+		x.hints, err = parseHints(c.Hint)
+	case TypeRemoveHint:
+		x.hintName = strings.ToLower(strings.TrimSpace(c.Hint))
+	case TypeReplaceFunction:
+		if len(c.From) == 0 || len(c.To) == 0 {
+			return x, fmt.Errorf("from or to of %s is empty", c.Type)
+		}
+		x.from, x.to = strings.ToLower(c.From), c.To
+	case TypeRemoveForUpdate:
+	default:
+		return x, fmt.Errorf("unknown type %s", c.Type)
+	}
+	return x, err
+}
+
+//splitTable splits table or db.table
+func splitTable(s string) (string, string, error) {
+	fields := strings.Split(s, ".")
+	switch {
+	case len(fields) == 1 && len(fields[0]) > 0:
+		return "", fields[0], nil
+	case len(fields) == 2 && len(fields[0]) > 0 && len(fields[1]) > 0:
+		return fields[0], fields[1], nil
+	}
+	return "", "", fmt.Errorf("invalid table %s", s)
+}
+
+//parseHints parses the hints of a select statement with the hints
+func parseHints(hint string) ([]*ast.TableOptimizerHint, error) {
+	stmt, err := parser.New().ParseOneStmt("select /*+ "+hint+" */ 1", "", "")
+	if err != nil {
+		return nil, err
+	}
+	hints := stmt.(*ast.SelectStmt).TableHints
+	if len(hints) == 0 {
+		return nil, fmt.Errorf("invalid hint %s", hint)
+	}
+	return hints, nil
+}
+
+//Load reads the rules from a JSON file
+func Load(name string) (*Rewriter, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var r Rules
+	if err = json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("parse rewrite rules %s fail , %v", name, err)
+	}
+	return New(r)
+}
+
+//Default rewrites the queries replayed by the process , it is set by
+//commands before events are handled
+var Default *Rewriter
+
+func Init(name string) error {
+	if len(name) == 0 {
+		Default = nil
+		return nil
+	}
+	w, err := Load(name)
+	if err != nil {
+		return err
+	}
+	Default = w
+	return nil
+}
+
+//Query rewrites a query executed in the database db , it returns the query
+//itself if no rule changes it
+func (w *Rewriter) Query(db string, query string) string {
+	if w == nil || len(query) == 0 {
+		return query
+	}
+	var (
+		stmts []ast.StmtNode
+		//the AST is changed but not restored yet
+		changed   bool
+		parseFail bool
+	)
+	restore := func() {
+		if changed {
+			if s, err := restoreStmts(stmts); err == nil {
+				query = s
+			}
+		}
+		stmts, changed = nil, false
+	}
+	for i := range w.rules {
+		r := &w.rules[i]
+		if r.match != nil {
+			restore()
+			if !r.match.MatchString(query) {
+				continue
+			}
+		}
+		if r.kind == TypeRegex {
+			restore()
+			query = r.pattern.ReplaceAllString(query, r.replace)
+			parseFail = false
+			continue
+		}
+		if stmts == nil && !parseFail {
+			var err error
+			stmts, _, err = parser.New().Parse(query, "", "")
+			parseFail = err != nil
+		}
+		for _, stmt := range stmts {
+			if r.apply(db, stmt) {
+				changed = true
+			}
+		}
+	}
+	restore()
+	return query
+}
+
+//Schemas renames the databases of qualified tables , columns and use
+//statements by mapDB , it returns the query itself if no database is renamed
+func Schemas(query string, mapDB func(string) (string, bool)) string {
+	w := &Rewriter{rules: []rule{{kind: TypeRenameSchema, mapDB: mapDB}}}
+	return w.Query("", query)
+}
+
+func restoreStmts(stmts []ast.StmtNode) (string, error) {
+	var sb strings.Builder
+	for i, stmt := range stmts {
+		if i > 0 {
+			sb.WriteString("; ")
+		}
+		if err := stmt.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, &sb)); err != nil {
+			return "", err
+		}
+	}
+	return sb.String(), nil
+}
+
+//apply applies a rule other than regex to a statement , it tells if the
+//statement is changed
+func (r *rule) apply(db string, stmt ast.StmtNode) bool {
+	switch r.kind {
+	case TypeAddHint:
+		hints := stmtHints(stmt)
+		if hints == nil {
+			return false
+		}
+		*hints = append(*hints, r.hints...)
+		return true
+	case TypeRemoveHint:
+		hints := stmtHints(stmt)
+		if hints == nil || len(*hints) == 0 {
+			return false
+		}
+		kept := (*hints)[:0]
+		for _, h := range *hints {
+			if len(r.hintName) > 0 && h.HintName.L != r.hintName {
+				kept = append(kept, h)
+			}
+		}
+		changed := len(kept) != len(*hints)
+		*hints = kept
+		return changed
+	}
+	v := &visitor{r: r, db: strings.ToLower(db)}
+	stmt.Accept(v)
+	return v.changed
+}
+
+//stmtHints returns the optimizer hints of a statement , nil if the
+//statement has no hints
+func stmtHints(stmt ast.StmtNode) *[]*ast.TableOptimizerHint {
+	switch x := stmt.(type) {
+	case *ast.SelectStmt:
+		return &x.TableHints
+	case *ast.InsertStmt:
+		return &x.TableHints
+	case *ast.UpdateStmt:
+		return &x.TableHints
+	case *ast.DeleteStmt:
+		return &x.TableHints
+	}
+	return nil
+}
+
+type visitor struct {
+	r *rule
+	//database of the connection
+	db      string
+	changed bool
+}
+
+func (v *visitor) Enter(n ast.Node) (ast.Node, bool) {
+	r := v.r
+	switch x := n.(type) {
+	case *ast.TableName:
+		switch r.kind {
+		case TypeRenameSchema:
+			if db, ok := r.schema(x.Schema.O); ok {
+				x.Schema = model.NewCIStr(db)
+				v.changed = true
+			}
+		case TypeRenameTable:
+			if v.matchTable(x.Schema.L, x.Name.L) {
+				x.Name = model.NewCIStr(r.to)
+				if len(r.toDB) > 0 {
+					x.Schema = model.NewCIStr(r.toDB)
+				}
+				v.changed = true
+			}
+		}
+	case *ast.ColumnName:
+		switch r.kind {
+		case TypeRenameSchema:
+			if db, ok := r.schema(x.Schema.O); ok {
+				x.Schema = model.NewCIStr(db)
+				v.changed = true
+			}
+		case TypeRenameTable:
+			//columns qualified by the table name , not an alias
+			if len(x.Table.L) > 0 && v.matchTable(x.Schema.L, x.Table.L) {
+				x.Table = model.NewCIStr(r.to)
+				if len(x.Schema.L) > 0 && len(r.toDB) > 0 {
+					x.Schema = model.NewCIStr(r.toDB)
+				}
+				v.changed = true
+			}
+		}
+	case *ast.UseStmt:
+		if r.kind != TypeRenameSchema {
+			break
+		}
+		if db, ok := r.schema(x.DBName); ok {
+			x.DBName = db
+			v.changed = true
+		}
+	case *ast.FuncCallExpr:
+		if r.kind == TypeReplaceFunction && x.FnName.L == r.from {
+			x.FnName = model.NewCIStr(r.to)
+			v.changed = true
+		}
+	case *ast.AggregateFuncExpr:
+		if r.kind == TypeReplaceFunction && strings.ToLower(x.F) == r.from {
+			x.F = r.to
+			v.changed = true
+		}
+	case *ast.SelectStmt:
+		if r.kind == TypeRemoveForUpdate && x.LockInfo != nil && x.LockInfo.LockType != ast.SelectLockNone {
+			x.LockInfo = nil
+			v.changed = true
+		}
+	}
+	return n, false
+}
+
+func (v *visitor) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+//schema returns the new name of the database of rename-schema
+func (r *rule) schema(name string) (string, bool) {
+	if len(name) == 0 {
+		return "", false
+	}
+	if r.mapDB != nil {
+		return r.mapDB(name)
+	}
+	return r.to, strings.ToLower(name) == r.from
+}
+
+//matchTable tells if the table schema.name is the table to rename , the
+//table is in the database of the connection if schema is empty
+func (v *visitor) matchTable(schema, name string) bool {
+	if name != v.r.from {
+		return false
+	}
+	if len(v.r.fromDB) == 0 {
+		return true
+	}
+	if len(schema) == 0 {
+		schema = v.db
+	}
+	return schema == v.r.fromDB
+}
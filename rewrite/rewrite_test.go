@@ -0,0 +1,182 @@
+package rewrite
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewriter_Query(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rule
+		db    string
+		query string
+		want  string
+	}{
+		{
+			name:  "no rules",
+			query: "select * from t",
+			want:  "select * from t",
+		},
+		{
+			name:  "regex",
+			rules: []Rule{{Type: TypeRegex, Pattern: `(?i)\s+for\s+update\s*$`}},
+			query: "select * from t where id = 1 for update",
+			want:  "select * from t where id = 1",
+		},
+		{
+			name:  "regex groups and match",
+			rules: []Rule{{Type: TypeRegex, Match: "^insert", Pattern: `orders_(\d+)`, Replace: "orders_x$1"}},
+			query: "select * from orders_1",
+			want:  "select * from orders_1",
+		},
+		{
+			name:  "rename schema",
+			rules: []Rule{{Type: TypeRenameSchema, From: "Prod", To: "staging"}},
+			query: "select prod.t.a from prod.t join x on t.a = x.a",
+			want:  "SELECT `staging`.`t`.`a` FROM `staging`.`t` JOIN `x` ON `t`.`a`=`x`.`a`",
+		},
+		{
+			name:  "rename schema of use",
+			rules: []Rule{{Type: TypeRenameSchema, From: "prod", To: "staging"}},
+			query: "use prod",
+			want:  "USE `staging`",
+		},
+		{
+			name:  "rename table",
+			rules: []Rule{{Type: TypeRenameTable, From: "shop.orders", To: "orders_0"}},
+			db:    "shop",
+			query: "select orders.id, o.id from orders, shop.orders o, crm.orders",
+			want:  "SELECT `orders_0`.`id`,`o`.`id` FROM ((`orders_0`) JOIN `shop`.`orders_0` AS `o`) JOIN `crm`.`orders`",
+		},
+		{
+			name:  "rename table to another db",
+			rules: []Rule{{Type: TypeRenameTable, From: "orders", To: "archive.orders_0"}},
+			db:    "shop",
+			query: "update orders set a = 1 where id = 2",
+			want:  "UPDATE `archive`.`orders_0` SET `a`=1 WHERE `id`=2",
+		},
+		{
+			name:  "table of another db",
+			rules: []Rule{{Type: TypeRenameTable, From: "shop.orders", To: "orders_0"}},
+			db:    "crm",
+			query: "select * from orders",
+			want:  "select * from orders",
+		},
+		{
+			name:  "add hint",
+			rules: []Rule{{Type: TypeAddHint, Hint: "use_index(t, idx), max_execution_time(1000)"}},
+			query: "select a from t where b = 1",
+			want:  "SELECT /*+ USE_INDEX(`t` `idx`) MAX_EXECUTION_TIME(1000)*/ `a` FROM `t` WHERE `b`=1",
+		},
+		{
+			name:  "add hint to matched queries",
+			rules: []Rule{{Type: TypeAddHint, Hint: "max_execution_time(1000)", Match: "(?i)from x"}},
+			query: "select a from t",
+			want:  "select a from t",
+		},
+		{
+			name:  "remove hint",
+			rules: []Rule{{Type: TypeRemoveHint, Hint: "USE_INDEX"}},
+			query: "select /*+ use_index(t, idx) max_execution_time(1000) */ a from t",
+			want:  "SELECT /*+ MAX_EXECUTION_TIME(1000)*/ `a` FROM `t`",
+		},
+		{
+			name:  "remove all hints",
+			rules: []Rule{{Type: TypeRemoveHint}},
+			query: "delete /*+ use_index(t, idx) */ from t where a = 1",
+			want:  "DELETE FROM `t` WHERE `a`=1",
+		},
+		{
+			name:  "replace function",
+			rules: []Rule{{Type: TypeReplaceFunction, From: "NOW", To: "utc_timestamp"}, {Type: TypeReplaceFunction, From: "count", To: "sum"}},
+			query: "select now(), count(a) from t",
+			want:  "SELECT UTC_TIMESTAMP(),SUM(`a`) FROM `t`",
+		},
+		{
+			name:  "remove for update",
+			rules: []Rule{{Type: TypeRemoveForUpdate}},
+			query: "select a from t where b = 'x' for update",
+			want:  "SELECT `a` FROM `t` WHERE `b`=_UTF8MB4'x'",
+		},
+		{
+			name:  "unchanged ast",
+			rules: []Rule{{Type: TypeRemoveForUpdate}, {Type: TypeRenameTable, From: "x", To: "y"}},
+			query: "select a from t",
+			want:  "select a from t",
+		},
+		{
+			name:  "rules in order",
+			rules: []Rule{{Type: TypeRenameTable, From: "t", To: "t1"}, {Type: TypeRegex, Pattern: "`t1`", Replace: "`t2`"}, {Type: TypeRemoveForUpdate}},
+			query: "select a from t for update",
+			want:  "SELECT `a` FROM `t2`",
+		},
+		{
+			name:  "not parsed",
+			rules: []Rule{{Type: TypeRemoveForUpdate}, {Type: TypeRegex, Pattern: "selec ", Replace: "select "}},
+			query: "selec a from t for update",
+			want:  "select a from t for update",
+		},
+	}
+	for _, tt := range tests {
+		w, err := New(Rules{Rules: tt.rules})
+		assert.Nil(t, err, tt.name)
+		assert.Equal(t, tt.want, w.Query(tt.db, tt.query), tt.name)
+	}
+
+	var w *Rewriter
+	assert.Equal(t, "select 1", w.Query("", "select 1"))
+}
+
+func TestNew_invalid(t *testing.T) {
+	for _, r := range []Rule{
+		{Type: "drop"},
+		{Type: TypeRegex},
+		{Type: TypeRegex, Pattern: "("},
+		{Type: TypeRemoveForUpdate, Match: "("},
+		{Type: TypeRenameSchema, From: "a"},
+		{Type: TypeRenameTable, From: "a.b.c", To: "d"},
+		{Type: TypeRenameTable, From: "a", To: "."},
+		{Type: TypeAddHint, Hint: "not a hint("},
+		{Type: TypeReplaceFunction, To: "f"},
+	} {
+		_, err := New(Rules{Rules: []Rule{r}})
+		assert.NotNil(t, err, r.Type)
+	}
+}
+
+func TestInit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rewrite")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "rewrite.json")
+	assert.Nil(t, ioutil.WriteFile(name, []byte(`{"rules": [{"type": "remove-for-update"}]}`), 0644))
+	assert.Nil(t, Init(name))
+	assert.NotNil(t, Default)
+	assert.Equal(t, "SELECT 1", Default.Query("", "select 1 for update"))
+
+	assert.Nil(t, Init(""))
+	assert.Nil(t, Default)
+
+	assert.Nil(t, ioutil.WriteFile(name, []byte(`{"rules": [`), 0644))
+	assert.NotNil(t, Init(name))
+	assert.NotNil(t, Init(filepath.Join(dir, "missing.json")))
+}
+
+func TestSchemas(t *testing.T) {
+	mapDB := func(db string) (string, bool) {
+		if db == "prod" {
+			return "stage", true
+		}
+		return db, false
+	}
+	assert.Equal(t, "USE `stage`", Schemas("use prod", mapDB))
+	assert.Equal(t, "SELECT `stage`.`t`.`a` FROM (`stage`.`t`) JOIN `crm`.`x`", Schemas("select prod.t.a from prod.t, crm.x", mapDB))
+	assert.Equal(t, "select a from t", Schemas("select a from t", mapDB))
+	assert.Equal(t, "select a from crm.t", Schemas("select a from crm.t", mapDB))
+}
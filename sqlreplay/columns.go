@@ -0,0 +1,90 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package sqlreplay
+
+import (
+	"database/sql"
+	"reflect"
+
+	"github.com/bobguo/mysql-replay/stream"
+)
+
+//read column definitions from replay server result
+func (h *ReplayEventHandler) ReadColumns(f *sql.Rows, e *stream.MySQLEvent) {
+	columns := getDriverColumns(f)
+	if columns == nil {
+		//not a go-sql-driver result , only the information
+		//exposed by database/sql is available
+		types, err := f.ColumnTypes()
+		if err != nil {
+			h.log.Warn("get column types fail , " + err.Error())
+			return
+		}
+		columns = make([]stream.ColumnInfo, 0, len(types))
+		for _, tp := range types {
+			ci := stream.ColumnInfo{
+				Name: tp.Name(),
+				Type: tp.DatabaseTypeName(),
+			}
+			if _, scale, ok := tp.DecimalSize(); ok {
+				ci.Decimals = uint8(scale)
+			}
+			columns = append(columns, ci)
+		}
+	}
+	e.Rr.Columns = columns
+	e.Rr.ColumnNum = len(columns)
+	e.Rr.ColNames = make([]string, 0, len(columns))
+	for _, column := range columns {
+		e.Rr.ColNames = append(e.Rr.ColNames, column.Name)
+	}
+}
+
+//Get the raw column definitions kept by go-sql-driver in
+//sql.Rows.rowsi.rs.columns via reflection, return nil if
+//the driver rows is not the one of go-sql-driver
+func getDriverColumns(f *sql.Rows) (columns []stream.ColumnInfo) {
+	defer func() {
+		if x := recover(); x != nil {
+			columns = nil
+		}
+	}()
+	rowsi := reflect.ValueOf(f).Elem().FieldByName("rowsi")
+	if !rowsi.IsValid() || rowsi.IsNil() {
+		return nil
+	}
+	rows := rowsi.Elem()
+	if rows.Kind() != reflect.Ptr || rows.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	fields := rows.Elem().FieldByName("rs").FieldByName("columns")
+	if !fields.IsValid() || fields.Kind() != reflect.Slice {
+		return nil
+	}
+	columns = make([]stream.ColumnInfo, 0, fields.Len())
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Index(i)
+		columns = append(columns, stream.NewColumnInfo(
+			field.FieldByName("name").String(),
+			byte(field.FieldByName("fieldType").Uint()),
+			uint16(field.FieldByName("flags").Uint()),
+			byte(field.FieldByName("decimals").Uint()),
+			uint8(field.FieldByName("charSet").Uint()),
+			uint32(field.FieldByName("length").Uint()),
+		))
+	}
+	return columns
+}
@@ -0,0 +1,43 @@
+package sqlreplay
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestSqlReplay_ReadColumns_Fallback(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRowsWithColumnDefinition(
+		sqlmock.NewColumn("id").OfType("BIGINT", int64(1)),
+		sqlmock.NewColumn("price").OfType("DECIMAL", 1.5).WithPrecisionAndScale(10, 2),
+	).AddRow(int64(1), 1.5)
+	mock.ExpectQuery("select").WillReturnRows(rows)
+
+	f, err := db.Query("select id,price from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	h := &ReplayEventHandler{log: zap.NewNop()}
+	e := new(stream.MySQLEvent)
+	e.NewReplayRes()
+	h.ReadColumns(f, e)
+
+	ast := assert.New(t)
+	ast.Equal(2, e.Rr.ColumnNum)
+	ast.Equal([]string{"id", "price"}, e.Rr.ColNames)
+	ast.Equal([]stream.ColumnInfo{
+		{Name: "id", Type: "BIGINT"},
+		{Name: "price", Type: "DECIMAL", Decimals: 2},
+	}, e.Rr.Columns)
+}
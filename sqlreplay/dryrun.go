@@ -0,0 +1,189 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package sqlreplay
+
+import (
+	"context"
+
+	"github.com/bobguo/mysql-replay/parse"
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/pingcap/errors"
+)
+
+//dryRun keeps the writes of a connection in transactions rolled back on
+//the replay server , autocommit writes are executed in transactions of
+//their own and commits of captured transactions are executed as rollbacks .
+//ddl and statements that can not be parsed are skipped by the config
+type dryRun struct {
+	txn *txnTracker
+	//a transaction is open on the replay server
+	open bool
+}
+
+func newDryRun() *dryRun {
+	return &dryRun{txn: newTxnTracker()}
+}
+
+//dryRunPlan tells how an event is executed in dry-run mode
+type dryRunPlan struct {
+	//executed before the event
+	before string
+	//the event is executed as a rollback
+	rollback bool
+	//executed after the event
+	after string
+	//reason if the event is not executed
+	skip string
+}
+
+//plan returns how the event is executed , events must be given in the
+//captured order of the connection
+func (d *dryRun) plan(e *stream.MySQLEvent) dryRunPlan {
+	var (
+		p     dryRunPlan
+		query string
+		kind  uint16
+	)
+	switch e.Type {
+	case util.EventHandshake, util.EventQuit:
+		//the transaction is rolled back when the connection is closed
+		d.txn.reset()
+		d.open = false
+		return p
+	case util.EventQuery:
+		query, kind = e.Query, stmtType(e.Query)
+	case util.EventStmtPrepare:
+		d.txn.stmts[e.StmtID] = stmtType(e.Query)
+		return p
+	case util.EventStmtExecute:
+		var ok bool
+		if kind, ok = d.txn.stmts[e.StmtID]; !ok {
+			return p
+		}
+	case util.EventStmtClose:
+		delete(d.txn.stmts, e.StmtID)
+		return p
+	default:
+		return p
+	}
+
+	t := d.txn
+	autocommitWrite := t.autocommit && !t.inTxn
+	switch kind {
+	case util.BeginStmt:
+		//begin commits the open transaction
+		if d.open {
+			p.before = "ROLLBACK"
+		}
+		d.open = true
+	case util.CommitStmt:
+		p.rollback = true
+		d.open = false
+	case util.RollbackStmt:
+		d.open = false
+	case util.SetStmt:
+		if len(query) == 0 {
+			//the value of a prepared set statement is unknown , it may
+			//turn autocommit on and commit the open transaction
+			if d.open {
+				p.before = "ROLLBACK"
+				d.open = false
+			}
+			break
+		}
+		on, ok := parse.Autocommit(query)
+		if !ok {
+			break
+		}
+		//autocommit of the replay server is never turned off , writes
+		//are kept in explicit transactions instead
+		p.skip = "autocommit is emulated by transactions in dry-run mode"
+		if on && !t.autocommit && d.open {
+			p.before = "ROLLBACK"
+			d.open = false
+		}
+	case util.InsertStmt, util.UpdateStmt, util.DeleteStmt:
+		if !d.open {
+			p.before = "BEGIN"
+			d.open = true
+		}
+		if autocommitWrite {
+			p.after = "ROLLBACK"
+			d.open = false
+		}
+	}
+	t.statement(query, kind)
+	return p
+}
+
+//applyDryRun applies the event in dry-run mode , see dryRun
+func (h *ReplayEventHandler) applyDryRun(ctx context.Context, e *stream.MySQLEvent) error {
+	p := h.dry.plan(e)
+	if len(p.before) > 0 {
+		if err := h.execDryRun(ctx, p.before); err != nil {
+			//the event must not be executed outside of a transaction ,
+			//closing the connection rolls back the open transaction
+			h.quit(true)
+			h.dry.open = false
+			return errors.Annotate(err, "dry-run "+p.before)
+		}
+	}
+	if len(p.skip) > 0 {
+		e.Rr.SqlStatment = e.Query
+		e.Rr.Skipped = p.skip
+		return nil
+	}
+
+	var err error
+	if p.rollback {
+		err = h.execute(ctx, "ROLLBACK", e)
+		if err != nil {
+			h.quit(true)
+		}
+	} else {
+		err = h.ApplyEvent(ctx, e)
+	}
+
+	if len(p.after) > 0 {
+		if rerr := h.execDryRun(ctx, p.after); rerr != nil {
+			h.log.Warn("dry-run " + p.after + " fail , close the connection , " + rerr.Error())
+			h.quit(true)
+		}
+	}
+	return err
+}
+
+//resumeDryRun opens the transaction again after reconnecting , so that the
+//event is not executed outside of a transaction
+func (h *ReplayEventHandler) resumeDryRun(ctx context.Context) error {
+	if h.dry == nil || !h.dry.open {
+		return nil
+	}
+	return h.execDryRun(ctx, "BEGIN")
+}
+
+func (h *ReplayEventHandler) execDryRun(ctx context.Context, query string) error {
+	if len(h.cfg.Dsn) == 0 {
+		return nil
+	}
+	conn, err := h.getConn(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, query)
+	return err
+}
@@ -0,0 +1,97 @@
+package sqlreplay
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRun_plan(t *testing.T) {
+	const skip = "autocommit is emulated by transactions in dry-run mode"
+	tests := []struct {
+		name   string
+		events []stream.MySQLEvent
+		plans  []dryRunPlan
+	}{
+		{
+			name:   "autocommit",
+			events: []stream.MySQLEvent{query("select 1"), query("insert into t values (1)"), query("update t set a = 1")},
+			plans:  []dryRunPlan{{}, {before: "BEGIN", after: "ROLLBACK"}, {before: "BEGIN", after: "ROLLBACK"}},
+		},
+		{
+			name: "transaction",
+			events: []stream.MySQLEvent{query("begin"), query("insert into t values (1)"), query("select 1"),
+				query("commit"), query("delete from t")},
+			plans: []dryRunPlan{{}, {}, {}, {rollback: true}, {before: "BEGIN", after: "ROLLBACK"}},
+		},
+		{
+			name:   "rollback",
+			events: []stream.MySQLEvent{query("start transaction"), query("update t set a = 1"), query("rollback"), query("update t set a = 1")},
+			plans:  []dryRunPlan{{}, {}, {}, {before: "BEGIN", after: "ROLLBACK"}},
+		},
+		{
+			name:   "begin in transaction",
+			events: []stream.MySQLEvent{query("begin"), query("insert into t values (1)"), query("begin"), query("commit")},
+			plans:  []dryRunPlan{{}, {}, {before: "ROLLBACK"}, {rollback: true}},
+		},
+		{
+			name: "autocommit off",
+			events: []stream.MySQLEvent{query("set autocommit = 0"), query("insert into t values (1)"), query("update t set a = 1"),
+				query("commit"), query("delete from t"), query("set autocommit = 1"), query("delete from t")},
+			plans: []dryRunPlan{{skip: skip}, {before: "BEGIN"}, {}, {rollback: true}, {before: "BEGIN"},
+				{before: "ROLLBACK", skip: skip}, {before: "BEGIN", after: "ROLLBACK"}},
+		},
+		{
+			name: "prepared statements",
+			events: []stream.MySQLEvent{
+				{Type: util.EventStmtPrepare, StmtID: "1", Query: "insert into t values (?)"},
+				{Type: util.EventStmtPrepare, StmtID: "2", Query: "commit"},
+				{Type: util.EventStmtExecute, StmtID: "1"},
+				query("begin"),
+				{Type: util.EventStmtExecute, StmtID: "1"},
+				{Type: util.EventStmtExecute, StmtID: "2"},
+				{Type: util.EventStmtClose, StmtID: "1"},
+				{Type: util.EventStmtExecute, StmtID: "1"},
+			},
+			plans: []dryRunPlan{{}, {}, {before: "BEGIN", after: "ROLLBACK"}, {}, {}, {rollback: true}, {}, {}},
+		},
+		{
+			name:   "reconnect",
+			events: []stream.MySQLEvent{query("begin"), query("insert into t values (1)"), {Type: util.EventQuit}, {Type: util.EventHandshake}, query("insert into t values (1)")},
+			plans:  []dryRunPlan{{}, {}, {}, {}, {before: "BEGIN", after: "ROLLBACK"}},
+		},
+	}
+	for _, tt := range tests {
+		d := newDryRun()
+		for i, e := range tt.events {
+			assert.Equal(t, tt.plans[i], d.plan(&e), "%s : %d", tt.name, i)
+		}
+	}
+}
+
+func TestReplayEventHandler_applyDryRun(t *testing.T) {
+	cfg := &util.Config{DryRun: true}
+	assert.Nil(t, cfg.CheckMode())
+	h := &ReplayEventHandler{cfg: cfg, dry: newDryRun(), stmts: make(map[string]statement), skipped: make(map[string]skippedStmt)}
+
+	e := query("set autocommit = 0")
+	e.NewReplayRes()
+	assert.Nil(t, h.applyDryRun(context.Background(), &e))
+	assert.Equal(t, "autocommit is emulated by transactions in dry-run mode", e.Rr.Skipped)
+	assert.Equal(t, "set autocommit = 0", e.Rr.SqlStatment)
+
+	e = query("create table t (a int)")
+	e.NewReplayRes()
+	assert.True(t, h.skip(&e))
+	assert.Equal(t, "ddl statement in dry-run mode", e.Rr.Skipped)
+
+	e = query("insert into t values (1)")
+	e.NewReplayRes()
+	assert.Nil(t, h.applyDryRun(context.Background(), &e))
+	assert.Empty(t, e.Rr.Skipped)
+	assert.True(t, h.dry.open)
+	assert.Nil(t, h.resumeDryRun(context.Background()))
+}
@@ -0,0 +1,90 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package sqlreplay
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"net"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/pingcap/errors"
+)
+
+//the prepared statement of stmt execute event is unknown on replay server,
+//usually because the prepare failed or was captured before pcap begin
+var ErrStmtNotFound = errors.New("prepared statement is not exist , maybe prepare fail")
+
+//no session of --max-sessions is given to the connection in --max-session-wait
+var ErrSessionWait = errors.New("wait for a session of the replay server timeout")
+
+//classify the error returned by ApplyEvent
+func classifyError(err error) util.ErrCategory {
+	if err == nil {
+		return util.ErrCategoryNone
+	}
+	cause := errors.Cause(err)
+	switch cause.(type) {
+	case *mysql.MySQLError, *pq.Error:
+		return util.ErrCategoryServer
+	}
+	if netErr, ok := cause.(net.Error); ok {
+		if netErr.Timeout() {
+			return util.ErrCategoryTimeout
+		}
+		return util.ErrCategoryNetwork
+	}
+	switch cause {
+	case context.DeadlineExceeded, context.Canceled:
+		return util.ErrCategoryTimeout
+	case driver.ErrBadConn, mysql.ErrInvalidConn, sql.ErrConnDone, io.EOF, io.ErrUnexpectedEOF:
+		return util.ErrCategoryNetwork
+	case ErrStmtNotFound, ErrSessionWait:
+		return util.ErrCategoryReplay
+	}
+	return util.ErrCategoryDriver
+}
+
+//save the error of ApplyEvent into ReplayRes , only errors sent by
+//the replay server have an error number and SQLSTATE
+func setReplayResError(rr *stream.ReplayRes, err error) {
+	rr.ErrCategory = classifyError(err)
+	if err == nil {
+		return
+	}
+	if mysqlError, ok := errors.Cause(err).(*mysql.MySQLError); ok {
+		rr.ErrNO = mysqlError.Number
+		rr.ErrDesc = mysqlError.Message
+		if mysqlError.SQLState != [5]byte{} {
+			rr.SQLState = string(mysqlError.SQLState[:])
+		}
+		return
+	}
+	if pqError, ok := errors.Cause(err).(*pq.Error); ok {
+		//PostgreSQL has no error number
+		rr.ErrNO = 0
+		rr.ErrDesc = pqError.Message
+		rr.SQLState = string(pqError.Code)
+		return
+	}
+	rr.ErrNO = 0
+	rr.ErrDesc = err.Error()
+}
@@ -0,0 +1,69 @@
+package sqlreplay
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSqlReplay_classifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want util.ErrCategory
+	}{
+		{name: "nil", err: nil, want: util.ErrCategoryNone},
+		{name: "server", err: &mysql.MySQLError{Number: 1146}, want: util.ErrCategoryServer},
+		{name: "timeout", err: context.DeadlineExceeded, want: util.ErrCategoryTimeout},
+		{name: "bad conn", err: driver.ErrBadConn, want: util.ErrCategoryNetwork},
+		{name: "invalid conn", err: mysql.ErrInvalidConn, want: util.ErrCategoryNetwork},
+		{name: "stmt not found", err: errors.Annotate(ErrStmtNotFound, "stmt id 1"), want: util.ErrCategoryReplay},
+		{name: "driver", err: errors.New("sql: converting argument"), want: util.ErrCategoryDriver},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.New(t).Equal(tt.want, classifyError(tt.err))
+		})
+	}
+}
+
+func TestSqlReplay_setReplayResError_server(t *testing.T) {
+	rr := new(stream.ReplayRes)
+	err := &mysql.MySQLError{Number: 1062, SQLState: [5]byte{'2', '3', '0', '0', '0'}, Message: "Duplicate entry"}
+	setReplayResError(rr, err)
+
+	ast := assert.New(t)
+	ast.Equal(uint16(1062), rr.ErrNO)
+	ast.Equal("23000", rr.SQLState)
+	ast.Equal("Duplicate entry", rr.ErrDesc)
+	ast.Equal(util.ErrCategoryServer, rr.ErrCategory)
+}
+
+func TestSqlReplay_setReplayResError_network(t *testing.T) {
+	rr := new(stream.ReplayRes)
+	setReplayResError(rr, mysql.ErrInvalidConn)
+
+	ast := assert.New(t)
+	ast.Equal(uint16(0), rr.ErrNO)
+	ast.Equal("", rr.SQLState)
+	ast.Equal(mysql.ErrInvalidConn.Error(), rr.ErrDesc)
+	ast.Equal(util.ErrCategoryNetwork, rr.ErrCategory)
+}
+
+func TestSqlReplay_setReplayResError_postgres(t *testing.T) {
+	rr := new(stream.ReplayRes)
+	setReplayResError(rr, &pq.Error{Code: "23505", Message: "duplicate key value"})
+
+	ast := assert.New(t)
+	ast.Equal(uint16(0), rr.ErrNO)
+	ast.Equal("23505", rr.SQLState)
+	ast.Equal("duplicate key value", rr.ErrDesc)
+	ast.Equal(util.ErrCategoryServer, rr.ErrCategory)
+}
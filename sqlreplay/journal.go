@@ -0,0 +1,267 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package sqlreplay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bobguo/mysql-replay/mask"
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+)
+
+//JournalDir is the dir of journals in the output dir
+const JournalDir = "journal"
+
+//Journal appends the events of a connection to JSON lines files , a
+//line an event . Files are named <conn hash>-<start time>-<seq>.jsonl
+//and rotated when they are larger than the max size , only the buffer
+//of the current file is kept in memory
+type Journal struct {
+	dir      string
+	prefix   string
+	maxSize  int64
+	sync     string
+	interval time.Duration
+
+	f        *os.File
+	w        *bufio.Writer
+	size     int64
+	seq      int
+	lastSync time.Time
+}
+
+func NewJournal(conn stream.ConnID, cfg *util.Config) *Journal {
+	return &Journal{
+		dir:      filepath.Join(cfg.OutputDir, JournalDir),
+		prefix:   fmt.Sprintf("%s-%d", conn.HashStr(), time.Now().UnixNano()),
+		maxSize:  int64(cfg.JournalMaxSize) * 1024 * 1024,
+		sync:     cfg.JournalSync,
+		interval: cfg.JournalSyncInterval,
+	}
+}
+
+//forClone names the files of the clone i of --scale
+//<conn hash>_clone<i>-<start time>-<seq>.jsonl , the events of the clone
+//are aggregated with the key <conn hash>_clone<i>
+func (j *Journal) forClone(i int) {
+	j.prefix = strings.Replace(j.prefix, "-", fmt.Sprintf("_clone%d-", i), 1)
+}
+
+func (j *Journal) open() error {
+	err := os.MkdirAll(j.dir, 0755)
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(j.dir, fmt.Sprintf("%s-%d.jsonl", j.prefix, j.seq))
+	j.f, err = os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	j.seq++
+	j.size = 0
+	j.lastSync = time.Now()
+	if j.w == nil {
+		j.w = bufio.NewWriterSize(j.f, 32*1024)
+	} else {
+		j.w.Reset(j.f)
+	}
+	return nil
+}
+
+//Append writes an event as a line , the line is complete in the file
+//once it is flushed
+func (j *Journal) Append(e *stream.MySQLEvent) error {
+	m := mask.Default.Event(*e)
+	line, err := json.Marshal(&m)
+	if err != nil {
+		return err
+	}
+	if j.f != nil && j.maxSize > 0 && j.size+int64(len(line))+1 > j.maxSize && j.size > 0 {
+		if err = j.Close(); err != nil {
+			return err
+		}
+	}
+	if j.f == nil {
+		if err = j.open(); err != nil {
+			return err
+		}
+	}
+	line = append(line, '\n')
+	if _, err = j.w.Write(line); err != nil {
+		return err
+	}
+	j.size += int64(len(line))
+
+	switch j.sync {
+	case util.JournalSyncAlways:
+		return j.flush(true)
+	case util.JournalSyncInterval:
+		if time.Since(j.lastSync) >= j.interval {
+			return j.flush(true)
+		}
+	}
+	return nil
+}
+
+func (j *Journal) flush(sync bool) error {
+	if err := j.w.Flush(); err != nil {
+		return err
+	}
+	j.lastSync = time.Now()
+	if !sync {
+		return nil
+	}
+	return j.f.Sync()
+}
+
+//Close flushes and closes the current file , the next Append opens a
+//new one
+func (j *Journal) Close() error {
+	if j.f == nil {
+		return nil
+	}
+	err := j.flush(j.sync != util.JournalSyncNone)
+	if cerr := j.f.Close(); err == nil {
+		err = cerr
+	}
+	j.f = nil
+	return err
+}
+
+type journalFile struct {
+	name  string
+	hash  string
+	start int64
+	seq   int
+}
+
+func parseJournalName(name string) (journalFile, bool) {
+	jf := journalFile{name: name}
+	fields := strings.Split(strings.TrimSuffix(name, ".jsonl"), "-")
+	if len(fields) != 3 || !strings.HasSuffix(name, ".jsonl") {
+		return jf, false
+	}
+	var err error
+	jf.hash = fields[0]
+	if jf.start, err = parseInt64(fields[1]); err != nil {
+		return jf, false
+	}
+	seq, err := parseInt64(fields[2])
+	jf.seq = int(seq)
+	return jf, err == nil
+}
+
+func parseInt64(s string) (int64, error) {
+	var v int64
+	_, err := fmt.Sscanf(s, "%d", &v)
+	return v, err
+}
+
+//AggregateJournal writes the journals of output dir to one JSON object
+//{"conn hash":[event ...]} , the view written by old versions . Lines are
+//copied file by file , so the memory used does not grow with the events
+func AggregateJournal(outputDir string, name string) error {
+	dir := filepath.Join(outputDir, JournalDir)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var files []journalFile
+	for _, info := range infos {
+		if jf, ok := parseJournalName(info.Name()); ok && !info.IsDir() {
+			files = append(files, jf)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if a.hash != b.hash {
+			return a.hash < b.hash
+		}
+		if a.start != b.start {
+			return a.start < b.start
+		}
+		return a.seq < b.seq
+	})
+
+	out, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriterSize(out, 64*1024)
+	err = writeAggregate(w, dir, files)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func writeAggregate(w *bufio.Writer, dir string, files []journalFile) error {
+	w.WriteByte('{')
+	hash := ""
+	first := true
+	for i, jf := range files {
+		if i == 0 || jf.hash != hash {
+			if i > 0 {
+				w.WriteString("],")
+			}
+			hash = jf.hash
+			key, _ := json.Marshal(hash)
+			w.Write(key)
+			w.WriteString(":[")
+			first = true
+		}
+		f, err := os.Open(filepath.Join(dir, jf.name))
+		if err != nil {
+			return err
+		}
+		r := bufio.NewReader(f)
+		for {
+			line, err := r.ReadBytes('\n')
+			if err != nil && err != io.EOF {
+				f.Close()
+				return err
+			}
+			//a line without newline was cut by a crash
+			if err == io.EOF {
+				break
+			}
+			if !first {
+				w.WriteByte(',')
+			}
+			first = false
+			w.Write(line[:len(line)-1])
+		}
+		f.Close()
+	}
+	if len(files) > 0 {
+		w.WriteByte(']')
+	}
+	_, err := w.WriteString("}")
+	return err
+}
@@ -0,0 +1,153 @@
+package sqlreplay
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func journalConfig(dir string, sync string) *util.Config {
+	return &util.Config{
+		OutputDir:           dir,
+		Journal:             true,
+		JournalMaxSize:      1,
+		JournalSync:         sync,
+		JournalSyncInterval: time.Second,
+	}
+}
+
+func journalLines(t *testing.T, name string) []string {
+	f, err := os.Open(name)
+	assert.Nil(t, err)
+	defer f.Close()
+	var lines []string
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	return lines
+}
+
+func TestJournal(t *testing.T) {
+	tests := []struct {
+		name string
+		sync string
+	}{
+		{"none", util.JournalSyncNone},
+		{"interval", util.JournalSyncInterval},
+		{"always", util.JournalSyncAlways},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			conn, err := stream.ParseConnID("10.0.0.1:1000", "10.0.0.2:4000")
+			assert.Nil(t, err)
+			j := NewJournal(conn, journalConfig(dir, tt.sync))
+			for i := 0; i < 3; i++ {
+				e := stream.MySQLEvent{Conn: conn, Time: int64(i), Type: util.EventQuery, Query: "select 1"}
+				e.NewReplayRes()
+				e.Rr.SqlStatment = "select 1"
+				assert.Nil(t, j.Append(&e))
+			}
+			assert.Nil(t, j.Close())
+			assert.Nil(t, j.Close())
+
+			infos, err := ioutil.ReadDir(filepath.Join(dir, JournalDir))
+			assert.Nil(t, err)
+			assert.Equal(t, 1, len(infos))
+			assert.True(t, strings.HasPrefix(infos[0].Name(), conn.HashStr()+"-"))
+			lines := journalLines(t, filepath.Join(dir, JournalDir, infos[0].Name()))
+			assert.Equal(t, 3, len(lines))
+			var e map[string]interface{}
+			assert.Nil(t, json.Unmarshal([]byte(lines[2]), &e))
+			assert.Equal(t, float64(2), e["time"])
+			assert.Equal(t, "select 1", e["replay_res"].(map[string]interface{})["SqlStatment"])
+		})
+	}
+}
+
+func TestJournal_Rotate(t *testing.T) {
+	dir := t.TempDir()
+	conn, err := stream.ParseConnID("10.0.0.1:1000", "10.0.0.2:4000")
+	assert.Nil(t, err)
+	j := NewJournal(conn, journalConfig(dir, util.JournalSyncNone))
+	query := strings.Repeat("x", 300*1024)
+	for i := 0; i < 7; i++ {
+		e := stream.MySQLEvent{Conn: conn, Time: int64(i), Type: util.EventQuery, Query: query}
+		assert.Nil(t, j.Append(&e))
+	}
+	assert.Nil(t, j.Close())
+
+	//3 events of 300K in a file of 1M
+	infos, err := ioutil.ReadDir(filepath.Join(dir, JournalDir))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(infos))
+	var num []int
+	for _, info := range infos {
+		assert.True(t, info.Size() <= 1024*1024)
+		num = append(num, len(journalLines(t, filepath.Join(dir, JournalDir, info.Name()))))
+	}
+	assert.Equal(t, []int{3, 3, 1}, num)
+}
+
+func TestAggregateJournal(t *testing.T) {
+	dir := t.TempDir()
+	var hashes []string
+	for _, src := range []string{"10.0.0.1:1000", "10.0.0.1:1001"} {
+		conn, err := stream.ParseConnID(src, "10.0.0.2:4000")
+		assert.Nil(t, err)
+		hashes = append(hashes, conn.HashStr())
+		j := NewJournal(conn, journalConfig(dir, util.JournalSyncNone))
+		for i := 0; i < 2; i++ {
+			e := stream.MySQLEvent{Conn: conn, Time: int64(i), Type: util.EventQuery, Query: "select 1"}
+			assert.Nil(t, j.Append(&e))
+			//every event in its own file
+			assert.Nil(t, j.Close())
+		}
+	}
+	//the clone of --scale is aggregated with its own key
+	conn, err := stream.ParseConnID("10.0.0.1:1000", "10.0.0.2:4000")
+	assert.Nil(t, err)
+	j := NewJournal(conn, journalConfig(dir, util.JournalSyncNone))
+	j.forClone(1)
+	assert.Nil(t, j.Append(&stream.MySQLEvent{Conn: conn, Time: 5, Type: util.EventQuery, Query: "select 1"}))
+	assert.Nil(t, j.Close())
+	//a line cut by a crash is skipped
+	name := filepath.Join(dir, JournalDir, hashes[0]+"-1-0.jsonl")
+	assert.Nil(t, ioutil.WriteFile(name, []byte("{\"time\":"), 0644))
+
+	out := filepath.Join(dir, "mysql_events.json")
+	assert.Nil(t, AggregateJournal(dir, out))
+	b, err := ioutil.ReadFile(out)
+	assert.Nil(t, err)
+	var res map[string][]map[string]interface{}
+	assert.Nil(t, json.Unmarshal(b, &res))
+	assert.Equal(t, 3, len(res))
+	clone := res[hashes[0]+"_clone1"]
+	assert.Equal(t, 1, len(clone))
+	assert.Equal(t, float64(5), clone[0]["time"])
+	for _, hash := range hashes {
+		assert.Equal(t, 2, len(res[hash]))
+		assert.Equal(t, float64(0), res[hash][0]["time"])
+		assert.Equal(t, float64(1), res[hash][1]["time"])
+	}
+
+	//no journal
+	empty := t.TempDir()
+	assert.NotNil(t, AggregateJournal(empty, filepath.Join(empty, "mysql_events.json")))
+	assert.Nil(t, os.MkdirAll(filepath.Join(empty, JournalDir), 0755))
+	assert.Nil(t, AggregateJournal(empty, filepath.Join(empty, "mysql_events.json")))
+	b, err = ioutil.ReadFile(filepath.Join(empty, "mysql_events.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, "{}", string(b))
+}
@@ -0,0 +1,160 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package sqlreplay
+
+import (
+	"context"
+	"time"
+
+	"github.com/bobguo/mysql-replay/stats"
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/pingcap/errors"
+)
+
+//sessionIdle is the time without events after which a connection out of
+//transactions gives its session to the connections waiting for sessions
+const sessionIdle = 100 * time.Millisecond
+
+//waitSession waits for a session of the replay server , the session is
+//kept until the captured connection quits or is idle , reconnecting keeps
+//it . It waits at most --max-session-wait
+func (h *ReplayEventHandler) waitSession(ctx context.Context) error {
+	if h.limiter == nil || h.session {
+		return nil
+	}
+	parent := ctx
+	if h.cfg.MaxSessionWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.cfg.MaxSessionWait)
+		defer cancel()
+	}
+	wait, ok := h.limiter.AcquireSession(ctx)
+	addWait("Session", wait)
+	if !ok {
+		if parent.Err() == nil {
+			return errors.Annotate(ErrSessionWait, h.cfg.MaxSessionWait.String())
+		}
+		return errors.Annotate(parent.Err(), "wait for a session of the replay server")
+	}
+	h.session = true
+	return nil
+}
+
+//idle tells if the session can be given to the connections waiting for
+//sessions , sessions in transactions are kept
+func (h *ReplayEventHandler) idle() bool {
+	if !h.session || !h.limiter.SessionWaited() {
+		return false
+	}
+	if h.applied != nil && h.applied.open() || h.dry != nil && h.dry.open {
+		return false
+	}
+	return true
+}
+
+//releaseIdleSessions gives the sessions of the idle connection to the
+//connections waiting for them , the connection waits for a session again
+//by the next event , prepared statements are prepared again
+func (h *ReplayEventHandler) releaseIdleSessions() {
+	for _, x := range append([]*ReplayEventHandler{h}, h.targets...) {
+		if !x.idle() {
+			continue
+		}
+		x.quit(true)
+		x.releaseSession()
+		stats.AddStatic("SessionIdleReleases", 1, false)
+	}
+}
+
+//next returns the next event of ch , the sessions are given to the
+//connections waiting for them while the connection is idle
+func (h *ReplayEventHandler) next(ch chan stream.MySQLEvent) (stream.MySQLEvent, bool) {
+	select {
+	case e, ok := <-ch:
+		return e, ok
+	default:
+	}
+	if h.limiter == nil {
+		e, ok := <-ch
+		return e, ok
+	}
+	ticker := time.NewTicker(sessionIdle)
+	defer ticker.Stop()
+	for {
+		select {
+		case e, ok := <-ch:
+			return e, ok
+		case <-ticker.C:
+			h.releaseIdleSessions()
+		}
+	}
+}
+
+func (h *ReplayEventHandler) releaseSession() {
+	if !h.session {
+		return
+	}
+	h.limiter.ReleaseSession()
+	h.session = false
+}
+
+//waitStmt waits until the statement of the event can run on the replay
+//server , events running no statement take nothing . The session is
+//taken before the statement , so that statements never wait for sessions
+func (h *ReplayEventHandler) waitStmt(ctx context.Context, e *stream.MySQLEvent) error {
+	if h.limiter == nil || len(h.cfg.Dsn) == 0 {
+		return nil
+	}
+	switch e.Type {
+	case util.EventQuery, util.EventStmtPrepare, util.EventStmtExecute:
+	default:
+		return nil
+	}
+	if err := h.waitSession(ctx); err != nil {
+		return err
+	}
+	return h.acquireStmt(ctx)
+}
+
+func (h *ReplayEventHandler) acquireStmt(ctx context.Context) error {
+	wait, ok := h.limiter.AcquireStmt(ctx)
+	addWait("Stmt", wait)
+	if !ok {
+		return errors.Annotate(ctx.Err(), "wait for a statement of the replay server")
+	}
+	h.stmt = true
+	return nil
+}
+
+func (h *ReplayEventHandler) releaseStmt() {
+	if !h.stmt {
+		return
+	}
+	h.limiter.ReleaseStmt()
+	h.stmt = false
+}
+
+//addWait adds the time waited for the limiter to the stats
+func addWait(kind string, wait time.Duration) {
+	if wait <= 0 {
+		return
+	}
+	ms := uint64(wait / time.Millisecond)
+	stats.AddStatic(kind+"Wait", ms, false)
+	stats.AddStatic(kind+"Waits", 1, false)
+	stats.MaxStatic(kind+"MaxWait", ms)
+}
@@ -0,0 +1,127 @@
+package sqlreplay
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestReplayEventHandler_waitStmt(t *testing.T) {
+	cfg := &util.Config{Dsn: "root@tcp(127.0.0.1:3306)/test"}
+	limiter := util.NewLimiter(1, 1)
+	h1 := &ReplayEventHandler{cfg: cfg, limiter: limiter}
+	h2 := &ReplayEventHandler{cfg: cfg, limiter: limiter}
+
+	//events without statements take nothing
+	err := h1.waitStmt(context.Background(), &stream.MySQLEvent{Type: util.EventStmtClose})
+	assert.Nil(t, err)
+	assert.False(t, h1.stmt)
+	assert.False(t, h1.session)
+
+	err = h1.waitStmt(context.Background(), &stream.MySQLEvent{Type: util.EventQuery})
+	assert.Nil(t, err)
+	assert.True(t, h1.stmt)
+	assert.True(t, h1.session)
+	h1.releaseStmt()
+	assert.False(t, h1.stmt)
+
+	//the session is kept by h1 until it quits
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = h2.waitStmt(ctx, &stream.MySQLEvent{Type: util.EventQuery})
+	assert.NotNil(t, err)
+	assert.False(t, h2.stmt)
+	assert.False(t, h2.session)
+
+	h1.quit(true)
+	assert.True(t, h1.session)
+	h1.quit(false)
+	assert.False(t, h1.session)
+	err = h2.waitStmt(context.Background(), &stream.MySQLEvent{Type: util.EventStmtExecute})
+	assert.Nil(t, err)
+	assert.True(t, h2.stmt)
+	assert.True(t, h2.session)
+
+	//no limiter
+	h3 := &ReplayEventHandler{cfg: cfg}
+	err = h3.waitStmt(context.Background(), &stream.MySQLEvent{Type: util.EventQuery})
+	assert.Nil(t, err)
+	assert.False(t, h3.stmt)
+}
+
+func TestReplayEventHandler_waitSession_timeout(t *testing.T) {
+	cfg := &util.Config{Dsn: "root@tcp(127.0.0.1:3306)/test", MaxSessionWait: 10 * time.Millisecond}
+	limiter := util.NewLimiter(1, 0)
+	h1 := &ReplayEventHandler{cfg: cfg, limiter: limiter}
+	h2 := &ReplayEventHandler{cfg: cfg, limiter: limiter}
+	assert.Nil(t, h1.waitSession(context.Background()))
+
+	err := h2.waitSession(context.Background())
+	assert.Equal(t, ErrSessionWait, errors.Cause(err))
+	assert.Equal(t, util.ErrCategoryReplay, classifyError(err))
+	assert.Equal(t, uint16(0), retryCode(err))
+	assert.False(t, h2.session)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = h2.waitSession(ctx)
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+}
+
+func TestReplayEventHandler_releaseIdleSessions(t *testing.T) {
+	cfg := &util.Config{Dsn: "root@tcp(127.0.0.1:3306)/test"}
+	limiter := util.NewLimiter(1, 0)
+	h1 := &ReplayEventHandler{cfg: cfg, limiter: limiter, applied: newTxnTracker(), log: zap.NewNop()}
+	h2 := &ReplayEventHandler{cfg: cfg, limiter: limiter}
+	assert.Nil(t, h1.waitSession(context.Background()))
+
+	//nobody waits
+	assert.False(t, h1.idle())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- h2.waitSession(context.Background())
+	}()
+	for !limiter.SessionWaited() {
+		time.Sleep(time.Millisecond)
+	}
+
+	//sessions in transactions are kept
+	h1.applied.commitPoint(&stream.MySQLEvent{Type: util.EventQuery, Query: "begin"})
+	h1.releaseIdleSessions()
+	assert.True(t, h1.session)
+
+	h1.applied.commitPoint(&stream.MySQLEvent{Type: util.EventQuery, Query: "commit"})
+	h1.releaseIdleSessions()
+	assert.False(t, h1.session)
+	assert.Nil(t, <-done)
+	assert.True(t, h2.session)
+}
+
+func TestReplayEventHandler_next(t *testing.T) {
+	cfg := &util.Config{Dsn: "root@tcp(127.0.0.1:3306)/test"}
+	limiter := util.NewLimiter(1, 0)
+	h1 := &ReplayEventHandler{cfg: cfg, limiter: limiter, applied: newTxnTracker(), log: zap.NewNop()}
+	h2 := &ReplayEventHandler{cfg: cfg, limiter: limiter}
+	assert.Nil(t, h1.waitSession(context.Background()))
+
+	//h1 gives its session to h2 while it waits for events
+	ch := make(chan stream.MySQLEvent, 1)
+	go func() {
+		assert.Nil(t, h2.waitSession(context.Background()))
+		ch <- stream.MySQLEvent{Type: util.EventQuit}
+		close(ch)
+	}()
+	e, ok := h1.next(ch)
+	assert.True(t, ok)
+	assert.Equal(t, util.EventQuit, e.Type)
+	assert.False(t, h1.session)
+	_, ok = h1.next(ch)
+	assert.False(t, ok)
+}
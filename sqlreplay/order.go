@@ -0,0 +1,117 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package sqlreplay
+
+import (
+	"github.com/bobguo/mysql-replay/parse"
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+)
+
+//txnTracker follows the transactions of a captured connection to find
+//its commit points , i.e. commits of transactions with writes , writes
+//in autocommit mode and ddl , reads are never commit points
+type txnTracker struct {
+	autocommit bool
+	inTxn      bool
+	//the transaction has writes
+	dirty bool
+	//statement types of prepared statements
+	stmts map[string]uint16
+}
+
+func newTxnTracker() *txnTracker {
+	return &txnTracker{autocommit: true, stmts: make(map[string]uint16)}
+}
+
+func (t *txnTracker) reset() {
+	t.autocommit, t.inTxn, t.dirty = true, false, false
+	t.stmts = make(map[string]uint16)
+}
+
+//commitPoint tells if the event is a commit point , events must be
+//given in the captured order of the connection
+func (t *txnTracker) commitPoint(e *stream.MySQLEvent) bool {
+	switch e.Type {
+	case util.EventHandshake, util.EventQuit:
+		t.reset()
+	case util.EventQuery:
+		return t.statement(e.Query, stmtType(e.Query))
+	case util.EventStmtPrepare:
+		t.stmts[e.StmtID] = stmtType(e.Query)
+	case util.EventStmtExecute:
+		kind, ok := t.stmts[e.StmtID]
+		if ok {
+			return t.statement("", kind)
+		}
+	case util.EventStmtClose:
+		delete(t.stmts, e.StmtID)
+	}
+	return false
+}
+
+//open tells if the next statement runs in a transaction , i.e. after a
+//begin or with autocommit off
+func (t *txnTracker) open() bool {
+	return t.inTxn || !t.autocommit
+}
+
+func stmtType(query string) uint16 {
+	kind, err := parse.GetSQLStmtType(query)
+	if err != nil {
+		return util.UnknownStmt
+	}
+	return kind
+}
+
+func (t *txnTracker) statement(query string, kind uint16) bool {
+	switch kind {
+	case util.BeginStmt:
+		//begin commits the current transaction
+		commit := t.dirty
+		t.inTxn, t.dirty = true, false
+		return commit
+	case util.CommitStmt:
+		commit := t.dirty
+		t.inTxn, t.dirty = false, false
+		return commit
+	case util.RollbackStmt:
+		t.inTxn, t.dirty = false, false
+	case util.SetStmt:
+		on, ok := parse.Autocommit(query)
+		if !ok {
+			return false
+		}
+		//turning autocommit on commits the current transaction
+		commit := on && !t.autocommit && t.dirty
+		if commit {
+			t.inTxn, t.dirty = false, false
+		}
+		t.autocommit = on
+		return commit
+	case util.InsertStmt, util.UpdateStmt, util.DeleteStmt:
+		if t.inTxn || !t.autocommit {
+			t.dirty = true
+			return false
+		}
+		return true
+	case util.DDLStmt:
+		//ddl commits the current transaction and itself
+		t.inTxn, t.dirty = false, false
+		return true
+	}
+	return false
+}
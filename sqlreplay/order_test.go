@@ -0,0 +1,72 @@
+package sqlreplay
+
+import (
+	"testing"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func query(q string) stream.MySQLEvent {
+	return stream.MySQLEvent{Type: util.EventQuery, Query: q}
+}
+
+func TestTxnTracker(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []stream.MySQLEvent
+		points []bool
+	}{
+		{
+			name:   "autocommit",
+			events: []stream.MySQLEvent{query("select 1"), query("insert into t values (1)"), query("update t set a = 2"), query("delete from t")},
+			points: []bool{false, true, true, true},
+		},
+		{
+			name: "transaction",
+			events: []stream.MySQLEvent{query("begin"), query("insert into t values (1)"), query("select 1"),
+				query("commit"), query("start transaction"), query("select 1"), query("commit")},
+			points: []bool{false, false, false, true, false, false, false},
+		},
+		{
+			name:   "rollback",
+			events: []stream.MySQLEvent{query("begin"), query("update t set a = 1"), query("rollback"), query("commit")},
+			points: []bool{false, false, false, false},
+		},
+		{
+			name:   "implicit commit",
+			events: []stream.MySQLEvent{query("begin"), query("insert into t values (1)"), query("begin"), query("update t set a = 1"), query("create table x (a int)")},
+			points: []bool{false, false, true, false, true},
+		},
+		{
+			name: "autocommit off",
+			events: []stream.MySQLEvent{query("set autocommit = 0"), query("insert into t values (1)"), query("commit"),
+				query("update t set a = 1"), query("set autocommit = 1"), query("delete from t")},
+			points: []bool{false, false, true, false, true, true},
+		},
+		{
+			name: "prepared statements",
+			events: []stream.MySQLEvent{
+				{Type: util.EventStmtPrepare, StmtID: "1", Query: "insert into t values (?)"},
+				{Type: util.EventStmtPrepare, StmtID: "2", Query: "select * from t where a = ?"},
+				{Type: util.EventStmtExecute, StmtID: "1"},
+				{Type: util.EventStmtExecute, StmtID: "2"},
+				{Type: util.EventStmtClose, StmtID: "1"},
+				{Type: util.EventStmtExecute, StmtID: "1"},
+			},
+			points: []bool{false, false, true, false, false, false},
+		},
+		{
+			name:   "reconnect",
+			events: []stream.MySQLEvent{query("set autocommit = 0"), query("insert into t values (1)"), {Type: util.EventQuit}, {Type: util.EventHandshake}, query("insert into t values (1)")},
+			points: []bool{false, false, false, false, true},
+		},
+	}
+	for _, tt := range tests {
+		txn := newTxnTracker()
+		for i, e := range tt.events {
+			assert.Equal(t, tt.points[i], txn.commitPoint(&e), "%s : %d", tt.name, i)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package sqlreplay
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/bobguo/mysql-replay/stats"
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+//retryCode returns the error code of err looked up in the retry policy ,
+//errors of a lost connection to the replay server are util.ErrServerLost
+func retryCode(err error) uint16 {
+	cause := errors.Cause(err)
+	if mysqlError, ok := cause.(*mysql.MySQLError); ok {
+		return mysqlError.Number
+	}
+	if cause == context.DeadlineExceeded || cause == sql.ErrConnDone || classifyError(err) == util.ErrCategoryNetwork {
+		return util.ErrServerLost
+	}
+	return 0
+}
+
+//retry tells whether the event failed by err after the number of attempts
+//is applied again . It waits for the backoff of the rule of err and
+//reconnects after a lost connection before the event is applied again .
+//The statement of the limiter is given to the other connections during the
+//backoff and taken again for the next attempt .
+//Errors rolling back the transaction are not retried in a transaction ,
+//the retry would run without the statements before it
+func (h *ReplayEventHandler) retry(ctx context.Context, e *stream.MySQLEvent, err error, attempts int) bool {
+	code := retryCode(err)
+	rule, ok := h.cfg.RetryPolicy.Rule(code)
+	if !ok {
+		return false
+	}
+	if !rule.Retry(attempts) || h.inTxn && !rule.InTxn {
+		stats.AddStatic("RetryGiveUp", 1, false)
+		if rule.GiveUp == util.GiveUpReconnect {
+			if rerr := h.reconnect(ctx); rerr != nil {
+				h.log.Warn("reconnect error", zap.Error(rerr))
+			}
+		}
+		return false
+	}
+	backoff := rule.BackoffOf(attempts)
+	h.log.Warn(fmt.Sprintf("apply %s fail with error %d , retry #%d after %v", e.Type, code, attempts, backoff))
+	stmt := h.stmt
+	h.releaseStmt()
+	if !sleep(ctx, backoff) {
+		return false
+	}
+	if stmt {
+		if serr := h.acquireStmt(ctx); serr != nil {
+			return false
+		}
+	}
+	stats.AddStatic("Retries", 1, false)
+	//a transaction of dry-run may be rolled back by the error , the retry
+	//must not run outside of it , the new session begins it again
+	if code == util.ErrServerLost || h.dry != nil && !rule.InTxn {
+		if rerr := h.reconnect(ctx); rerr != nil {
+			h.log.Warn("reconnect error", zap.Error(rerr))
+			return false
+		}
+	}
+	return true
+}
+
+//reconnect opens a new session in the current database , the prepared
+//statements are prepared again when they are executed
+func (h *ReplayEventHandler) reconnect(ctx context.Context) error {
+	h.quit(true)
+	err := h.handshake(ctx, h.schema)
+	if err == nil {
+		err = h.resumeDryRun(ctx)
+	}
+	return err
+}
+
+//sleep waits for d , it returns false if ctx is done before
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
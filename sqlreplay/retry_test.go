@@ -0,0 +1,117 @@
+package sqlreplay
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/bobguo/mysql-replay/stats"
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestSqlReplay_retryCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want uint16
+	}{
+		{name: "server", err: &mysql.MySQLError{Number: 1205}, want: 1205},
+		{name: "annotated", err: errors.Annotate(&mysql.MySQLError{Number: 9007}, "stmt"), want: 9007},
+		{name: "timeout", err: context.DeadlineExceeded, want: util.ErrServerLost},
+		{name: "bad conn", err: driver.ErrBadConn, want: util.ErrServerLost},
+		{name: "invalid conn", err: mysql.ErrInvalidConn, want: util.ErrServerLost},
+		{name: "stmt not found", err: errors.Annotate(ErrStmtNotFound, "stmt id 1"), want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.New(t).Equal(tt.want, retryCode(tt.err))
+		})
+	}
+}
+
+func TestReplayEventHandler_retry(t *testing.T) {
+	cfg := &util.Config{Retry: "1205:2:1ms"}
+	assert.Nil(t, cfg.CheckRetry())
+	h := &ReplayEventHandler{cfg: cfg, log: zap.NewNop()}
+	e := &stream.MySQLEvent{Type: util.EventQuery}
+	lockWait := &mysql.MySQLError{Number: 1205}
+
+	assert.True(t, h.retry(context.Background(), e, lockWait, 1))
+	assert.True(t, h.retry(context.Background(), e, lockWait, 2))
+	giveUp := stats.GetValue("RetryGiveUp")
+	assert.False(t, h.retry(context.Background(), e, lockWait, 3))
+	assert.Equal(t, giveUp+1, stats.GetValue("RetryGiveUp"))
+
+	//deadlocks roll back the transaction , lock wait timeouts do not
+	h.cfg = &util.Config{Retry: "1213:2:1ms"}
+	assert.Nil(t, h.cfg.CheckRetry())
+	deadlock := &mysql.MySQLError{Number: 1213}
+	assert.True(t, h.retry(context.Background(), e, deadlock, 1))
+	h.inTxn = true
+	assert.False(t, h.retry(context.Background(), e, deadlock, 1))
+	assert.True(t, h.retry(context.Background(), e, lockWait, 1))
+	h.inTxn = false
+
+	//errors without rules are not retried
+	assert.False(t, h.retry(context.Background(), e, &mysql.MySQLError{Number: 1062}, 1))
+
+	//no retry after ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, h.retry(ctx, e, lockWait, 1))
+
+	//a nil policy retries nothing
+	h.cfg = &util.Config{}
+	assert.False(t, h.retry(context.Background(), e, lockWait, 1))
+}
+
+func TestReplayEventHandler_retry_releaseStmt(t *testing.T) {
+	cfg := &util.Config{Retry: "1205:1:50ms"}
+	assert.Nil(t, cfg.CheckRetry())
+	limiter := util.NewLimiter(0, 1)
+	h1 := &ReplayEventHandler{cfg: cfg, log: zap.NewNop(), limiter: limiter}
+	h2 := &ReplayEventHandler{cfg: cfg, log: zap.NewNop(), limiter: limiter}
+	assert.Nil(t, h1.acquireStmt(context.Background()))
+
+	done := make(chan bool)
+	go func() {
+		done <- h1.retry(context.Background(), &stream.MySQLEvent{Type: util.EventQuery}, &mysql.MySQLError{Number: 1205}, 1)
+	}()
+	//the statement is free during the backoff of h1
+	ctx, cancel := context.WithTimeout(context.Background(), 40*time.Millisecond)
+	defer cancel()
+	assert.Nil(t, h2.acquireStmt(ctx))
+	h2.releaseStmt()
+	assert.True(t, <-done)
+	assert.True(t, h1.stmt)
+	h1.releaseStmt()
+}
+
+func TestReplayEventHandler_apply_inTxn(t *testing.T) {
+	h := &ReplayEventHandler{cfg: &util.Config{}, log: zap.NewNop(), applied: newTxnTracker()}
+	tests := []struct {
+		query string
+		inTxn bool
+	}{
+		{"insert into t values (1)", false},
+		{"begin", false},
+		{"insert into t values (2)", true},
+		{"commit", true},
+		{"set autocommit = 0", false},
+		{"update t set a = 1", true},
+		{"set autocommit = 1", true},
+		{"delete from t", false},
+	}
+	for _, tt := range tests {
+		e := &stream.MySQLEvent{Type: util.EventQuery, Query: tt.query}
+		e.NewReplayRes()
+		h.apply(e)
+		assert.Equal(t, tt.inTxn, h.inTxn, tt.query)
+	}
+}
@@ -0,0 +1,53 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package sqlreplay
+
+import (
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"go.uber.org/zap"
+)
+
+//NewEventHandler returns the handler replaying a connection , it is the
+//handler of the clones of the connection if --scale is more than 1
+func NewEventHandler(conn stream.ConnID, log *zap.Logger, cfg *util.Config) stream.MySQLEventHandler {
+	if cfg.Scale <= 1 {
+		return NewReplayEventHandler(conn, log, cfg)
+	}
+	c := &clones{}
+	for i := 0; i < cfg.Scale; i++ {
+		c.handlers = append(c.handlers, newReplayEventHandler(conn, log, cfg, i))
+	}
+	return c
+}
+
+//clones replays a connection by independent sessions , every clone gets
+//every event of the connection
+type clones struct {
+	handlers []*ReplayEventHandler
+}
+
+func (c *clones) OnEvent(e stream.MySQLEvent) {
+	for _, h := range c.handlers {
+		h.OnEvent(e)
+	}
+}
+
+func (c *clones) OnClose() {
+	for _, h := range c.handlers {
+		h.OnClose()
+	}
+}
@@ -0,0 +1,47 @@
+package sqlreplay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestNewEventHandler(t *testing.T) {
+	cfg := &util.Config{}
+	assert.Nil(t, cfg.CheckScale())
+	_, ok := NewEventHandler(stream.ConnID{}, zap.NewNop(), cfg).(*ReplayEventHandler)
+	assert.True(t, ok)
+
+	cfg = &util.Config{
+		DBMaps:          "shop=shop_{clone}",
+		Scale:           3,
+		ScaleShift:      time.Minute,
+		Clock:           util.NewClock(1),
+		ScaleKeyColumns: "id",
+		ScaleKeyOffset:  1000,
+	}
+	assert.Nil(t, cfg.CheckDBMap())
+	assert.Nil(t, cfg.CheckScale())
+	c, ok := NewEventHandler(stream.ConnID{}, zap.NewNop(), cfg).(*clones)
+	assert.True(t, ok)
+	assert.Equal(t, 3, len(c.handlers))
+
+	for i, h := range c.handlers {
+		assert.Equal(t, i, h.clone)
+		assert.Equal(t, time.Duration(i)*time.Minute, h.shift)
+		assert.Equal(t, int64(i)*1000, h.keyOffset)
+		db, _ := h.dbMap.Map("shop")
+		assert.Equal(t, []string{"shop_0", "shop_1", "shop_2"}[i], db)
+	}
+	assert.NotEqual(t, c.handlers[0].fileNamePrefix, c.handlers[1].fileNamePrefix)
+
+	h := c.handlers[0]
+	assert.Equal(t, "INSERT INTO `shop_0`.`t` (`id`) VALUES (1)", h.rewrite("insert into shop.t (id) values (1)"))
+	h = c.handlers[2]
+	assert.Equal(t, "INSERT INTO `shop_2`.`t` (`id`) VALUES (2001)", h.rewrite("insert into shop.t (id) values (1)"))
+	assert.Equal(t, "select 1", h.rewrite("select 1"))
+}
@@ -0,0 +1,62 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package sqlreplay
+
+import (
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+)
+
+//prepared statement not replayed on the replay server
+type skippedStmt struct {
+	query  string
+	reason string
+}
+
+//skip tells if the statement of the event is not replayed because of its
+//type , the statement and the reason are recorded in the replay result
+func (h *ReplayEventHandler) skip(e *stream.MySQLEvent) bool {
+	if len(h.cfg.SkipStmts) == 0 {
+		return false
+	}
+	var query, reason string
+	switch e.Type {
+	case util.EventQuery:
+		query = e.Query
+		reason = h.cfg.SkipStmts[stmtType(query)]
+	case util.EventStmtPrepare:
+		//statements are classified at prepare time
+		delete(h.skipped, e.StmtID)
+		query = e.Query
+		reason = h.cfg.SkipStmts[stmtType(query)]
+		if len(reason) > 0 {
+			h.stmtClose(e.StmtID)
+			h.skipped[e.StmtID] = skippedStmt{query: query, reason: reason}
+		}
+	case util.EventStmtExecute:
+		stmt := h.skipped[e.StmtID]
+		query, reason = stmt.query, stmt.reason
+		e.Rr.Values = e.Params
+	case util.EventStmtClose:
+		delete(h.skipped, e.StmtID)
+	}
+	if len(reason) == 0 {
+		return false
+	}
+	e.Rr.SqlStatment = query
+	e.Rr.Skipped = reason
+	return true
+}
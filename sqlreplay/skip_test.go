@@ -0,0 +1,50 @@
+package sqlreplay
+
+import (
+	"testing"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayEventHandler_skip(t *testing.T) {
+	cfg := &util.Config{Mode: util.ModeReadOnly, SkipStmtTypes: "set"}
+	assert.Nil(t, cfg.CheckMode())
+	h := &ReplayEventHandler{cfg: cfg, stmts: make(map[string]statement), skipped: make(map[string]skippedStmt)}
+
+	tests := []struct {
+		e      stream.MySQLEvent
+		reason string
+	}{
+		{stream.MySQLEvent{Type: util.EventQuery, Query: "select * from t"}, ""},
+		{stream.MySQLEvent{Type: util.EventQuery, Query: "insert into t values (1)"}, "insert statement in read-only mode"},
+		{stream.MySQLEvent{Type: util.EventQuery, Query: "set names utf8mb4"}, "set statement in skip-stmt-types"},
+		{stream.MySQLEvent{Type: util.EventQuery, Query: "not a statement"}, "unknown statement in read-only mode"},
+		{stream.MySQLEvent{Type: util.EventStmtPrepare, StmtID: "1", Query: "update t set a = ?"}, "update statement in read-only mode"},
+		{stream.MySQLEvent{Type: util.EventStmtExecute, StmtID: "1", Params: []interface{}{int64(1)}}, "update statement in read-only mode"},
+		{stream.MySQLEvent{Type: util.EventStmtClose, StmtID: "1"}, ""},
+		{stream.MySQLEvent{Type: util.EventStmtExecute, StmtID: "1"}, ""},
+	}
+	for _, tt := range tests {
+		e := tt.e
+		e.NewReplayRes()
+		assert.Equal(t, len(tt.reason) > 0, h.skip(&e), e.Query)
+		assert.Equal(t, tt.reason, e.Rr.Skipped, e.Query)
+	}
+
+	e := stream.MySQLEvent{Type: util.EventStmtPrepare, StmtID: "2", Query: "delete from t where a = ?"}
+	e.NewReplayRes()
+	h.skip(&e)
+	e = stream.MySQLEvent{Type: util.EventStmtExecute, StmtID: "2", Params: []interface{}{int64(1)}}
+	e.NewReplayRes()
+	assert.True(t, h.skip(&e))
+	assert.Equal(t, "delete from t where a = ?", e.Rr.SqlStatment)
+	assert.Equal(t, []interface{}{int64(1)}, e.Rr.Values)
+
+	h.cfg = &util.Config{}
+	assert.Nil(t, h.cfg.CheckMode())
+	e = stream.MySQLEvent{Type: util.EventQuery, Query: "insert into t values (1)"}
+	e.NewReplayRes()
+	assert.False(t, h.skip(&e))
+}
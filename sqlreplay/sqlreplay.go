@@ -35,7 +35,10 @@ import (
 	"time"
 	"unsafe"
 
+	"github.com/bobguo/mysql-replay/mask"
+	"github.com/bobguo/mysql-replay/parse"
 	"github.com/bobguo/mysql-replay/result"
+	"github.com/bobguo/mysql-replay/rewrite"
 	"github.com/bobguo/mysql-replay/stats"
 	"github.com/bobguo/mysql-replay/stream"
 	"github.com/bobguo/mysql-replay/util"
@@ -48,26 +51,82 @@ import (
 type statement struct {
 	query  string
 	handle *sql.Stmt
+	//positions of the params inserted into the keys of --scale-key-columns
+	keyParams []int
+}
+
+//digest of a prepared statement , kept even if the statement is not
+//prepared on the replay server
+type digest struct {
+	normalized string
+	digest     string
 }
 
 func NewReplayEventHandler(conn stream.ConnID, log *zap.Logger, cfg *util.Config) *ReplayEventHandler {
-	return &ReplayEventHandler{
+	return newReplayEventHandler(conn, log, cfg, 0)
+}
+
+//newReplayEventHandler returns the handler of the clone i of a connection
+//replayed by --scale , clone 0 is the connection itself
+func newReplayEventHandler(conn stream.ConnID, log *zap.Logger, cfg *util.Config, clone int) *ReplayEventHandler {
+	var journal *Journal
+	if cfg.Journal {
+		journal = NewJournal(conn, cfg)
+	}
+	fileNamePrefix := conn.HashStr() + ":" + conn.SrcAddr()
+	if clone > 0 {
+		log = log.With(zap.Int("clone", clone))
+		fileNamePrefix += fmt.Sprintf(":clone%d", clone)
+		if journal != nil {
+			journal.forClone(clone)
+		}
+	}
+	var (
+		txn     *txnTracker
+		tickets chan uint64
+	)
+	if cfg.CommitOrder != nil {
+		txn = newTxnTracker()
+		tickets = make(chan uint64, 10000)
+	}
+	var dry *dryRun
+	if cfg.DryRun {
+		dry = newDryRun()
+	}
+	h := &ReplayEventHandler{
+		dry:            dry,
+		txn:            txn,
+		applied:        newTxnTracker(),
+		tickets:        tickets,
+		journal:        journal,
 		pconn:          conn,
 		log:            log,
 		dsn:            cfg.Dsn,
 		MySQLConfig:    cfg.MySQLConfig,
+		pgDsn:          cfg.PgDsn,
+		limiter:        cfg.Limiter,
 		ctx:            context.Background(),
 		ch:             make(chan stream.MySQLEvent, 10000),
 		wg:             new(sync.WaitGroup),
 		stmts:          make(map[string]statement),
+		digests:        make(map[string]digest),
+		skipped:        make(map[string]skippedStmt),
 		once:           new(sync.Once),
 		wf:             NewWriteFile(),
-		fileNamePrefix: conn.HashStr() + ":" + conn.SrcAddr(),
+		fileNamePrefix: fileNamePrefix,
 		filePath:       cfg.OutputDir,
 		storePath:      cfg.StoreDir,
 		preFileSize:    cfg.PreFileSize,
 		cfg:            cfg,
+		clone:          clone,
+		shift:          time.Duration(clone) * cfg.ScaleShift,
+		keyOffset:      int64(clone) * cfg.ScaleKeyOffset,
+		dbMap:          cfg.DBMap.ForClone(clone),
+	}
+	for _, t := range cfg.Targets {
+		h.targets = append(h.targets, newTargetHandler(h, t))
 	}
+	return h
 }
 
 //Used for replay  SQL
@@ -81,6 +140,8 @@ type ReplayEventHandler struct {
 	pool                        *sql.DB
 	conn                        *sql.Conn
 	stmts                       map[string]statement
+	digests                     map[string]digest
+	skipped                     map[string]skippedStmt
 	ctx                         context.Context
 	filterStr                   string
 	needCompareRes              bool
@@ -103,8 +164,38 @@ type ReplayEventHandler struct {
 	preFileSize    uint64
 	pos            uint64
 	cfg            *util.Config
+	journal        *Journal
+	lagWarnTime    time.Time
+	txn            *txnTracker
+	dry            *dryRun
+	//transactions of the events applied on the replay server , inTxn tells
+	//if the event being applied runs in a transaction
+	applied *txnTracker
+	inTxn   bool
+	//tickets of the commit order of events in ch , 0 for events which
+	//are not commit points
+	tickets chan uint64
+	pgDsn   string
+	//name of the replay server , empty for the first one
+	target string
+	//sessions on the other replay servers
+	targets []*ReplayEventHandler
+	limiter *util.Limiter
+	//a session of the limiter is taken
+	session bool
+	//a statement of the limiter is taken
+	stmt bool
+	//index of the clone of --scale , the clone is replayed shift later and
+	//adds keyOffset to the keys it inserts
+	clone     int
+	shift     time.Duration
+	keyOffset int64
+	dbMap     *util.DBMap
 }
 
+//events later than the threshold on the virtual clock are counted as late
+const lateThreshold = 10 * time.Millisecond
+
 type WriteFile struct {
 	ch               chan stream.MySQLEvent
 	rrStartGoRuntine bool
@@ -247,7 +338,8 @@ func (h *ReplayEventHandler) AsyncWriteResToFile(e stream.MySQLEvent) {
 }
 
 func (h *ReplayEventHandler) WriteEvent(e stream.MySQLEvent) {
-	js, err := json.Marshal(e)
+	m := mask.Default.Event(e)
+	js, err := json.Marshal(m)
 	if err != nil {
 		stats.AddStatic("FormatJsonFail", 1, false)
 		h.log.Warn(fmt.Sprintf("format struct to json fail , %v", err))
@@ -260,6 +352,8 @@ func (h *ReplayEventHandler) WriteEvent(e stream.MySQLEvent) {
 
 func (h *ReplayEventHandler) DoEvent(e stream.MySQLEvent) {
 	if e.Type == util.EventStmtPrepare || e.Type == util.EventStmtClose {
+		e.NewReplayRes()
+		h.wait(e.Time)
 		h.ReplayEventAndWriteRes(e)
 		return
 	}
@@ -275,27 +369,92 @@ func (h *ReplayEventHandler) DoEvent(e stream.MySQLEvent) {
 		e.NewReplayRes()
 		//e.InitRr()
 
+		h.wait(e.Time)
 		h.ReplayEventAndWriteRes(e)
 	default:
 		h.log.Warn(fmt.Sprintf("unsport case %v", handleType))
 	}
 }
 
+//wait waits until the event captured at ts is due on the virtual clock ,
+//the replay lags behind if the event is late
+func (h *ReplayEventHandler) wait(ts int64) {
+	lag := h.cfg.Clock.WaitShift(h.ctx, ts, h.shift)
+	if lag < lateThreshold {
+		return
+	}
+	ms := uint64(lag / time.Millisecond)
+	stats.AddStatic("ReplayLag", ms, true)
+	stats.MaxStatic("ReplayMaxLag", ms)
+	stats.AddStatic("LateEvents", 1, false)
+	if lag > time.Second && time.Since(h.lagWarnTime) > 10*time.Second {
+		h.lagWarnTime = time.Now()
+		h.log.Warn(fmt.Sprintf("replay lags behind the captured time by %v , the target can not keep up", lag))
+	}
+}
+
 func (h *ReplayEventHandler) ReplayEventAndWriteRes(e stream.MySQLEvent) {
-	err := h.ApplyEvent(h.ctx, &e)
-	if err != nil {
-		if mysqlError, ok := err.(*mysql.MySQLError); ok {
-			e.Rr.ErrNO = mysqlError.Number
-			e.Rr.ErrDesc = mysqlError.Message
-		} else {
-			e.Rr.ErrNO = 20000
-			e.Rr.ErrDesc = "Failed to execute SQL and failed to convert to mysql error"
-		}
+	normalized := h.setDigest(&e)
+	e.Rr.Clone = h.clone
+	if !h.skip(&e) {
+		h.applyTargets(&e)
+	}
+	if len(e.Rr.Skipped) > 0 {
+		stats.AddStatic("SkipSQL", 1, false)
+		h.AsyncWriteResToFile(e)
+		return
 	}
 	stats.AddStatic("DealSQL", 1, false)
+	addDigest(&e, normalized)
 	h.AsyncWriteResToFile(e)
 }
 
+//setDigest sets the digest of a query or prepared statement and returns
+//the normalized sql
+func (h *ReplayEventHandler) setDigest(e *stream.MySQLEvent) string {
+	switch e.Type {
+	case util.EventQuery:
+		var normalized string
+		normalized, e.Digest = parse.Digest(e.Query)
+		return normalized
+	case util.EventStmtPrepare:
+		normalized, d := parse.Digest(e.Query)
+		h.digests[e.StmtID] = digest{normalized: normalized, digest: d}
+		e.Digest = d
+		return normalized
+	case util.EventStmtExecute:
+		d := h.digests[e.StmtID]
+		e.Digest = d.digest
+		return d.normalized
+	case util.EventStmtClose:
+		e.Digest = h.digests[e.StmtID].digest
+		delete(h.digests, e.StmtID)
+	}
+	return ""
+}
+
+//addDigest aggregates the executions of queries and prepared statements
+//by digest
+func addDigest(e *stream.MySQLEvent, normalized string) {
+	if e.Type != util.EventQuery && e.Type != util.EventStmtExecute {
+		return
+	}
+	s := stats.DigestSample{Digest: e.Digest, Normalized: normalized}
+	if e.Pr != nil {
+		s.PrBegin = e.Pr.GetSqlBeginTime()
+		s.PrEnd = e.Pr.GetSqlEndTime()
+		s.PrError = e.Pr.HasError()
+		s.PrRows = uint64(len(e.Pr.GetColumnVal()))
+	}
+	if e.Rr != nil {
+		s.RrBegin = e.Rr.SqlBeginTime
+		s.RrEnd = e.Rr.SqlEndTime
+		s.RrError = e.Rr.ErrNO != 0 || e.Rr.ErrCategory != util.ErrCategoryNone
+		s.RrRows = uint64(len(e.Rr.ColValues))
+	}
+	stats.AddDigest(s)
+}
+
 func (h *ReplayEventHandler) ReplayEvent(ch chan stream.MySQLEvent, wg *sync.WaitGroup) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -305,9 +464,11 @@ func (h *ReplayEventHandler) ReplayEvent(ch chan stream.MySQLEvent, wg *sync.Wai
 	}()
 	h.log.Info("thread begin to run for apply mysql event " + h.fileNamePrefix)
 	for {
-		e, ok := <-ch
+		e, ok := h.next(ch)
 		if ok {
+			ticket := h.waitCommitOrder(e)
 			h.DoEvent(e)
+			h.cfg.CommitOrder.Done(ticket)
 			h.writeEventToFile(e)
 		} else {
 			wg.Done()
@@ -319,31 +480,28 @@ func (h *ReplayEventHandler) ReplayEvent(ch chan stream.MySQLEvent, wg *sync.Wai
 
 }
 
-var eventsMap sync.Map
+//waitCommitOrder waits until the commit points captured before the event
+//are done if the event is a commit point , it returns the ticket of the event
+func (h *ReplayEventHandler) waitCommitOrder(e stream.MySQLEvent) uint64 {
+	if h.tickets == nil {
+		return 0
+	}
+	ticket := <-h.tickets
+	if !h.cfg.CommitOrder.Wait(h.ctx, ticket) {
+		stats.AddStatic("CommitOrderTimeout", 1, false)
+		m := mask.Default.Event(e)
+		h.log.Warn("commit without waiting for the commits captured before it , " + m.String())
+	}
+	return ticket
+}
 
 func (h *ReplayEventHandler) writeEventToFile(e stream.MySQLEvent) {
-	hash := e.Conn.HashStr()
-
-	events := []stream.MySQLEvent{}
-	if v, ok := eventsMap.Load(hash); ok {
-		events = v.([]stream.MySQLEvent)
+	if h.journal == nil {
+		return
 	}
-	events = append(events, e)
-
-	eventsMap.Store(hash, events)
-
-	writeMap := map[interface{}]interface{}{}
-	eventsMap.Range(func(key, value interface{}) bool {
-		writeMap[key] = value
-		return true
-	})
-
-	jsonBytes, err := json.Marshal(writeMap)
-	if err == nil {
-		err = os.WriteFile(h.cfg.OutputDir+"/mysql_events.json", jsonBytes, 0644)
-		if err != nil {
-			h.log.Warn(fmt.Sprintf("write file fail , %v", err))
-		}
+	err := h.journal.Append(&e)
+	if err != nil {
+		h.log.Warn(fmt.Sprintf("write event to journal fail , %v", err))
 	}
 }
 
@@ -355,6 +513,19 @@ func (h *ReplayEventHandler) OnEvent(e stream.MySQLEvent) {
 		h.wg.Add(1)
 		go h.ReplayEvent(h.ch, h.wg)
 	})
+	if h.cfg.Clock != nil && h.cfg.CheckNeedReplay(e.Time) == util.NeedReplaySQL {
+		//events come in the captured order of all connections , the clock
+		//is anchored at the first one replayed
+		h.cfg.Clock.Start(e.Time)
+	}
+	if h.tickets != nil {
+		//tickets are given in the captured order of all connections
+		var ticket uint64
+		if h.txn.commitPoint(&e) {
+			ticket = h.cfg.CommitOrder.Ticket()
+		}
+		h.tickets <- ticket
+	}
 	h.ch <- e
 	stats.AddStatic("GetSQL", 1, false)
 	stats.AddStatic("SQLChanLen", uint64(len(h.ch)), true)
@@ -362,7 +533,8 @@ func (h *ReplayEventHandler) OnEvent(e stream.MySQLEvent) {
 		h.log.Warn("sql Channel is nearly  full , " + fmt.Sprintf("%v-%v",len(h.ch),100000))
 	}*/
 
-	h.log.Info(fmt.Sprintf("OnEvent: %s", e.String()))
+	m := mask.Default.Event(e)
+	h.log.Info(fmt.Sprintf("OnEvent: %s", m.String()))
 }
 
 func (h *ReplayEventHandler) OnClose() {
@@ -371,7 +543,15 @@ func (h *ReplayEventHandler) OnClose() {
 	//wait write goroutine end
 	close(h.wf.ch)
 	h.wf.wg.Wait()
+	if h.journal != nil {
+		if err := h.journal.Close(); err != nil {
+			h.log.Warn(fmt.Sprintf("close journal fail , %v", err))
+		}
+	}
 	h.quit(false)
+	for _, t := range h.targets {
+		t.quit(false)
+	}
 }
 
 func (h *ReplayEventHandler) ApplyEvent(ctx context.Context, e *stream.MySQLEvent) error {
@@ -380,28 +560,39 @@ func (h *ReplayEventHandler) ApplyEvent(ctx context.Context, e *stream.MySQLEven
 		return nil
 	}
 
-	//apply mysql event on replay server
+	//apply mysql event on replay server , failed statements are applied
+	//again by the retry policy
+	var err error
+	for attempts := 1; ; attempts++ {
+		e.Rr.Attempts = attempts
+		err = h.applyEvent(ctx, e)
+		if err == nil || !h.retry(ctx, e, err, attempts) {
+			break
+		}
+	}
+	if err != nil {
+		m := mask.Default.Event(*e)
+		h.log.Warn("failed to apply "+m.String(), zap.Int("attempts", e.Rr.Attempts), zap.Error(err))
+	}
+	return err
+}
+
+func (h *ReplayEventHandler) applyEvent(ctx context.Context, e *stream.MySQLEvent) error {
 	var err error
-LOOP:
 	switch e.Type {
 	case util.EventQuery:
-		var mysqlError *mysql.MySQLError
 		e.Rr.ColValues = make([][]driver.Value, 0)
-		var ok bool
-	RETRYCOMQUERY:
-		err = h.execute(ctx, e.Query, e)
-		//fmt.Println(err)
+		query := h.rewrite(e.Query)
+		if query != e.Query {
+			e.Rr.OrigSqlStatment = e.Query
+		}
+		err = h.execute(ctx, query, e)
+		if db, ok := parse.UseDB(query); ok && err == nil {
+			//reconnect to the current database
+			h.schema = db
+		}
 		if err != nil {
 			stats.AddStatic("ExecSQLFail", 1, false)
-			if mysqlError, ok = err.(*mysql.MySQLError); ok {
-				//If TiDB thrown 1205: Lock wait timeout exceeded; try restarting transaction
-				//we try again until execute success
-				if mysqlError.Number == 1205 {
-					h.log.Warn(fmt.Sprintf("replay sql with lock wait timeout , try again %v", mysqlError))
-					e.Rr.ColValues = e.Rr.ColValues[:0][:0]
-					goto RETRYCOMQUERY
-				}
-			}
 		}
 	case util.EventStmtPrepare:
 		err = h.stmtPrepare(ctx, e.StmtID, e.Query)
@@ -411,40 +602,29 @@ LOOP:
 				logstr := fmt.Sprintf("prepare statment exec fail ,%s , %d ,%s ",
 					e.Query, mysqlError.Number, mysqlError.Message)
 				h.log.Error(logstr)
-			} else {
-				e.Rr.ErrNO = 20000
-				e.Rr.ErrDesc = "exec sql fail and covert  to mysql error struct err"
 			}
 		}
 	case util.EventStmtExecute:
-		_, ok := h.stmts[e.StmtID]
-		if ok {
-			var mysqlError *mysql.MySQLError
-			e.Rr.ColValues = make([][]driver.Value, 0)
-		RETRYCOMSTMTEXECUTE:
-			err = h.stmtExecute(ctx, e.StmtID, e.Params, e)
-			if err != nil {
-				stats.AddStatic("ExecSQLFail", 1, false)
-				if mysqlError, ok = err.(*mysql.MySQLError); ok {
-					//If TiDB thrown 1205: Lock wait timeout exceeded; try restarting transaction
-					//we try again until execute success
-					if mysqlError.Number == 1205 {
-						e.Rr.ColValues = e.Rr.ColValues[:0][:0]
-						goto RETRYCOMSTMTEXECUTE
-					}
-				}
-			}
-		} else {
-			err := new(mysql.MySQLError)
-			err.Number = 10000
-			err.Message = fmt.Sprintf("%v is not exist , maybe prepare fail", e.StmtID)
-			return err
+		if _, ok := h.stmts[e.StmtID]; !ok {
+			return errors.Annotate(ErrStmtNotFound, "stmt id "+e.StmtID)
+		}
+		e.Rr.ColValues = make([][]driver.Value, 0)
+		err = h.stmtExecute(ctx, e.StmtID, e.Params, e)
+		if err != nil {
+			stats.AddStatic("ExecSQLFail", 1, false)
 		}
 	case util.EventStmtClose:
 		h.stmtClose(e.StmtID)
 	case util.EventHandshake:
 		h.quit(false)
-		err = h.handshake(ctx, e.DB)
+		if e.Pr != nil && e.Pr.HasError() {
+			//the login was rejected by the production server,
+			//the client will not send any command on this connection
+			h.log.Info(fmt.Sprintf("skip failed login , %v-%v", e.Pr.GetErrNo(), e.Pr.GetErrDesc()))
+			return nil
+		}
+		db, _ := h.dbMap.Map(e.DB)
+		err = h.handshake(ctx, db)
 		if err != nil {
 			stats.AddStatic("ExecSQLFail", 1, false)
 		}
@@ -453,32 +633,31 @@ LOOP:
 	default:
 		h.log.Warn("unknown event", zap.Any("value", e))
 	}
-	if err != nil {
-		if sqlErr := errors.Unwrap(err); sqlErr == context.DeadlineExceeded || sqlErr == sql.ErrConnDone || sqlErr == mysql.ErrInvalidConn {
-			h.log.Warn("reconnect after "+e.String(), zap.String("cause", sqlErr.Error()))
-			h.quit(true)
-			err = h.handshake(ctx, h.schema)
-			if err != nil {
-				h.log.Warn("reconnect error", zap.Error(err))
-			} else {
-				//reconnect success ,try exec query again
-				goto LOOP
-			}
-		} else {
-			h.log.Warn("failed to apply "+e.String(), zap.Error(err))
-		}
-	}
 	return err
 }
 
 //connect to server and set autocommit on
 func (h *ReplayEventHandler) open(schema string) (*sql.DB, error) {
-	cfg := h.MySQLConfig
-	if len(schema) > 0 && cfg.DBName != schema {
-		cfg = cfg.Clone()
-		cfg.DBName = schema
+	var (
+		pool *sql.DB
+		err  error
+	)
+	if h.cfg.Protocol == util.ProtocolPostgres {
+		pool, err = sql.Open("postgres", util.PgDsnWithDB(h.pgDsn, schema))
+	} else {
+		cfg := h.MySQLConfig
+		if len(schema) > 0 && cfg.DBName != schema {
+			cfg = cfg.Clone()
+			cfg.DBName = schema
+		}
+		pool, err = sql.Open("mysql", cfg.FormatDSN())
+	}
+	if err != nil {
+		return nil, err
 	}
-	return sql.Open("mysql", cfg.FormatDSN())
+	//a captured connection has one session on the replay server
+	pool.SetMaxOpenConns(1)
+	return pool, nil
 }
 
 //Handle Handshake messages, similar to Use Database
@@ -510,6 +689,9 @@ func (h *ReplayEventHandler) getConn(ctx context.Context) (*sql.Conn, error) {
 		}
 	}
 	if h.conn == nil {
+		if err = h.waitSession(ctx); err != nil {
+			return nil, err
+		}
 		h.conn, err = h.pool.Conn(ctx)
 		if err != nil {
 			//fmt.Println(485,err)
@@ -548,6 +730,9 @@ func (h *ReplayEventHandler) quit(reconnect bool) {
 		}
 		h.pool = nil
 	}
+	if !reconnect {
+		h.releaseSession()
+	}
 }
 
 //Execute SQL on replay Server
@@ -576,6 +761,7 @@ func (h *ReplayEventHandler) execute(ctx context.Context, query string, e *strea
 		//stats.Add(stats.FailedQueries, 1)
 		return err
 	}
+	h.ReadColumns(rows, e)
 	for rows.Next() {
 		h.ReadRowValues(rows, e)
 	}
@@ -587,6 +773,9 @@ func (h *ReplayEventHandler) execute(ctx context.Context, query string, e *strea
 func (h *ReplayEventHandler) stmtPrepare(ctx context.Context, id string, query string) error {
 	stmt := h.stmts[id]
 	stmt.query = query
+	if h.keyOffset != 0 {
+		_, stmt.keyParams = rewrite.OffsetKeys(query, h.cfg.ScaleKeys, h.keyOffset)
+	}
 	if stmt.handle != nil {
 		if err := stmt.handle.Close(); err != nil {
 			h.log.Warn("close stmt handle fail ," + err.Error())
@@ -599,16 +788,29 @@ func (h *ReplayEventHandler) stmtPrepare(ctx context.Context, id string, query s
 		return err
 	}
 	//stats.Add(stats.StmtPrepares, 1)
-	stmt.handle, err = conn.PrepareContext(ctx, stmt.query)
+	stmt.handle, err = conn.PrepareContext(ctx, h.rewrite(stmt.query))
 	if err != nil {
 		//stats.Add(stats.FailedStmtPrepares, 1)
 		return err
 	}
 	h.stmts[id] = stmt
-	h.log.Debug(fmt.Sprintf("%v id is %v", query, id))
+	h.log.Debug(fmt.Sprintf("%v id is %v", mask.Default.Query(h.schema, query), id))
 	return nil
 }
 
+//rewrite rewrites a query before it is executed or prepared on the
+//replay server , databases are mapped before the rewrite rules are applied
+func (h *ReplayEventHandler) rewrite(query string) string {
+	if h.dbMap.Mentioned(query) {
+		query = rewrite.Schemas(query, h.dbMap.Map)
+	}
+	query = rewrite.Default.Query(h.schema, query)
+	if h.keyOffset != 0 {
+		query, _ = rewrite.OffsetKeys(query, h.cfg.ScaleKeys, h.keyOffset)
+	}
+	return query
+}
+
 //Retrieve the prepare statement from SQL.Stmt
 //via the unsafe and reflection mechanisms
 func (h *ReplayEventHandler) getQuery(s *sql.Stmt) string {
@@ -628,6 +830,10 @@ func (h *ReplayEventHandler) stmtExecute(ctx context.Context, id string, params
 	}
 
 	e.Rr.SqlStatment = h.getQuery(stmt)
+	if query := h.stmts[id].query; query != e.Rr.SqlStatment {
+		e.Rr.OrigSqlStatment = query
+	}
+	params = rewrite.OffsetParams(params, h.stmts[id].keyParams, h.keyOffset)
 	e.Rr.Values = params
 
 	//fmt.Println(e.Rr.SqlStatment,e.Rr.Values)
@@ -648,6 +854,7 @@ func (h *ReplayEventHandler) stmtExecute(ctx context.Context, id string, params
 		//stats.Add(stats.FailedStmtExecutes, 1)
 		return err
 	}
+	h.ReadColumns(rows, e)
 	for rows.Next() {
 		h.ReadRowValues(rows, e)
 	}
@@ -676,13 +883,13 @@ func (h *ReplayEventHandler) getStmt(ctx context.Context, id string) (*sql.Stmt,
 	if ok && stmt.handle != nil {
 		return stmt.handle, nil
 	} else if !ok {
-		return nil, errors.Errorf("no such statement #%d", id)
+		return nil, errors.Errorf("no such statement #%s", id)
 	}
 	conn, err := h.getConn(ctx)
 	if err != nil {
 		return nil, err
 	}
-	stmt.handle, err = conn.PrepareContext(ctx, stmt.query)
+	stmt.handle, err = conn.PrepareContext(ctx, h.rewrite(stmt.query))
 	if err != nil {
 		return nil, err
 	}
@@ -702,21 +909,16 @@ func (h *ReplayEventHandler) ReadRowValues(f *sql.Rows, e *stream.MySQLEvent) {
 	rf = reflect.NewAt(rf.Type(), unsafe.Pointer(rf.UnsafeAddr())).Elem()
 	z := rf.Interface().([]driver.Value)
 	rr := make([]driver.Value, 0, len(z))
-	var err error
 	for i := range z {
-		if z[i] == nil {
-			rr = append(rr, nil)
+		//keep the type returned by driver (time.Time , bool , int64 ...)
+		//for the typed comparison , the buffer of []byte is reused by driver
+		if b, ok := z[i].([]byte); ok {
+			c := make([]byte, len(b))
+			copy(c, b)
+			rr = append(rr, c)
 			continue
 		}
-		var a string
-		err = stream.ConvertAssignRows(z[i], &a)
-		if err == nil {
-			rr = append(rr, a)
-		} else {
-			h.log.Warn("get row values fail , covert column value to string fail ," + err.Error())
-		}
-	}
-	if err == nil {
-		e.Rr.ColValues = append(e.Rr.ColValues, rr)
+		rr = append(rr, z[i])
 	}
+	e.Rr.ColValues = append(e.Rr.ColValues, rr)
 }
@@ -64,7 +64,7 @@ func TestReplayEventHandler_GenerateNextFileName(t *testing.T) {
 		schema                      string
 		pool                        *sql.DB
 		conn                        *sql.Conn
-		stmts                       map[uint64]statement
+		stmts                       map[string]statement
 		ctx                         context.Context
 		filterStr                   string
 		needCompareRes              bool
@@ -415,6 +415,7 @@ func Test_DoEvent_EventStmtPrepare(t *testing.T){
 	}
 	h :=&ReplayEventHandler{
 		log:zap.L().Named("test"),
+		cfg:&util.Config{},
 	}
 	patches := gomonkey.ApplyMethod(reflect.TypeOf(h), "ReplayEventAndWriteRes",
 		func(_ *ReplayEventHandler,e stream.MySQLEvent) {
@@ -488,6 +489,7 @@ func Test_DoEvent_NeedReplaySQL(t *testing.T){
 		log:zap.L().Named("test"),
 	}
 	cfg :=&util.Config{}
+	h.cfg = cfg
 	patches := gomonkey.ApplyMethod(reflect.TypeOf(cfg), "CheckNeedReplay",
 		func(_ *util.Config,ts int64) uint16 {
 			return util.NeedReplaySQL
@@ -0,0 +1,97 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package sqlreplay
+
+import (
+	"sync"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"go.uber.org/zap"
+)
+
+//newTargetHandler returns the session of the connection on a replay server
+//given by --dsn after the first one , it only applies events
+func newTargetHandler(h *ReplayEventHandler, t *util.Target) *ReplayEventHandler {
+	var dry *dryRun
+	if h.cfg.DryRun {
+		dry = newDryRun()
+	}
+	return &ReplayEventHandler{
+		dry:         dry,
+		applied:     newTxnTracker(),
+		pconn:       h.pconn,
+		log:         h.log.With(zap.String("target", t.Name)),
+		target:      t.Name,
+		MySQLConfig: t.MySQLConfig,
+		pgDsn:       t.PgDsn,
+		limiter:     t.Limiter,
+		clone:       h.clone,
+		keyOffset:   h.keyOffset,
+		dbMap:       h.dbMap,
+		ctx:         h.ctx,
+		stmts:       make(map[string]statement),
+		cfg:         h.cfg,
+	}
+}
+
+//applyTargets applies the event on all replay servers in parallel , the
+//results of the servers after the first one are kept in e.Rr.Targets
+func (h *ReplayEventHandler) applyTargets(e *stream.MySQLEvent) {
+	if len(h.targets) == 0 {
+		h.apply(e)
+		return
+	}
+	var (
+		wg     sync.WaitGroup
+		events = make([]stream.MySQLEvent, len(h.targets))
+	)
+	for i, t := range h.targets {
+		events[i] = *e
+		events[i].NewReplayRes()
+		events[i].Rr.Target = t.target
+		wg.Add(1)
+		go func(t *ReplayEventHandler, e *stream.MySQLEvent) {
+			defer wg.Done()
+			t.apply(e)
+		}(t, &events[i])
+	}
+	h.apply(e)
+	wg.Wait()
+	for i := range events {
+		e.Rr.Targets = append(e.Rr.Targets, events[i].Rr)
+	}
+}
+
+//apply applies the event on the replay server of the handler
+func (h *ReplayEventHandler) apply(e *stream.MySQLEvent) {
+	if h.applied != nil {
+		h.inTxn = h.applied.open()
+		h.applied.commitPoint(e)
+	}
+	err := h.waitStmt(h.ctx, e)
+	switch {
+	case err != nil:
+	case h.dry != nil:
+		err = h.applyDryRun(h.ctx, e)
+	default:
+		err = h.ApplyEvent(h.ctx, e)
+	}
+	h.releaseStmt()
+	if err != nil {
+		setReplayResError(e.Rr, err)
+	}
+}
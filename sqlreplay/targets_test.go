@@ -0,0 +1,50 @@
+package sqlreplay
+
+import (
+	"testing"
+
+	"github.com/bobguo/mysql-replay/stream"
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestReplayEventHandler_applyTargets(t *testing.T) {
+	cfg := &util.Config{
+		DryRun:  true,
+		Targets: []*util.Target{{Name: "dsn2"}, {Name: "dsn3"}},
+	}
+	h := &ReplayEventHandler{cfg: cfg, log: zap.NewNop(), stmts: make(map[string]statement), dry: newDryRun()}
+	for _, target := range cfg.Targets {
+		h.targets = append(h.targets, newTargetHandler(h, target))
+	}
+	assert.Equal(t, 2, len(h.targets))
+	assert.NotSame(t, h.dry, h.targets[0].dry)
+
+	e := stream.MySQLEvent{Type: util.EventQuery, Query: "select 1"}
+	e.NewReplayRes()
+	h.applyTargets(&e)
+	assert.Empty(t, e.Rr.Target)
+	assert.Equal(t, 2, len(e.Rr.Targets))
+	assert.Equal(t, "dsn2", e.Rr.Targets[0].Target)
+	assert.Equal(t, "dsn3", e.Rr.Targets[1].Target)
+	assert.Empty(t, e.Rr.Targets[0].Targets)
+
+	//every server has a session of its own
+	e = stream.MySQLEvent{Type: util.EventQuery, Query: "set autocommit = 0"}
+	e.NewReplayRes()
+	h.applyTargets(&e)
+	assert.NotEmpty(t, e.Rr.Skipped)
+	for _, rr := range e.Rr.Targets {
+		assert.Equal(t, e.Rr.Skipped, rr.Skipped)
+	}
+	assert.False(t, h.dry.txn.autocommit)
+	assert.False(t, h.targets[0].dry.txn.autocommit)
+	assert.False(t, h.targets[1].dry.txn.autocommit)
+
+	h.targets = nil
+	e = stream.MySQLEvent{Type: util.EventQuery, Query: "select 1"}
+	e.NewReplayRes()
+	h.applyTargets(&e)
+	assert.Empty(t, e.Rr.Targets)
+}
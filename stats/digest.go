@@ -0,0 +1,202 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package stats
+
+import (
+	"encoding/json"
+	"math/bits"
+	"sort"
+	"sync"
+)
+
+//latency of bucket i is less than 2^i microseconds , the last bucket
+//holds all latencies longer than about 36 minutes
+const histogramBuckets = 32
+
+//Histogram is the latency distribution in microseconds
+type Histogram struct {
+	Count   uint64                   `json:"count"`
+	Sum     uint64                   `json:"sum"`
+	Min     uint64                   `json:"min"`
+	Max     uint64                   `json:"max"`
+	Buckets [histogramBuckets]uint64 `json:"buckets"`
+}
+
+func (h *Histogram) Add(us uint64) {
+	if h.Count == 0 || us < h.Min {
+		h.Min = us
+	}
+	if us > h.Max {
+		h.Max = us
+	}
+	h.Count++
+	h.Sum += us
+	i := bits.Len64(us)
+	if i >= histogramBuckets {
+		i = histogramBuckets - 1
+	}
+	h.Buckets[i]++
+}
+
+//Percentile returns the upper bound of the bucket of the p percentile ,
+//p is in [0,1]
+func (h *Histogram) Percentile(p float64) uint64 {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := uint64(p*float64(h.Count) + 0.5)
+	if rank == 0 {
+		rank = 1
+	}
+	var n uint64
+	for i, c := range h.Buckets {
+		n += c
+		if n >= rank {
+			if i == 0 {
+				return 0
+			}
+			if bound := uint64(1)<<uint(i) - 1; bound < h.Max {
+				return bound
+			}
+			return h.Max
+		}
+	}
+	return h.Max
+}
+
+//MarshalJSON adds the percentiles of the latency
+func (h Histogram) MarshalJSON() ([]byte, error) {
+	type histogram Histogram
+	return json.Marshal(struct {
+		histogram
+		P50 uint64 `json:"p50"`
+		P95 uint64 `json:"p95"`
+		P99 uint64 `json:"p99"`
+	}{histogram(h), h.Percentile(0.5), h.Percentile(0.95), h.Percentile(0.99)})
+}
+
+//DigestSample is an execution of a query , times are in nanoseconds and
+//zero if unknown
+type DigestSample struct {
+	Digest     string
+	Normalized string
+	PrBegin    uint64
+	PrEnd      uint64
+	RrBegin    uint64
+	RrEnd      uint64
+	PrError    bool
+	RrError    bool
+	PrRows     uint64
+	RrRows     uint64
+}
+
+//DigestStats aggregates the executions of queries with the same digest ,
+//in production (Pr) and on the replay server (Rr)
+type DigestStats struct {
+	Digest    string    `json:"digest"`
+	Query     string    `json:"query"`
+	Count     uint64    `json:"count"`
+	PrLatency Histogram `json:"pr-latency"`
+	RrLatency Histogram `json:"rr-latency"`
+	PrErrors  uint64    `json:"pr-errors"`
+	RrErrors  uint64    `json:"rr-errors"`
+	PrRows    uint64    `json:"pr-rows"`
+	RrRows    uint64    `json:"rr-rows"`
+}
+
+//Digests aggregates the executions of queries by digest
+type Digests struct {
+	mu sync.Mutex
+	m  map[string]*DigestStats
+}
+
+func NewDigests() *Digests {
+	return &Digests{m: make(map[string]*DigestStats)}
+}
+
+//digests of the replay , served by the http server
+var digests = NewDigests()
+
+func latency(begin, end uint64) (uint64, bool) {
+	if begin == 0 || end < begin {
+		return 0, false
+	}
+	return (end - begin) / 1000, true
+}
+
+func (d *Digests) Add(s DigestSample) {
+	if len(s.Digest) == 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ds, ok := d.m[s.Digest]
+	if !ok {
+		ds = &DigestStats{Digest: s.Digest, Query: s.Normalized}
+		d.m[s.Digest] = ds
+	}
+	ds.Count++
+	if us, ok := latency(s.PrBegin, s.PrEnd); ok {
+		ds.PrLatency.Add(us)
+	}
+	if us, ok := latency(s.RrBegin, s.RrEnd); ok {
+		ds.RrLatency.Add(us)
+	}
+	if s.PrError {
+		ds.PrErrors++
+	}
+	if s.RrError {
+		ds.RrErrors++
+	}
+	ds.PrRows += s.PrRows
+	ds.RrRows += s.RrRows
+}
+
+//Dump returns a copy of the stats of all digests , the most executed
+//first
+func (d *Digests) Dump() []DigestStats {
+	d.mu.Lock()
+	res := make([]DigestStats, 0, len(d.m))
+	for _, ds := range d.m {
+		res = append(res, *ds)
+	}
+	d.mu.Unlock()
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].Digest < res[j].Digest
+	})
+	return res
+}
+
+func (d *Digests) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.m = make(map[string]*DigestStats)
+}
+
+func AddDigest(s DigestSample) {
+	digests.Add(s)
+}
+
+func DumpDigests() []DigestStats {
+	return digests.Dump()
+}
+
+func ResetDigests() {
+	digests.Reset()
+}
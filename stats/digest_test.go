@@ -0,0 +1,60 @@
+package stats
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	assert.Equal(t, uint64(0), h.Percentile(0.5))
+	for _, us := range []uint64{0, 1, 3, 100, 100, 100, 100, 100, 100, 5000} {
+		h.Add(us)
+	}
+	assert.Equal(t, uint64(10), h.Count)
+	assert.Equal(t, uint64(0), h.Min)
+	assert.Equal(t, uint64(5000), h.Max)
+	assert.Equal(t, uint64(5604), h.Sum)
+	//100 is in bucket [64,127]
+	assert.Equal(t, uint64(127), h.Percentile(0.5))
+	assert.Equal(t, uint64(5000), h.Percentile(0.99))
+	assert.Equal(t, uint64(0), h.Percentile(0))
+
+	h.Add(1 << 40)
+	assert.Equal(t, uint64(1), h.Buckets[histogramBuckets-1])
+
+	js, err := json.Marshal(h)
+	assert.Nil(t, err)
+	var m map[string]interface{}
+	assert.Nil(t, json.Unmarshal(js, &m))
+	assert.Equal(t, float64(127), m["p50"])
+	assert.Equal(t, float64(11), m["count"])
+}
+
+func TestAddDigest(t *testing.T) {
+	ResetDigests()
+	defer ResetDigests()
+	AddDigest(DigestSample{Normalized: "select ?"})
+	AddDigest(DigestSample{Digest: "a", Normalized: "select ?", PrBegin: 1000, PrEnd: 3000, RrBegin: 1000, RrEnd: 11000, PrRows: 1, RrRows: 1})
+	AddDigest(DigestSample{Digest: "a", Normalized: "select ? ", PrBegin: 1000, PrEnd: 0, RrError: true})
+	AddDigest(DigestSample{Digest: "b", Normalized: "insert into t values ( ... )", PrError: true, RrError: true})
+
+	res := DumpDigests()
+	assert.Equal(t, 2, len(res))
+	a := res[0]
+	assert.Equal(t, "a", a.Digest)
+	assert.Equal(t, "select ?", a.Query)
+	assert.Equal(t, uint64(2), a.Count)
+	assert.Equal(t, uint64(1), a.PrLatency.Count)
+	assert.Equal(t, uint64(2), a.PrLatency.Sum)
+	assert.Equal(t, uint64(10), a.RrLatency.Sum)
+	assert.Equal(t, uint64(0), a.PrErrors)
+	assert.Equal(t, uint64(1), a.RrErrors)
+	assert.Equal(t, uint64(1), a.PrRows)
+	b := res[1]
+	assert.Equal(t, "b", b.Digest)
+	assert.Equal(t, uint64(1), b.PrErrors)
+	assert.Equal(t, uint64(0), b.PrLatency.Count)
+}
@@ -47,6 +47,20 @@ func init() {
 	Static["ExecSQLFail"] = 0
 	Static["WriteResFileFail"] = 0
 	Static["FormatJsonFail"] = 0
+	Static["ReplayLag"] = 0
+	Static["ReplayMaxLag"] = 0
+	Static["LateEvents"] = 0
+	Static["CommitOrderTimeout"] = 0
+	Static["SkipSQL"] = 0
+	Static["SessionWait"] = 0
+	Static["SessionWaits"] = 0
+	Static["SessionMaxWait"] = 0
+	Static["StmtWait"] = 0
+	Static["StmtWaits"] = 0
+	Static["StmtMaxWait"] = 0
+	Static["SessionIdleReleases"] = 0
+	Static["Retries"] = 0
+	Static["RetryGiveUp"] = 0
 }
 
 func AddStatic(key string, value uint64, replace bool) {
@@ -63,6 +77,15 @@ func AddStatic(key string, value uint64, replace bool) {
 	}
 }
 
+//MaxStatic keeps the largest value of the key
+func MaxStatic(key string, value uint64) {
+	Mu.Lock()
+	defer Mu.Unlock()
+	if v, ok := Static[key]; !ok || value > v {
+		Static[key] = value
+	}
+}
+
 func DumpStatic() string {
 	Mu.RLock()
 	defer Mu.RUnlock()
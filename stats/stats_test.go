@@ -46,4 +46,11 @@ func Test_AddStatic_not_exist(t *testing.T){
 func Test_DumpStatic( t *testing.T){
 	str := DumpStatic()
 	assert.New(t).NotEqual(len(str),0)
-}
\ No newline at end of file
+}
+func Test_MaxStatic(t *testing.T){
+	MaxStatic("MaxLag",uint64(5))
+	MaxStatic("MaxLag",uint64(3))
+	assert.New(t).Equal(Static["MaxLag"],uint64(5))
+	MaxStatic("MaxLag",uint64(8))
+	assert.New(t).Equal(Static["MaxLag"],uint64(8))
+}
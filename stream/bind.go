@@ -0,0 +1,127 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package stream
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+//BindParams inlines the params of a prepared statement to its query , so
+//that a COM_STMT_EXECUTE or Sql.StmtExecute can be written as a plain SQL
+//statement . ? in strings , quoted identifiers and comments are kept
+func BindParams(query string, args []interface{}) (string, error) {
+	if len(args) == 0 {
+		return query, nil
+	}
+	var sb strings.Builder
+	n := 0
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := i + 1
+			for end < len(query) && query[end] != c {
+				if query[end] == '\\' && c != '`' {
+					end++
+				}
+				end++
+			}
+			if end >= len(query) {
+				end = len(query) - 1
+			}
+			sb.WriteString(query[i : end+1])
+			i = end
+			continue
+		case c == '#' || c == '-' && strings.HasPrefix(query[i:], "-- "):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i - 1
+			}
+			sb.WriteString(query[i : i+end+1])
+			i += end
+			continue
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				end = len(query) - i - 4
+			}
+			sb.WriteString(query[i : i+end+4])
+			i += end + 3
+			continue
+		case c == '?':
+			if n >= len(args) {
+				return "", fmt.Errorf("too few args %d for query", len(args))
+			}
+			sb.WriteString(sqlLiteral(args[n]))
+			n++
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	if n != len(args) {
+		return "", fmt.Errorf("%d args for %d placeholders", len(args), n)
+	}
+	return sb.String(), nil
+}
+
+//sqlLiteral writes a parameter as SQL literal
+func sqlLiteral(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if x {
+			return "TRUE"
+		}
+		return "FALSE"
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case uint64:
+		return strconv.FormatUint(x, 10)
+	case float64:
+		if math.IsInf(x, 0) || math.IsNaN(x) {
+			return "NULL"
+		}
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case float32:
+		if math.IsInf(float64(x), 0) || math.IsNaN(float64(x)) {
+			return "NULL"
+		}
+		return strconv.FormatFloat(float64(x), 'g', -1, 32)
+	case time.Time:
+		return "'" + x.Format("2006-01-02 15:04:05.999999") + "'"
+	case []byte:
+		if !utf8.Valid(x) {
+			return "X'" + hex.EncodeToString(x) + "'"
+		}
+		return sqlQuote(string(x))
+	case string:
+		return sqlQuote(x)
+	}
+	return sqlQuote(fmt.Sprint(v))
+}
+
+var sqlQuoteReplacer = strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\x00", `\0`, "\n", `\n`, "\r", `\r`, "\x1a", `\Z`)
+
+func sqlQuote(s string) string {
+	return "'" + sqlQuoteReplacer.Replace(s) + "'"
+}
@@ -0,0 +1,36 @@
+package stream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBindParams(t *testing.T) {
+	tests := []struct {
+		query  string
+		args   []interface{}
+		expect string
+		fail   bool
+	}{
+		{"select ?", []interface{}{nil}, "select NULL", false},
+		{"select '?' , ? -- ?\n, ?", []interface{}{int64(1), []byte{0xff}}, "select '?' , 1 -- ?\n, X'ff'", false},
+		{"select `a?` /* ? */ from t where a = ?", []interface{}{"a\nb"}, "select `a?` /* ? */ from t where a = 'a\\nb'", false},
+		{"select ?", nil, "select ?", false},
+		{"select ?", []interface{}{true, false}, "", true},
+		{"select ?,?,?", []interface{}{float32(1.5), uint64(2), time.Date(2021, 11, 29, 20, 49, 10, 123000000, time.UTC)},
+			"select 1.5,2,'2021-11-29 20:49:10.123'", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			s, err := BindParams(tt.query, tt.args)
+			if tt.fail {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expect, s)
+		})
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/bobguo/mysql-replay/stats"
 	"github.com/bobguo/mysql-replay/util"
@@ -12,19 +14,22 @@ import (
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/reassembly"
+	"go.uber.org/zap"
 )
 
 type MySQLEvent struct {
-	Conn     ConnID              `json:"conn"`
-	Time     int64               `json:"time"`
-	Type     util.MysqlEventType `json:"type"`
-	StmtID   string              `json:"stmtID,omitempty"`
-	Params   []interface{}       `json:"params,omitempty"`
-	DB       string              `json:"db,omitempty"`
-	Username string              `json:"username,omitempty"`
-	Query    string              `json:"query,omitempty"`
-	Pr       *PacketRes          `json:"packet_res,omitempty"`
-	Rr       *ReplayRes          `json:"replay_res,omitempty"`
+	Conn       ConnID              `json:"conn"`
+	Time       int64               `json:"time"`
+	Type       util.MysqlEventType `json:"type"`
+	StmtID     string              `json:"stmtID,omitempty"`
+	Params     []interface{}       `json:"params,omitempty"`
+	DB         string              `json:"db,omitempty"`
+	Username   string              `json:"username,omitempty"`
+	AuthPlugin string              `json:"auth_plugin,omitempty"`
+	Query      string              `json:"query,omitempty"`
+	Digest     string              `json:"digest,omitempty"`
+	Pr         *PacketRes          `json:"packet_res,omitempty"`
+	Rr         *ReplayRes          `json:"replay_res,omitempty"`
 }
 
 func (event *MySQLEvent) Reset(params []interface{}) *MySQLEvent {
@@ -34,6 +39,7 @@ func (event *MySQLEvent) Reset(params []interface{}) *MySQLEvent {
 	event.Params = params
 	event.DB = ""
 	event.Query = ""
+	event.Digest = ""
 	return event
 }
 
@@ -41,9 +47,12 @@ func (event *MySQLEvent) NewReplayRes() {
 	rr := new(ReplayRes)
 	rr.ErrNO = 0
 	rr.ErrDesc = ""
+	rr.SQLState = ""
+	rr.ErrCategory = util.ErrCategoryNone
 	rr.Values = rr.Values[0:0]
 	rr.ColumnNum = 0
 	rr.ColNames = rr.ColNames[0:0]
+	rr.Columns = nil
 	rr.ColValues = rr.ColValues[0:0][0:0]
 	rr.SqlStatment = ""
 	rr.SqlBeginTime = 0
@@ -58,13 +67,18 @@ func (event *MySQLEvent) String() string {
 	case util.EventQuery:
 		return fmt.Sprintf("%s execute {query:%q} @ %d", conn, formatQuery(event.Query), event.Time)
 	case util.EventStmtExecute:
-		return fmt.Sprintf("%s execute stmt {id:%d,params:%v} @%d", conn, event.StmtID, event.Params, event.Time)
+		return fmt.Sprintf("%s execute stmt {id:%s,params:%v} @%d", conn, event.StmtID, event.Params, event.Time)
 	case util.EventStmtPrepare:
-		return fmt.Sprintf("%s prepare stmt {id:%d,query:%q} @%d", conn, event.StmtID, formatQuery(event.Query), event.Time)
+		return fmt.Sprintf("%s prepare stmt {id:%s,query:%q} @%d", conn, event.StmtID, formatQuery(event.Query), event.Time)
 	case util.EventStmtClose:
-		return fmt.Sprintf("%s close stmt {id:%d} @%d", conn, event.StmtID, event.Time)
+		return fmt.Sprintf("%s close stmt {id:%s} @%d", conn, event.StmtID, event.Time)
 	case util.EventHandshake:
-		return fmt.Sprintf("%s connect {username:%q,db:%q} @%d", conn, event.Username, event.DB, event.Time)
+		if event.Pr != nil && event.Pr.HasError() {
+			return fmt.Sprintf("%s connect fail {username:%q,db:%q,plugin:%q,errno:%d,sqlstate:%q} @%d", conn,
+				event.Username, event.DB, event.AuthPlugin, event.Pr.GetErrNo(), event.Pr.GetSQLState(), event.Time)
+		}
+		return fmt.Sprintf("%s connect {username:%q,db:%q,plugin:%q} @%d", conn, event.Username, event.DB,
+			event.AuthPlugin, event.Time)
 	case util.EventQuit:
 		return fmt.Sprintf("%s quit @%d", conn, event.Time)
 	default:
@@ -89,6 +103,8 @@ const (
 	typeStr = byte('s')
 	typeBin = byte('b')
 	typeNil = byte('0')
+	typeBol = byte('t')
+	typeTim = byte('T')
 	typeLst = byte('[')
 )
 
@@ -103,7 +119,7 @@ func AppendEvent(buf []byte, event MySQLEvent) ([]byte, error) {
 		buf = strconv.AppendQuote(buf, event.Query)
 	case util.EventStmtExecute:
 		buf = append(buf, sep)
-		buf = []byte(event.StmtID)
+		buf = strconv.AppendQuote(buf, event.StmtID)
 		buf = append(buf, sep)
 		buf, err = AppendStmtParams(buf, event.Params)
 		if err != nil {
@@ -111,15 +127,19 @@ func AppendEvent(buf []byte, event MySQLEvent) ([]byte, error) {
 		}
 	case util.EventStmtPrepare:
 		buf = append(buf, sep)
-		buf = []byte(event.StmtID)
+		buf = strconv.AppendQuote(buf, event.StmtID)
 		buf = append(buf, sep)
 		buf = strconv.AppendQuote(buf, event.Query)
 	case util.EventStmtClose:
 		buf = append(buf, sep)
-		buf = []byte(event.StmtID)
+		buf = strconv.AppendQuote(buf, event.StmtID)
 	case util.EventHandshake:
 		buf = append(buf, sep)
 		buf = strconv.AppendQuote(buf, event.DB)
+		buf = append(buf, sep)
+		buf = strconv.AppendQuote(buf, event.Username)
+		buf = append(buf, sep)
+		buf = strconv.AppendQuote(buf, event.AuthPlugin)
 	case util.EventQuit:
 	default:
 		return nil, fmt.Errorf("unknown event type: %v", event.Type)
@@ -163,6 +183,14 @@ func AppendStmtParams(buf []byte, params []interface{}) ([]byte, error) {
 			buf[s+i] = typeBin
 			buf = append(buf, sep)
 			buf = strconv.AppendQuote(buf, hex.EncodeToString(x))
+		case bool:
+			buf[s+i] = typeBol
+			buf = append(buf, sep)
+			buf = strconv.AppendBool(buf, x)
+		case time.Time:
+			buf[s+i] = typeTim
+			buf = append(buf, sep)
+			buf = x.AppendFormat(buf, time.RFC3339Nano)
 		default:
 			return nil, fmt.Errorf("unsupported param type: %T", param)
 		}
@@ -215,7 +243,10 @@ func ScanEvent(s string, pos int, event *MySQLEvent) (int, error) {
 			return pos, fmt.Errorf("scan stmt-id of event from an empty string")
 		}
 		posNext = nextSep(s, pos)
-		event.StmtID = string(s[pos:posNext])
+		event.StmtID, err = scanStmtID(s[pos:posNext])
+		if err != nil {
+			return pos, err
+		}
 		pos = posNext + 1
 		// params
 		event.Params, posNext, err = ScanStmtParams(s, pos, event.Params[:0])
@@ -229,7 +260,10 @@ func ScanEvent(s string, pos int, event *MySQLEvent) (int, error) {
 			return pos, fmt.Errorf("scan stmt-id of event from an empty string")
 		}
 		posNext = nextSep(s, pos)
-		event.StmtID = string(s[pos:posNext])
+		event.StmtID, err = scanStmtID(s[pos:posNext])
+		if err != nil {
+			return pos, err
+		}
 		pos = posNext + 1
 		// query
 		if len(s) < pos+1 {
@@ -247,7 +281,10 @@ func ScanEvent(s string, pos int, event *MySQLEvent) (int, error) {
 			return pos, fmt.Errorf("scan stmt-id of event from an empty string")
 		}
 		posNext = nextSep(s, pos)
-		event.StmtID = string(s[pos:posNext])
+		event.StmtID, err = scanStmtID(s[pos:posNext])
+		if err != nil {
+			return pos, err
+		}
 		return posNext, nil
 	case util.EventHandshake:
 		// db
@@ -259,6 +296,25 @@ func ScanEvent(s string, pos int, event *MySQLEvent) (int, error) {
 		if err != nil {
 			return pos, fmt.Errorf("scan db of event from (%s): %v", s[pos:posNext], err)
 		}
+		// username and auth plugin , absent in the old format
+		if posNext+1 >= len(s) || s[posNext+1] != '"' {
+			return posNext, nil
+		}
+		pos = posNext + 1
+		posNext = nextSep(s, pos)
+		event.Username, err = strconv.Unquote(s[pos:posNext])
+		if err != nil {
+			return pos, fmt.Errorf("scan username of event from (%s): %v", s[pos:posNext], err)
+		}
+		pos = posNext + 1
+		if len(s) < pos+1 {
+			return pos, fmt.Errorf("scan auth plugin of event from an empty string")
+		}
+		posNext = nextSep(s, pos)
+		event.AuthPlugin, err = strconv.Unquote(s[pos:posNext])
+		if err != nil {
+			return pos, fmt.Errorf("scan auth plugin of event from (%s): %v", s[pos:posNext], err)
+		}
 		return posNext, nil
 	case util.EventQuit:
 		return posNext, nil
@@ -328,6 +384,18 @@ func ScanStmtParams(s string, pos int, params []interface{}) ([]interface{}, int
 				return nil, pos, fmt.Errorf("parse params[%d] from (%s) as bin: %v", i, raw, err)
 			}
 			params = append(params, val)
+		case typeBol:
+			val, err := strconv.ParseBool(raw)
+			if err != nil {
+				return nil, pos, fmt.Errorf("parse params[%d] from (%s) as bool: %v", i, raw, err)
+			}
+			params = append(params, val)
+		case typeTim:
+			val, err := time.Parse(time.RFC3339Nano, raw)
+			if err != nil {
+				return nil, pos, fmt.Errorf("parse params[%d] from (%s) as time: %v", i, raw, err)
+			}
+			params = append(params, val)
 		default:
 			return nil, pos, fmt.Errorf("unsupported param type: %v", t)
 		}
@@ -335,6 +403,18 @@ func ScanStmtParams(s string, pos int, params []interface{}) ([]interface{}, int
 	return params, posNext, nil
 }
 
+//the stmt-id is quoted as the query , it is written raw in the old format
+func scanStmtID(s string) (string, error) {
+	if len(s) == 0 || s[0] != '"' {
+		return s, nil
+	}
+	id, err := strconv.Unquote(s)
+	if err != nil {
+		return "", fmt.Errorf("scan stmt-id of event from (%s): %v", s, err)
+	}
+	return id, nil
+}
+
 func nextSep(s string, pos int) int {
 	size := len(s)
 	if pos >= size {
@@ -348,6 +428,11 @@ func nextSep(s string, pos int) int {
 }
 
 func NewFactoryFromEventHandler(factory func(ConnID) MySQLEventHandler, opts FactoryOptions) *mysqlStreamFactory {
+	proto, err := GetProtocol(opts.Protocol)
+	if err != nil {
+		zap.L().Warn(err.Error() + " , use mysql protocol")
+		proto, _ = GetProtocol(ProtocolMySQL)
+	}
 	f := defaultHandlerFactory
 	if factory != nil {
 		f = func(conn ConnID) MySQLPacketHandler {
@@ -355,14 +440,19 @@ func NewFactoryFromEventHandler(factory func(ConnID) MySQLEventHandler, opts Fac
 			if impl == nil {
 				return RejectConn(conn)
 			}
+			log := conn.Logger(proto.Name() + "-stream")
 			return &eventHandler{
-				fsm:  NewMySQLFSM(conn.Logger("mysql-stream")),
+				dec:  proto.NewDecoder(conn, log),
+				log:  log,
 				conn: conn,
 				impl: impl,
+				once: new(sync.Once),
+				c:    make(chan MySQLPacket, 10000),
+				wg:   new(sync.WaitGroup),
 			}
 		}
 	}
-	return &mysqlStreamFactory{new: f, opts: opts}
+	return &mysqlStreamFactory{new: f, opts: opts, proto: proto}
 }
 
 type MySQLEventHandler interface {
@@ -371,9 +461,15 @@ type MySQLEventHandler interface {
 }
 
 type eventHandler struct {
-	fsm  *MySQLFSM
+	dec  Decoder
+	log  *zap.Logger
 	conn ConnID
 	impl MySQLEventHandler
+
+	//Used to parse message packets asynchronously
+	once *sync.Once
+	c    chan MySQLPacket
+	wg   *sync.WaitGroup
 }
 
 func (h *eventHandler) Accept(ci gopacket.CaptureInfo, dir reassembly.TCPFlowDirection, tcp *layers.TCP) bool {
@@ -381,90 +477,120 @@ func (h *eventHandler) Accept(ci gopacket.CaptureInfo, dir reassembly.TCPFlowDir
 }
 
 func (h *eventHandler) ParsePacket(pkt MySQLPacket) *MySQLEvent {
-	h.fsm.Handle(pkt)
-	if !h.fsm.Ready() || !h.fsm.Changed() {
-		//h.fsm.log.Warn("packet is not ready")
+	return h.dec.Decode(pkt)
+}
+
+//Decode implements Decoder for the MySQL protocol
+func (fsm *MySQLFSM) Decode(pkt MySQLPacket) *MySQLEvent {
+	fsm.Handle(pkt)
+	if !fsm.Ready() || !fsm.Changed() {
+		//fsm.log.Warn("packet is not ready")
 		return nil
 	}
 	e := &MySQLEvent{
 		Conn: pkt.Conn,
 		Time: pkt.Time.UnixNano(),
 	}
-	switch h.fsm.State() {
+	switch fsm.State() {
 	case util.StateComQuery2:
 		e.Type = util.EventQuery
-		e.Query = h.fsm.Query()
+		e.Query = fsm.Query()
 
 	case util.StateComStmtExecute2:
-		stmt := h.fsm.Stmt()
+		stmt := fsm.Stmt()
 		e.Type = util.EventStmtExecute
 		e.StmtID = strconv.FormatUint(uint64(stmt.ID), 10)
-		e.Params = h.fsm.StmtParams()
+		e.Params = fsm.StmtParams()
 
 	case util.StateComStmtPrepare1:
-		stmt := h.fsm.Stmt()
+		stmt := fsm.Stmt()
 		e.Type = util.EventStmtPrepare
 		e.StmtID = strconv.FormatUint(uint64(stmt.ID), 10)
 		e.Query = stmt.Query
 
 	case util.StateComStmtClose:
-		stmt := h.fsm.Stmt()
+		stmt := fsm.Stmt()
 		e.Type = util.EventStmtClose
 		e.StmtID = strconv.FormatUint(uint64(stmt.ID), 10)
 
-	case util.StateHandshake1:
+	case util.StateHandshake2, util.StateHandshakeFail:
+		//only emit the handshake event after the server accepts
+		//or rejects the login , a failed login carries the error
+		//code of the server in PacketRes
 		e.Type = util.EventHandshake
-		e.DB = h.fsm.Schema()
-		e.Username = h.fsm.Username()
+		e.DB = fsm.Schema()
+		e.Username = fsm.Username()
+		e.AuthPlugin = fsm.AuthPlugin()
 
 	case util.StateComQuit:
 		e.Type = util.EventQuit
 	default:
 		return nil
 	}
+	e.Pr = fsm.pr
+	fsm.pr = nil
 	return e
 }
 
 func (h *eventHandler) AsyncParsePacket() {
-	h.fsm.log.Info("thread begin to run for parse packet " + h.conn.HashStr())
+	h.log.Info("thread begin to run for parse packet " + h.conn.HashStr())
 	for {
-		pkt, ok := <-h.fsm.c
+		pkt, ok := <-h.c
 		if ok {
-			e := h.ParsePacket(pkt)
+			e := h.parsePacket(pkt)
 			if e == nil {
 				continue
 			}
 			stats.AddStatic("DealPacket", 1, false)
-			e.Pr = h.fsm.pr
-			h.fsm.pr = nil
 			h.impl.OnEvent(*e)
 		} else {
-			h.fsm.wg.Done()
-			h.fsm.log.Info("thread end to run for parse packet " + h.conn.HashStr())
+			h.wg.Done()
+			h.log.Info("thread end to run for parse packet " + h.conn.HashStr())
 			return
 		}
 	}
 }
 
+//parsePacket parses the packet and passes it to the tracer if the
+//handler traces packets
+func (h *eventHandler) parsePacket(pkt MySQLPacket) *MySQLEvent {
+	tracer, ok := h.impl.(PacketTracer)
+	if !ok {
+		return h.ParsePacket(pkt)
+	}
+	t := PacketTrace{Packet: pkt}
+	sd, ok := h.dec.(StateDecoder)
+	if ok {
+		t.From = sd.StateName()
+	}
+	e := h.ParsePacket(pkt)
+	if ok {
+		t.To = sd.StateName()
+		t.Unparsed, t.Reason = sd.Unparsed()
+	}
+	tracer.OnPacket(t)
+	return e
+}
+
 //deal  packet from pacp file
 func (h *eventHandler) OnPacket(pkt MySQLPacket) {
 
-	h.fsm.once.Do(func() {
-		h.fsm.wg.Add(1)
+	h.once.Do(func() {
+		h.wg.Add(1)
 		go h.AsyncParsePacket()
 	})
-	h.fsm.c <- pkt
+	h.c <- pkt
 	stats.AddStatic("ReadPacket", 1, false)
-	stats.AddStatic("PacketChanLen", uint64(len(h.fsm.c)), true)
+	stats.AddStatic("PacketChanLen", uint64(len(h.c)), true)
 	/*
-		if len(h.fsm.c) >90000 && len(h.fsm.c)% 1000 ==0 {
-			h.fsm.log.Warn("packet Channel is nearly  full , " + fmt.Sprintf("%v-%v",len(h.fsm.c),100000))
+		if len(h.c) >90000 && len(h.c)% 1000 ==0 {
+			h.log.Warn("packet Channel is nearly  full , " + fmt.Sprintf("%v-%v",len(h.c),100000))
 		}
 	*/
 }
 
 func (h *eventHandler) OnClose() {
-	close(h.fsm.c)
-	h.fsm.wg.Wait()
+	close(h.c)
+	h.wg.Wait()
 	h.impl.OnClose()
 }
@@ -15,6 +15,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -34,49 +35,61 @@ func TestEventCodec(t *testing.T) {
 		{MySQLEvent{
 			Time: 0,
 			Type: util.EventHandshake,
-		}, "0\t0\t\"\"", true},
+		}, "0\t1\t\"\"\t\"\"\t\"\"", true},
 		{MySQLEvent{
 			Time: 1,
 			Type: util.EventHandshake,
 			DB:   "test",
-		}, "1\t0\t\"test\"", true},
+		}, "1\t1\t\"test\"\t\"\"\t\"\"", true},
 		{MySQLEvent{
 			Time: 2,
 			Type: util.EventQuit,
-		}, "2\t1", true},
+		}, "2\t2", true},
 		{MySQLEvent{
 			Time:  3,
 			Type:  util.EventQuery,
 			Query: "select * from t where id = 1",
-		}, "3\t2\t\"select * from t where id = 1\"", true},
+		}, "3\t3\t\"select * from t where id = 1\"", true},
 		{MySQLEvent{
 			Time:   4,
 			Type:   util.EventStmtPrepare,
-			StmtID: 1,
+			StmtID: "1",
 			Query:  "select * from t where id = ?",
-		}, "4\t3\t1\t\"select * from t where id = ?\"", true},
+		}, "4\t4\t\"1\"\t\"select * from t where id = ?\"", true},
 		{MySQLEvent{
 			Time:   5,
 			Type:   util.EventStmtExecute,
-			StmtID: 1,
+			StmtID: "1",
 			Params: []interface{}{int64(1)},
-		}, "5\t4\t1\t[i\t1", true},
+		}, "5\t5\t\"1\"\t[i\t1", true},
 		{MySQLEvent{
 			Time:   6,
 			Type:   util.EventStmtExecute,
-			StmtID: 1,
+			StmtID: "1",
 			Params: []interface{}{},
-		}, "6\t4\t1\t[", true},
+		}, "6\t5\t\"1\"\t[", true},
 		{MySQLEvent{
 			Time:   7,
 			Type:   util.EventStmtExecute,
-			StmtID: 1,
-		}, "7\t4\t1\t[", true},
+			StmtID: "1",
+		}, "7\t5\t\"1\"\t[", true},
 		{MySQLEvent{
 			Time:   8,
 			Type:   util.EventStmtClose,
-			StmtID: 1,
-		}, "8\t5\t1", true},
+			StmtID: "1",
+		}, "8\t6\t\"1\"", true},
+		{MySQLEvent{
+			Time:   9,
+			Type:   util.EventStmtPrepare,
+			StmtID: "s\t1\n",
+			Query:  "select ?",
+		}, "9\t4\t\"s\\t1\\n\"\t\"select ?\"", true},
+		{MySQLEvent{
+			Time:   10,
+			Type:   util.EventStmtExecute,
+			StmtID: "s\t1\n",
+			Params: []interface{}{"a"},
+		}, "10\t5\t\"s\\t1\\n\"\t[s\t\"a\"", true},
 	} {
 		t.Run(t.Name()+strconv.Itoa(i), func(t *testing.T) {
 			buf = buf[:0]
@@ -101,6 +114,17 @@ func TestEventCodec(t *testing.T) {
 	}
 }
 
+func TestScanEvent_OldStmtID(t *testing.T) {
+	var event MySQLEvent
+	n, err := ScanEvent("8\t6\t1", 0, event.Reset(nil))
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+	require.Equal(t, "1", event.StmtID)
+
+	_, err = ScanEvent("8\t6\t\"1", 0, event.Reset(nil))
+	require.Error(t, err)
+}
+
 func TestStmtParamsCodec(t *testing.T) {
 	var (
 		buf    = make([]byte, 0, 1024)
@@ -183,7 +207,7 @@ func BenchmarkScanEventStmtExecute(b *testing.B) {
 	raw, _ := AppendEvent(make([]byte, 0, 4096), MySQLEvent{
 		Time:   time.Now().UnixNano() / int64(time.Millisecond),
 		Type:   util.EventStmtExecute,
-		StmtID: 1,
+		StmtID: "1",
 		Params: []interface{}{
 			int64(0),
 			int64(4855),
@@ -205,7 +229,7 @@ func BenchmarkScanEventStmtExecuteJson(b *testing.B) {
 	raw, _ := json.Marshal(MySQLEvent{
 		Time:   time.Now().UnixNano() / int64(time.Millisecond),
 		Type:   util.EventStmtExecute,
-		StmtID: 1,
+		StmtID: "1",
 		Params: []interface{}{
 			int64(0),
 			int64(4855),
@@ -222,35 +246,36 @@ func BenchmarkScanEventStmtExecuteJson(b *testing.B) {
 func TestStream_ParsePacket_EventQuery(t *testing.T){
 	pkt := new(MySQLPacket)
 	h:=new(eventHandler)
-	h.fsm = new(MySQLFSM)
+	fsm := new(MySQLFSM)
+	h.dec = fsm
 	pkt.Time= time.Now()
 
 	query := "select * from test.test"
-	patches := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Handle",
+	patches := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Handle",
 		func  (_ *MySQLFSM,pkt MySQLPacket) {
 			return
 		})
 	defer patches.Reset()
 
-	patches1 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Ready",
+	patches1 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Ready",
 		func  (_ *MySQLFSM) bool{
 			return true
 		})
 	defer patches1.Reset()
 
-	patches2 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Changed",
+	patches2 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Changed",
 		func  (_ *MySQLFSM) bool{
 			return true
 		})
 	defer patches2.Reset()
 
-	patches3 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "State",
+	patches3 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "State",
 		func  (_ *MySQLFSM) int{
 			return util.StateComQuery2
 		})
 	defer patches3.Reset()
 
-	patches4 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Query",
+	patches4 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Query",
 		func  (_ *MySQLFSM) string{
 			return query
 		})
@@ -268,42 +293,43 @@ func TestStream_ParsePacket_EventQuery(t *testing.T){
 func TestStream_ParsePacket_EventStmtExecute(t *testing.T){
 	pkt := new(MySQLPacket)
 	h:=new(eventHandler)
-	h.fsm = new(MySQLFSM)
+	fsm := new(MySQLFSM)
+	h.dec = fsm
 	pkt.Time= time.Now()
 	stmt:=new(Stmt)
 	stmt.ID=10
 
-	patches := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Handle",
+	patches := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Handle",
 		func  (_ *MySQLFSM,pkt MySQLPacket) {
 			return
 		})
 	defer patches.Reset()
 
-	patches1 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Ready",
+	patches1 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Ready",
 		func  (_ *MySQLFSM) bool{
 			return true
 		})
 	defer patches1.Reset()
 
-	patches2 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Changed",
+	patches2 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Changed",
 		func  (_ *MySQLFSM) bool{
 			return true
 		})
 	defer patches2.Reset()
 
-	patches3 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "State",
+	patches3 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "State",
 		func  (_ *MySQLFSM) int{
 			return util.StateComStmtExecute2
 		})
 	defer patches3.Reset()
 
-	patches4 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Stmt",
+	patches4 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Stmt",
 		func  (_ *MySQLFSM) Stmt{
 			return *stmt
 		})
 	defer patches4.Reset()
 
-	patches5 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "StmtParams",
+	patches5 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "StmtParams",
 		func  (_ *MySQLFSM)  []interface{}{
 			return nil
 		})
@@ -314,7 +340,7 @@ func TestStream_ParsePacket_EventStmtExecute(t *testing.T){
 	ast := assert.New(t)
 
 	ast.Equal(e.Type ,util.EventStmtExecute)
-	ast.Equal(e.StmtID,uint64(stmt.ID))
+	ast.Equal(e.StmtID,strconv.FormatUint(uint64(stmt.ID),10))
 	ast.Nil(e.Params)
 
 }
@@ -323,37 +349,38 @@ func TestStream_ParsePacket_StateComStmtPrepare1(t *testing.T){
 	query:="select * from test.test"
 	pkt := new(MySQLPacket)
 	h:=new(eventHandler)
-	h.fsm = new(MySQLFSM)
+	fsm := new(MySQLFSM)
+	h.dec = fsm
 	pkt.Time= time.Now()
 	stmt:=new(Stmt)
 	stmt.ID=10
 	stmt.Query=query
 
-	patches := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Handle",
+	patches := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Handle",
 		func  (_ *MySQLFSM,pkt MySQLPacket) {
 			return
 		})
 	defer patches.Reset()
 
-	patches1 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Ready",
+	patches1 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Ready",
 		func  (_ *MySQLFSM) bool{
 			return true
 		})
 	defer patches1.Reset()
 
-	patches2 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Changed",
+	patches2 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Changed",
 		func  (_ *MySQLFSM) bool{
 			return true
 		})
 	defer patches2.Reset()
 
-	patches3 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "State",
+	patches3 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "State",
 		func  (_ *MySQLFSM) int{
 			return util.StateComStmtPrepare1
 		})
 	defer patches3.Reset()
 
-	patches4 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Stmt",
+	patches4 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Stmt",
 		func  (_ *MySQLFSM) Stmt{
 			return *stmt
 		})
@@ -364,7 +391,7 @@ func TestStream_ParsePacket_StateComStmtPrepare1(t *testing.T){
 	ast := assert.New(t)
 
 	ast.Equal(e.Type ,util.EventStmtPrepare)
-	ast.Equal(e.StmtID,uint64(stmt.ID))
+	ast.Equal(e.StmtID,strconv.FormatUint(uint64(stmt.ID),10))
 	ast.Equal(e.Query ,query)
 
 }
@@ -373,37 +400,38 @@ func TestStream_ParsePacket_StateComStmtClose(t *testing.T){
 
 	pkt := new(MySQLPacket)
 	h:=new(eventHandler)
-	h.fsm = new(MySQLFSM)
+	fsm := new(MySQLFSM)
+	h.dec = fsm
 	pkt.Time= time.Now()
 	stmt:=new(Stmt)
 	stmt.ID=10
 
 
-	patches := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Handle",
+	patches := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Handle",
 		func  (_ *MySQLFSM,pkt MySQLPacket) {
 			return
 		})
 	defer patches.Reset()
 
-	patches1 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Ready",
+	patches1 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Ready",
 		func  (_ *MySQLFSM) bool{
 			return true
 		})
 	defer patches1.Reset()
 
-	patches2 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Changed",
+	patches2 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Changed",
 		func  (_ *MySQLFSM) bool{
 			return true
 		})
 	defer patches2.Reset()
 
-	patches3 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "State",
+	patches3 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "State",
 		func  (_ *MySQLFSM) int{
 			return util.StateComStmtClose
 		})
 	defer patches3.Reset()
 
-	patches4 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Stmt",
+	patches4 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Stmt",
 		func  (_ *MySQLFSM) Stmt{
 			return *stmt
 		})
@@ -414,44 +442,45 @@ func TestStream_ParsePacket_StateComStmtClose(t *testing.T){
 	ast := assert.New(t)
 
 	ast.Equal(e.Type ,util.EventStmtClose)
-	ast.Equal(e.StmtID,uint64(stmt.ID))
+	ast.Equal(e.StmtID,strconv.FormatUint(uint64(stmt.ID),10))
 
 }
 
-func TestStream_ParsePacket_StateHandshake1(t *testing.T){
+func TestStream_ParsePacket_StateHandshake2(t *testing.T){
 
 	pkt := new(MySQLPacket)
 	h:=new(eventHandler)
-	h.fsm = new(MySQLFSM)
+	fsm := new(MySQLFSM)
+	h.dec = fsm
 	pkt.Time= time.Now()
 	db:="test"
 
 
-	patches := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Handle",
+	patches := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Handle",
 		func  (_ *MySQLFSM,pkt MySQLPacket) {
 			return
 		})
 	defer patches.Reset()
 
-	patches1 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Ready",
+	patches1 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Ready",
 		func  (_ *MySQLFSM) bool{
 			return true
 		})
 	defer patches1.Reset()
 
-	patches2 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Changed",
+	patches2 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Changed",
 		func  (_ *MySQLFSM) bool{
 			return true
 		})
 	defer patches2.Reset()
 
-	patches3 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "State",
+	patches3 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "State",
 		func  (_ *MySQLFSM) int{
-			return util.StateHandshake1
+			return util.StateHandshake2
 		})
 	defer patches3.Reset()
 
-	patches4 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Schema",
+	patches4 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Schema",
 		func  (_ *MySQLFSM) string{
 			return db
 		})
@@ -470,29 +499,30 @@ func TestStream_ParsePacket_StateComQuit(t *testing.T){
 
 	pkt := new(MySQLPacket)
 	h:=new(eventHandler)
-	h.fsm = new(MySQLFSM)
+	fsm := new(MySQLFSM)
+	h.dec = fsm
 	pkt.Time= time.Now()
 
 
-	patches := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Handle",
+	patches := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Handle",
 		func  (_ *MySQLFSM,pkt MySQLPacket) {
 			return
 		})
 	defer patches.Reset()
 
-	patches1 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Ready",
+	patches1 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Ready",
 		func  (_ *MySQLFSM) bool{
 			return true
 		})
 	defer patches1.Reset()
 
-	patches2 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Changed",
+	patches2 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Changed",
 		func  (_ *MySQLFSM) bool{
 			return true
 		})
 	defer patches2.Reset()
 
-	patches3 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "State",
+	patches3 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "State",
 		func  (_ *MySQLFSM) int{
 			return util.StateComQuit
 		})
@@ -512,30 +542,31 @@ func TestStream_ParsePacket_100(t *testing.T){
 
 	pkt := new(MySQLPacket)
 	h:=new(eventHandler)
-	h.fsm = new(MySQLFSM)
+	fsm := new(MySQLFSM)
+	h.dec = fsm
 	pkt.Time= time.Now()
 
 
 
-	patches := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Handle",
+	patches := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Handle",
 		func  (_ *MySQLFSM,pkt MySQLPacket) {
 			return
 		})
 	defer patches.Reset()
 
-	patches1 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Ready",
+	patches1 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Ready",
 		func  (_ *MySQLFSM) bool{
 			return true
 		})
 	defer patches1.Reset()
 
-	patches2 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Changed",
+	patches2 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Changed",
 		func  (_ *MySQLFSM) bool{
 			return true
 		})
 	defer patches2.Reset()
 
-	patches3 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "State",
+	patches3 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "State",
 		func  (_ *MySQLFSM) int{
 			return 100
 		})
@@ -554,24 +585,25 @@ func TestStream_ParsePacket_NotReady(t *testing.T){
 
 	pkt := new(MySQLPacket)
 	h:=new(eventHandler)
-	h.fsm = new(MySQLFSM)
+	fsm := new(MySQLFSM)
+	h.dec = fsm
 	pkt.Time= time.Now()
 
 
 
-	patches := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Handle",
+	patches := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Handle",
 		func  (_ *MySQLFSM,pkt MySQLPacket) {
 			return
 		})
 	defer patches.Reset()
 
-	patches1 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Ready",
+	patches1 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Ready",
 		func  (_ *MySQLFSM) bool{
 			return false
 		})
 	defer patches1.Reset()
 
-	patches2 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Changed",
+	patches2 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Changed",
 		func  (_ *MySQLFSM) bool{
 			return true
 		})
@@ -590,24 +622,25 @@ func TestStream_ParsePacket_NotChange(t *testing.T){
 
 	pkt := new(MySQLPacket)
 	h:=new(eventHandler)
-	h.fsm = new(MySQLFSM)
+	fsm := new(MySQLFSM)
+	h.dec = fsm
 	pkt.Time= time.Now()
 
 
 
-	patches := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Handle",
+	patches := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Handle",
 		func  (_ *MySQLFSM,pkt MySQLPacket) {
 			return
 		})
 	defer patches.Reset()
 
-	patches1 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Ready",
+	patches1 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Ready",
 		func  (_ *MySQLFSM) bool{
 			return true
 		})
 	defer patches1.Reset()
 
-	patches2 := gomonkey.ApplyMethod(reflect.TypeOf(h.fsm), "Changed",
+	patches2 := gomonkey.ApplyMethod(reflect.TypeOf(fsm), "Changed",
 		func  (_ *MySQLFSM) bool{
 			return false
 		})
@@ -633,19 +666,20 @@ func TestMysqlEvent_Reset(t *testing.T){
 	params  := []interface{}{"aaa","bbbb"}
 	event.Reset(params)
 	assert.New(t).Equal(event.Params,params)
-	assert.New(t).Equal(event.StmtID,uint64(0))
+	assert.New(t).Equal(event.StmtID,"")
 }
 
 func TestMySQLEvent_String(t *testing.T) {
 	ts := time.Now().Unix()
 	query:= "select * from t;"
-	stmtid:=uint64(10)
+	stmtid:="10"
 	params:=[]interface{}{"aaa","bbb"}
 	db:="test"
+	conn:=ConnID{}.HashStr()
 	type fields struct {
 		Time   int64
-		Type   uint64
-		StmtID uint64
+		Type   util.MysqlEventType
+		StmtID string
 		Params []interface{}
 		DB     string
 		Query  string
@@ -665,7 +699,7 @@ func TestMySQLEvent_String(t *testing.T) {
 				Query: query,
 				Time :ts,
 			},
-			want:fmt.Sprintf("execute {query:%q} @ %d", formatQuery(query), ts),
+			want:fmt.Sprintf("%s execute {query:%q} @ %d", conn, formatQuery(query), ts),
 		},
 		{
 			name : "EventStmtExecute",
@@ -675,7 +709,7 @@ func TestMySQLEvent_String(t *testing.T) {
 				StmtID: stmtid,
 				Params: params,
 			},
-			want:fmt.Sprintf("execute stmt {id:%d,params:%v} @%d", stmtid, params, ts),
+			want:fmt.Sprintf("%s execute stmt {id:%s,params:%v} @%d", conn, stmtid, params, ts),
 		},
 		{
 			name : "EventStmtPrepare",
@@ -685,7 +719,7 @@ func TestMySQLEvent_String(t *testing.T) {
 				StmtID: stmtid,
 				Query: query,
 			},
-			want:fmt.Sprintf("prepare stmt {id:%d,query:%q} @%d",stmtid, formatQuery(query), ts),
+			want:fmt.Sprintf("%s prepare stmt {id:%s,query:%q} @%d", conn, stmtid, formatQuery(query), ts),
 		},
 		{
 			name : "EventStmtClose",
@@ -695,7 +729,7 @@ func TestMySQLEvent_String(t *testing.T) {
 				StmtID: stmtid,
 				Query: query,
 			},
-			want:fmt.Sprintf("close stmt {id:%d} @%d", stmtid, ts),
+			want:fmt.Sprintf("%s close stmt {id:%s} @%d", conn, stmtid, ts),
 		},
 		{
 			name : "EventHandshake",
@@ -706,7 +740,7 @@ func TestMySQLEvent_String(t *testing.T) {
 				Query: query,
 				DB: db,
 			},
-			want:fmt.Sprintf("connect {db:%q} @%d",db,ts),
+			want:fmt.Sprintf("%s connect {username:%q,db:%q,plugin:%q} @%d", conn, "", db, "", ts),
 		},
 		{
 			name : "EventQuit",
@@ -717,18 +751,18 @@ func TestMySQLEvent_String(t *testing.T) {
 				Query: query,
 				DB: db,
 			},
-			want:fmt.Sprintf("quit @%d", ts),
+			want:fmt.Sprintf("%s quit @%d", conn, ts),
 		},
 		{
 			name : "UnknownEvent",
 			fields: fields{
-				Type:uint64(1000),
+				Type:util.MysqlEventType(1000),
 				Time :ts,
 				StmtID: stmtid,
 				Query: query,
 				DB: db,
 			},
-			want:fmt.Sprintf("unknown event {type:%v} @%d", uint64(1000), ts),
+			want:fmt.Sprintf("%s unknown event {type:%v} @%d", conn, util.MysqlEventType(1000), ts),
 		},
 	}
 		for _, tt := range tests {
@@ -818,9 +852,12 @@ func TestEvent_Accept(t *testing.T){
 func TestEvent_AsyncParsePacket (t *testing.T){
 	log := zap.L().Named("test")
 	h:=new(eventHandler)
-	h.fsm=NewMySQLFSM(log)
-	h.fsm.wg.Add(1)
-	close(h.fsm.c)
+	h.dec=NewMySQLFSM(log)
+	h.log=log
+	h.c=make(chan MySQLPacket)
+	h.wg=new(sync.WaitGroup)
+	h.wg.Add(1)
+	close(h.c)
 	h.AsyncParsePacket()
 }
 
@@ -828,6 +865,9 @@ func TestEvent_OnClose (t *testing.T){
 	log := zap.L().Named("test")
 	h:=new(eventHandler)
 	h.impl=&ForTest{}
-	h.fsm=NewMySQLFSM(log)
+	h.dec=NewMySQLFSM(log)
+	h.log=log
+	h.c=make(chan MySQLPacket)
+	h.wg=new(sync.WaitGroup)
 	h.OnClose()
 }
\ No newline at end of file
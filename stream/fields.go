@@ -204,3 +204,48 @@ func (mf *mysqlField) scanType() reflect.Type {
 		return scanTypeUnknown
 	}
 }
+
+//ColumnInfo is the column definition of a result set, it is saved for both
+//the captured result and the replayed result so that schema level differences
+//(type, precision, charset, renamed column) can be reported
+type ColumnInfo struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Length   uint32 `json:"length"`
+	Flags    uint16 `json:"flags"`
+	Decimals uint8  `json:"decimals"`
+	Charset  uint8  `json:"charset"`
+}
+
+//flags describing the column type itself, key flags depend on
+//the indexes of the table and are not part of the type
+const columnTypeFlags = uint16(flagNotNULL | flagUnsigned | flagZeroFill | flagBinary)
+
+func (ci ColumnInfo) TypeFlags() uint16 {
+	return ci.Flags & columnTypeFlags
+}
+
+//NewColumnInfo converts a raw column definition to ColumnInfo ,
+//it is used for column definitions read by go-sql-driver
+func NewColumnInfo(name string, tp byte, flags uint16, decimals byte, charset uint8, length uint32) ColumnInfo {
+	mf := mysqlField{
+		name:      name,
+		length:    length,
+		flags:     fieldFlag(flags),
+		fieldType: fieldType(tp),
+		decimals:  decimals,
+		charSet:   charset,
+	}
+	return mf.columnInfo()
+}
+
+func (mf *mysqlField) columnInfo() ColumnInfo {
+	return ColumnInfo{
+		Name:     mf.name,
+		Type:     mf.typeDatabaseName(),
+		Length:   mf.length,
+		Flags:    uint16(mf.flags),
+		Decimals: mf.decimals,
+		Charset:  mf.charSet,
+	}
+}
@@ -547,4 +547,21 @@ func Test_mysqlField_scanType(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestNewColumnInfo(t *testing.T) {
+	ci := NewColumnInfo("id", byte(fieldTypeLongLong), uint16(flagNotNULL|flagUnsigned|flagPriKey), 0, 63, 20)
+	want := ColumnInfo{
+		Name:     "id",
+		Type:     "UNSIGNED BIGINT",
+		Length:   20,
+		Flags:    uint16(flagNotNULL | flagUnsigned | flagPriKey),
+		Decimals: 0,
+		Charset:  63,
+	}
+	if !reflect.DeepEqual(ci, want) {
+		t.Errorf("NewColumnInfo() = %v, want %v", ci, want)
+	}
+	if got := ci.TypeFlags(); got != uint16(flagNotNULL|flagUnsigned) {
+		t.Errorf("TypeFlags() = %#x", got)
+	}
+}
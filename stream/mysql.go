@@ -9,11 +9,10 @@ import (
 	"io"
 	"math"
 	"strconv"
-	"sync"
+	"strings"
 	"time"
 
 	"github.com/bobguo/mysql-replay/util"
-	"github.com/go-sql-driver/mysql"
 	"github.com/google/gopacket/reassembly"
 	"github.com/pingcap/errors"
 	"go.uber.org/zap"
@@ -51,6 +50,10 @@ func StateName(state int) string {
 		return "Handshake1"
 	case util.StateSkipPacket:
 		return "StateSkipPacket"
+	case util.StateHandshake2:
+		return "Handshake2"
+	case util.StateHandshakeFail:
+		return "HandshakeFail"
 	default:
 		return "Invalid"
 	}
@@ -73,34 +76,47 @@ func NewMySQLFSM(log *zap.Logger) *MySQLFSM {
 		stmts:   map[uint32]Stmt{},
 		params:  []interface{}{},
 		packets: []MySQLPacket{},
-		once:    new(sync.Once),
-		c:       make(chan MySQLPacket, 10000),
-		wg:      new(sync.WaitGroup),
 	}
 }
 
 //use for save result from replay server
 type ReplayRes struct {
-	ErrNO   uint16
-	ErrDesc string
+	ErrNO       uint16
+	ErrDesc     string
+	SQLState    string
+	ErrCategory util.ErrCategory
 	//AffectedRows uint64
 	//InsertId     uint64
-	SqlStatment  string
-	Values       []interface{}
-	SqlBeginTime uint64
-	SqlEndTime   uint64
+	SqlStatment string
+	//the statement before rewriting , empty if it is not rewritten
+	OrigSqlStatment string
+	Values          []interface{}
+	SqlBeginTime    uint64
+	SqlEndTime      uint64
 	//	SqlExecTime  int64
 	ColumnNum int
 	ColNames  []string
+	Columns   []ColumnInfo
 	ColValues [][]driver.Value
+	//reason if the statement is not replayed
+	Skipped string
+	//times the event is applied by the retry policy , 0 if it is not applied
+	Attempts int
+	//index of the clone of --scale
+	Clone int
+	//name of the replay server , empty for the first one
+	Target string
+	//results of the other replay servers in the order of --dsn
+	Targets []*ReplayRes
 }
 
 func (rr ReplayRes) MarshalJSON() ([]byte, error) {
 	if rr.SqlStatment == "" {
 		return []byte("{}"), nil
 	}
-
-	return json.Marshal(rr)
+	//the alias has no MarshalJSON , marshal rr itself would recurse
+	type replayRes ReplayRes
+	return json.Marshal(replayRes(rr))
 }
 
 //use for save result from packet (pcap)
@@ -108,6 +124,8 @@ type PacketRes struct {
 	//save for query result
 	errNo        uint16
 	errDesc      string
+	sqlState     string
+	errCategory  util.ErrCategory
 	affectedRows uint64
 	insertId     uint64
 	status       statusFlag
@@ -119,43 +137,20 @@ type PacketRes struct {
 	bRows        *binaryRows
 	tRows        *textRows
 	readColEnd   bool
+	//result set decoded by a decoder other than MySQL
+	columns []ColumnInfo
+	values  [][]driver.Value
 	//Use to ignore the EOF package following the Columns message package
 	ifReadColEndEofPacket bool
 	//Indicates whether the result set is finished reading
 	ifReadResEnd bool
 }
 
-func ConvertResToStr(v [][]driver.Value) ([][]string, error) {
-	resSet := make([][]string, 0)
-	for a := range v {
-		rowStr := make([]string, 0)
-		for b := range v[a] {
-			var c string
-			if v[a][b] == nil {
-				//pay attention on the following logic
-				//If driver.Value is nil, we convert it to a string of length 0,
-				//but then we can't compare nil to a string of length 0
-				c = ""
-				rowStr = append(rowStr, c)
-				continue
-			}
-			err := ConvertAssignRows(v[a][b], &c)
-			if err != nil {
-				return nil, err
-			} else {
-				rowStr = append(rowStr, c)
-			}
-		}
-		resSet = append(resSet, rowStr)
-	}
-	return resSet, nil
-}
-
 func (pr *PacketRes) MarshalJSON() ([]byte, error) {
 	val := pr.GetColumnVal()
 	if val != nil {
 		results := []interface{}{}
-		prResult, err := ConvertResToStr(val)
+		prResult, err := ConvertRows(val, pr.GetColumns())
 		if err != nil {
 			return nil, err
 		}
@@ -166,7 +161,7 @@ func (pr *PacketRes) MarshalJSON() ([]byte, error) {
 				results = append(results, res)
 				continue
 			}
-			resMap := make(map[string]string)
+			resMap := make(map[string]Value)
 			for i, name := range names {
 				resMap[name] = res[i]
 			}
@@ -180,6 +175,71 @@ func (pr *PacketRes) MarshalJSON() ([]byte, error) {
 	return []byte("[]"), nil
 }
 
+//PacketResRecord is the serialisable form of PacketRes , it is kept in
+//the event log so the captured result can be compared without packets
+type PacketResRecord struct {
+	ErrNo        uint16           `json:"errno,omitempty"`
+	ErrDesc      string           `json:"errdesc,omitempty"`
+	SQLState     string           `json:"sqlstate,omitempty"`
+	ErrCategory  util.ErrCategory `json:"category,omitempty"`
+	AffectedRows uint64           `json:"affected,omitempty"`
+	InsertID     uint64           `json:"insertid,omitempty"`
+	BeginTime    uint64           `json:"begin"`
+	EndTime      uint64           `json:"end"`
+	Columns      []ColumnInfo     `json:"columns,omitempty"`
+	//nil if the statement returns no result set
+	Rows [][]Value `json:"rows"`
+}
+
+func (pr *PacketRes) Record() (*PacketResRecord, error) {
+	r := &PacketResRecord{
+		ErrNo:        pr.errNo,
+		ErrDesc:      pr.errDesc,
+		SQLState:     pr.sqlState,
+		ErrCategory:  pr.errCategory,
+		AffectedRows: pr.affectedRows,
+		InsertID:     pr.insertId,
+		BeginTime:    pr.sqlBeginTime,
+		EndTime:      pr.sqlEndTime,
+		Columns:      pr.GetColumns(),
+	}
+	if val := pr.GetColumnVal(); val != nil {
+		var err error
+		r.Rows, err = ConvertRows(val, r.Columns)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+//NewPacketResFromRecord restores a PacketRes read from the event log ,
+//the values of rows are kept as Value
+func NewPacketResFromRecord(r *PacketResRecord) *PacketRes {
+	pr := &PacketRes{
+		errNo:        r.ErrNo,
+		errDesc:      r.ErrDesc,
+		sqlState:     r.SQLState,
+		errCategory:  r.ErrCategory,
+		affectedRows: r.AffectedRows,
+		insertId:     r.InsertID,
+		sqlBeginTime: r.BeginTime,
+		sqlEndTime:   r.EndTime,
+		columns:      r.Columns,
+	}
+	if r.Rows != nil {
+		pr.values = make([][]driver.Value, 0, len(r.Rows))
+		for _, row := range r.Rows {
+			values := make([]driver.Value, 0, len(row))
+			for _, v := range row {
+				values = append(values, v)
+			}
+			pr.values = append(pr.values, values)
+		}
+	}
+	return pr
+}
+
 func (pr *PacketRes) GetSqlBeginTime() uint64 {
 	return pr.sqlBeginTime
 }
@@ -188,6 +248,10 @@ func (pr *PacketRes) GetSqlEndTime() uint64 {
 	return pr.sqlEndTime
 }
 
+func (pr *PacketRes) GetAffectedRows() uint64 {
+	return pr.affectedRows
+}
+
 func (pr *PacketRes) GetErrNo() uint16 {
 	return pr.errNo
 }
@@ -195,13 +259,27 @@ func (pr *PacketRes) GetErrDesc() string {
 	return pr.errDesc
 }
 
+func (pr *PacketRes) GetSQLState() string {
+	return pr.sqlState
+}
+
+func (pr *PacketRes) GetErrCategory() util.ErrCategory {
+	return pr.errCategory
+}
+
+//HasError reports whether the server returned an error , the error of
+//a protocol without numeric error code only has sqlstate and category
+func (pr *PacketRes) HasError() bool {
+	return pr.errNo != 0 || pr.errCategory != util.ErrCategoryNone
+}
+
 func (pr *PacketRes) GetColumnVal() [][]driver.Value {
 	if pr.bRows != nil {
 		return pr.bRows.rs.columnValue
 	} else if pr.tRows != nil {
 		return pr.tRows.rs.columnValue
 	}
-	return nil
+	return pr.values
 }
 
 func (pr *PacketRes) GetColumnNames() []string {
@@ -213,7 +291,14 @@ func (pr *PacketRes) GetColumnNames() []string {
 	}
 
 	if columns == nil {
-		return nil
+		if pr.columns == nil {
+			return nil
+		}
+		columnNames := make([]string, 0, len(pr.columns))
+		for _, column := range pr.columns {
+			columnNames = append(columnNames, column.Name)
+		}
+		return columnNames
 	}
 
 	var columnNames []string
@@ -224,26 +309,46 @@ func (pr *PacketRes) GetColumnNames() []string {
 	return columnNames
 }
 
+//get column definitions of the result set read from packet
+func (pr *PacketRes) GetColumns() []ColumnInfo {
+	var columns []mysqlField
+	if pr.bRows != nil {
+		columns = pr.bRows.rs.columns
+	} else if pr.tRows != nil {
+		columns = pr.tRows.rs.columns
+	}
+
+	if columns == nil {
+		return pr.columns
+	}
+
+	infos := make([]ColumnInfo, 0, len(columns))
+	for i := range columns {
+		infos = append(infos, columns[i].columnInfo())
+	}
+	return infos
+}
+
 //Store network packet, parse SQL statement and result packet
 type MySQLFSM struct {
 	log *zap.Logger
 
-	//Used to parse message packets asynchronously
-	once *sync.Once
-	c    chan MySQLPacket
-	wg   *sync.WaitGroup
-
 	// state info
 	changed bool
 	state   int
-	query   string        // com_query
-	stmt    Stmt          // com_stmt_prepare,com_stmt_execute,com_stmt_close
-	params  []interface{} // com_stmt_execute
+	reason  string // why the last packet is skipped
+	//sequence numbers of the packet skipped as out of sequence , the
+	//reason is built only when it is asked
+	badSeq, wantSeq int
+	query           string        // com_query
+	stmt            Stmt          // com_stmt_prepare,com_stmt_execute,com_stmt_close
+	params          []interface{} // com_stmt_execute
 
 	// session info
-	schema   string          // handshake1
-	username string          // handshake1
-	stmts    map[uint32]Stmt // com_stmt_prepare,com_stmt_execute,com_stmt_close
+	schema     string          // handshake1
+	username   string          // handshake1
+	authPlugin string          // handshake0,handshake1,auth switch request
+	stmts      map[uint32]Stmt // com_stmt_prepare,com_stmt_execute,com_stmt_close
 
 	// current command
 	data    *bytes.Buffer
@@ -255,6 +360,20 @@ type MySQLFSM struct {
 
 func (fsm *MySQLFSM) State() int { return fsm.state }
 
+func (fsm *MySQLFSM) StateName() string { return StateName(fsm.state) }
+
+//Unparsed tells if the last packet is skipped , a command is skipped
+//until the next command begins
+func (fsm *MySQLFSM) Unparsed() (bool, string) {
+	if fsm.state != util.StateUnknown && fsm.state != util.StateSkipPacket {
+		return false, ""
+	}
+	if len(fsm.reason) == 0 && fsm.badSeq != fsm.wantSeq {
+		return true, fmt.Sprintf("unexpected seq %d , expect %d", fsm.badSeq, fsm.wantSeq)
+	}
+	return true, fsm.reason
+}
+
 func (fsm *MySQLFSM) Query() string { return fsm.query }
 
 func (fsm *MySQLFSM) Stmt() Stmt { return fsm.stmt }
@@ -273,6 +392,8 @@ func (fsm *MySQLFSM) Schema() string { return fsm.schema }
 
 func (fsm *MySQLFSM) Username() string { return fsm.username }
 
+func (fsm *MySQLFSM) AuthPlugin() string { return fsm.authPlugin }
+
 func (fsm *MySQLFSM) Changed() bool { return fsm.changed }
 
 func (fsm *MySQLFSM) Ready() bool {
@@ -317,6 +438,8 @@ func (fsm *MySQLFSM) Handle(pkt MySQLPacket) {
 		fsm.packets = append(fsm.packets, pkt)
 	} else {
 		stateChgBefore := StateName(fsm.State())
+		fsm.reason = ""
+		fsm.badSeq, fsm.wantSeq = pkt.Seq, fsm.nextSeq()
 		fsm.setStatusWithNoChange(util.StateSkipPacket)
 		//fsm.setStatusWithNoChange(StateInit)
 		stateChgAfter := StateName(fsm.State())
@@ -336,6 +459,13 @@ func (fsm *MySQLFSM) Handle(pkt MySQLPacket) {
 		fsm.handleComStmtPrepareResponse()
 	} else if fsm.state == util.StateHandshake0 {
 		fsm.handleHandshakeResponse()
+	} else if fsm.state == util.StateHandshake1 {
+		//auth switch, caching_sha2_password fast/full auth and
+		//public key exchange until the server sends OK or ERR
+		fsm.handleAuthPacket()
+		if fsm.state == util.StateHandshake2 || fsm.state == util.StateHandshakeFail {
+			fsm.pr.sqlEndTime = uint64(pkt.Time.UnixNano())
+		}
 	} else if fsm.state == util.StateComQuery || fsm.state == util.StateComQuery1 {
 		if fsm.state == util.StateComQuery {
 			fsm.setStatusWithNoChange(util.StateComQuery1)
@@ -391,6 +521,20 @@ func (fsm *MySQLFSM) Packets() []MySQLPacket {
 	return fsm.packets[fsm.start : fsm.start+fsm.count]
 }
 
+//load the last complete packet, large packets are
+//split into several packets of maxPacketSize
+func (fsm *MySQLFSM) loadLast() bool {
+	n := len(fsm.packets)
+	if n == 0 {
+		return false
+	}
+	k := n - 1
+	for k > 0 && fsm.packets[k-1].Len == maxPacketSize {
+		k--
+	}
+	return fsm.load(k)
+}
+
 func (fsm *MySQLFSM) nextSeq() int {
 	n := len(fsm.packets)
 	if n == 0 {
@@ -438,6 +582,10 @@ func (fsm *MySQLFSM) set(to int, msg ...string) {
 	from := fsm.state
 	fsm.state = to
 	fsm.changed = from != to
+	fsm.badSeq, fsm.wantSeq = 0, 0
+	if len(msg) > 0 {
+		fsm.reason = msg[0]
+	}
 	if !fsm.changed || fsm.log == nil || !fsm.log.Core().Enabled(zap.DebugLevel) {
 		return
 	}
@@ -460,8 +608,8 @@ func (fsm *MySQLFSM) set(to int, msg ...string) {
 		tmpl += fmt.Sprintf("{query:%q,id:%d,num-params:%d}", query, fsm.stmt.ID, fsm.stmt.NumParams)
 	case util.StateComStmtClose:
 		tmpl += fmt.Sprintf("{query:%q,id:%d,num-params:%d}", query, fsm.stmt.ID, fsm.stmt.NumParams)
-	case util.StateHandshake1:
-		tmpl += fmt.Sprintf("{schema:%q}", fsm.schema)
+	case util.StateHandshake1, util.StateHandshake2, util.StateHandshakeFail:
+		tmpl += fmt.Sprintf("{schema:%q,plugin:%q}", fsm.schema, fsm.authPlugin)
 	case util.StateInit:
 		return
 	}
@@ -524,10 +672,12 @@ func (fsm *MySQLFSM) handleInitPacket() {
 		fsm.handleComStmtPrepareRequestNoLoad()
 	} else if fsm.isClientCommand(comStmtClose) {
 		fsm.handleComStmtCloseNoLoad()
+	} else if fsm.isClientCommand(comInitDB) {
+		fsm.handleComInitDBNoLoad()
 	} else if fsm.isClientCommand(comQuit) {
 		fsm.set(util.StateComQuit)
 	} else if fsm.isHandshakeRequest() {
-		fsm.set(util.StateHandshake0)
+		fsm.handleHandshakeRequestNoLoad()
 	} else {
 		if fsm.assertDir(reassembly.TCPDirClientToServer) && fsm.data.Len() > 0 {
 			fsm.set(util.StateUnknown, fmt.Sprintf("init: skip client command(0x%02x)", fsm.data.Bytes()[0]))
@@ -544,6 +694,15 @@ func (fsm *MySQLFSM) handleComQueryNoLoad() {
 	fsm.set(util.StateComQuery)
 }
 
+//COM_INIT_DB changes the database like USE , it is decoded as a USE
+//statement as database/sql can not send COM_INIT_DB , the response is
+//read like the response of COM_QUERY
+func (fsm *MySQLFSM) handleComInitDBNoLoad() {
+	db := string(fsm.data.Bytes()[1:])
+	fsm.query = "use `" + strings.Replace(db, "`", "``", -1) + "`"
+	fsm.set(util.StateComQuery)
+}
+
 func (fsm *MySQLFSM) handleComStmtExecuteNoLoad() {
 	var (
 		ok     bool
@@ -740,6 +899,72 @@ func (fsm *MySQLFSM) handleComStmtPrepareResponse() {
 	fsm.set(util.StateComStmtPrepare1)
 }
 
+//Initial Handshake Packet
+//https://dev.mysql.com/doc/internals/en/connection-phase-packets.html#packet-Protocol::Handshake
+func (fsm *MySQLFSM) handleHandshakeRequestNoLoad() {
+	//the server default auth plugin is used unless the
+	//client asks for another one in the handshake response
+	fsm.authPlugin = defaultAuthPlugin
+	fsm.set(util.StateHandshake0)
+
+	var (
+		flags clientFlag
+		bs    []byte
+		ok    bool
+	)
+	data := fsm.data.Bytes()
+	if data[0] == handshakeV9 {
+		return
+	}
+	// protocol version [1 byte] , server version [NUL string]
+	if _, data, ok = readBytesNUL(data[1:]); !ok {
+		fsm.log.Debug("handshake: cannot read server version")
+		return
+	}
+	// connection id [4 bytes] , auth-plugin-data-part-1 [8 bytes] , filler [1 byte]
+	if _, data, ok = readBytesN(data, 13); !ok {
+		fsm.log.Debug("handshake: cannot read auth-plugin-data-part-1")
+		return
+	}
+	if bs, data, ok = readBytesN(data, 2); !ok {
+		fsm.log.Debug("handshake: cannot read capability flags")
+		return
+	}
+	flags |= clientFlag(bs[0])
+	flags |= clientFlag(bs[1]) << 8
+	// character set [1 byte] , status flags [2 bytes]
+	if _, data, ok = readBytesN(data, 3); !ok {
+		return
+	}
+	if bs, data, ok = readBytesN(data, 2); !ok {
+		return
+	}
+	flags |= clientFlag(bs[0]) << 16
+	flags |= clientFlag(bs[1]) << 24
+	// length of auth-plugin-data [1 byte] , reserved [10 bytes]
+	if bs, data, ok = readBytesN(data, 11); !ok {
+		return
+	}
+	if flags&clientSecureConn > 0 {
+		n := int(bs[0]) - 8
+		if n < 13 {
+			n = 13
+		}
+		if _, data, ok = readBytesN(data, n); !ok {
+			fsm.log.Debug("handshake: cannot read auth-plugin-data-part-2")
+			return
+		}
+	}
+	if flags&clientPluginAuth > 0 && len(data) > 0 {
+		//some servers do not terminate the plugin name with NUL
+		plugin, _, ok := readBytesNUL(data)
+		if !ok {
+			plugin = data
+		}
+		fsm.authPlugin = string(plugin)
+	}
+}
+
 func (fsm *MySQLFSM) handleHandshakeResponse() {
 	//handle handshake response
 
@@ -787,6 +1012,12 @@ func (fsm *MySQLFSM) handleHandshakeResponse() {
 			fsm.set(util.StateUnknown, "handshake: cannot read max-packet size, character set and reserved")
 			return
 		}
+		if flags&clientSSL > 0 && len(data) == 0 {
+			//SSLRequest packet, the rest of the connection is encrypted
+			fsm.set(util.StateUnknown, "handshake: ssl connection is not supported")
+			fsm.log.Warn("ssl connection is not supported , skip it")
+			return
+		}
 		var username []byte
 		if username, data, ok = readBytesNUL(data); !ok {
 			fsm.set(util.StateUnknown, "handshake: cannot read username")
@@ -827,6 +1058,16 @@ func (fsm *MySQLFSM) handleHandshakeResponse() {
 			}
 			fsm.schema = string(db)
 		}
+		if flags&clientPluginAuth > 0 && len(data) > 0 {
+			var plugin []byte
+			if plugin, data, ok = readBytesNUL(data); !ok {
+				fsm.set(util.StateUnknown, "handshake: cannot read auth plugin name")
+				return
+			}
+			if len(plugin) > 0 {
+				fsm.authPlugin = string(plugin)
+			}
+		}
 	} else {
 		if _, data, ok = readBytesN(data, 3); !ok {
 			fsm.set(util.StateUnknown, "handshake: cannot read max-packet size")
@@ -854,6 +1095,63 @@ func (fsm *MySQLFSM) handleHandshakeResponse() {
 	fsm.set(util.StateHandshake1)
 }
 
+//Handle the packets following the handshake response. The login is only
+//finished when the server sends OK or ERR, before that the server may ask
+//to switch the auth plugin (0xfe) or send more auth data (0x01), e.g.
+//caching_sha2_password fast auth result or the RSA public key.
+//https://dev.mysql.com/doc/internals/en/authentication-method-mismatch.html
+func (fsm *MySQLFSM) handleAuthPacket() {
+	if !fsm.loadLast() {
+		fsm.set(util.StateUnknown, "auth: cannot load packet")
+		fsm.log.Warn("parse auth packet fail , can not load packet " +
+			fmt.Sprintf("%v", len(fsm.packets)))
+		return
+	}
+	if fsm.assertDir(reassembly.TCPDirClientToServer) {
+		//scrambled password, public key request or encrypted password
+		fsm.log.Debug("auth: client send auth data with plugin " + fsm.authPlugin)
+		return
+	}
+	data := fsm.data.Bytes()
+	if len(data) == 0 {
+		fsm.set(util.StateUnknown, "auth: empty packet")
+		return
+	}
+	switch data[0] {
+	case iOK:
+		fsm.set(util.StateHandshake2)
+	case iERR:
+		err := fsm.handleErrorPacket(data)
+		fsm.setPacketResError(err)
+		fsm.log.Info("login fail on server , " + err.Error())
+		fsm.set(util.StateHandshakeFail)
+	case iEOF:
+		//AuthSwitchRequest , a single 0xfe byte is the
+		//old AuthSwitchRequest for mysql_old_password
+		if len(data) == 1 {
+			fsm.authPlugin = "mysql_old_password"
+		} else {
+			plugin, _, ok := readBytesNUL(data[1:])
+			if !ok {
+				plugin = data[1:]
+			}
+			fsm.authPlugin = string(plugin)
+		}
+		fsm.log.Debug("auth: server switch auth plugin to " + fsm.authPlugin)
+	case iAuthMoreData:
+		if len(data) == 2 && data[1] == cachingSha2PasswordFastAuthSuccess {
+			fsm.log.Debug("auth: caching_sha2_password fast auth success")
+		} else if len(data) == 2 && data[1] == cachingSha2PasswordPerformFullAuthentication {
+			fsm.log.Debug("auth: caching_sha2_password perform full authentication")
+		} else {
+			fsm.log.Debug("auth: server send more auth data with plugin " + fsm.authPlugin)
+		}
+	default:
+		fsm.set(util.StateUnknown, fmt.Sprintf("auth: unexpected packet(0x%02x)", data[0]))
+		fsm.log.Warn("unexpected auth packet :" + strconv.Itoa(int(data[0])))
+	}
+}
+
 func parseExecParams(stmt Stmt, nullBitmap []byte, paramTypes []byte, paramValues []byte) (params []interface{}, err error) {
 	//parse  prepare params
 
@@ -1199,14 +1497,7 @@ func (fsm *MySQLFSM) handleReadSQLResult() error { //ColumnNum() error {
 				fmt.Sprintf("%d", fsm.pr.packetnum) +
 				fmt.Sprintf("%d", len(fsm.packets)))
 			fsm.pr.ifReadResEnd = true
-			if mysqlError, ok := err.(*MySQLError); ok {
-				fsm.pr.errNo = mysqlError.Number
-				fsm.pr.errDesc = mysqlError.Message
-			} else {
-				fsm.log.Warn("chang to MySQLError fail ," + err.Error())
-				fsm.pr.errNo = 20000
-				fsm.pr.errDesc = "exec sql fail and coverted to mysql errorstruct err"
-			}
+			fsm.setPacketResError(err)
 			return err
 		}
 		if fsm.pr.columnNum == 0 {
@@ -1267,6 +1558,7 @@ func (fsm *MySQLFSM) handleReadSQLResult() error { //ColumnNum() error {
 					return nil
 				} else if err != nil {
 					fsm.log.Warn("resd rows from packet error" + err.Error())
+					fsm.setPacketResError(err)
 					return err
 				}
 			}
@@ -1287,15 +1579,7 @@ func (fsm *MySQLFSM) handleReadPrepareExecResult() error {
 				fmt.Sprintf("%d", fsm.pr.packetnum) +
 				fmt.Sprintf("%d", len(fsm.packets)))
 			fsm.pr.ifReadResEnd = true
-			if mysqlError, ok := err.(*mysql.MySQLError); ok {
-				//fmt.Println("change to mysql.MySQLError success",err)
-				fsm.pr.errNo = mysqlError.Number
-				fsm.pr.errDesc = mysqlError.Message
-			} else {
-				fsm.log.Warn("chang to MySQLError fail ," + err.Error())
-				fsm.pr.errNo = 20000
-				fsm.pr.errDesc = "exec sql fail and coverted to mysql errorstruct err"
-			}
+			fsm.setPacketResError(err)
 			return err
 		}
 		if fsm.pr.columnNum == 0 {
@@ -1366,6 +1650,7 @@ func (fsm *MySQLFSM) handleReadPrepareExecResult() error {
 					logstr := fmt.Sprintf("read %v row error ,the packet is %v  ", len(rows.rs.columnValue), data)
 					fsm.log.Warn(logstr)
 					rows.rs.done = true
+					fsm.setPacketResError(err)
 					return err
 				}
 			}
@@ -1439,7 +1724,7 @@ func (fsm *MySQLFSM) handleOkPacket(data []byte) error {
 // Error Packet
 // http://dev.mysql.com/doc/internals/en/generic-response-packets.html#packet-ERR_Packet
 func (fsm *MySQLFSM) handleErrorPacket(data []byte) error {
-	if data[0] != iERR {
+	if len(data) < 3 || data[0] != iERR {
 		return ErrMalformPkt
 	}
 
@@ -1449,18 +1734,46 @@ func (fsm *MySQLFSM) handleErrorPacket(data []byte) error {
 	errno := binary.LittleEndian.Uint16(data[1:3])
 
 	pos := 3
+	sqlState := ""
 
 	// SQL State [optional: # + 5bytes string]
-	if data[3] == 0x23 {
-		//sqlstate := string(data[4 : 4+5])
+	if len(data) >= 9 && data[3] == 0x23 {
+		sqlState = string(data[4 : 4+5])
 		pos = 9
 	}
 
 	// Error Message [string]
 	return &MySQLError{
-		Number:  errno,
-		Message: string(data[pos:]),
+		Number:   errno,
+		SQLState: sqlState,
+		Message:  string(data[pos:]),
+	}
+}
+
+//error returned by server
+func (pr *PacketRes) setError(err *MySQLError) {
+	pr.errNo = err.Number
+	pr.sqlState = err.SQLState
+	pr.errDesc = err.Message
+	pr.errCategory = util.ErrCategoryServer
+}
+
+//Save the error of current command into PacketRes. Error packets from the
+//server keep errno and SQLSTATE , other errors mean that we failed to
+//decode the response from pcap
+func (fsm *MySQLFSM) setPacketResError(err error) {
+	if fsm.pr == nil || err == nil {
+		return
+	}
+	if mysqlError, ok := err.(*MySQLError); ok {
+		fsm.pr.setError(mysqlError)
+		return
 	}
+	fsm.log.Warn("decode response packet fail ," + err.Error())
+	fsm.pr.errNo = 0
+	fsm.pr.sqlState = ""
+	fsm.pr.errDesc = err.Error()
+	fsm.pr.errCategory = util.ErrCategoryParse
 }
 
 // Read Packets as Field Packets until EOF-Packet or an Error appears
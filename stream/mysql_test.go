@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"database/sql/driver"
 	"encoding/binary"
+	"encoding/json"
 	"github.com/agiledragon/gomonkey"
 	"github.com/bobguo/mysql-replay/util"
+	"github.com/google/gopacket/reassembly"
 
 	//"github.com/gobwas/glob/syntax/ast"
 	"testing"
@@ -1226,3 +1228,188 @@ func TestFSM_isClientCommand_server(t *testing.T){
 
 
  */
+
+func newAuthTestPacket(seq int, dir reassembly.TCPFlowDirection, data []byte) MySQLPacket {
+	return MySQLPacket{
+		Time: time.Now(),
+		Dir:  dir,
+		Len:  len(data),
+		Seq:  seq,
+		Data: data,
+	}
+}
+
+//build a protocol v10 initial handshake packet with the given auth plugin
+func newHandshakeRequest(plugin string) []byte {
+	flags := clientProtocol41 | clientSecureConn | clientPluginAuth
+	data := []byte{handshakeV10}
+	data = append(data, []byte("8.0.27")...)
+	data = append(data, 0)
+	data = append(data, 1, 0, 0, 0)
+	data = append(data, []byte("12345678")...)
+	data = append(data, 0)
+	data = append(data, byte(flags), byte(flags>>8))
+	data = append(data, 0xff, 2, 0)
+	data = append(data, byte(flags>>16), byte(flags>>24))
+	data = append(data, 21)
+	data = append(data, make([]byte, 10)...)
+	data = append(data, []byte("123456789012")...)
+	data = append(data, 0)
+	data = append(data, []byte(plugin)...)
+	data = append(data, 0)
+	return data
+}
+
+//build a protocol 41 handshake response packet with the given auth plugin
+func newHandshakeResponse(user, db, plugin string) []byte {
+	flags := clientProtocol41 | clientSecureConn | clientPluginAuth | clientConnectWithDB
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, uint32(flags))
+	data = append(data, make([]byte, 28)...)
+	data = append(data, []byte(user)...)
+	data = append(data, 0)
+	data = append(data, 20)
+	data = append(data, make([]byte, 20)...)
+	data = append(data, []byte(db)...)
+	data = append(data, 0)
+	data = append(data, []byte(plugin)...)
+	data = append(data, 0)
+	return data
+}
+
+func TestFSM_Handle_Auth_CachingSha2FullAuth(t *testing.T) {
+	fsm := NewMySQLFSM(logger)
+	ast := assert.New(t)
+
+	fsm.Handle(newAuthTestPacket(0, reassembly.TCPDirServerToClient, newHandshakeRequest("caching_sha2_password")))
+	ast.Equal(util.StateHandshake0, fsm.State())
+	ast.Equal("caching_sha2_password", fsm.AuthPlugin())
+
+	fsm.Handle(newAuthTestPacket(1, reassembly.TCPDirClientToServer, newHandshakeResponse("root", "test", "caching_sha2_password")))
+	ast.Equal(util.StateHandshake1, fsm.State())
+	ast.Equal("root", fsm.Username())
+	ast.Equal("test", fsm.Schema())
+
+	//perform full authentication , request public key and send encrypted password
+	fsm.Handle(newAuthTestPacket(2, reassembly.TCPDirServerToClient, []byte{iAuthMoreData, cachingSha2PasswordPerformFullAuthentication}))
+	ast.Equal(util.StateHandshake1, fsm.State())
+	fsm.Handle(newAuthTestPacket(3, reassembly.TCPDirClientToServer, []byte{cachingSha2PasswordRequestPublicKey}))
+	ast.Equal(util.StateHandshake1, fsm.State())
+	fsm.Handle(newAuthTestPacket(4, reassembly.TCPDirServerToClient, append([]byte{iAuthMoreData}, []byte("-----BEGIN PUBLIC KEY-----")...)))
+	ast.Equal(util.StateHandshake1, fsm.State())
+	fsm.Handle(newAuthTestPacket(5, reassembly.TCPDirClientToServer, make([]byte, 256)))
+	ast.Equal(util.StateHandshake1, fsm.State())
+	ast.False(fsm.Changed())
+
+	fsm.Handle(newAuthTestPacket(6, reassembly.TCPDirServerToClient, []byte{iOK, 0, 0, 2, 0, 0, 0}))
+	ast.Equal(util.StateHandshake2, fsm.State())
+	ast.True(fsm.Changed())
+	ast.Equal(uint16(0), fsm.pr.GetErrNo())
+}
+
+func TestFSM_Handle_Auth_SwitchAndFail(t *testing.T) {
+	fsm := NewMySQLFSM(logger)
+	ast := assert.New(t)
+
+	fsm.Handle(newAuthTestPacket(0, reassembly.TCPDirServerToClient, newHandshakeRequest("caching_sha2_password")))
+	fsm.Handle(newAuthTestPacket(1, reassembly.TCPDirClientToServer, newHandshakeResponse("root", "test", "caching_sha2_password")))
+
+	switchReq := []byte{iEOF}
+	switchReq = append(switchReq, []byte("mysql_native_password")...)
+	switchReq = append(switchReq, 0)
+	switchReq = append(switchReq, []byte("12345678901234567890")...)
+	fsm.Handle(newAuthTestPacket(2, reassembly.TCPDirServerToClient, switchReq))
+	ast.Equal(util.StateHandshake1, fsm.State())
+	ast.Equal("mysql_native_password", fsm.AuthPlugin())
+
+	fsm.Handle(newAuthTestPacket(3, reassembly.TCPDirClientToServer, make([]byte, 20)))
+	errPkt := []byte{iERR, 0x15, 0x04, '#'}
+	errPkt = append(errPkt, []byte("28000Access denied for user 'root'@'localhost'")...)
+	fsm.Handle(newAuthTestPacket(4, reassembly.TCPDirServerToClient, errPkt))
+	ast.Equal(util.StateHandshakeFail, fsm.State())
+	ast.True(fsm.Changed())
+	ast.Equal(uint16(1045), fsm.pr.GetErrNo())
+	ast.Equal("Access denied for user 'root'@'localhost'", fsm.pr.GetErrDesc())
+}
+
+func TestFSM_handleErrorPacket_SQLState(t *testing.T) {
+	fsm := NewMySQLFSM(logger)
+	fsm.InitValue()
+	data := []byte{iERR, 0x7a, 0x04, '#'}
+	data = append(data, []byte("42S02Table 'test.t' doesn't exist")...)
+	err := fsm.handleErrorPacket(data)
+	fsm.setPacketResError(err)
+
+	ast := assert.New(t)
+	ast.Equal(uint16(1146), fsm.pr.GetErrNo())
+	ast.Equal("42S02", fsm.pr.GetSQLState())
+	ast.Equal("Table 'test.t' doesn't exist", fsm.pr.GetErrDesc())
+	ast.Equal(util.ErrCategoryServer, fsm.pr.GetErrCategory())
+}
+
+func TestFSM_setPacketResError_Parse(t *testing.T) {
+	fsm := NewMySQLFSM(logger)
+	fsm.InitValue()
+	fsm.setPacketResError(ErrMalformPkt)
+
+	ast := assert.New(t)
+	ast.Equal(uint16(0), fsm.pr.GetErrNo())
+	ast.Equal("", fsm.pr.GetSQLState())
+	ast.Equal(ErrMalformPkt.Error(), fsm.pr.GetErrDesc())
+	ast.Equal(util.ErrCategoryParse, fsm.pr.GetErrCategory())
+}
+
+func TestReplayRes_MarshalJSON(t *testing.T) {
+	b, err := json.Marshal(ReplayRes{})
+	assert.Nil(t, err)
+	assert.Equal(t, "{}", string(b))
+
+	b, err = json.Marshal(&ReplayRes{SqlStatment: "select 1", ErrNO: 1062})
+	assert.Nil(t, err)
+	var res map[string]interface{}
+	assert.Nil(t, json.Unmarshal(b, &res))
+	assert.Equal(t, "select 1", res["SqlStatment"])
+	assert.Equal(t, float64(1062), res["ErrNO"])
+}
+
+func TestFSM_Unparsed(t *testing.T) {
+	fsm := NewMySQLFSM(logger)
+	ast := assert.New(t)
+
+	fsm.Handle(newAuthTestPacket(0, reassembly.TCPDirClientToServer, []byte{comQuery, 's'}))
+	ast.Equal("ComQuery", fsm.StateName())
+	unparsed, _ := fsm.Unparsed()
+	ast.False(unparsed)
+
+	//COM_PING is not decoded
+	fsm.Handle(newAuthTestPacket(0, reassembly.TCPDirClientToServer, []byte{0x0e}))
+	unparsed, reason := fsm.Unparsed()
+	ast.True(unparsed)
+	ast.Equal("init: skip client command(0x0e)", reason)
+
+	fsm.Handle(newAuthTestPacket(0, reassembly.TCPDirClientToServer, []byte{comQuery, 's'}))
+	fsm.Handle(newAuthTestPacket(3, reassembly.TCPDirServerToClient, []byte{iOK, 0, 0, 2, 0, 0, 0}))
+	unparsed, reason = fsm.Unparsed()
+	ast.True(unparsed)
+	ast.Equal("unexpected seq 3 , expect 1", reason)
+	ast.Equal("StateSkipPacket", fsm.StateName())
+
+	//the sequence numbers are not reported for later skipped packets
+	fsm.set(util.StateInit)
+	fsm.set(util.StateSkipPacket)
+	unparsed, reason = fsm.Unparsed()
+	ast.True(unparsed)
+	ast.NotContains(reason, "unexpected seq")
+}
+
+func TestFSM_ComInitDB(t *testing.T) {
+	fsm := NewMySQLFSM(logger)
+	ast := assert.New(t)
+
+	fsm.Handle(newAuthTestPacket(0, reassembly.TCPDirClientToServer, append([]byte{comInitDB}, "orders`prod"...)))
+	ast.Equal("ComQuery", fsm.StateName())
+	ast.Equal("use `orders``prod`", fsm.Query())
+
+	fsm.Handle(newAuthTestPacket(1, reassembly.TCPDirServerToClient, []byte{iOK, 0, 0, 2, 0, 0, 0}))
+	ast.Equal(util.StateComQuery2, fsm.State())
+}
@@ -0,0 +1,953 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package stream
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/google/gopacket/reassembly"
+	"go.uber.org/zap"
+)
+
+const ProtocolMySQLX = util.ProtocolMySQLX
+
+//message types of client (Mysqlx.ClientMessages.Type)
+const (
+	mysqlxConCapabilitiesGet       = 1
+	mysqlxConCapabilitiesSet       = 2
+	mysqlxConClose                 = 3
+	mysqlxSessAuthenticateStart    = 4
+	mysqlxSessAuthenticateContinue = 5
+	mysqlxSessReset                = 6
+	mysqlxSessClose                = 7
+	mysqlxSQLStmtExecute           = 12
+	mysqlxCrudFind                 = 17
+	mysqlxCrudInsert               = 18
+	mysqlxCrudUpdate               = 19
+	mysqlxCrudDelete               = 20
+	mysqlxPreparePrepare           = 40
+	mysqlxPrepareExecute           = 41
+	mysqlxPrepareDeallocate        = 42
+	mysqlxCompression              = 46
+)
+
+//message types of server (Mysqlx.ServerMessages.Type)
+const (
+	mysqlxOk                      = 0
+	mysqlxError                   = 1
+	mysqlxConnCapabilities        = 2
+	mysqlxAuthenticateContinue    = 3
+	mysqlxAuthenticateOk          = 4
+	mysqlxNotice                  = 11
+	mysqlxColumnMetaData          = 12
+	mysqlxRow                     = 13
+	mysqlxFetchDone               = 14
+	mysqlxFetchDoneMoreResultsets = 16
+	mysqlxStmtExecuteOk           = 17
+	mysqlxFetchDoneMoreOutParams  = 18
+	mysqlxServerCompression       = 19
+)
+
+//column types of Mysqlx.Resultset.ColumnMetaData
+const (
+	mysqlxTypeSint     = 1
+	mysqlxTypeUint     = 2
+	mysqlxTypeDouble   = 5
+	mysqlxTypeFloat    = 6
+	mysqlxTypeBytes    = 7
+	mysqlxTypeTime     = 10
+	mysqlxTypeDatetime = 12
+	mysqlxTypeSet      = 15
+	mysqlxTypeEnum     = 16
+	mysqlxTypeBit      = 17
+	mysqlxTypeDecimal  = 18
+)
+
+//flags of Mysqlx.Resultset.ColumnMetaData , the meaning of the lowest
+//bit depends on the column type
+const (
+	mysqlxFlagTypeSpecific = 0x0001
+	mysqlxFlagNotNull      = 0x0010
+)
+
+//content types of bytes columns
+const (
+	mysqlxContentGeometry = 1
+	mysqlxContentJSON     = 2
+)
+
+type mysqlxProtocol struct{}
+
+func (mysqlxProtocol) Name() string { return ProtocolMySQLX }
+
+func (mysqlxProtocol) NewFramer() Framer { return mysqlxFramer{} }
+
+func (mysqlxProtocol) NewDecoder(conn ConnID, log *zap.Logger) Decoder {
+	return NewMySQLXDecoder(log)
+}
+
+//X Protocol message: 4 bytes little endian length , 1 byte type ,
+//protobuf payload . The length includes the type byte , so the type
+//byte is kept in the packet data
+type mysqlxFramer struct{}
+
+func (mysqlxFramer) HeaderLen() int { return 4 }
+
+func (mysqlxFramer) Lookup(buf *bytes.Buffer, dir reassembly.TCPFlowDirection) (int, int) {
+	bs := buf.Bytes()
+	if len(bs) < 4 {
+		return -1, -1
+	}
+	n := int(binary.LittleEndian.Uint32(bs))
+	if n < 1 {
+		//a broken stream , at least the type byte
+		n = 1
+	}
+	return n, 0
+}
+
+func (mysqlxFramer) Consumed(pkt *MySQLPacket) {}
+
+type mysqlxColumn struct {
+	typ              uint64
+	name             string
+	collation        uint64
+	fractionalDigits uint32
+	length           uint32
+	flags            uint32
+	contentType      uint32
+}
+
+func newMySQLXColumn(m pbMessage) mysqlxColumn {
+	return mysqlxColumn{
+		typ:              m.Uint(1),
+		name:             m.String(2),
+		collation:        m.Uint(8),
+		fractionalDigits: uint32(m.Uint(9)),
+		length:           uint32(m.Uint(10)),
+		flags:            uint32(m.Uint(11)),
+		contentType:      uint32(m.Uint(12)),
+	}
+}
+
+//columnInfo reports the column in the names of the classic protocol , so
+//it can be compared with the column read when replay.
+//X Protocol does not tell the exact integer and string types , they are
+//guessed from the display width like MySQL reports by default
+func (c mysqlxColumn) columnInfo() ColumnInfo {
+	ci := ColumnInfo{
+		Name:     c.name,
+		Length:   c.length,
+		Decimals: uint8(c.fractionalDigits),
+	}
+	if c.collation <= math.MaxUint8 {
+		ci.Charset = uint8(c.collation)
+	}
+	specific := c.flags&mysqlxFlagTypeSpecific != 0
+	if c.flags&mysqlxFlagNotNull != 0 {
+		ci.Flags |= uint16(flagNotNULL)
+	}
+	switch c.typ {
+	case mysqlxTypeSint:
+		ci.Type = mysqlxIntType(c.length, false)
+		if specific {
+			ci.Flags |= uint16(flagZeroFill)
+		}
+	case mysqlxTypeUint:
+		ci.Type = "UNSIGNED " + mysqlxIntType(c.length, true)
+		ci.Flags |= uint16(flagUnsigned)
+		if specific {
+			ci.Flags |= uint16(flagZeroFill)
+		}
+	case mysqlxTypeDouble, mysqlxTypeFloat, mysqlxTypeDecimal:
+		ci.Type = map[uint64]string{
+			mysqlxTypeDouble:  "DOUBLE",
+			mysqlxTypeFloat:   "FLOAT",
+			mysqlxTypeDecimal: "DECIMAL",
+		}[c.typ]
+		if specific {
+			ci.Flags |= uint16(flagUnsigned)
+		}
+	case mysqlxTypeBytes:
+		binaryCharset := ci.Charset == collations[binaryCollation]
+		switch {
+		case c.contentType == mysqlxContentGeometry:
+			ci.Type = "GEOMETRY"
+		case c.contentType == mysqlxContentJSON:
+			ci.Type = "JSON"
+		case specific && binaryCharset:
+			ci.Type = "BINARY"
+		case specific:
+			ci.Type = "CHAR"
+		case binaryCharset:
+			ci.Type = "VARBINARY"
+		default:
+			ci.Type = "VARCHAR"
+		}
+	case mysqlxTypeTime:
+		ci.Type = "TIME"
+	case mysqlxTypeDatetime:
+		switch {
+		case specific:
+			ci.Type = "TIMESTAMP"
+		case c.length == 10:
+			ci.Type = "DATE"
+		default:
+			ci.Type = "DATETIME"
+		}
+	case mysqlxTypeSet:
+		ci.Type = "SET"
+	case mysqlxTypeEnum:
+		ci.Type = "ENUM"
+	case mysqlxTypeBit:
+		ci.Type = "BIT"
+	}
+	if ci.Charset == collations[binaryCollation] {
+		ci.Flags |= uint16(flagBinary)
+	}
+	return ci
+}
+
+func mysqlxIntType(length uint32, unsigned bool) string {
+	if unsigned {
+		length++
+	}
+	switch {
+	case length <= 4:
+		return "TINYINT"
+	case length <= 6:
+		return "SMALLINT"
+	case length <= 9:
+		return "MEDIUMINT"
+	case length <= 11:
+		return "INT"
+	}
+	return "BIGINT"
+}
+
+//value decodes a field of Mysqlx.Resultset.Row to the same type the
+//classic text protocol reads , an empty field is NULL
+func (c mysqlxColumn) value(b []byte) (driver.Value, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	switch c.typ {
+	case mysqlxTypeSint:
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errPbTruncated
+		}
+		return int64(v>>1) ^ -int64(v&1), nil
+	case mysqlxTypeUint:
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errPbTruncated
+		}
+		return v, nil
+	case mysqlxTypeDouble:
+		if len(b) < 8 {
+			return nil, errPbTruncated
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case mysqlxTypeFloat:
+		if len(b) < 4 {
+			return nil, errPbTruncated
+		}
+		return math.Float32frombits(binary.LittleEndian.Uint32(b)), nil
+	case mysqlxTypeBytes, mysqlxTypeEnum:
+		//a trailing 0x00 tells an empty string from NULL
+		return cloneBytes(b[:len(b)-1]), nil
+	case mysqlxTypeSet:
+		return mysqlxSet(b)
+	case mysqlxTypeBit:
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errPbTruncated
+		}
+		size := int(c.length+7) / 8
+		if size < 1 || size > 8 {
+			size = 8
+		}
+		res := make([]byte, 8)
+		binary.BigEndian.PutUint64(res, v)
+		return res[8-size:], nil
+	case mysqlxTypeTime:
+		return c.time(b)
+	case mysqlxTypeDatetime:
+		return c.datetime(b)
+	case mysqlxTypeDecimal:
+		return mysqlxDecimal(b)
+	}
+	return cloneBytes(b), nil
+}
+
+func mysqlxVarints(b []byte) ([]uint64, error) {
+	var res []uint64
+	for len(b) > 0 {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errPbTruncated
+		}
+		res = append(res, v)
+		b = b[n:]
+	}
+	return res, nil
+}
+
+func (c mysqlxColumn) fraction(us uint64) string {
+	if c.fractionalDigits == 0 || c.fractionalDigits > 6 {
+		return ""
+	}
+	return "." + fmt.Sprintf("%06d", us)[:c.fractionalDigits]
+}
+
+//sign byte , hours , minutes , seconds , microseconds
+func (c mysqlxColumn) time(b []byte) (driver.Value, error) {
+	sign := ""
+	if b[0] != 0 {
+		sign = "-"
+	}
+	v, err := mysqlxVarints(b[1:])
+	if err != nil {
+		return nil, err
+	}
+	v = append(v, 0, 0, 0, 0)
+	return sign + fmt.Sprintf("%02d:%02d:%02d", v[0], v[1], v[2]) + c.fraction(v[3]), nil
+}
+
+//year , month , day , hours , minutes , seconds , microseconds
+func (c mysqlxColumn) datetime(b []byte) (driver.Value, error) {
+	v, err := mysqlxVarints(b)
+	if err != nil {
+		return nil, err
+	}
+	v = append(v, 0, 0, 0, 0, 0, 0, 0)
+	s := fmt.Sprintf("%04d-%02d-%02d", v[0], v[1], v[2])
+	if c.columnInfo().Type == "DATE" {
+		return s, nil
+	}
+	return s + fmt.Sprintf(" %02d:%02d:%02d", v[3], v[4], v[5]) + c.fraction(v[6]), nil
+}
+
+//scale byte , BCD digits , sign nibble (0xc positive , 0xd negative)
+func mysqlxDecimal(b []byte) (driver.Value, error) {
+	scale := int(b[0])
+	digits := make([]byte, 0, 2*len(b))
+	neg := false
+	end := false
+	for _, x := range b[1:] {
+		for _, nib := range []byte{x >> 4, x & 0x0f} {
+			if nib > 9 {
+				neg = nib == 0x0b || nib == 0x0d
+				end = true
+				break
+			}
+			digits = append(digits, '0'+nib)
+		}
+		if end {
+			break
+		}
+	}
+	if !end {
+		return nil, fmt.Errorf("decimal without sign %x", b)
+	}
+	for len(digits) <= scale {
+		digits = append([]byte{'0'}, digits...)
+	}
+	intPart := strings.TrimLeft(string(digits[:len(digits)-scale]), "0")
+	if len(intPart) == 0 {
+		intPart = "0"
+	}
+	s := intPart
+	if scale > 0 {
+		s += "." + string(digits[len(digits)-scale:])
+	}
+	if neg {
+		s = "-" + s
+	}
+	return s, nil
+}
+
+//elements with varint length , a single 0x01 is the empty set
+func mysqlxSet(b []byte) (driver.Value, error) {
+	if len(b) == 1 && b[0] == 0x01 {
+		return []byte{}, nil
+	}
+	var items []string
+	for len(b) > 0 {
+		l, n := binary.Uvarint(b)
+		if n <= 0 || uint64(len(b)-n) < l {
+			return nil, errPbTruncated
+		}
+		items = append(items, string(b[n:n+int(l)]))
+		b = b[n+int(l):]
+	}
+	return []byte(strings.Join(items, ",")), nil
+}
+
+//a prepared statement , positions maps the ? of the query to the args of
+//Mysqlx.Prepare.Execute , nil means in order
+type mysqlxStmt struct {
+	positions []int
+}
+
+//a client message waiting for the response of server
+type mysqlxRequest struct {
+	typ byte
+	//event made when the request is complete , 0 if it is not replayed
+	tp     util.MysqlEventType
+	query  string
+	stmtID string
+	params []interface{}
+	//document insert is translated when the generated ids are known
+	insert pbMessage
+	ids    []string
+	//the capabilities set switches to tls or compression
+	unsupported bool
+
+	pr      *PacketRes
+	columns []mysqlxColumn
+	//the first result set is complete
+	done bool
+}
+
+//MySQLXDecoder decodes the MySQL X Protocol , the SQL and CRUD messages
+//are replayed as SQL on the classic protocol
+type MySQLXDecoder struct {
+	log *zap.Logger
+
+	//session info
+	username    string
+	database    string
+	authMethod  string
+	unsupported bool
+
+	stmts map[uint64]*mysqlxStmt
+	//every client message is answered in order
+	reqs []*mysqlxRequest
+}
+
+func NewMySQLXDecoder(log *zap.Logger) *MySQLXDecoder {
+	return &MySQLXDecoder{
+		log:   log,
+		stmts: map[uint64]*mysqlxStmt{},
+	}
+}
+
+func (d *MySQLXDecoder) Username() string { return d.username }
+
+func (d *MySQLXDecoder) Database() string { return d.database }
+
+func (d *MySQLXDecoder) AuthMethod() string { return d.authMethod }
+
+func (d *MySQLXDecoder) Decode(pkt MySQLPacket) *MySQLEvent {
+	if d.unsupported || len(pkt.Data) == 0 {
+		return nil
+	}
+	msg, err := pbDecode(pkt.Data[1:])
+	if err != nil {
+		d.log.Warn(fmt.Sprintf("decode message %d fail , %s", pkt.Data[0], err.Error()))
+		return nil
+	}
+	if pkt.Dir == reassembly.TCPDirClientToServer {
+		return d.handleClient(pkt, msg)
+	}
+	return d.handleServer(pkt, msg)
+}
+
+func (d *MySQLXDecoder) push(pkt MySQLPacket, req *mysqlxRequest) {
+	req.typ = pkt.Data[0]
+	req.pr = new(PacketRes)
+	req.pr.sqlBeginTime = uint64(pkt.Time.UnixNano())
+	d.reqs = append(d.reqs, req)
+}
+
+func (d *MySQLXDecoder) head() *mysqlxRequest {
+	if len(d.reqs) == 0 {
+		return nil
+	}
+	return d.reqs[0]
+}
+
+func (d *MySQLXDecoder) pop() {
+	if len(d.reqs) > 0 {
+		d.reqs = d.reqs[1:]
+	}
+}
+
+func (d *MySQLXDecoder) handleClient(pkt MySQLPacket, msg pbMessage) *MySQLEvent {
+	req := &mysqlxRequest{}
+	var err error
+	switch pkt.Data[0] {
+	case mysqlxConCapabilitiesSet:
+		req.unsupported = d.switchesTransport(msg)
+	case mysqlxSessAuthenticateStart:
+		d.authMethod = msg.String(1)
+		//PLAIN sends the credentials at once
+		d.setCredentials(msg.Bytes(3))
+	case mysqlxSessAuthenticateContinue:
+		d.setCredentials(msg.Bytes(1))
+	case mysqlxSQLStmtExecute:
+		err = d.sqlStmtExecute(msg, req)
+	case mysqlxCrudFind, mysqlxCrudInsert, mysqlxCrudUpdate, mysqlxCrudDelete:
+		req.tp = util.EventQuery
+		if pkt.Data[0] == mysqlxCrudInsert && mysqlxDocModel(msg.Uint(2)) {
+			//translated after the server tells the generated ids
+			req.insert = msg
+			break
+		}
+		req.query, _, err = mysqlxCrudToSQL(pkt.Data[0], msg, false)
+	case mysqlxPreparePrepare:
+		err = d.prepare(msg, req)
+	case mysqlxPrepareExecute:
+		err = d.execute(msg, req)
+	case mysqlxPrepareDeallocate:
+		id := msg.Uint(1)
+		delete(d.stmts, id)
+		d.push(pkt, req)
+		//no response is needed to replay a closed statement
+		e := d.newEvent(pkt, &mysqlxRequest{pr: new(PacketRes)}, util.EventStmtClose)
+		e.StmtID = strconv.FormatUint(id, 10)
+		e.Pr.sqlBeginTime = e.Pr.sqlEndTime
+		return e
+	case mysqlxSessClose, mysqlxConClose:
+		d.push(pkt, req)
+		e := d.newEvent(pkt, &mysqlxRequest{pr: new(PacketRes)}, util.EventQuit)
+		e.Pr.sqlBeginTime = e.Pr.sqlEndTime
+		return e
+	case mysqlxCompression:
+		d.log.Warn("compressed messages are not supported")
+		d.unsupported = true
+		return nil
+	default:
+		//capabilities get , session reset , expectations , cursors and
+		//view messages are not replayed
+		d.log.Debug(fmt.Sprintf("skip client message %d", pkt.Data[0]))
+	}
+	if err != nil {
+		d.log.Warn(fmt.Sprintf("message %d is not replayed , %s", pkt.Data[0], err.Error()))
+		req.tp = 0
+	}
+	d.push(pkt, req)
+	return nil
+}
+
+//tls and compression capabilities change the data of the connection
+func (d *MySQLXDecoder) switchesTransport(msg pbMessage) bool {
+	caps, err := msg.Message(1)
+	if err != nil {
+		return false
+	}
+	list, err := caps.RepeatedMessage(1)
+	if err != nil {
+		return false
+	}
+	for _, c := range list {
+		name := c.String(1)
+		if name == "tls" || name == "compression" {
+			return true
+		}
+	}
+	return false
+}
+
+//auth data of PLAIN , MYSQL41 and SHA256_MEMORY is schema\0user\0secret
+func (d *MySQLXDecoder) setCredentials(data []byte) {
+	parts := bytes.SplitN(data, []byte{0}, 3)
+	if len(parts) < 3 {
+		return
+	}
+	d.database = string(parts[0])
+	d.username = string(parts[1])
+}
+
+func (d *MySQLXDecoder) sqlStmtExecute(msg pbMessage, req *mysqlxRequest) error {
+	ns := msg.String(3)
+	if len(ns) != 0 && ns != "sql" {
+		//admin commands of mysqlx namespace
+		return fmt.Errorf("unsupported namespace %s", ns)
+	}
+	args, err := mysqlxAnys(msg.Repeated(2))
+	if err != nil {
+		return err
+	}
+	req.tp = util.EventQuery
+	req.query, err = BindParams(msg.String(1), args)
+	return err
+}
+
+func (d *MySQLXDecoder) prepare(msg pbMessage, req *mysqlxRequest) error {
+	id := msg.Uint(1)
+	req.tp = util.EventStmtPrepare
+	req.stmtID = strconv.FormatUint(id, 10)
+	one, err := msg.Message(2)
+	if err != nil {
+		return err
+	}
+	//Mysqlx.Prepare.Prepare.OneOfMessage: find , insert , update , delete , stmt_execute
+	stmt := &mysqlxStmt{}
+	switch one.Uint(1) {
+	case 1, 2, 3, 4:
+		typ := byte(mysqlxCrudFind + one.Uint(1) - 1)
+		m, err := one.Message(int(one.Uint(1)) + 1)
+		if err != nil {
+			return err
+		}
+		req.query, stmt.positions, err = mysqlxCrudToSQL(typ, m, true)
+		if err != nil {
+			return err
+		}
+	case 5:
+		m, err := one.Message(6)
+		if err != nil {
+			return err
+		}
+		if ns := m.String(3); len(ns) != 0 && ns != "sql" {
+			return fmt.Errorf("unsupported namespace %s", ns)
+		}
+		req.query = m.String(1)
+	default:
+		return fmt.Errorf("unknown prepared message %d", one.Uint(1))
+	}
+	d.stmts[id] = stmt
+	return nil
+}
+
+func (d *MySQLXDecoder) execute(msg pbMessage, req *mysqlxRequest) error {
+	id := msg.Uint(1)
+	req.tp = util.EventStmtExecute
+	req.stmtID = strconv.FormatUint(id, 10)
+	args, err := mysqlxAnys(msg.Repeated(2))
+	if err != nil {
+		return err
+	}
+	stmt, ok := d.stmts[id]
+	if !ok || stmt.positions == nil {
+		req.params = args
+		return nil
+	}
+	req.params = make([]interface{}, 0, len(stmt.positions))
+	for _, pos := range stmt.positions {
+		if pos >= len(args) {
+			return fmt.Errorf("placeholder %d out of %d args", pos, len(args))
+		}
+		req.params = append(req.params, args[pos])
+	}
+	return nil
+}
+
+func (d *MySQLXDecoder) handleServer(pkt MySQLPacket, msg pbMessage) *MySQLEvent {
+	req := d.head()
+	switch pkt.Data[0] {
+	case mysqlxNotice:
+		d.handleNotice(msg, req)
+		return nil
+	case mysqlxColumnMetaData:
+		if req != nil && !req.done {
+			req.columns = append(req.columns, newMySQLXColumn(msg))
+		}
+		return nil
+	case mysqlxRow:
+		if req != nil && !req.done {
+			d.addRow(req, msg)
+		}
+		return nil
+	case mysqlxFetchDone, mysqlxFetchDoneMoreResultsets, mysqlxFetchDoneMoreOutParams:
+		if req != nil {
+			req.done = true
+		}
+		return nil
+	case mysqlxServerCompression:
+		d.log.Warn("compressed messages are not supported")
+		d.unsupported = true
+		return nil
+	case mysqlxOk, mysqlxConnCapabilities, mysqlxAuthenticateContinue, mysqlxAuthenticateOk, mysqlxStmtExecuteOk:
+	case mysqlxError:
+		err := &MySQLError{
+			Number:   uint16(msg.Uint(2)),
+			Message:  msg.String(3),
+			SQLState: msg.String(4),
+		}
+		if req == nil {
+			d.log.Warn("server error without request , " + err.Error())
+			return nil
+		}
+		req.pr.setError(err)
+	default:
+		return nil
+	}
+	if req == nil {
+		return nil
+	}
+	d.pop()
+
+	switch {
+	case req.unsupported && pkt.Data[0] == mysqlxOk:
+		d.log.Warn("handshake: tls and compression are not supported")
+		d.unsupported = true
+	case req.typ == mysqlxSessAuthenticateStart || req.typ == mysqlxSessAuthenticateContinue:
+		if pkt.Data[0] == mysqlxAuthenticateOk || pkt.Data[0] == mysqlxError {
+			return d.newEvent(pkt, req, util.EventHandshake)
+		}
+	case req.tp != 0:
+		return d.newEvent(pkt, req, req.tp)
+	}
+	return nil
+}
+
+//the generated ids of document insert are told by a SessionStateChanged notice
+func (d *MySQLXDecoder) handleNotice(msg pbMessage, req *mysqlxRequest) {
+	//Mysqlx.Notice.Frame type 3 is SessionStateChanged
+	if msg.Uint(1) != 3 || req == nil {
+		return
+	}
+	state, err := msg.Message(3)
+	if err != nil {
+		return
+	}
+	//GENERATED_DOCUMENT_IDS
+	if state.Uint(1) != 12 {
+		return
+	}
+	values, err := state.RepeatedMessage(2)
+	if err != nil {
+		return
+	}
+	for _, v := range values {
+		if s, err := mysqlxScalar(v); err == nil {
+			req.ids = append(req.ids, fmt.Sprint(mysqlxText(s)))
+		}
+	}
+}
+
+func (d *MySQLXDecoder) addRow(req *mysqlxRequest, msg pbMessage) {
+	fields := msg.Repeated(1)
+	values := make([]driver.Value, 0, len(fields))
+	for i, field := range fields {
+		var column mysqlxColumn
+		if i < len(req.columns) {
+			column = req.columns[i]
+		}
+		v, err := column.value(field)
+		if err != nil {
+			d.log.Warn("read row fail , " + err.Error())
+			return
+		}
+		values = append(values, v)
+	}
+	req.pr.values = append(req.pr.values, values)
+}
+
+func (d *MySQLXDecoder) newEvent(pkt MySQLPacket, req *mysqlxRequest, tp util.MysqlEventType) *MySQLEvent {
+	e := &MySQLEvent{
+		Conn: pkt.Conn,
+		Time: pkt.Time.UnixNano(),
+		Type: tp,
+		Pr:   req.pr,
+	}
+	e.Pr.sqlEndTime = uint64(pkt.Time.UnixNano())
+	if req.columns != nil {
+		e.Pr.columns = make([]ColumnInfo, 0, len(req.columns))
+		for _, column := range req.columns {
+			e.Pr.columns = append(e.Pr.columns, column.columnInfo())
+		}
+		if e.Pr.values == nil {
+			e.Pr.values = [][]driver.Value{}
+		}
+	}
+	switch tp {
+	case util.EventHandshake:
+		e.DB = d.database
+		e.Username = d.username
+		e.AuthPlugin = d.authMethod
+	case util.EventQuery:
+		e.Query = req.query
+		if req.insert != nil {
+			var err error
+			e.Query, err = mysqlxInsertToSQL(req.insert, req.ids)
+			if err != nil {
+				d.log.Warn("insert is not replayed , " + err.Error())
+				return nil
+			}
+		}
+	case util.EventStmtPrepare:
+		e.StmtID = req.stmtID
+		e.Query = req.query
+	case util.EventStmtExecute:
+		e.StmtID = req.stmtID
+		e.Params = req.params
+	}
+	return e
+}
+
+//scalar types of Mysqlx.Datatypes.Scalar
+const (
+	mysqlxScalarSint   = 1
+	mysqlxScalarUint   = 2
+	mysqlxScalarNull   = 3
+	mysqlxScalarOctets = 4
+	mysqlxScalarDouble = 5
+	mysqlxScalarFloat  = 6
+	mysqlxScalarBool   = 7
+	mysqlxScalarString = 8
+)
+
+//mysqlxScalar converts a Mysqlx.Datatypes.Scalar to a parameter value
+func mysqlxScalar(m pbMessage) (interface{}, error) {
+	switch m.Uint(1) {
+	case mysqlxScalarSint:
+		return m.Sint(2), nil
+	case mysqlxScalarUint:
+		return m.Uint(3), nil
+	case mysqlxScalarNull:
+		return nil, nil
+	case mysqlxScalarOctets:
+		o, err := m.Message(5)
+		if err != nil {
+			return nil, err
+		}
+		return cloneBytes(o.Bytes(1)), nil
+	case mysqlxScalarDouble:
+		return m.Double(6), nil
+	case mysqlxScalarFloat:
+		return float64(m.Float(7)), nil
+	case mysqlxScalarBool:
+		return m.Bool(8), nil
+	case mysqlxScalarString:
+		s, err := m.Message(9)
+		if err != nil {
+			return nil, err
+		}
+		return s.String(1), nil
+	}
+	return nil, fmt.Errorf("unknown scalar type %d", m.Uint(1))
+}
+
+//mysqlxAny converts a Mysqlx.Datatypes.Any , objects and arrays are
+//converted to JSON text
+func mysqlxAny(m pbMessage) (interface{}, error) {
+	switch m.Uint(1) {
+	case 1:
+		s, err := m.Message(2)
+		if err != nil {
+			return nil, err
+		}
+		return mysqlxScalar(s)
+	case 2, 3:
+		s, err := mysqlxAnyJSON(m)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown any type %d", m.Uint(1))
+}
+
+func mysqlxAnyJSON(m pbMessage) (string, error) {
+	switch m.Uint(1) {
+	case 1:
+		s, err := m.Message(2)
+		if err != nil {
+			return "", err
+		}
+		v, err := mysqlxScalar(s)
+		if err != nil {
+			return "", err
+		}
+		return jsonText(mysqlxText(v)), nil
+	case 2:
+		obj, err := m.Message(3)
+		if err != nil {
+			return "", err
+		}
+		fields, err := obj.RepeatedMessage(1)
+		if err != nil {
+			return "", err
+		}
+		items := make([]string, 0, len(fields))
+		for _, f := range fields {
+			v, err := f.Message(2)
+			if err != nil {
+				return "", err
+			}
+			s, err := mysqlxAnyJSON(v)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, jsonText(f.String(1))+":"+s)
+		}
+		return "{" + strings.Join(items, ",") + "}", nil
+	case 3:
+		arr, err := m.Message(4)
+		if err != nil {
+			return "", err
+		}
+		values, err := arr.RepeatedMessage(1)
+		if err != nil {
+			return "", err
+		}
+		items := make([]string, 0, len(values))
+		for _, v := range values {
+			s, err := mysqlxAnyJSON(v)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return "[" + strings.Join(items, ",") + "]", nil
+	}
+	return "", fmt.Errorf("unknown any type %d", m.Uint(1))
+}
+
+func mysqlxAnys(list [][]byte) ([]interface{}, error) {
+	res := make([]interface{}, 0, len(list))
+	for _, b := range list {
+		m, err := pbDecode(b)
+		if err != nil {
+			return nil, err
+		}
+		v, err := mysqlxAny(m)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	return res, nil
+}
+
+//bytes are shown as text
+func mysqlxText(v interface{}) interface{} {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return v
+}
+
+func jsonText(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "null"
+	}
+	return string(b)
+}
@@ -0,0 +1,632 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//the CRUD messages are translated to SQL in the same way the X Plugin
+//does , documents are stored in the doc column of the collection table
+
+//Mysqlx.Crud.DataModel , DOCUMENT is the default
+func mysqlxDocModel(model uint64) bool {
+	return model != 2
+}
+
+//mysqlxBuilder renders the expressions of a CRUD message . Placeholders
+//are replaced with the args of the message , or kept as ? when the
+//message is prepared and the positions are recorded
+type mysqlxBuilder struct {
+	doc       bool
+	args      []interface{}
+	prepared  bool
+	positions []int
+	err       error
+}
+
+func (b *mysqlxBuilder) fail(err error) string {
+	if b.err == nil {
+		b.err = err
+	}
+	return ""
+}
+
+//mysqlxCrudToSQL translates Crud.Find , Crud.Insert , Crud.Update and
+//Crud.Delete to SQL
+func mysqlxCrudToSQL(typ byte, msg pbMessage, prepared bool) (string, []int, error) {
+	var (
+		query string
+		err   error
+	)
+	b := &mysqlxBuilder{prepared: prepared}
+	switch typ {
+	case mysqlxCrudFind:
+		b.doc = mysqlxDocModel(msg.Uint(3))
+		b.args, err = mysqlxScalars(msg.Repeated(11))
+		if err == nil {
+			query = b.find(msg)
+		}
+	case mysqlxCrudInsert:
+		b.doc = mysqlxDocModel(msg.Uint(2))
+		b.args, err = mysqlxScalars(msg.Repeated(5))
+		if err == nil {
+			query = b.insert(msg, nil)
+		}
+	case mysqlxCrudUpdate:
+		b.doc = mysqlxDocModel(msg.Uint(3))
+		b.args, err = mysqlxScalars(msg.Repeated(8))
+		if err == nil {
+			query = b.update(msg)
+		}
+	case mysqlxCrudDelete:
+		b.doc = mysqlxDocModel(msg.Uint(2))
+		b.args, err = mysqlxScalars(msg.Repeated(6))
+		if err == nil {
+			query = b.delete(msg)
+		}
+	default:
+		err = fmt.Errorf("unknown crud message %d", typ)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if b.err != nil {
+		return "", nil, b.err
+	}
+	return query, b.positions, nil
+}
+
+//mysqlxInsertToSQL translates a document insert , the documents without
+//_id get the ids generated by the captured server in order
+func mysqlxInsertToSQL(msg pbMessage, ids []string) (string, error) {
+	var err error
+	b := &mysqlxBuilder{doc: mysqlxDocModel(msg.Uint(2))}
+	b.args, err = mysqlxScalars(msg.Repeated(5))
+	if err != nil {
+		return "", err
+	}
+	query := b.insert(msg, ids)
+	return query, b.err
+}
+
+func (b *mysqlxBuilder) find(msg pbMessage) string {
+	var sb strings.Builder
+	sb.WriteString("SELECT ")
+	projection := b.messages(msg, 4)
+	switch {
+	case len(projection) == 0 && b.doc:
+		sb.WriteString("doc")
+	case len(projection) == 0:
+		sb.WriteString("*")
+	case b.doc:
+		//Crud.Projection: source , alias
+		items := make([]string, 0, len(projection))
+		for _, p := range projection {
+			items = append(items, sqlQuote(p.String(2))+","+b.exprOf(p, 1))
+		}
+		sb.WriteString("JSON_OBJECT(" + strings.Join(items, ",") + ") AS doc")
+	default:
+		items := make([]string, 0, len(projection))
+		for _, p := range projection {
+			item := b.exprOf(p, 1)
+			if alias := p.String(2); len(alias) > 0 {
+				item += " AS " + mysqlxIdent(alias)
+			}
+			items = append(items, item)
+		}
+		sb.WriteString(strings.Join(items, ","))
+	}
+	sb.WriteString(" FROM " + b.collection(msg, 2))
+	b.where(&sb, msg, 5)
+	if grouping := b.messages(msg, 8); len(grouping) > 0 {
+		items := make([]string, 0, len(grouping))
+		for _, g := range grouping {
+			items = append(items, b.expr(g))
+		}
+		sb.WriteString(" GROUP BY " + strings.Join(items, ","))
+	}
+	if msg.Has(9) {
+		sb.WriteString(" HAVING " + b.exprOf(msg, 9))
+	}
+	b.order(&sb, msg, 7)
+	b.limit(&sb, msg, 6, 14, true)
+	switch msg.Uint(12) {
+	case 1:
+		sb.WriteString(" FOR SHARE")
+	case 2:
+		sb.WriteString(" FOR UPDATE")
+	}
+	switch msg.Uint(13) {
+	case 1:
+		sb.WriteString(" NOWAIT")
+	case 2:
+		sb.WriteString(" SKIP LOCKED")
+	}
+	return sb.String()
+}
+
+func (b *mysqlxBuilder) insert(msg pbMessage, ids []string) string {
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO " + b.collection(msg, 1))
+	if b.doc {
+		sb.WriteString(" (doc)")
+	} else if columns := b.messages(msg, 3); len(columns) > 0 {
+		items := make([]string, 0, len(columns))
+		for _, c := range columns {
+			items = append(items, mysqlxIdent(c.String(1)))
+		}
+		sb.WriteString(" (" + strings.Join(items, ",") + ")")
+	}
+	sb.WriteString(" VALUES ")
+	for i, row := range b.messages(msg, 4) {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fields := b.messages(row, 1)
+		items := make([]string, 0, len(fields))
+		for _, f := range fields {
+			item := b.expr(f)
+			if b.doc && len(ids) > 0 && !b.hasID(f) {
+				item = "JSON_SET(" + item + ",'$._id'," + sqlQuote(ids[0]) + ")"
+				ids = ids[1:]
+			}
+			items = append(items, item)
+		}
+		sb.WriteString("(" + strings.Join(items, ",") + ")")
+	}
+	if msg.Bool(6) {
+		sb.WriteString(" ON DUPLICATE KEY UPDATE doc = VALUES(doc)")
+	}
+	return sb.String()
+}
+
+//hasID tells if the document of insert has _id , placeholders and other
+//expressions are supposed to have one
+func (b *mysqlxBuilder) hasID(expr pbMessage) bool {
+	switch expr.Uint(1) {
+	case mysqlxExprObject:
+		obj, err := expr.Message(8)
+		if err != nil {
+			return true
+		}
+		for _, f := range b.messages(obj, 1) {
+			if f.String(1) == "_id" {
+				return true
+			}
+		}
+		return false
+	case mysqlxExprLiteral:
+		s, err := expr.Message(4)
+		if err != nil {
+			return true
+		}
+		v, err := mysqlxScalar(s)
+		if err != nil {
+			return true
+		}
+		var doc map[string]interface{}
+		if json.Unmarshal([]byte(fmt.Sprint(mysqlxText(v))), &doc) != nil {
+			return true
+		}
+		_, ok := doc["_id"]
+		return ok
+	}
+	return true
+}
+
+//Crud.UpdateOperation types
+const (
+	mysqlxUpdateSet         = 1
+	mysqlxUpdateItemRemove  = 2
+	mysqlxUpdateItemSet     = 3
+	mysqlxUpdateItemReplace = 4
+	mysqlxUpdateItemMerge   = 5
+	mysqlxUpdateArrayInsert = 6
+	mysqlxUpdateArrayAppend = 7
+	mysqlxUpdateMergePatch  = 8
+)
+
+var mysqlxUpdateFuncs = map[uint64]string{
+	mysqlxUpdateItemRemove:  "JSON_REMOVE",
+	mysqlxUpdateItemSet:     "JSON_SET",
+	mysqlxUpdateItemReplace: "JSON_REPLACE",
+	mysqlxUpdateItemMerge:   "JSON_MERGE_PRESERVE",
+	mysqlxUpdateArrayInsert: "JSON_ARRAY_INSERT",
+	mysqlxUpdateArrayAppend: "JSON_ARRAY_APPEND",
+	mysqlxUpdateMergePatch:  "JSON_MERGE_PATCH",
+}
+
+func (b *mysqlxBuilder) update(msg pbMessage) string {
+	var sb strings.Builder
+	sb.WriteString("UPDATE " + b.collection(msg, 2) + " SET ")
+	//the assignments of a single table update are applied from left to right
+	for i, op := range b.messages(msg, 7) {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		source, err := op.Message(1)
+		if err != nil {
+			return b.fail(err)
+		}
+		column := "doc"
+		if !b.doc {
+			column = mysqlxIdent(source.String(2))
+		}
+		tp := op.Uint(2)
+		if tp == mysqlxUpdateSet {
+			if b.doc {
+				return b.fail(errors.New("SET is not allowed on document"))
+			}
+			sb.WriteString(column + "=" + b.exprOf(op, 3))
+			continue
+		}
+		fn, ok := mysqlxUpdateFuncs[tp]
+		if !ok {
+			return b.fail(fmt.Errorf("unknown update operation %d", tp))
+		}
+		args := column
+		if tp != mysqlxUpdateItemMerge && tp != mysqlxUpdateMergePatch {
+			args += "," + sqlQuote(b.path(source))
+		}
+		if tp != mysqlxUpdateItemRemove {
+			args += "," + b.exprOf(op, 3)
+		}
+		sb.WriteString(column + "=" + fn + "(" + args + ")")
+	}
+	b.where(&sb, msg, 4)
+	b.order(&sb, msg, 6)
+	b.limit(&sb, msg, 5, 9, false)
+	return sb.String()
+}
+
+func (b *mysqlxBuilder) delete(msg pbMessage) string {
+	var sb strings.Builder
+	sb.WriteString("DELETE FROM " + b.collection(msg, 1))
+	b.where(&sb, msg, 3)
+	b.order(&sb, msg, 5)
+	b.limit(&sb, msg, 4, 7, false)
+	return sb.String()
+}
+
+//Crud.Collection: name , schema
+func (b *mysqlxBuilder) collection(msg pbMessage, num int) string {
+	c, err := msg.Message(num)
+	if err != nil {
+		return b.fail(err)
+	}
+	if len(c.String(1)) == 0 {
+		return b.fail(errors.New("collection without name"))
+	}
+	if schema := c.String(2); len(schema) > 0 {
+		return mysqlxIdent(schema) + "." + mysqlxIdent(c.String(1))
+	}
+	return mysqlxIdent(c.String(1))
+}
+
+func (b *mysqlxBuilder) where(sb *strings.Builder, msg pbMessage, num int) {
+	if msg.Has(num) {
+		sb.WriteString(" WHERE " + b.exprOf(msg, num))
+	}
+}
+
+//Crud.Order: expr , direction
+func (b *mysqlxBuilder) order(sb *strings.Builder, msg pbMessage, num int) {
+	orders := b.messages(msg, num)
+	if len(orders) == 0 {
+		return
+	}
+	items := make([]string, 0, len(orders))
+	for _, o := range orders {
+		item := b.exprOf(o, 1)
+		if o.Uint(2) == 2 {
+			item += " DESC"
+		}
+		items = append(items, item)
+	}
+	sb.WriteString(" ORDER BY " + strings.Join(items, ","))
+}
+
+//Crud.Limit or Crud.LimitExpr: row_count , offset
+func (b *mysqlxBuilder) limit(sb *strings.Builder, msg pbMessage, num int, exprNum int, offset bool) {
+	switch {
+	case msg.Has(num):
+		l, err := msg.Message(num)
+		if err != nil {
+			b.fail(err)
+			return
+		}
+		sb.WriteString(" LIMIT ")
+		if offset && l.Uint(2) > 0 {
+			sb.WriteString(strconv.FormatUint(l.Uint(2), 10) + ",")
+		}
+		sb.WriteString(strconv.FormatUint(l.Uint(1), 10))
+	case msg.Has(exprNum):
+		l, err := msg.Message(exprNum)
+		if err != nil {
+			b.fail(err)
+			return
+		}
+		sb.WriteString(" LIMIT ")
+		if offset && l.Has(2) {
+			sb.WriteString(b.exprOf(l, 2) + ",")
+		}
+		sb.WriteString(b.exprOf(l, 1))
+	}
+}
+
+func (b *mysqlxBuilder) messages(msg pbMessage, num int) []pbMessage {
+	list, err := msg.RepeatedMessage(num)
+	if err != nil {
+		b.fail(err)
+	}
+	return list
+}
+
+//Mysqlx.Expr.Expr types
+const (
+	mysqlxExprIdent       = 1
+	mysqlxExprLiteral     = 2
+	mysqlxExprVariable    = 3
+	mysqlxExprFuncCall    = 4
+	mysqlxExprOperator    = 5
+	mysqlxExprPlaceholder = 6
+	mysqlxExprObject      = 7
+	mysqlxExprArray       = 8
+)
+
+func (b *mysqlxBuilder) exprOf(msg pbMessage, num int) string {
+	expr, err := msg.Message(num)
+	if err != nil {
+		return b.fail(err)
+	}
+	return b.expr(expr)
+}
+
+func (b *mysqlxBuilder) expr(expr pbMessage) string {
+	switch expr.Uint(1) {
+	case mysqlxExprIdent:
+		id, err := expr.Message(2)
+		if err != nil {
+			return b.fail(err)
+		}
+		return b.ident(id)
+	case mysqlxExprLiteral:
+		s, err := expr.Message(4)
+		if err != nil {
+			return b.fail(err)
+		}
+		v, err := mysqlxScalar(s)
+		if err != nil {
+			return b.fail(err)
+		}
+		return sqlLiteral(v)
+	case mysqlxExprFuncCall:
+		fc, err := expr.Message(5)
+		if err != nil {
+			return b.fail(err)
+		}
+		//FunctionCall: name (Identifier: name , schema_name) , param
+		id, err := fc.Message(1)
+		if err != nil {
+			return b.fail(err)
+		}
+		name := id.String(1)
+		if schema := id.String(2); len(schema) > 0 {
+			name = mysqlxIdent(schema) + "." + mysqlxIdent(name)
+		}
+		return name + "(" + strings.Join(b.exprs(fc, 2), ",") + ")"
+	case mysqlxExprOperator:
+		op, err := expr.Message(6)
+		if err != nil {
+			return b.fail(err)
+		}
+		return b.operator(op.String(1), b.exprs(op, 2))
+	case mysqlxExprPlaceholder:
+		pos := int(expr.Uint(7))
+		if b.prepared {
+			b.positions = append(b.positions, pos)
+			return "?"
+		}
+		if pos >= len(b.args) {
+			return b.fail(fmt.Errorf("placeholder %d out of %d args", pos, len(b.args)))
+		}
+		return sqlLiteral(b.args[pos])
+	case mysqlxExprObject:
+		obj, err := expr.Message(8)
+		if err != nil {
+			return b.fail(err)
+		}
+		fields := b.messages(obj, 1)
+		items := make([]string, 0, len(fields))
+		for _, f := range fields {
+			items = append(items, sqlQuote(f.String(1))+","+b.exprOf(f, 2))
+		}
+		return "JSON_OBJECT(" + strings.Join(items, ",") + ")"
+	case mysqlxExprArray:
+		arr, err := expr.Message(9)
+		if err != nil {
+			return b.fail(err)
+		}
+		return "JSON_ARRAY(" + strings.Join(b.exprs(arr, 1), ",") + ")"
+	}
+	return b.fail(fmt.Errorf("unsupported expression type %d", expr.Uint(1)))
+}
+
+func (b *mysqlxBuilder) exprs(msg pbMessage, num int) []string {
+	list := b.messages(msg, num)
+	res := make([]string, 0, len(list))
+	for _, e := range list {
+		res = append(res, b.expr(e))
+	}
+	return res
+}
+
+//ColumnIdentifier: document_path , name , table_name , schema_name
+func (b *mysqlxBuilder) ident(id pbMessage) string {
+	name := id.String(2)
+	hasPath := len(id.Repeated(1)) > 0
+	if b.doc && len(name) == 0 {
+		if !hasPath {
+			return "doc"
+		}
+		return "JSON_EXTRACT(doc," + sqlQuote(b.path(id)) + ")"
+	}
+	column := mysqlxIdent(name)
+	if table := id.String(3); len(table) > 0 {
+		column = mysqlxIdent(table) + "." + column
+		if schema := id.String(4); len(schema) > 0 {
+			column = mysqlxIdent(schema) + "." + column
+		}
+	}
+	if hasPath {
+		return "JSON_EXTRACT(" + column + "," + sqlQuote(b.path(id)) + ")"
+	}
+	return column
+}
+
+//path of a ColumnIdentifier , DocumentPathItem: type , value , index
+func (b *mysqlxBuilder) path(id pbMessage) string {
+	var sb strings.Builder
+	sb.WriteString("$")
+	for _, item := range b.messages(id, 1) {
+		switch item.Uint(1) {
+		case 1:
+			member := item.String(2)
+			if mysqlxPlainMember(member) {
+				sb.WriteString("." + member)
+			} else {
+				sb.WriteString("." + jsonText(member))
+			}
+		case 2:
+			sb.WriteString(".*")
+		case 3:
+			sb.WriteString("[" + strconv.FormatUint(item.Uint(3), 10) + "]")
+		case 4:
+			sb.WriteString("[*]")
+		case 5:
+			sb.WriteString("**")
+		}
+	}
+	return sb.String()
+}
+
+func mysqlxPlainMember(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i, c := range s {
+		if c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9' {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+var mysqlxBinaryOperators = map[string]string{
+	"==": "=", "!=": "!=", "<": "<", ">": ">", "<=": "<=", ">=": ">=",
+	"&&": "AND", "||": "OR", "xor": "XOR",
+	"+": "+", "-": "-", "*": "*", "/": "/", "div": "DIV", "%": "%",
+	"&": "&", "|": "|", "^": "^", "<<": "<<", ">>": ">>",
+	"is": "IS", "is_not": "IS NOT", "like": "LIKE", "not_like": "NOT LIKE",
+	"regexp": "REGEXP", "not_regexp": "NOT REGEXP",
+}
+
+var mysqlxUnaryOperators = map[string]string{
+	"!": "NOT ", "not": "NOT ", "~": "~", "sign_plus": "+", "sign_minus": "-",
+}
+
+func (b *mysqlxBuilder) operator(name string, params []string) string {
+	if op, ok := mysqlxBinaryOperators[name]; ok {
+		if name == "*" && len(params) == 0 {
+			return "*"
+		}
+		if len(params) != 2 {
+			return b.fail(fmt.Errorf("operator %s needs 2 params", name))
+		}
+		return "(" + params[0] + " " + op + " " + params[1] + ")"
+	}
+	if op, ok := mysqlxUnaryOperators[name]; ok {
+		if len(params) != 1 {
+			return b.fail(fmt.Errorf("operator %s needs 1 param", name))
+		}
+		return "(" + op + params[0] + ")"
+	}
+	switch name {
+	case "in", "not_in":
+		if len(params) < 2 {
+			return b.fail(fmt.Errorf("operator %s needs a list", name))
+		}
+		op := " IN "
+		if name == "not_in" {
+			op = " NOT IN "
+		}
+		return "(" + params[0] + op + "(" + strings.Join(params[1:], ",") + "))"
+	case "between", "not_between":
+		if len(params) != 3 {
+			return b.fail(fmt.Errorf("operator %s needs 3 params", name))
+		}
+		op := " BETWEEN "
+		if name == "not_between" {
+			op = " NOT BETWEEN "
+		}
+		return "(" + params[0] + op + params[1] + " AND " + params[2] + ")"
+	case "cont_in", "not_cont_in":
+		if len(params) != 2 {
+			return b.fail(fmt.Errorf("operator %s needs 2 params", name))
+		}
+		s := "JSON_CONTAINS(" + params[1] + ",CAST(" + params[0] + " AS JSON))"
+		if name == "not_cont_in" {
+			s = "(NOT " + s + ")"
+		}
+		return s
+	case "overlaps", "not_overlaps":
+		if len(params) != 2 {
+			return b.fail(fmt.Errorf("operator %s needs 2 params", name))
+		}
+		s := "JSON_OVERLAPS(" + params[0] + "," + params[1] + ")"
+		if name == "not_overlaps" {
+			s = "(NOT " + s + ")"
+		}
+		return s
+	}
+	return b.fail(fmt.Errorf("unsupported operator %s", name))
+}
+
+func mysqlxScalars(list [][]byte) ([]interface{}, error) {
+	res := make([]interface{}, 0, len(list))
+	for _, b := range list {
+		m, err := pbDecode(b)
+		if err != nil {
+			return nil, err
+		}
+		v, err := mysqlxScalar(m)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	return res, nil
+}
+
+func mysqlxIdent(s string) string {
+	return "`" + strings.ReplaceAll(s, "`", "``") + "`"
+}
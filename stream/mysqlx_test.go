@@ -0,0 +1,312 @@
+package stream
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func pbAppendVarint(data []byte, v uint64) []byte {
+	b := make([]byte, binary.MaxVarintLen64)
+	return append(data, b[:binary.PutUvarint(b, v)]...)
+}
+
+func pbKey(num int, wire int) []byte {
+	return pbAppendVarint(nil, uint64(num<<3|wire))
+}
+
+func pbUint(num int, v uint64) []byte {
+	return pbAppendVarint(pbKey(num, pbVarint), v)
+}
+
+func pbSint(num int, v int64) []byte {
+	return pbUint(num, uint64(v<<1)^uint64(v>>63))
+}
+
+func pbLen(num int, fields ...[]byte) []byte {
+	body := bytes.Join(fields, nil)
+	data := pbAppendVarint(pbKey(num, pbBytes), uint64(len(body)))
+	return append(data, body...)
+}
+
+func pbStr(num int, s string) []byte {
+	return pbLen(num, []byte(s))
+}
+
+//build a message with length and type
+func xMsg(typ byte, fields ...[]byte) []byte {
+	body := bytes.Join(fields, nil)
+	data := make([]byte, 5, 5+len(body))
+	binary.LittleEndian.PutUint32(data, uint32(len(body)+1))
+	data[4] = typ
+	return append(data, body...)
+}
+
+//the packet data of a message , the header is cut by the stream
+func xPacket(dir bool, typ byte, fields ...[]byte) MySQLPacket {
+	d := pgServer
+	if dir {
+		d = pgClient
+	}
+	data := xMsg(typ, fields...)[4:]
+	return MySQLPacket{Time: time.Now(), Dir: d, Len: len(data), Data: data}
+}
+
+func xScalarString(s string) []byte {
+	return bytes.Join([][]byte{pbUint(1, mysqlxScalarString), pbLen(9, pbStr(1, s))}, nil)
+}
+
+func xScalarSint(v int64) []byte {
+	return bytes.Join([][]byte{pbUint(1, mysqlxScalarSint), pbSint(2, v)}, nil)
+}
+
+func xAnyScalar(scalar []byte) []byte {
+	return bytes.Join([][]byte{pbUint(1, 1), pbLen(2, scalar)}, nil)
+}
+
+func xExprMember(members ...string) []byte {
+	var path [][]byte
+	for _, m := range members {
+		path = append(path, pbLen(1, pbUint(1, 1), pbStr(2, m)))
+	}
+	return bytes.Join([][]byte{pbUint(1, mysqlxExprIdent), pbLen(2, path...)}, nil)
+}
+
+func xExprColumn(name string) []byte {
+	return bytes.Join([][]byte{pbUint(1, mysqlxExprIdent), pbLen(2, pbStr(2, name))}, nil)
+}
+
+func xExprLiteral(scalar []byte) []byte {
+	return bytes.Join([][]byte{pbUint(1, mysqlxExprLiteral), pbLen(4, scalar)}, nil)
+}
+
+func xExprPlaceholder(pos uint64) []byte {
+	return bytes.Join([][]byte{pbUint(1, mysqlxExprPlaceholder), pbUint(7, pos)}, nil)
+}
+
+func xExprOperator(name string, params ...[]byte) []byte {
+	fields := [][]byte{pbStr(1, name)}
+	for _, p := range params {
+		fields = append(fields, pbLen(2, p))
+	}
+	return bytes.Join([][]byte{pbUint(1, mysqlxExprOperator), pbLen(6, fields...)}, nil)
+}
+
+func xCollection(schema, name string) []byte {
+	return bytes.Join([][]byte{pbStr(1, name), pbStr(2, schema)}, nil)
+}
+
+func xDecode(d Decoder, pkts ...MySQLPacket) []*MySQLEvent {
+	var events []*MySQLEvent
+	for _, pkt := range pkts {
+		if e := d.Decode(pkt); e != nil {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func xLogin(t *testing.T, d *MySQLXDecoder) {
+	events := xDecode(d,
+		xPacket(true, mysqlxConCapabilitiesGet),
+		xPacket(false, mysqlxConnCapabilities),
+		xPacket(true, mysqlxSessAuthenticateStart, pbStr(1, "MYSQL41")),
+		xPacket(false, mysqlxAuthenticateContinue, pbStr(1, "salt")),
+		xPacket(true, mysqlxSessAuthenticateContinue, pbStr(1, "shop\x00app\x00*ABCDEF")),
+		xPacket(false, mysqlxNotice, pbUint(1, 3), pbLen(3, pbUint(1, 7))),
+		xPacket(false, mysqlxAuthenticateOk),
+	)
+	ast := assert.New(t)
+	ast.Len(events, 1)
+	ast.Equal(util.EventHandshake, events[0].Type)
+	ast.Equal("app", events[0].Username)
+	ast.Equal("shop", events[0].DB)
+	ast.Equal("MYSQL41", events[0].AuthPlugin)
+	ast.False(events[0].Pr.HasError())
+}
+
+func TestMySQLXDecoder_Handshake(t *testing.T) {
+	xLogin(t, NewMySQLXDecoder(zap.NewNop()))
+}
+
+func TestMySQLXDecoder_HandshakeFail(t *testing.T) {
+	d := NewMySQLXDecoder(zap.NewNop())
+	events := xDecode(d,
+		xPacket(true, mysqlxSessAuthenticateStart, pbStr(1, "PLAIN"), pbStr(3, "shop\x00app\x00secret")),
+		xPacket(false, mysqlxError, pbUint(2, 1045), pbStr(3, "Access denied"), pbStr(4, "HY000")),
+	)
+	ast := assert.New(t)
+	ast.Len(events, 1)
+	ast.Equal(util.EventHandshake, events[0].Type)
+	ast.Equal("app", events[0].Username)
+	ast.True(events[0].Pr.HasError())
+	ast.Equal(uint16(1045), events[0].Pr.GetErrNo())
+}
+
+func TestMySQLXDecoder_StmtExecute(t *testing.T) {
+	d := NewMySQLXDecoder(zap.NewNop())
+	xLogin(t, d)
+	events := xDecode(d,
+		xPacket(true, mysqlxSQLStmtExecute, pbStr(1, "select id,name,price,ts from t where name = ? and id > ?"),
+			pbLen(2, xAnyScalar(xScalarString("it's"))), pbLen(2, xAnyScalar(xScalarSint(-1)))),
+		xPacket(false, mysqlxColumnMetaData, pbUint(1, mysqlxTypeSint), pbStr(2, "id"), pbUint(10, 11)),
+		xPacket(false, mysqlxColumnMetaData, pbUint(1, mysqlxTypeBytes), pbStr(2, "name"), pbUint(8, 255), pbUint(10, 80)),
+		xPacket(false, mysqlxColumnMetaData, pbUint(1, mysqlxTypeDecimal), pbStr(2, "price"), pbUint(9, 2)),
+		xPacket(false, mysqlxColumnMetaData, pbUint(1, mysqlxTypeDatetime), pbStr(2, "ts"), pbUint(10, 19)),
+		xPacket(false, mysqlxRow, pbLen(1, pbSint(0, 7)[1:]), pbLen(1, []byte("it's\x00")),
+			pbLen(1, []byte{2, 0x12, 0x34, 0x5d}), pbLen(1, []byte{0xe5, 0x0f, 11, 22, 15, 5})),
+		xPacket(false, mysqlxRow, pbLen(1), pbLen(1, []byte{0}), pbLen(1), pbLen(1)),
+		xPacket(false, mysqlxFetchDone),
+		xPacket(false, mysqlxNotice, pbUint(1, 3), pbLen(3, pbUint(1, 4))),
+		xPacket(false, mysqlxStmtExecuteOk),
+	)
+	ast := assert.New(t)
+	ast.Len(events, 1)
+	e := events[0]
+	ast.Equal(util.EventQuery, e.Type)
+	ast.Equal(`select id,name,price,ts from t where name = 'it\'s' and id > -1`, e.Query)
+	ast.Equal([]string{"id", "name", "price", "ts"}, e.Pr.GetColumnNames())
+	ast.Equal([]string{"INT", "VARCHAR", "DECIMAL", "DATETIME"}, []string{e.Pr.GetColumns()[0].Type,
+		e.Pr.GetColumns()[1].Type, e.Pr.GetColumns()[2].Type, e.Pr.GetColumns()[3].Type})
+	ast.Equal([][]driver.Value{
+		{int64(7), []byte("it's"), "-123.45", "2021-11-22 15:05:00"},
+		{nil, []byte{}, nil, nil},
+	}, e.Pr.GetColumnVal())
+
+	rows, err := ConvertRows(e.Pr.GetColumnVal(), e.Pr.GetColumns())
+	ast.Nil(err)
+	ast.Equal(Value{Kind: KindDecimal, Data: "-123.45"}, rows[0][2])
+	ast.Equal(Value{Kind: KindTemporal, Data: "2021-11-22 15:05:00"}, rows[0][3])
+	ast.Equal(Value{Kind: KindString}, rows[1][1])
+}
+
+func TestMySQLXDecoder_Error(t *testing.T) {
+	d := NewMySQLXDecoder(zap.NewNop())
+	xLogin(t, d)
+	events := xDecode(d,
+		xPacket(true, mysqlxSQLStmtExecute, pbStr(1, "select * from missing")),
+		xPacket(false, mysqlxError, pbUint(2, 1146), pbStr(3, "Table 'shop.missing' doesn't exist"), pbStr(4, "42S02")),
+	)
+	ast := assert.New(t)
+	ast.Len(events, 1)
+	ast.Equal(util.EventQuery, events[0].Type)
+	ast.True(events[0].Pr.HasError())
+	ast.Equal("42S02", events[0].Pr.GetSQLState())
+	ast.Equal(util.ErrCategoryServer, events[0].Pr.GetErrCategory())
+}
+
+func TestMySQLXDecoder_Crud(t *testing.T) {
+	d := NewMySQLXDecoder(zap.NewNop())
+	xLogin(t, d)
+	doc := bytes.Join([][]byte{pbUint(1, mysqlxExprObject),
+		pbLen(8, pbLen(1, pbStr(1, "name"), pbLen(2, xExprLiteral(xScalarString("bob")))))}, nil)
+	events := xDecode(d,
+		xPacket(true, mysqlxCrudFind, pbLen(2, xCollection("shop", "users")),
+			pbLen(5, xExprOperator("==", xExprMember("name"), xExprPlaceholder(0))),
+			pbLen(11, xScalarString("bob")),
+			pbLen(7, pbLen(1, xExprMember("age")), pbUint(2, 2)),
+			pbLen(6, pbUint(1, 10), pbUint(2, 20))),
+		xPacket(false, mysqlxColumnMetaData, pbUint(1, mysqlxTypeBytes), pbStr(2, "doc"), pbUint(8, 63), pbUint(12, mysqlxContentJSON)),
+		xPacket(false, mysqlxRow, pbLen(1, []byte(`{"name": "bob"}`+"\x00"))),
+		xPacket(false, mysqlxFetchDone),
+		xPacket(false, mysqlxStmtExecuteOk),
+		xPacket(true, mysqlxCrudInsert, pbLen(1, xCollection("shop", "users")), pbLen(4, pbLen(1, doc))),
+		xPacket(false, mysqlxNotice, pbUint(1, 3), pbLen(3, pbUint(1, 12), pbLen(2, pbUint(1, mysqlxScalarOctets), pbLen(5, pbStr(1, "0001"))))),
+		xPacket(false, mysqlxStmtExecuteOk),
+		xPacket(true, mysqlxCrudUpdate, pbLen(2, xCollection("shop", "users")),
+			pbLen(4, xExprOperator("==", xExprMember("_id"), xExprLiteral(xScalarString("0001")))),
+			pbLen(7, pbLen(1, pbLen(1, pbUint(1, 1), pbStr(2, "age"))), pbUint(2, mysqlxUpdateItemSet),
+				pbLen(3, xExprLiteral(xScalarSint(30))))),
+		xPacket(false, mysqlxStmtExecuteOk),
+		xPacket(true, mysqlxCrudDelete, pbLen(1, xCollection("", "orders")), pbUint(2, 2),
+			pbLen(3, xExprOperator("in", xExprColumn("id"), xExprLiteral(xScalarSint(1)), xExprLiteral(xScalarSint(2)))),
+			pbLen(4, pbUint(1, 5))),
+		xPacket(false, mysqlxStmtExecuteOk),
+	)
+	ast := assert.New(t)
+	ast.Len(events, 4)
+	ast.Equal("SELECT doc FROM `shop`.`users` WHERE (JSON_EXTRACT(doc,'$.name') = 'bob') "+
+		"ORDER BY JSON_EXTRACT(doc,'$.age') DESC LIMIT 20,10", events[0].Query)
+	ast.Equal("JSON", events[0].Pr.GetColumns()[0].Type)
+	ast.Equal([][]driver.Value{{[]byte(`{"name": "bob"}`)}}, events[0].Pr.GetColumnVal())
+	ast.Equal("INSERT INTO `shop`.`users` (doc) VALUES (JSON_SET(JSON_OBJECT('name','bob'),'$._id','0001'))", events[1].Query)
+	ast.Equal("UPDATE `shop`.`users` SET doc=JSON_SET(doc,'$.age',30) WHERE (JSON_EXTRACT(doc,'$._id') = '0001')", events[2].Query)
+	ast.Equal("DELETE FROM `orders` WHERE (`id` IN (1,2)) LIMIT 5", events[3].Query)
+	for _, e := range events {
+		ast.Equal(util.EventQuery, e.Type)
+		ast.False(e.Pr.HasError())
+	}
+}
+
+func TestMySQLXDecoder_Prepare(t *testing.T) {
+	d := NewMySQLXDecoder(zap.NewNop())
+	xLogin(t, d)
+	find := bytes.Join([][]byte{pbLen(2, xCollection("shop", "t")), pbUint(3, 2),
+		pbLen(5, xExprOperator("&&",
+			xExprOperator(">", xExprColumn("a"), xExprPlaceholder(1)),
+			xExprOperator("<", xExprColumn("b"), xExprPlaceholder(0))))}, nil)
+	events := xDecode(d,
+		xPacket(true, mysqlxPreparePrepare, pbUint(1, 3), pbLen(2, pbUint(1, 1), pbLen(2, find))),
+		xPacket(false, mysqlxOk),
+		xPacket(true, mysqlxPrepareExecute, pbUint(1, 3),
+			pbLen(2, xAnyScalar(xScalarSint(5))), pbLen(2, xAnyScalar(xScalarString("x")))),
+		xPacket(false, mysqlxFetchDone),
+		xPacket(false, mysqlxStmtExecuteOk),
+		xPacket(true, mysqlxPrepareDeallocate, pbUint(1, 3)),
+		xPacket(false, mysqlxOk),
+		xPacket(true, mysqlxSessClose),
+		xPacket(false, mysqlxOk),
+	)
+	ast := assert.New(t)
+	ast.Len(events, 4)
+	ast.Equal(util.EventStmtPrepare, events[0].Type)
+	ast.Equal("3", events[0].StmtID)
+	ast.Equal("SELECT * FROM `shop`.`t` WHERE ((`a` > ?) AND (`b` < ?))", events[0].Query)
+	ast.Equal(util.EventStmtExecute, events[1].Type)
+	ast.Equal([]interface{}{"x", int64(5)}, events[1].Params)
+	ast.Equal(util.EventStmtClose, events[2].Type)
+	ast.Equal("3", events[2].StmtID)
+	ast.Equal(util.EventQuit, events[3].Type)
+}
+
+func TestMySQLXFramer(t *testing.T) {
+	f := mysqlxProtocol{}.NewFramer()
+	ast := assert.New(t)
+	ast.Equal(4, f.HeaderLen())
+	n, seq := f.Lookup(bytes.NewBuffer([]byte{5, 0}), pgClient)
+	ast.Equal(-1, n)
+	ast.Equal(-1, seq)
+	n, seq = f.Lookup(bytes.NewBuffer(xMsg(mysqlxSQLStmtExecute, pbStr(1, "select 1"))), pgClient)
+	ast.Equal(11, n)
+	ast.Equal(0, seq)
+}
+
+func TestMySQLXColumnValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		column mysqlxColumn
+		data   []byte
+		expect driver.Value
+	}{
+		{"uint", mysqlxColumn{typ: mysqlxTypeUint}, []byte{0xac, 0x02}, uint64(300)},
+		{"decimal", mysqlxColumn{typ: mysqlxTypeDecimal}, []byte{3, 0x00, 0x5c}, "0.005"},
+		{"time", mysqlxColumn{typ: mysqlxTypeTime, fractionalDigits: 3}, []byte{1, 25, 3, 4, 0xa0, 0x8d, 0x06}, "-25:03:04.100"},
+		{"date", mysqlxColumn{typ: mysqlxTypeDatetime, length: 10}, []byte{0xe5, 0x0f, 1, 2}, "2021-01-02"},
+		{"set", mysqlxColumn{typ: mysqlxTypeSet}, []byte{1, 'a', 1, 'b'}, []byte("a,b")},
+		{"empty set", mysqlxColumn{typ: mysqlxTypeSet}, []byte{1}, []byte{}},
+		{"bit", mysqlxColumn{typ: mysqlxTypeBit, length: 10}, []byte{0x81, 0x02}, []byte{1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := tt.column.value(tt.data)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expect, v)
+		})
+	}
+}
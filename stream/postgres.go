@@ -0,0 +1,808 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package stream
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/google/gopacket/reassembly"
+	"github.com/lib/pq"
+	"github.com/lib/pq/oid"
+	"go.uber.org/zap"
+)
+
+//codes of the untyped messages sent by client before startup
+const (
+	pgProtocolVersion3 = 196608
+	pgCancelRequest    = 80877102
+	pgSSLRequest       = 80877103
+	pgGSSENCRequest    = 80877104
+)
+
+type postgresProtocol struct{}
+
+func (postgresProtocol) Name() string { return ProtocolPostgres }
+
+func (postgresProtocol) NewFramer() Framer { return &postgresFramer{} }
+
+func (postgresProtocol) NewDecoder(conn ConnID, log *zap.Logger) Decoder {
+	return NewPostgresDecoder(log)
+}
+
+//PostgreSQL v3 message: 1 byte type , 4 bytes length (length included) ,
+//the startup messages of client have no type byte.
+//The type byte is kept in the packet data , so the header length is 0
+type postgresFramer struct {
+	//client sent SSLRequest or GSSENCRequest , server answers a single byte
+	sslPending bool
+}
+
+func (f *postgresFramer) HeaderLen() int { return 0 }
+
+func (f *postgresFramer) Lookup(buf *bytes.Buffer, dir reassembly.TCPFlowDirection) (int, int) {
+	bs := buf.Bytes()
+	if dir == reassembly.TCPDirServerToClient && f.sslPending {
+		if len(bs) < 1 {
+			return -1, -1
+		}
+		if bs[0] == 'S' || bs[0] == 'N' || bs[0] == 'G' {
+			return 1, 0
+		}
+	}
+	if dir == reassembly.TCPDirClientToServer && len(bs) > 0 && bs[0] == 0 {
+		//untyped startup message , length is always less than 16M
+		if len(bs) < 4 {
+			return -1, -1
+		}
+		return pgMessageLen(binary.BigEndian.Uint32(bs)), 0
+	}
+	if len(bs) < 5 {
+		return -1, -1
+	}
+	return 1 + pgMessageLen(binary.BigEndian.Uint32(bs[1:])), 0
+}
+
+func pgMessageLen(n uint32) int {
+	//length includes itself , a smaller value is a broken stream ,
+	//return the minimal length to make progress
+	if n < 4 {
+		return 4
+	}
+	return int(n)
+}
+
+func (f *postgresFramer) Consumed(pkt *MySQLPacket) {
+	if pkt.Dir == reassembly.TCPDirClientToServer {
+		if len(pkt.Data) == 8 && pkt.Data[0] == 0 {
+			code := binary.BigEndian.Uint32(pkt.Data[4:])
+			if code == pgSSLRequest || code == pgGSSENCRequest {
+				f.sslPending = true
+			}
+		}
+	} else if f.sslPending {
+		f.sslPending = false
+	}
+}
+
+type pgColumn struct {
+	name   string
+	oid    uint32
+	mod    int32
+	format int16
+}
+
+func (c pgColumn) columnInfo() ColumnInfo {
+	ci := ColumnInfo{
+		Name: c.name,
+		Type: oid.TypeName[oid.Oid(c.oid)],
+	}
+	if c.oid == uint32(oid.T_numeric) {
+		//the same scale as database/sql reports for lib/pq
+		ci.Decimals = uint8((c.mod - 4) & 0xffff)
+	}
+	return ci
+}
+
+type pgStmt struct {
+	query   string
+	types   []uint32
+	columns []pgColumn
+}
+
+type pgPortal struct {
+	stmt    string
+	params  []interface{}
+	formats []int16
+	columns []pgColumn
+}
+
+//a client request waiting for the response of server
+type pgRequest struct {
+	typ    byte
+	name   string //statement name for Parse/Execute/Describe
+	query  string
+	params []interface{}
+	//describe target , 'S' statement or 'P' portal
+	target byte
+	pr     *PacketRes
+	rows   [][]byte
+	//the first result set of a simple query is complete
+	done bool
+}
+
+//PostgresDecoder decodes the PostgreSQL v3 protocol (simple and extended query)
+type PostgresDecoder struct {
+	log *zap.Logger
+
+	//session info
+	username    string
+	database    string
+	authMethod  string
+	unsupported bool
+
+	stmts   map[string]*pgStmt
+	portals map[string]*pgPortal
+	//requests in order , the server answers in the same order
+	reqs []*pgRequest
+}
+
+func NewPostgresDecoder(log *zap.Logger) *PostgresDecoder {
+	return &PostgresDecoder{
+		log:     log,
+		stmts:   map[string]*pgStmt{},
+		portals: map[string]*pgPortal{},
+	}
+}
+
+func (d *PostgresDecoder) Username() string { return d.username }
+
+func (d *PostgresDecoder) Database() string { return d.database }
+
+func (d *PostgresDecoder) AuthMethod() string { return d.authMethod }
+
+func (d *PostgresDecoder) Decode(pkt MySQLPacket) *MySQLEvent {
+	if d.unsupported || len(pkt.Data) == 0 {
+		return nil
+	}
+	if pkt.Dir == reassembly.TCPDirClientToServer {
+		return d.handleClient(pkt)
+	}
+	return d.handleServer(pkt)
+}
+
+func (d *PostgresDecoder) push(pkt MySQLPacket, req *pgRequest) {
+	req.pr = new(PacketRes)
+	req.pr.sqlBeginTime = uint64(pkt.Time.UnixNano())
+	d.reqs = append(d.reqs, req)
+}
+
+func (d *PostgresDecoder) head() *pgRequest {
+	if len(d.reqs) == 0 {
+		return nil
+	}
+	return d.reqs[0]
+}
+
+func (d *PostgresDecoder) pop() {
+	if len(d.reqs) > 0 {
+		d.reqs = d.reqs[1:]
+	}
+}
+
+func (d *PostgresDecoder) handleClient(pkt MySQLPacket) *MySQLEvent {
+	data := pkt.Data
+	if data[0] == 0 {
+		d.handleStartup(pkt)
+		return nil
+	}
+	r := &pgReader{data: data[5:]}
+	switch data[0] {
+	case 'Q':
+		d.push(pkt, &pgRequest{typ: 'Q', query: r.cstring()})
+	case 'P':
+		name, query := r.cstring(), r.cstring()
+		n := r.count(4)
+		types := make([]uint32, 0, n)
+		for i := 0; i < n; i++ {
+			types = append(types, uint32(r.int32()))
+		}
+		d.stmts[name] = &pgStmt{query: query, types: types}
+		d.push(pkt, &pgRequest{typ: 'P', name: name, query: query})
+	case 'B':
+		d.handleBind(pkt, r)
+	case 'D':
+		target := r.byte()
+		d.push(pkt, &pgRequest{typ: 'D', target: target, name: r.cstring()})
+	case 'E':
+		name := r.cstring()
+		req := &pgRequest{typ: 'E', query: name}
+		if portal, ok := d.portals[name]; ok {
+			req.name = portal.stmt
+			req.params = portal.params
+		}
+		d.push(pkt, req)
+	case 'C':
+		target, name := r.byte(), r.cstring()
+		d.push(pkt, &pgRequest{typ: 'C'})
+		if target == 'P' {
+			delete(d.portals, name)
+			return nil
+		}
+		//no response is needed to replay a closed statement
+		delete(d.stmts, name)
+		e := d.newEvent(pkt, &pgRequest{pr: new(PacketRes)}, util.EventStmtClose)
+		e.StmtID = name
+		e.Pr.sqlBeginTime = e.Pr.sqlEndTime
+		return e
+	case 'S':
+		d.push(pkt, &pgRequest{typ: 'S'})
+	case 'X':
+		e := d.newEvent(pkt, &pgRequest{pr: new(PacketRes)}, util.EventQuit)
+		e.Pr.sqlBeginTime = e.Pr.sqlEndTime
+		return e
+	default:
+		//Flush , copy data and function call are not replayed
+		d.log.Debug(fmt.Sprintf("skip client message %c", data[0]))
+	}
+	if r.err != nil {
+		d.log.Warn("read client message fail , " + r.err.Error())
+		d.corrupt(r)
+	}
+	return nil
+}
+
+//corrupt stops decoding the connection after a corrupt message , the
+//following messages can not be trusted
+func (d *PostgresDecoder) corrupt(r *pgReader) {
+	if r.corrupt {
+		d.log.Warn("skip the connection after a corrupt message")
+		d.unsupported = true
+	}
+}
+
+func (d *PostgresDecoder) handleStartup(pkt MySQLPacket) {
+	r := &pgReader{data: pkt.Data[4:]}
+	code := uint32(r.int32())
+	switch code {
+	case pgProtocolVersion3:
+		for {
+			key := r.cstring()
+			if len(key) == 0 || r.err != nil {
+				break
+			}
+			val := r.cstring()
+			switch key {
+			case "user":
+				d.username = val
+			case "database":
+				d.database = val
+			}
+		}
+		if len(d.database) == 0 {
+			d.database = d.username
+		}
+		d.push(pkt, &pgRequest{typ: 0})
+	case pgSSLRequest, pgGSSENCRequest, pgCancelRequest:
+		//wait for the answer of server or the next connection
+	default:
+		d.log.Warn(fmt.Sprintf("unsupported startup message , code %d", code))
+		d.unsupported = true
+	}
+}
+
+func (d *PostgresDecoder) handleBind(pkt MySQLPacket, r *pgReader) {
+	portal := &pgPortal{}
+	name := r.cstring()
+	portal.stmt = r.cstring()
+	stmt, ok := d.stmts[portal.stmt]
+	if !ok {
+		stmt = &pgStmt{}
+	}
+	formats := r.formats()
+	n := r.count(4)
+	for i := 0; i < n && r.err == nil; i++ {
+		val := r.value()
+		if val == nil {
+			portal.params = append(portal.params, nil)
+			continue
+		}
+		var typ uint32
+		if i < len(stmt.types) {
+			typ = stmt.types[i]
+		}
+		format := pgFormat(formats, i)
+		if format == 0 {
+			//text parameters are sent as text again when replay
+			portal.params = append(portal.params, string(val))
+			continue
+		}
+		portal.params = append(portal.params, decodePgValue(val, typ, format))
+	}
+	portal.formats = r.formats()
+	portal.columns = pgSetFormats(stmt.columns, portal.formats)
+	d.portals[name] = portal
+	d.push(pkt, &pgRequest{typ: 'B', name: portal.stmt})
+}
+
+func (d *PostgresDecoder) handleServer(pkt MySQLPacket) *MySQLEvent {
+	data := pkt.Data
+	if len(data) == 1 {
+		//answer of SSLRequest or GSSENCRequest
+		if data[0] != 'N' {
+			d.log.Warn("handshake: ssl connection is not supported")
+			d.unsupported = true
+		}
+		return nil
+	}
+	if len(data) < 5 {
+		return nil
+	}
+	r := &pgReader{data: data[5:]}
+	req := d.head()
+	switch data[0] {
+	case 'R':
+		switch r.int32() {
+		case 0:
+			if len(d.authMethod) == 0 {
+				d.authMethod = "trust"
+			}
+		case 3:
+			d.authMethod = "password"
+		case 5:
+			d.authMethod = "md5"
+		case 10:
+			d.authMethod = r.cstring()
+		}
+	case 'E':
+		return d.handleError(pkt, r)
+	case 'Z':
+		return d.handleReady(pkt)
+	case '1':
+		if req != nil && req.typ == 'P' {
+			d.pop()
+			return d.newEvent(pkt, req, util.EventStmtPrepare)
+		}
+	case '2', '3', 'n':
+		d.pop()
+	case 'T':
+		columns := r.columns()
+		if req == nil {
+			break
+		}
+		switch req.typ {
+		case 'D':
+			if req.target == 'S' {
+				if stmt, ok := d.stmts[req.name]; ok {
+					stmt.columns = columns
+				}
+			} else if portal, ok := d.portals[req.name]; ok {
+				portal.columns = pgSetFormats(columns, portal.formats)
+			}
+			d.pop()
+		case 'Q':
+			if !req.done && req.pr.columns == nil {
+				req.pr.columns = make([]ColumnInfo, 0, len(columns))
+				for _, column := range columns {
+					req.pr.columns = append(req.pr.columns, column.columnInfo())
+				}
+				req.rows = nil
+				d.setPortalColumns("", columns)
+			}
+		}
+	case 'D':
+		if req != nil && (req.typ == 'Q' && !req.done || req.typ == 'E') {
+			row := make([]byte, len(data)-5)
+			copy(row, data[5:])
+			req.rows = append(req.rows, row)
+		}
+	case 'C', 'I', 's':
+		if req == nil {
+			break
+		}
+		if req.typ == 'Q' {
+			req.done = true
+		} else if req.typ == 'E' {
+			d.pop()
+			return d.newEvent(pkt, req, util.EventStmtExecute)
+		}
+	default:
+		//ParameterStatus , BackendKeyData , notice , ParameterDescription and copy
+	}
+	if r.err != nil {
+		d.log.Warn("read server message fail , " + r.err.Error())
+		d.corrupt(r)
+	}
+	return nil
+}
+
+//the simple query uses the unnamed portal to decode the rows
+func (d *PostgresDecoder) setPortalColumns(name string, columns []pgColumn) {
+	d.portals[name] = &pgPortal{columns: columns}
+}
+
+func (d *PostgresDecoder) handleError(pkt MySQLPacket, r *pgReader) *MySQLEvent {
+	var err MySQLError
+	for r.err == nil {
+		field := r.byte()
+		if field == 0 {
+			break
+		}
+		val := r.cstring()
+		switch field {
+		case 'C':
+			err.SQLState = val
+		case 'M':
+			err.Message = val
+		}
+	}
+	req := d.head()
+	if req == nil {
+		d.log.Warn("server error without request , " + err.Error())
+		return nil
+	}
+	req.pr.setError(&err)
+	switch req.typ {
+	case 0:
+		d.pop()
+		return d.newEvent(pkt, req, util.EventHandshake)
+	case 'Q', 'S':
+		//wait for ReadyForQuery
+		return nil
+	}
+
+	//the server skips the extended query messages until Sync
+	var failed *pgRequest
+	for len(d.reqs) > 0 && d.reqs[0].typ != 'S' {
+		r := d.reqs[0]
+		if failed == nil && (r.typ == 'P' || r.typ == 'E') {
+			failed = r
+		}
+		d.pop()
+	}
+	if failed == nil {
+		return nil
+	}
+	failed.pr = req.pr
+	if failed.typ == 'P' {
+		return d.newEvent(pkt, failed, util.EventStmtPrepare)
+	}
+	return d.newEvent(pkt, failed, util.EventStmtExecute)
+}
+
+func (d *PostgresDecoder) handleReady(pkt MySQLPacket) *MySQLEvent {
+	for len(d.reqs) > 0 {
+		req := d.reqs[0]
+		d.pop()
+		switch req.typ {
+		case 0:
+			return d.newEvent(pkt, req, util.EventHandshake)
+		case 'Q':
+			return d.newEvent(pkt, req, util.EventQuery)
+		case 'S':
+			return nil
+		}
+	}
+	return nil
+}
+
+func (d *PostgresDecoder) newEvent(pkt MySQLPacket, req *pgRequest, tp util.MysqlEventType) *MySQLEvent {
+	e := &MySQLEvent{
+		Conn: pkt.Conn,
+		Time: pkt.Time.UnixNano(),
+		Type: tp,
+		Pr:   req.pr,
+	}
+	e.Pr.sqlEndTime = uint64(pkt.Time.UnixNano())
+	switch tp {
+	case util.EventHandshake:
+		e.DB = d.database
+		e.Username = d.username
+		e.AuthPlugin = d.authMethod
+	case util.EventQuery:
+		e.Query = req.query
+		d.setRows(e.Pr, "", req.rows)
+	case util.EventStmtPrepare:
+		e.StmtID = req.name
+		e.Query = req.query
+	case util.EventStmtExecute:
+		e.StmtID = req.name
+		e.Params = req.params
+		d.setRows(e.Pr, req.query, req.rows)
+	}
+	return e
+}
+
+//decode the rows of a result set with the columns of the portal
+func (d *PostgresDecoder) setRows(pr *PacketRes, portal string, rows [][]byte) {
+	var columns []pgColumn
+	if p, ok := d.portals[portal]; ok {
+		columns = p.columns
+	}
+	if pr.columns == nil && columns != nil {
+		pr.columns = make([]ColumnInfo, 0, len(columns))
+		for _, column := range columns {
+			pr.columns = append(pr.columns, column.columnInfo())
+		}
+	}
+	if pr.HasError() {
+		return
+	}
+	pr.values = make([][]driver.Value, 0, len(rows))
+	for _, row := range rows {
+		r := &pgReader{data: row}
+		n := r.count(4)
+		values := make([]driver.Value, 0, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			val := r.value()
+			if val == nil {
+				values = append(values, nil)
+				continue
+			}
+			var column pgColumn
+			if i < len(columns) {
+				column = columns[i]
+			}
+			values = append(values, decodePgValue(val, column.oid, column.format))
+		}
+		if r.err != nil {
+			d.log.Warn("read data row fail , " + r.err.Error())
+			d.corrupt(r)
+			continue
+		}
+		pr.values = append(pr.values, values)
+	}
+}
+
+//format code of column i , no code means text and one code is used for all
+func pgFormat(formats []int16, i int) int16 {
+	switch {
+	case len(formats) == 0:
+		return 0
+	case len(formats) == 1:
+		return formats[0]
+	case i < len(formats):
+		return formats[i]
+	}
+	return 0
+}
+
+func pgSetFormats(columns []pgColumn, formats []int16) []pgColumn {
+	if columns == nil {
+		return nil
+	}
+	res := make([]pgColumn, len(columns))
+	for i := range columns {
+		res[i] = columns[i]
+		res[i].format = pgFormat(formats, i)
+	}
+	return res
+}
+
+//pgEpoch is the zero time of the binary date and timestamp
+var pgEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+//decodePgValue converts a value to the same type lib/pq returns , so the
+//captured value and the replayed value are compared in the same kind
+func decodePgValue(val []byte, typ uint32, format int16) driver.Value {
+	t := oid.Oid(typ)
+	if format == 1 {
+		switch {
+		case t == oid.T_int2 && len(val) == 2:
+			return int64(int16(binary.BigEndian.Uint16(val)))
+		case t == oid.T_int4 && len(val) == 4:
+			return int64(int32(binary.BigEndian.Uint32(val)))
+		case t == oid.T_int8 && len(val) == 8:
+			return int64(binary.BigEndian.Uint64(val))
+		case t == oid.T_float4 && len(val) == 4:
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(val)))
+		case t == oid.T_float8 && len(val) == 8:
+			return math.Float64frombits(binary.BigEndian.Uint64(val))
+		case t == oid.T_bool && len(val) == 1:
+			return val[0] != 0
+		case t == oid.T_date && len(val) == 4:
+			return pgEpoch.AddDate(0, 0, int(int32(binary.BigEndian.Uint32(val))))
+		case (t == oid.T_timestamp || t == oid.T_timestamptz) && len(val) == 8:
+			us := int64(binary.BigEndian.Uint64(val))
+			return pgEpoch.Add(time.Duration(us) * time.Microsecond)
+		case t == oid.T_char || t == oid.T_varchar || t == oid.T_text:
+			return string(val)
+		}
+		return cloneBytes(val)
+	}
+
+	s := string(val)
+	switch t {
+	case oid.T_char, oid.T_varchar, oid.T_text:
+		return s
+	case oid.T_bytea:
+		if b, err := parsePgBytea(val); err == nil {
+			return b
+		}
+	case oid.T_timestamptz, oid.T_timestamp, oid.T_date:
+		if ts, err := pq.ParseTimestamp(nil, s); err == nil {
+			return ts
+		}
+	case oid.T_time:
+		if ts, err := time.Parse("15:04:05", s); err == nil {
+			return ts
+		}
+	case oid.T_timetz:
+		if ts, err := time.Parse("15:04:05-07", s); err == nil {
+			return ts
+		}
+	case oid.T_bool:
+		return len(s) > 0 && s[0] == 't'
+	case oid.T_int8, oid.T_int4, oid.T_int2:
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+	case oid.T_float4, oid.T_float8:
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return cloneBytes(val)
+}
+
+//bytea in hex (\x0102) or escape format
+func parsePgBytea(s []byte) ([]byte, error) {
+	if len(s) >= 2 && s[0] == '\\' && s[1] == 'x' {
+		return hex.DecodeString(string(s[2:]))
+	}
+	res := make([]byte, 0, len(s))
+	for len(s) > 0 {
+		if s[0] != '\\' {
+			res = append(res, s[0])
+			s = s[1:]
+			continue
+		}
+		if len(s) >= 2 && s[1] == '\\' {
+			res = append(res, '\\')
+			s = s[2:]
+			continue
+		}
+		if len(s) < 4 {
+			return nil, fmt.Errorf("invalid bytea sequence %v", s)
+		}
+		b, err := strconv.ParseUint(string(s[1:4]), 8, 8)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, byte(b))
+		s = s[4:]
+	}
+	return res, nil
+}
+
+//pgReader reads the fields of a message , the first error is kept
+type pgReader struct {
+	data []byte
+	pos  int
+	err  error
+	//a count of the message is negative or larger than the message
+	corrupt bool
+}
+
+func (r *pgReader) need(n int) bool {
+	if r.err != nil {
+		return false
+	}
+	if r.pos+n > len(r.data) {
+		r.err = fmt.Errorf("message too short , need %d bytes at %d of %d", n, r.pos, len(r.data))
+		return false
+	}
+	return true
+}
+
+func (r *pgReader) byte() byte {
+	if !r.need(1) {
+		return 0
+	}
+	r.pos++
+	return r.data[r.pos-1]
+}
+
+func (r *pgReader) int16() int16 {
+	if !r.need(2) {
+		return 0
+	}
+	r.pos += 2
+	return int16(binary.BigEndian.Uint16(r.data[r.pos-2:]))
+}
+
+//count reads the int16 count of the fields of at least size bytes following it
+func (r *pgReader) count(size int) int {
+	n := int(r.int16())
+	if r.err != nil {
+		return 0
+	}
+	if n < 0 || n*size > len(r.data)-r.pos {
+		r.err = fmt.Errorf("invalid count %d at %d of %d", n, r.pos-2, len(r.data))
+		r.corrupt = true
+		return 0
+	}
+	return n
+}
+
+func (r *pgReader) int32() int32 {
+	if !r.need(4) {
+		return 0
+	}
+	r.pos += 4
+	return int32(binary.BigEndian.Uint32(r.data[r.pos-4:]))
+}
+
+func (r *pgReader) cstring() string {
+	if r.err != nil {
+		return ""
+	}
+	end := bytes.IndexByte(r.data[r.pos:], 0)
+	if end < 0 {
+		r.err = fmt.Errorf("string is not terminated at %d", r.pos)
+		return ""
+	}
+	s := string(r.data[r.pos : r.pos+end])
+	r.pos += end + 1
+	return s
+}
+
+//value with int32 length , -1 is NULL
+func (r *pgReader) value() []byte {
+	n := r.int32()
+	if n < 0 || r.err != nil {
+		return nil
+	}
+	if !r.need(int(n)) {
+		return nil
+	}
+	r.pos += int(n)
+	return r.data[r.pos-int(n) : r.pos]
+}
+
+func (r *pgReader) formats() []int16 {
+	n := r.count(2)
+	formats := make([]int16, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		formats = append(formats, r.int16())
+	}
+	return formats
+}
+
+//fields of RowDescription
+func (r *pgReader) columns() []pgColumn {
+	//an empty name and 18 bytes of the other fields at least
+	n := r.count(19)
+	columns := make([]pgColumn, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		c := pgColumn{name: r.cstring()}
+		r.int32() //table oid
+		r.int16() //column number
+		c.oid = uint32(r.int32())
+		r.int16() //type size
+		c.mod = r.int32()
+		c.format = r.int16()
+		columns = append(columns, c)
+	}
+	return columns
+}
@@ -0,0 +1,373 @@
+package stream
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/google/gopacket/reassembly"
+	"github.com/lib/pq/oid"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+const (
+	pgClient = reassembly.TCPDirClientToServer
+	pgServer = reassembly.TCPDirServerToClient
+)
+
+//build a typed message
+func pgMsg(typ byte, body ...[]byte) []byte {
+	data := []byte{typ, 0, 0, 0, 0}
+	for _, b := range body {
+		data = append(data, b...)
+	}
+	binary.BigEndian.PutUint32(data[1:], uint32(len(data)-1))
+	return data
+}
+
+func pgStr(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+func pgInt16(v int16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, uint16(v))
+	return b
+}
+
+func pgInt32(v int32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(v))
+	return b
+}
+
+func pgStartup(params ...string) []byte {
+	data := append(pgInt32(0), pgInt32(pgProtocolVersion3)...)
+	for _, p := range params {
+		data = append(data, pgStr(p)...)
+	}
+	data = append(data, 0)
+	binary.BigEndian.PutUint32(data, uint32(len(data)))
+	return data
+}
+
+func pgRowDescription(names []string, oids []oid.Oid) []byte {
+	body := pgInt16(int16(len(names)))
+	for i, name := range names {
+		body = append(body, pgStr(name)...)
+		body = append(body, pgInt32(0)...)
+		body = append(body, pgInt16(0)...)
+		body = append(body, pgInt32(int32(oids[i]))...)
+		body = append(body, pgInt16(-1)...)
+		body = append(body, pgInt32(-1)...)
+		body = append(body, pgInt16(0)...)
+	}
+	return pgMsg('T', body)
+}
+
+//nil is NULL
+func pgDataRow(values ...[]byte) []byte {
+	body := pgInt16(int16(len(values)))
+	for _, v := range values {
+		if v == nil {
+			body = append(body, pgInt32(-1)...)
+			continue
+		}
+		body = append(body, pgInt32(int32(len(v)))...)
+		body = append(body, v...)
+	}
+	return pgMsg('D', body)
+}
+
+func pgError(code, msg string) []byte {
+	body := []byte{'S'}
+	body = append(body, pgStr("ERROR")...)
+	body = append(body, 'C')
+	body = append(body, pgStr(code)...)
+	body = append(body, 'M')
+	body = append(body, pgStr(msg)...)
+	body = append(body, 0)
+	return pgMsg('E', body)
+}
+
+func pgReady() []byte {
+	return pgMsg('Z', []byte{'I'})
+}
+
+func newPgTestPacket(dir reassembly.TCPFlowDirection, data []byte) MySQLPacket {
+	return MySQLPacket{
+		Time: time.Now(),
+		Dir:  dir,
+		Len:  len(data),
+		Data: data,
+	}
+}
+
+//feed packets to decoder and return the events
+func pgDecode(d *PostgresDecoder, pkts ...MySQLPacket) []*MySQLEvent {
+	var events []*MySQLEvent
+	for _, pkt := range pkts {
+		if e := d.Decode(pkt); e != nil {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func pgLogin(t *testing.T, d *PostgresDecoder) {
+	events := pgDecode(d,
+		newPgTestPacket(pgClient, pgStartup("user", "app", "database", "shop")),
+		newPgTestPacket(pgServer, pgMsg('R', pgInt32(5), []byte("salt"))),
+		newPgTestPacket(pgClient, pgMsg('p', pgStr("md5xxxx"))),
+		newPgTestPacket(pgServer, pgMsg('R', pgInt32(0))),
+		newPgTestPacket(pgServer, pgMsg('S', pgStr("TimeZone"), pgStr("UTC"))),
+		newPgTestPacket(pgServer, pgMsg('K', pgInt32(1), pgInt32(2))),
+		newPgTestPacket(pgServer, pgReady()),
+	)
+	ast := assert.New(t)
+	ast.Len(events, 1)
+	ast.Equal(util.EventHandshake, events[0].Type)
+	ast.Equal("app", events[0].Username)
+	ast.Equal("shop", events[0].DB)
+	ast.Equal("md5", events[0].AuthPlugin)
+	ast.False(events[0].Pr.HasError())
+}
+
+func TestPostgresDecoder_Handshake(t *testing.T) {
+	pgLogin(t, NewPostgresDecoder(zap.NewNop()))
+}
+
+func TestPostgresDecoder_HandshakeFail(t *testing.T) {
+	d := NewPostgresDecoder(zap.NewNop())
+	events := pgDecode(d,
+		newPgTestPacket(pgClient, pgStartup("user", "app")),
+		newPgTestPacket(pgServer, pgError("28P01", "password authentication failed")),
+	)
+	ast := assert.New(t)
+	ast.Len(events, 1)
+	ast.Equal(util.EventHandshake, events[0].Type)
+	ast.Equal("app", events[0].DB)
+	ast.True(events[0].Pr.HasError())
+	ast.Equal("28P01", events[0].Pr.GetSQLState())
+	ast.Equal(util.ErrCategoryServer, events[0].Pr.GetErrCategory())
+}
+
+func TestPostgresDecoder_SimpleQuery(t *testing.T) {
+	d := NewPostgresDecoder(zap.NewNop())
+	pgLogin(t, d)
+	events := pgDecode(d,
+		newPgTestPacket(pgClient, pgMsg('Q', pgStr("select id,name,price from t"))),
+		newPgTestPacket(pgServer, pgRowDescription([]string{"id", "name", "price"},
+			[]oid.Oid{oid.T_int4, oid.T_text, oid.T_numeric})),
+		newPgTestPacket(pgServer, pgDataRow([]byte("1"), []byte(""), []byte("1.50"))),
+		newPgTestPacket(pgServer, pgDataRow([]byte("2"), nil, nil)),
+		newPgTestPacket(pgServer, pgMsg('C', pgStr("SELECT 2"))),
+		newPgTestPacket(pgServer, pgReady()),
+	)
+	ast := assert.New(t)
+	ast.Len(events, 1)
+	e := events[0]
+	ast.Equal(util.EventQuery, e.Type)
+	ast.Equal("select id,name,price from t", e.Query)
+	ast.Equal([]string{"id", "name", "price"}, e.Pr.GetColumnNames())
+	ast.Equal("INT4", e.Pr.GetColumns()[0].Type)
+	ast.Equal([][]driver.Value{
+		{int64(1), "", []byte("1.50")},
+		{int64(2), nil, nil},
+	}, e.Pr.GetColumnVal())
+
+	rows, err := ConvertRows(e.Pr.GetColumnVal(), e.Pr.GetColumns())
+	ast.Nil(err)
+	ast.Equal(Value{Kind: KindDecimal, Data: "1.50"}, rows[0][2])
+	ast.Equal(Value{Kind: KindString}, rows[0][1])
+	ast.Equal(Value{Kind: KindNull}, rows[1][1])
+}
+
+func TestPostgresDecoder_SimpleQueryError(t *testing.T) {
+	d := NewPostgresDecoder(zap.NewNop())
+	pgLogin(t, d)
+	events := pgDecode(d,
+		newPgTestPacket(pgClient, pgMsg('Q', pgStr("select * from missing"))),
+		newPgTestPacket(pgServer, pgError("42P01", `relation "missing" does not exist`)),
+		newPgTestPacket(pgServer, pgReady()),
+	)
+	ast := assert.New(t)
+	ast.Len(events, 1)
+	ast.Equal(util.EventQuery, events[0].Type)
+	ast.Equal("42P01", events[0].Pr.GetSQLState())
+	ast.Equal(`relation "missing" does not exist`, events[0].Pr.GetErrDesc())
+	ast.Nil(events[0].Pr.GetColumnVal())
+}
+
+func TestPostgresDecoder_ExtendedQuery(t *testing.T) {
+	d := NewPostgresDecoder(zap.NewNop())
+	pgLogin(t, d)
+	bind := pgMsg('B', pgStr(""), pgStr("s1"),
+		pgInt16(1), pgInt16(1), //binary parameters
+		pgInt16(1), pgInt32(4), pgInt32(42),
+		pgInt16(0))
+	events := pgDecode(d,
+		//prepare
+		newPgTestPacket(pgClient, pgMsg('P', pgStr("s1"), pgStr("select name from t where id=$1"),
+			pgInt16(1), pgInt32(int32(oid.T_int4)))),
+		newPgTestPacket(pgClient, pgMsg('D', []byte{'S'}, pgStr("s1"))),
+		newPgTestPacket(pgClient, pgMsg('S')),
+		newPgTestPacket(pgServer, pgMsg('1')),
+		newPgTestPacket(pgServer, pgMsg('t', pgInt16(1), pgInt32(int32(oid.T_int4)))),
+		newPgTestPacket(pgServer, pgRowDescription([]string{"name"}, []oid.Oid{oid.T_varchar})),
+		newPgTestPacket(pgServer, pgReady()),
+		//execute
+		newPgTestPacket(pgClient, bind),
+		newPgTestPacket(pgClient, pgMsg('E', pgStr(""), pgInt32(0))),
+		newPgTestPacket(pgClient, pgMsg('S')),
+		newPgTestPacket(pgServer, pgMsg('2')),
+		newPgTestPacket(pgServer, pgDataRow([]byte("bob"))),
+		newPgTestPacket(pgServer, pgMsg('C', pgStr("SELECT 1"))),
+		newPgTestPacket(pgServer, pgReady()),
+		//close
+		newPgTestPacket(pgClient, pgMsg('C', []byte{'S'}, pgStr("s1"))),
+		newPgTestPacket(pgClient, pgMsg('S')),
+		newPgTestPacket(pgServer, pgMsg('3')),
+		newPgTestPacket(pgServer, pgReady()),
+		newPgTestPacket(pgClient, pgMsg('X')),
+	)
+	ast := assert.New(t)
+	ast.Len(events, 4)
+
+	ast.Equal(util.EventStmtPrepare, events[0].Type)
+	ast.Equal("s1", events[0].StmtID)
+	ast.Equal("select name from t where id=$1", events[0].Query)
+
+	ast.Equal(util.EventStmtExecute, events[1].Type)
+	ast.Equal("s1", events[1].StmtID)
+	ast.Equal([]interface{}{int64(42)}, events[1].Params)
+	ast.Equal([][]driver.Value{{"bob"}}, events[1].Pr.GetColumnVal())
+	ast.Equal([]string{"name"}, events[1].Pr.GetColumnNames())
+
+	ast.Equal(util.EventStmtClose, events[2].Type)
+	ast.Equal("s1", events[2].StmtID)
+	ast.NotNil(events[2].Pr)
+
+	ast.Equal(util.EventQuit, events[3].Type)
+	ast.Empty(d.reqs)
+}
+
+func TestPostgresDecoder_ExtendedQueryError(t *testing.T) {
+	d := NewPostgresDecoder(zap.NewNop())
+	pgLogin(t, d)
+	events := pgDecode(d,
+		newPgTestPacket(pgClient, pgMsg('P', pgStr(""), pgStr("insert into t values($1)"), pgInt16(0))),
+		newPgTestPacket(pgClient, pgMsg('B', pgStr(""), pgStr(""), pgInt16(0),
+			pgInt16(1), pgInt32(1), []byte("1"), pgInt16(0))),
+		newPgTestPacket(pgClient, pgMsg('E', pgStr(""), pgInt32(0))),
+		newPgTestPacket(pgClient, pgMsg('S')),
+		newPgTestPacket(pgServer, pgMsg('1')),
+		newPgTestPacket(pgServer, pgMsg('2')),
+		newPgTestPacket(pgServer, pgError("23505", "duplicate key value violates unique constraint")),
+		newPgTestPacket(pgServer, pgReady()),
+	)
+	ast := assert.New(t)
+	ast.Len(events, 2)
+	ast.Equal(util.EventStmtPrepare, events[0].Type)
+	ast.False(events[0].Pr.HasError())
+	ast.Equal(util.EventStmtExecute, events[1].Type)
+	ast.Equal([]interface{}{"1"}, events[1].Params)
+	ast.Equal("23505", events[1].Pr.GetSQLState())
+	ast.Empty(d.reqs)
+}
+
+func TestPostgresDecoder_CorruptCount(t *testing.T) {
+	execute := []MySQLPacket{
+		newPgTestPacket(pgClient, pgMsg('P', pgStr("s1"), pgStr("select 1"), pgInt16(0))),
+		newPgTestPacket(pgClient, pgMsg('B', pgStr(""), pgStr("s1"), pgInt16(0), pgInt16(0), pgInt16(0))),
+		newPgTestPacket(pgClient, pgMsg('E', pgStr(""), pgInt32(0))),
+		newPgTestPacket(pgServer, pgMsg('1')),
+		newPgTestPacket(pgServer, pgMsg('2')),
+	}
+	tests := []struct {
+		name string
+		pkts []MySQLPacket
+	}{
+		{"parse negative", []MySQLPacket{newPgTestPacket(pgClient, pgMsg('P', pgStr(""), pgStr(""), pgInt16(-1)))}},
+		{"parse too large", []MySQLPacket{newPgTestPacket(pgClient, pgMsg('P', pgStr(""), pgStr(""), pgInt16(1000)))}},
+		{"bind formats", []MySQLPacket{newPgTestPacket(pgClient, pgMsg('B', pgStr(""), pgStr(""), pgInt16(-2)))}},
+		{"bind params", []MySQLPacket{newPgTestPacket(pgClient, pgMsg('B', pgStr(""), pgStr(""), pgInt16(0), pgInt16(-3)))}},
+		{"row description", []MySQLPacket{newPgTestPacket(pgServer, pgMsg('T', pgInt16(-1)))}},
+		{"data row", append(execute,
+			newPgTestPacket(pgServer, pgMsg('D', pgInt16(-1))),
+			newPgTestPacket(pgServer, pgMsg('C', pgStr("SELECT 1"))),
+		)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewPostgresDecoder(zap.NewNop())
+			pgLogin(t, d)
+			assert.NotPanics(t, func() { pgDecode(d, tt.pkts...) })
+			assert.True(t, d.unsupported)
+		})
+	}
+}
+
+func TestPostgresFramer(t *testing.T) {
+	ast := assert.New(t)
+	f := &postgresFramer{}
+
+	ssl := append(pgInt32(8), pgInt32(pgSSLRequest)...)
+	length, seq := f.Lookup(bytes.NewBuffer(ssl), pgClient)
+	ast.Equal(8, length)
+	ast.Equal(0, seq)
+	f.Consumed(&MySQLPacket{Dir: pgClient, Data: ssl})
+
+	//the answer of SSLRequest is a single byte
+	length, _ = f.Lookup(bytes.NewBuffer([]byte{'N'}), pgServer)
+	ast.Equal(1, length)
+	f.Consumed(&MySQLPacket{Dir: pgServer, Data: []byte{'N'}})
+
+	//NoticeResponse after the answer
+	length, _ = f.Lookup(bytes.NewBuffer([]byte{'N'}), pgServer)
+	ast.Equal(-1, length)
+
+	startup := pgStartup("user", "app")
+	length, _ = f.Lookup(bytes.NewBuffer(startup), pgClient)
+	ast.Equal(len(startup), length)
+
+	query := pgMsg('Q', pgStr("select 1"))
+	length, _ = f.Lookup(bytes.NewBuffer(query[:4]), pgClient)
+	ast.Equal(-1, length)
+	length, _ = f.Lookup(bytes.NewBuffer(query), pgClient)
+	ast.Equal(len(query), length)
+}
+
+func Test_decodePgValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		val    []byte
+		typ    oid.Oid
+		format int16
+		want   driver.Value
+	}{
+		{name: "text int", val: []byte("-7"), typ: oid.T_int8, want: int64(-7)},
+		{name: "text bool", val: []byte("t"), typ: oid.T_bool, want: true},
+		{name: "text bytea", val: []byte(`\x00ff`), typ: oid.T_bytea, want: []byte{0, 0xff}},
+		{name: "text timestamp", val: []byte("2021-11-22 15:05:00.12"), typ: oid.T_timestamp,
+			want: time.Date(2021, 11, 22, 15, 5, 0, 120000000, time.FixedZone("", 0))},
+		{name: "text numeric", val: []byte("1.50"), typ: oid.T_numeric, want: []byte("1.50")},
+		{name: "binary int2", val: pgInt16(-2), typ: oid.T_int2, format: 1, want: int64(-2)},
+		{name: "binary float8", val: []byte{0x3f, 0xf8, 0, 0, 0, 0, 0, 0}, typ: oid.T_float8, format: 1, want: 1.5},
+		{name: "binary date", val: pgInt32(1), typ: oid.T_date, format: 1, want: time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decodePgValue(tt.val, uint32(tt.typ), tt.format)
+			if want, ok := tt.want.(time.Time); ok {
+				assert.True(t, want.Equal(got.(time.Time)), "%v != %v", want, got)
+				return
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,159 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package stream
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+//protobuf wire types
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+	pbFixed32 = 5
+)
+
+var errPbTruncated = errors.New("protobuf: truncated message")
+
+type pbField struct {
+	num  int
+	wire int
+	v    uint64 //varint , fixed32 and fixed64
+	b    []byte //length delimited
+}
+
+//pbMessage is a decoded protobuf message , only the wire format is
+//known , the fields are interpreted by the caller
+type pbMessage []pbField
+
+func pbDecode(data []byte) (pbMessage, error) {
+	var msg pbMessage
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errPbTruncated
+		}
+		data = data[n:]
+		f := pbField{num: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case pbVarint:
+			f.v, n = binary.Uvarint(data)
+			if n <= 0 {
+				return nil, errPbTruncated
+			}
+			data = data[n:]
+		case pbFixed64:
+			if len(data) < 8 {
+				return nil, errPbTruncated
+			}
+			f.v = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case pbFixed32:
+			if len(data) < 4 {
+				return nil, errPbTruncated
+			}
+			f.v = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		case pbBytes:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < l {
+				return nil, errPbTruncated
+			}
+			f.b = data[n : n+int(l)]
+			data = data[n+int(l):]
+		default:
+			return nil, errors.New("protobuf: unsupported wire type")
+		}
+		msg = append(msg, f)
+	}
+	return msg, nil
+}
+
+func (m pbMessage) field(num int) (pbField, bool) {
+	//the last one wins for a non repeated field
+	for i := len(m) - 1; i >= 0; i-- {
+		if m[i].num == num {
+			return m[i], true
+		}
+	}
+	return pbField{}, false
+}
+
+func (m pbMessage) Has(num int) bool {
+	_, ok := m.field(num)
+	return ok
+}
+
+func (m pbMessage) Uint(num int) uint64 {
+	f, _ := m.field(num)
+	return f.v
+}
+
+//zigzag encoded sint64
+func (m pbMessage) Sint(num int) int64 {
+	v := m.Uint(num)
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (m pbMessage) Bool(num int) bool {
+	return m.Uint(num) != 0
+}
+
+func (m pbMessage) Double(num int) float64 {
+	return math.Float64frombits(m.Uint(num))
+}
+
+func (m pbMessage) Float(num int) float32 {
+	return math.Float32frombits(uint32(m.Uint(num)))
+}
+
+func (m pbMessage) Bytes(num int) []byte {
+	f, _ := m.field(num)
+	return f.b
+}
+
+func (m pbMessage) String(num int) string {
+	return string(m.Bytes(num))
+}
+
+func (m pbMessage) Message(num int) (pbMessage, error) {
+	return pbDecode(m.Bytes(num))
+}
+
+func (m pbMessage) Repeated(num int) [][]byte {
+	var res [][]byte
+	for _, f := range m {
+		if f.num == num && f.wire == pbBytes {
+			res = append(res, f.b)
+		}
+	}
+	return res
+}
+
+func (m pbMessage) RepeatedMessage(num int) ([]pbMessage, error) {
+	var res []pbMessage
+	for _, b := range m.Repeated(num) {
+		msg, err := pbDecode(b)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, msg)
+	}
+	return res, nil
+}
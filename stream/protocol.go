@@ -0,0 +1,149 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package stream
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/bobguo/mysql-replay/util"
+	"github.com/google/gopacket/reassembly"
+	"go.uber.org/zap"
+)
+
+const (
+	ProtocolMySQL    = util.ProtocolMySQL
+	ProtocolPostgres = util.ProtocolPostgres
+)
+
+//Protocol is the wire protocol of a database server , the stream splits
+//the tcp data of a connection into packets with the Framer and the
+//Decoder turns the packets into protocol neutral events
+type Protocol interface {
+	Name() string
+	NewFramer() Framer
+	NewDecoder(conn ConnID, log *zap.Logger) Decoder
+}
+
+//Framer finds packet boundaries in the reassembled data of one connection
+type Framer interface {
+	//length of the packet header , the header is not part of the packet data
+	HeaderLen() int
+	//Lookup returns the data length and the sequence of the next packet
+	//in buf , length or seq is -1 if buf does not hold a complete header
+	Lookup(buf *bytes.Buffer, dir reassembly.TCPFlowDirection) (length int, seq int)
+	//Consumed is called after a packet is cut from the stream
+	Consumed(pkt *MySQLPacket)
+}
+
+//Decoder keeps the protocol state of one connection
+type Decoder interface {
+	//Decode handles a packet and returns the event completed by it ,
+	//the result read from packets is attached to the event as PacketRes
+	Decode(pkt MySQLPacket) *MySQLEvent
+}
+
+//StateDecoder is implemented by decoders which expose the protocol state ,
+//it is used to trace the packets of a connection
+type StateDecoder interface {
+	Decoder
+	//StateName is the name of the current state
+	StateName() string
+	//Unparsed tells if the last packet is skipped by the decoder and why
+	Unparsed() (bool, string)
+}
+
+//PacketTrace is a packet handled by the decoder , states are empty if
+//the decoder does not expose its state
+type PacketTrace struct {
+	Packet   MySQLPacket
+	From     string
+	To       string
+	Unparsed bool
+	Reason   string
+}
+
+//PacketTracer is implemented by event handlers which want to see every
+//packet of a connection , OnPacket is called before the event completed
+//by the packet is passed to OnEvent
+type PacketTracer interface {
+	OnPacket(t PacketTrace)
+}
+
+var (
+	protocolsMu sync.RWMutex
+	protocols   = map[string]Protocol{}
+)
+
+func RegisterProtocol(p Protocol) {
+	protocolsMu.Lock()
+	defer protocolsMu.Unlock()
+	protocols[p.Name()] = p
+}
+
+//GetProtocol returns the registered protocol , MySQL if name is empty
+func GetProtocol(name string) (Protocol, error) {
+	if len(name) == 0 {
+		name = ProtocolMySQL
+	}
+	protocolsMu.RLock()
+	defer protocolsMu.RUnlock()
+	p, ok := protocols[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown protocol %s", name)
+	}
+	return p, nil
+}
+
+func ProtocolNames() []string {
+	protocolsMu.RLock()
+	defer protocolsMu.RUnlock()
+	names := make([]string, 0, len(protocols))
+	for name := range protocols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterProtocol(mysqlProtocol{})
+	RegisterProtocol(postgresProtocol{})
+	RegisterProtocol(mysqlxProtocol{})
+}
+
+type mysqlProtocol struct{}
+
+func (mysqlProtocol) Name() string { return ProtocolMySQL }
+
+func (mysqlProtocol) NewFramer() Framer { return mysqlFramer{} }
+
+func (mysqlProtocol) NewDecoder(conn ConnID, log *zap.Logger) Decoder {
+	return NewMySQLFSM(log)
+}
+
+//MySQL packet: 3 bytes length , 1 byte sequence
+type mysqlFramer struct{}
+
+func (mysqlFramer) HeaderLen() int { return 4 }
+
+func (mysqlFramer) Lookup(buf *bytes.Buffer, dir reassembly.TCPFlowDirection) (int, int) {
+	return lookupPacketLen(buf), lookupPacketSeq(buf)
+}
+
+func (mysqlFramer) Consumed(pkt *MySQLPacket) {}
@@ -0,0 +1,26 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetProtocol(t *testing.T) {
+	ast := assert.New(t)
+
+	p, err := GetProtocol("")
+	ast.Nil(err)
+	ast.Equal(ProtocolMySQL, p.Name())
+	ast.Equal(4, p.NewFramer().HeaderLen())
+
+	p, err = GetProtocol(ProtocolPostgres)
+	ast.Nil(err)
+	ast.Equal(ProtocolPostgres, p.Name())
+	ast.Equal(0, p.NewFramer().HeaderLen())
+
+	_, err = GetProtocol("oracle")
+	ast.NotNil(err)
+
+	ast.Equal([]string{ProtocolMySQL, ProtocolMySQLX, ProtocolPostgres}, ProtocolNames())
+}
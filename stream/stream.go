@@ -6,6 +6,9 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/gopacket"
@@ -50,6 +53,47 @@ func (k ConnID) Reverse() ConnID {
 	return ConnID{k[0].Reverse(), k[1].Reverse()}
 }
 
+//ParseConnID builds the ConnID of a tcp connection from the addresses
+//returned by SrcAddr and DstAddr
+func ParseConnID(src string, dst string) (ConnID, error) {
+	srcIP, srcPort, err := parseAddr(src)
+	if err != nil {
+		return ConnID{}, err
+	}
+	dstIP, dstPort, err := parseAddr(dst)
+	if err != nil {
+		return ConnID{}, err
+	}
+	ipType := layers.EndpointIPv4
+	if srcIP.To4() == nil || dstIP.To4() == nil {
+		ipType = layers.EndpointIPv6
+		srcIP, dstIP = srcIP.To16(), dstIP.To16()
+	} else {
+		srcIP, dstIP = srcIP.To4(), dstIP.To4()
+	}
+	ports := gopacket.NewFlow(layers.EndpointTCPPort, srcPort, dstPort)
+	return ConnID{gopacket.NewFlow(ipType, srcIP, dstIP), ports}, nil
+}
+
+//ip:port , the port is returned in network byte order
+func parseAddr(addr string) (net.IP, []byte, error) {
+	i := strings.LastIndexByte(addr, ':')
+	if i < 0 {
+		return nil, nil, fmt.Errorf("invalid address %s", addr)
+	}
+	ip := net.ParseIP(addr[:i])
+	if ip == nil {
+		return nil, nil, fmt.Errorf("invalid ip of address %s", addr)
+	}
+	port, err := strconv.ParseUint(addr[i+1:], 10, 16)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid port of address %s", addr)
+	}
+	raw := make([]byte, 2)
+	binary.BigEndian.PutUint16(raw, uint16(port))
+	return ip, raw, nil
+}
+
 func (k ConnID) Hash() uint64 {
 	h := fnvHash(k[0].Src().Raw(), k[1].Src().Raw()) + fnvHash(k[0].Dst().Raw(), k[1].Dst().Raw())
 	h ^= uint64(k[0].EndpointType())
@@ -77,6 +121,8 @@ type FactoryOptions struct {
 	ConnCacheSize uint
 	Synchronized  bool
 	ForceStart    bool
+	//wire protocol name , default is MySQL
+	Protocol string
 }
 
 /*
@@ -91,8 +137,9 @@ func NewFactoryFromPacketHandler(factory func(ConnID) MySQLPacketHandler, opts F
 var _ reassembly.StreamFactory = &mysqlStreamFactory{}
 
 type mysqlStreamFactory struct {
-	new  func(key ConnID) MySQLPacketHandler
-	opts FactoryOptions
+	new   func(key ConnID) MySQLPacketHandler
+	opts  FactoryOptions
+	proto Protocol
 
 	//ts time.Time
 }
@@ -114,12 +161,13 @@ func (f *mysqlStreamFactory) New(netFlow, tcpFlow gopacket.Flow, tcp *layers.TCP
 
 	//stats.Add(stats.Streams, 1)
 	return &mysqlStream{
-		conn: conn,
-		log:  log,
-		ch:   ch,
-		done: done,
-		h:    h,
-		opts: f.opts,
+		conn:   conn,
+		log:    log,
+		ch:     ch,
+		done:   done,
+		h:      h,
+		opts:   f.opts,
+		framer: f.proto.NewFramer(),
 	}
 }
 
@@ -139,8 +187,9 @@ type mysqlStream struct {
 	ch   chan MySQLPacket
 	done chan struct{}
 
-	h    MySQLPacketHandler
-	opts FactoryOptions
+	h      MySQLPacketHandler
+	opts   FactoryOptions
+	framer Framer
 }
 
 func (s *mysqlStream) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir reassembly.TCPFlowDirection, nextSeq reassembly.Sequence, start *bool, ac reassembly.AssemblerContext) bool {
@@ -187,7 +236,7 @@ func (s *mysqlStream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.A
 
 	if buf == nil {
 		buf = bytes.NewBuffer(data)
-		if seq := lookupPacketSeq(buf); s.getBuf(!dir) == nil && seq != 0 {
+		if _, seq := s.framer.Lookup(buf, dir); s.getBuf(!dir) == nil && seq != 0 {
 			s.log.Warn("drop init packet with non-zero seq",
 				zap.String("dir", dir.String()), zap.String("data", formatData(data)))
 			return
@@ -207,6 +256,7 @@ func (s *mysqlStream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.A
 	s.setBuf(dir, buf)
 
 	cnt := 0
+	hdr := s.framer.HeaderLen()
 	for buf.Len() > 0 {
 		pkt := s.getPkt(dir)
 		if pkt == nil {
@@ -214,15 +264,14 @@ func (s *mysqlStream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.A
 				Conn: s.conn,
 				Time: ts,
 				Dir:  dir,
-				Len:  lookupPacketLen(buf),
-				Seq:  lookupPacketSeq(buf),
 			}
+			pkt.Len, pkt.Seq = s.framer.Lookup(buf, dir)
 		}
-		if pkt.Seq == -1 || buf.Len() < pkt.Len+4 {
+		if pkt.Seq == -1 || pkt.Len == -1 || buf.Len() < pkt.Len+hdr {
 			s.log.Debug("wait for more packet data", zap.String("dir", dir.String()),
 				zap.String("msg", fmt.Sprintf("pkt seq is %v,pkt len %v,buf len %v",
 					pkt.Seq, pkt.Len, buf.Len())))
-			if s.getPkt(dir) == nil && pkt.Seq >= 0 {
+			if s.getPkt(dir) == nil && pkt.Seq >= 0 && pkt.Len >= 0 {
 				s.setPkt(dir, pkt)
 			}
 			if ac == nil && cnt > 0 {
@@ -232,7 +281,8 @@ func (s *mysqlStream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.A
 			return
 		}
 		pkt.Data = make([]byte, pkt.Len)
-		copy(pkt.Data, buf.Next(pkt.Len + 4)[4:])
+		copy(pkt.Data, buf.Next(pkt.Len + hdr)[hdr:])
+		s.framer.Consumed(pkt)
 		cnt += 1
 		//stats.Add(stats.Packets, 1)
 		if s.opts.Synchronized {
@@ -388,6 +388,7 @@ func Test_mysqlStreamFactory_New_Synchronized(t *testing.T){
 		return &ForTest3{}
 	}
 	f.opts.Synchronized = true
+	f.proto, _ = GetProtocol(ProtocolMySQL)
 	f.New(netFlow,tcpFlow,tcp,ac)
 }
 
@@ -401,6 +402,7 @@ func Test_mysqlStreamFactory_New_Asynchronized(t *testing.T){
 		return &ForTest3{}
 	}
 	f.opts.Synchronized = false
+	f.proto, _ = GetProtocol(ProtocolMySQL)
 	f.New(netFlow,tcpFlow,tcp,ac)
 }
 
@@ -0,0 +1,263 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package stream
+
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+//ValueKind is the type of a value in a result set , values of
+//different kind are never equal even if their text is the same
+type ValueKind uint8
+
+const (
+	KindNull ValueKind = iota
+	KindInt
+	KindDecimal
+	KindFloat
+	KindTemporal
+	KindJSON
+	KindString
+	KindBinary
+)
+
+var valueKindNames = []string{
+	KindNull:     "null",
+	KindInt:      "int",
+	KindDecimal:  "decimal",
+	KindFloat:    "float",
+	KindTemporal: "temporal",
+	KindJSON:     "json",
+	KindString:   "string",
+	KindBinary:   "binary",
+}
+
+func (k ValueKind) String() string {
+	if int(k) < len(valueKindNames) {
+		return valueKindNames[k]
+	}
+	return "unknown"
+}
+
+func parseValueKind(s string) (ValueKind, error) {
+	for i, name := range valueKindNames {
+		if name == s {
+			return ValueKind(i), nil
+		}
+	}
+	return KindNull, fmt.Errorf("unknown value kind %s", s)
+}
+
+//Value is a typed value of a result set row.
+//Data is the canonical text of the value , binary data is kept as
+//raw bytes and encoded as base64 when serialised
+type Value struct {
+	Kind ValueKind
+	Data string
+}
+
+type jsonValue struct {
+	Type  string `json:"t"`
+	Value string `json:"v"`
+}
+
+//NULL is serialised as null , other values as {"t":kind,"v":text}
+func (v Value) MarshalJSON() ([]byte, error) {
+	if v.Kind == KindNull {
+		return []byte("null"), nil
+	}
+	data := v.Data
+	if v.Kind == KindBinary {
+		data = base64.StdEncoding.EncodeToString([]byte(v.Data))
+	}
+	return json.Marshal(jsonValue{Type: v.Kind.String(), Value: data})
+}
+
+func (v *Value) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*v = Value{Kind: KindNull}
+		return nil
+	}
+	var jv jsonValue
+	err := json.Unmarshal(b, &jv)
+	if err != nil {
+		return err
+	}
+	kind, err := parseValueKind(jv.Type)
+	if err != nil {
+		return err
+	}
+	data := jv.Value
+	if kind == KindBinary {
+		raw, err := base64.StdEncoding.DecodeString(jv.Value)
+		if err != nil {
+			return err
+		}
+		data = string(raw)
+	}
+	*v = Value{Kind: kind, Data: data}
+	return nil
+}
+
+func (v Value) IsNull() bool {
+	return v.Kind == KindNull
+}
+
+func (v Value) Equal(o Value) bool {
+	return v.Kind == o.Kind && v.Data == o.Data
+}
+
+func (v Value) String() string {
+	switch v.Kind {
+	case KindNull:
+		return "NULL"
+	case KindBinary:
+		return "0x" + fmt.Sprintf("%X", v.Data)
+	}
+	return v.Data
+}
+
+//NewValue converts a value read from packet or from replay server to a
+//typed value , col is the column definition of the value and may be nil
+func NewValue(v driver.Value, col *ColumnInfo) (Value, error) {
+	tp := ""
+	if col != nil {
+		tp = strings.TrimPrefix(col.Type, "UNSIGNED ")
+	}
+	switch x := v.(type) {
+	case nil:
+		return Value{Kind: KindNull}, nil
+	case Value:
+		//restored from event log
+		return x, nil
+	case int64:
+		return Value{Kind: KindInt, Data: strconv.FormatInt(x, 10)}, nil
+	case uint64:
+		return Value{Kind: KindInt, Data: strconv.FormatUint(x, 10)}, nil
+	case bool:
+		if x {
+			return Value{Kind: KindInt, Data: "1"}, nil
+		}
+		return Value{Kind: KindInt, Data: "0"}, nil
+	case float32:
+		return Value{Kind: KindFloat, Data: strconv.FormatFloat(float64(x), 'g', -1, 32)}, nil
+	case float64:
+		if tp == "FLOAT" || tp == "FLOAT4" {
+			return Value{Kind: KindFloat, Data: strconv.FormatFloat(x, 'g', -1, 32)}, nil
+		}
+		return Value{Kind: KindFloat, Data: strconv.FormatFloat(x, 'g', -1, 64)}, nil
+	case time.Time:
+		return Value{Kind: KindTemporal, Data: formatTemporal(x, col)}, nil
+	case []byte:
+		return newTextValue(string(x), tp)
+	case RawBytes:
+		return newTextValue(string(x), tp)
+	case string:
+		return newTextValue(x, tp)
+	}
+	return Value{}, fmt.Errorf("unsupported value type %T", v)
+}
+
+//value of text protocol (and strings of binary protocol) , the kind is
+//decided by the column type , the type names of MySQL and PostgreSQL
+//(as lib/pq reports) are both accepted
+func newTextValue(s string, tp string) (Value, error) {
+	tp = strings.TrimPrefix(tp, "UNSIGNED ")
+	switch tp {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "YEAR", "INT2", "INT4", "INT8":
+		return Value{Kind: KindInt, Data: s}, nil
+	case "DECIMAL", "NUMERIC":
+		return Value{Kind: KindDecimal, Data: s}, nil
+	case "FLOAT", "DOUBLE", "FLOAT4", "FLOAT8":
+		bitSize := 64
+		if tp == "FLOAT" || tp == "FLOAT4" {
+			bitSize = 32
+		}
+		f, err := strconv.ParseFloat(s, bitSize)
+		if err != nil {
+			return Value{}, err
+		}
+		return Value{Kind: KindFloat, Data: strconv.FormatFloat(f, 'g', -1, bitSize)}, nil
+	case "DATE", "DATETIME", "TIMESTAMP", "TIME", "TIMESTAMPTZ", "TIMETZ", "INTERVAL":
+		return Value{Kind: KindTemporal, Data: s}, nil
+	case "JSON", "JSONB":
+		return Value{Kind: KindJSON, Data: s}, nil
+	case "BIT", "BLOB", "TINYBLOB", "MEDIUMBLOB", "LONGBLOB", "BINARY", "VARBINARY", "GEOMETRY", "BYTEA":
+		return Value{Kind: KindBinary, Data: s}, nil
+	case "":
+		//no column definition
+		if !utf8.ValidString(s) {
+			return Value{Kind: KindBinary, Data: s}, nil
+		}
+	}
+	return Value{Kind: KindString, Data: s}, nil
+}
+
+func formatTemporal(t time.Time, col *ColumnInfo) string {
+	tp := ""
+	if col != nil {
+		tp = col.Type
+	}
+	switch tp {
+	case "DATE":
+		return t.Format("2006-01-02")
+	case "TIMESTAMPTZ":
+		//the session time zone of capture and replay may differ
+		return t.UTC().Format("2006-01-02 15:04:05.999999Z07:00")
+	case "TIME":
+		return t.Format("15:04:05.999999")
+	case "TIMETZ":
+		return t.Format("15:04:05.999999Z07:00")
+	}
+	if col == nil || col.Decimals == 0 {
+		return t.Format("2006-01-02 15:04:05.999999")
+	}
+	s := t.Format("2006-01-02 15:04:05")
+	if col.Decimals <= 6 {
+		frac := fmt.Sprintf("%09d", t.Nanosecond())
+		s += "." + frac[:col.Decimals]
+	}
+	return s
+}
+
+//ConvertRows converts all rows of a result set to typed values ,
+//columns may be nil or shorter than the row
+func ConvertRows(rows [][]driver.Value, columns []ColumnInfo) ([][]Value, error) {
+	res := make([][]Value, 0, len(rows))
+	for _, row := range rows {
+		vals := make([]Value, 0, len(row))
+		for i := range row {
+			var col *ColumnInfo
+			if i < len(columns) {
+				col = &columns[i]
+			}
+			v, err := NewValue(row[i], col)
+			if err != nil {
+				return nil, err
+			}
+			vals = append(vals, v)
+		}
+		res = append(res, vals)
+	}
+	return res, nil
+}
@@ -0,0 +1,91 @@
+package stream
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewValue(t *testing.T) {
+	tests := []struct {
+		name string
+		v    driver.Value
+		col  *ColumnInfo
+		want Value
+	}{
+		{name: "null", v: nil, col: &ColumnInfo{Type: "VARCHAR"}, want: Value{Kind: KindNull}},
+		{name: "empty string", v: []byte(""), col: &ColumnInfo{Type: "VARCHAR"}, want: Value{Kind: KindString, Data: ""}},
+		{name: "int64", v: int64(-3), want: Value{Kind: KindInt, Data: "-3"}},
+		{name: "uint64", v: uint64(18446744073709551615), want: Value{Kind: KindInt, Data: "18446744073709551615"}},
+		{name: "text int", v: []byte("12"), col: &ColumnInfo{Type: "UNSIGNED INT"}, want: Value{Kind: KindInt, Data: "12"}},
+		{name: "decimal", v: []byte("1.50"), col: &ColumnInfo{Type: "DECIMAL"}, want: Value{Kind: KindDecimal, Data: "1.50"}},
+		{name: "text float", v: []byte("1.1"), col: &ColumnInfo{Type: "FLOAT"}, want: Value{Kind: KindFloat, Data: "1.1"}},
+		{name: "binary float", v: float32(1.1), col: &ColumnInfo{Type: "FLOAT"}, want: Value{Kind: KindFloat, Data: "1.1"}},
+		{name: "text unsigned float", v: []byte("16777217"), col: &ColumnInfo{Type: "UNSIGNED FLOAT"}, want: Value{Kind: KindFloat, Data: "1.6777216e+07"}},
+		{name: "unsigned float as double", v: float64(float32(1.1)), col: &ColumnInfo{Type: "UNSIGNED FLOAT"}, want: Value{Kind: KindFloat, Data: "1.1"}},
+		{name: "double", v: float64(2.5), col: &ColumnInfo{Type: "DOUBLE"}, want: Value{Kind: KindFloat, Data: "2.5"}},
+		{name: "datetime", v: []byte("2021-11-22 15:05:00"), col: &ColumnInfo{Type: "DATETIME"}, want: Value{Kind: KindTemporal, Data: "2021-11-22 15:05:00"}},
+		{name: "time.Time", v: time.Date(2021, 11, 22, 15, 5, 0, 120000000, time.UTC), col: &ColumnInfo{Type: "DATETIME", Decimals: 3},
+			want: Value{Kind: KindTemporal, Data: "2021-11-22 15:05:00.120"}},
+		{name: "date", v: time.Date(2021, 11, 22, 0, 0, 0, 0, time.UTC), col: &ColumnInfo{Type: "DATE"}, want: Value{Kind: KindTemporal, Data: "2021-11-22"}},
+		{name: "json", v: []byte(`{"a": 1}`), col: &ColumnInfo{Type: "JSON"}, want: Value{Kind: KindJSON, Data: `{"a": 1}`}},
+		{name: "blob", v: []byte{0, 1}, col: &ColumnInfo{Type: "BLOB"}, want: Value{Kind: KindBinary, Data: "\x00\x01"}},
+		{name: "no column invalid utf8", v: []byte{0xff}, want: Value{Kind: KindBinary, Data: "\xff"}},
+		{name: "no column", v: "abc", want: Value{Kind: KindString, Data: "abc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewValue(tt.v, tt.col)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNewValue_Fail(t *testing.T) {
+	_, err := NewValue(struct{}{}, nil)
+	assert.NotNil(t, err)
+
+	_, err = NewValue([]byte("abc"), &ColumnInfo{Type: "DOUBLE"})
+	assert.NotNil(t, err)
+}
+
+func TestValue_JSON(t *testing.T) {
+	vals := []Value{
+		{Kind: KindNull},
+		{Kind: KindString, Data: ""},
+		{Kind: KindInt, Data: "1"},
+		{Kind: KindBinary, Data: "\x00\xff"},
+	}
+	b, err := json.Marshal(vals)
+	assert.Nil(t, err)
+	assert.Equal(t, `[null,{"t":"string","v":""},{"t":"int","v":"1"},{"t":"binary","v":"AP8="}]`, string(b))
+
+	var got []Value
+	assert.Nil(t, json.Unmarshal(b, &got))
+	assert.Equal(t, vals, got)
+
+	assert.NotNil(t, json.Unmarshal([]byte(`{"t":"unknown","v":""}`), &got))
+}
+
+func TestValue_Equal(t *testing.T) {
+	ast := assert.New(t)
+	ast.False(Value{Kind: KindNull}.Equal(Value{Kind: KindString}))
+	ast.False(Value{Kind: KindInt, Data: "1"}.Equal(Value{Kind: KindString, Data: "1"}))
+	ast.True(Value{Kind: KindDecimal, Data: "1.0"}.Equal(Value{Kind: KindDecimal, Data: "1.0"}))
+}
+
+func TestConvertRows(t *testing.T) {
+	rows := [][]driver.Value{{int64(1), nil, []byte("a")}}
+	cols := []ColumnInfo{{Type: "BIGINT"}, {Type: "VARCHAR"}}
+	got, err := ConvertRows(rows, cols)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]Value{{
+		{Kind: KindInt, Data: "1"},
+		{Kind: KindNull},
+		{Kind: KindString, Data: "a"},
+	}}, got)
+}
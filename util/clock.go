@@ -0,0 +1,91 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package util
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//Clock is the virtual clock of a replay shared by all connections , it is
+//anchored at the first captured event by Start , an event captured at ts is
+//due at start + (ts - first) / speed
+type Clock struct {
+	//0 means as fast as possible
+	speed float64
+
+	mu    sync.Mutex
+	first int64
+	start time.Time
+	now   func() time.Time
+}
+
+func NewClock(speed float64) *Clock {
+	return &Clock{speed: speed, now: time.Now}
+}
+
+//Start anchors the clock at the event captured at ts if it is not started ,
+//it must be called in the captured order of events
+func (c *Clock) Start(ts int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.start.IsZero() {
+		c.first, c.start = ts, c.now()
+	}
+}
+
+//Due returns the time the event captured at ts is due , events captured
+//before the first one or before the clock starts are due at once
+func (c *Clock) Due(ts int64) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.start.IsZero() {
+		return c.now()
+	}
+	if ts <= c.first {
+		return c.start
+	}
+	return c.start.Add(time.Duration(float64(ts-c.first) / c.speed))
+}
+
+//Wait waits until the event captured at ts is due or ctx is done , it
+//returns how late the event is
+func (c *Clock) Wait(ctx context.Context, ts int64) time.Duration {
+	return c.WaitShift(ctx, ts, 0)
+}
+
+//WaitShift waits until shift after the event captured at ts is due , it
+//returns how late the event is
+func (c *Clock) WaitShift(ctx context.Context, ts int64, shift time.Duration) time.Duration {
+	if c == nil || c.speed <= 0 {
+		return 0
+	}
+	d := c.Due(ts).Add(shift).Sub(c.now())
+	if d < 0 {
+		return -d
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+	return 0
+}
@@ -0,0 +1,64 @@
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClock_Due(t *testing.T) {
+	start := time.Unix(100, 0)
+	c := NewClock(2)
+	c.now = func() time.Time { return start }
+
+	//events before the clock starts are due at once
+	assert.Equal(t, start, c.Due(int64(5*time.Second)))
+	c.Start(int64(10 * time.Second))
+	c.Start(int64(5 * time.Second))
+	assert.Equal(t, start, c.Due(int64(10*time.Second)))
+	assert.Equal(t, start.Add(500*time.Millisecond), c.Due(int64(11*time.Second)))
+	assert.Equal(t, start, c.Due(int64(9*time.Second)))
+
+	c = NewClock(0.5)
+	c.now = func() time.Time { return start }
+	c.Start(0)
+	assert.Equal(t, start.Add(2*time.Second), c.Due(int64(time.Second)))
+}
+
+func TestClock_Wait(t *testing.T) {
+	var c *Clock
+	assert.Equal(t, time.Duration(0), c.Wait(context.Background(), 1))
+
+	//events already due return how late they are
+	now := time.Unix(100, 0)
+	c = NewClock(1)
+	c.now = func() time.Time { return now }
+	c.Start(0)
+	assert.Equal(t, time.Duration(0), c.Wait(context.Background(), 0))
+	now = now.Add(3 * time.Second)
+	assert.Equal(t, 2*time.Second, c.Wait(context.Background(), int64(time.Second)))
+
+	//events not due wait until ctx is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	assert.Equal(t, time.Duration(0), c.Wait(ctx, int64(time.Hour)))
+	assert.True(t, time.Since(begin) < time.Minute)
+
+	//shifted events are due later
+	now = time.Unix(200, 0)
+	c = NewClock(1)
+	c.now = func() time.Time { return now }
+	c.Start(0)
+	now = now.Add(3 * time.Second)
+	assert.Equal(t, time.Second, c.WaitShift(context.Background(), int64(time.Second), time.Second))
+
+	c = NewClock(100)
+	begin = time.Now()
+	c.Start(0)
+	c.Wait(context.Background(), 0)
+	c.Wait(context.Background(), int64(time.Second))
+	assert.True(t, time.Since(begin) >= 10*time.Millisecond)
+}
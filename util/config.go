@@ -26,44 +26,158 @@ package util
 import (
 	"database/sql"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
 	"github.com/pingcap/errors"
 	"github.com/spf13/pflag"
 	"go.uber.org/zap"
 )
 
 type Config struct {
-	Dsn                string
-	RunTime            uint32
-	OutputDir          string
-	PreFileSize        uint64
-	StoreDir           string
-	ListenPort         uint16
-	DataDir            string
-	FlushInterval      time.Duration
-	DeviceName         string
-	SrcPort            uint16
-	RunType            uint16
-	MySQLConfig        *mysql.Config
-	BeginReplaySQLTime int64
-	BeginReplaySQL     bool
-	BeginTimes         string
-	Mu                 sync.RWMutex
-	Log                *zap.Logger
+	Dsn                 string
+	Dsns                []string
+	Targets             []*Target
+	Protocol            string
+	PgDsn               string
+	RunTime             uint32
+	OutputDir           string
+	PreFileSize         uint64
+	StoreDir            string
+	ListenPort          uint16
+	DataDir             string
+	FlushInterval       time.Duration
+	DeviceName          string
+	SrcPort             uint16
+	RunType             uint16
+	MySQLConfig         *mysql.Config
+	BeginReplaySQLTime  int64
+	BeginReplaySQL      bool
+	BeginTimes          string
+	StartTimes          string
+	StartTime           int64
+	EndTimes            string
+	EndTime             int64
+	EventFormat         string
+	BlockSize           int
+	Journal             bool
+	JournalMaxSize      uint64
+	JournalSync         string
+	JournalSyncInterval time.Duration
+	JournalAggregate    bool
+	ExportFormat        string
+	MaskConfig          string
+	RewriteConfig       string
+	DBMaps              string
+	DBMap               *DBMap
+	AnalyzeInterval     time.Duration
+	AnalyzeTop          int
+	InspectConn         string
+	InspectTypes        string
+	InspectHex          bool
+	InspectMaxRows      int
+	Speeds              string
+	Speed               float64
+	Clock               *Clock
+	CommitOrdered       bool
+	CommitOrderTimeout  time.Duration
+	CommitOrder         *CommitOrder
+	Mode                string
+	SkipStmtTypes       string
+	DryRun              bool
+	Scale               int
+	ScaleShift          time.Duration
+	ScaleKeyColumns     string
+	ScaleKeyOffset      int64
+	//lower case names of ScaleKeyColumns
+	ScaleKeys      map[string]bool
+	MaxSessions    int
+	MaxStmts       int
+	MaxSessionWait time.Duration
+	Limiter        *Limiter
+	Retry          string
+	RetryConfig    string
+	RetryPolicy    *RetryPolicy
+	//reasons of statement types not replayed
+	SkipStmts map[uint16]string
+	Mu        sync.RWMutex
+	Log       *zap.Logger
 }
 
 func (cfg *Config) CheckParamValid() error {
 
 	var err error
 
+	if len(cfg.Dsns) > 0 {
+		//the first dsn is the replay server of the rr- fields of results
+		cfg.Dsn = cfg.Dsns[0]
+	}
+
+	err = cfg.CheckProtocol()
+	if err != nil {
+		return err
+	}
+
 	err = cfg.CheckBeginTime()
 	if err != nil {
 		return err
 	}
 
+	err = cfg.CheckStartTime()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.CheckEndTime()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.CheckEventFormat()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.CheckJournal()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.CheckSpeed()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.CheckCommitOrder()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.CheckMode()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.CheckDBMap()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.CheckScale()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.CheckRetry()
+	if err != nil {
+		return err
+	}
+
 	err = cfg.CheckOutputDir()
 	if err != nil {
 		return err
@@ -86,6 +200,16 @@ func (cfg *Config) CheckParamValid() error {
 		if err != nil {
 			return err
 		}
+
+		err = cfg.CheckTargets()
+		if err != nil {
+			return err
+		}
+
+		err = cfg.CheckLimiter()
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -119,6 +243,17 @@ func (cfg *Config) CheckStoreDir() error {
 	return nil
 }
 
+func (cfg *Config) CheckProtocol() error {
+	switch cfg.Protocol {
+	case "":
+		cfg.Protocol = ProtocolMySQL
+	case ProtocolMySQL, ProtocolPostgres, ProtocolMySQLX:
+	default:
+		return errors.New("unsupported protocol " + cfg.Protocol)
+	}
+	return nil
+}
+
 func (cfg *Config) CheckDsn() error {
 	var err error
 	if len(cfg.Dsn) == 0 {
@@ -126,6 +261,11 @@ func (cfg *Config) CheckDsn() error {
 		return err
 	}
 
+	if cfg.Protocol == ProtocolPostgres {
+		cfg.PgDsn, err = parsePgDsn(cfg.Dsn)
+		return err
+	}
+
 	cfg.MySQLConfig, err = mysql.ParseDSN(cfg.Dsn)
 	if err != nil {
 		return err
@@ -133,9 +273,51 @@ func (cfg *Config) CheckDsn() error {
 	return nil
 }
 
+//lib/pq accepts both URL and key/value connection string , the URL
+//is converted so that dbname can be appended when replay handshake
+func parsePgDsn(dsn string) (string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		var err error
+		dsn, err = pq.ParseURL(dsn)
+		if err != nil {
+			return "", err
+		}
+	}
+	_, err := pq.NewConnector(dsn)
+	if err != nil {
+		return "", err
+	}
+	return dsn, nil
+}
+
+//PgDsnWithDB returns the connection string of postgres with database name
+func (cfg *Config) PgDsnWithDB(db string) string {
+	return PgDsnWithDB(cfg.PgDsn, db)
+}
+
+func PgDsnWithDB(dsn string, db string) string {
+	if len(db) == 0 {
+		return dsn
+	}
+	db = strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(db)
+	return dsn + " dbname='" + db + "'"
+}
+
 func (cfg *Config) TryConnectDstDB() error {
+	return tryConnect(cfg.Protocol, cfg.PgDsn, cfg.MySQLConfig, cfg.Log)
+}
+
+func tryConnect(protocol string, pgDsn string, mysqlConfig *mysql.Config, log *zap.Logger) error {
+	if protocol == ProtocolPostgres {
+		db, err := sql.Open("postgres", pgDsn)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return db.Ping()
+	}
 
-	connStr := cfg.MySQLConfig.FormatDSN()
+	connStr := mysqlConfig.FormatDSN()
 	db, err := sql.Open("mysql", connStr)
 	if err != nil {
 		return err
@@ -143,14 +325,14 @@ func (cfg *Config) TryConnectDstDB() error {
 	defer func() {
 		err = db.Close()
 		if err != nil {
-			cfg.Log.Warn("close db conn fail ," + err.Error())
+			log.Warn("close db conn fail ," + err.Error())
 		}
 	}()
 	rows, err := db.Query("select count(*) from mysql.user;")
 	defer func() {
 		if rows != nil {
 			if rs := rows.Close(); rs != nil {
-				cfg.Log.Warn("close row fail," + err.Error())
+				log.Warn("close row fail," + rs.Error())
 			}
 		}
 	}()
@@ -308,6 +490,215 @@ func (cfg *Config) CheckBeginTime() error {
 	return nil
 }
 
+//CheckStartTime parses the time to start replaying event logs from
+func (cfg *Config) CheckStartTime() error {
+	if len(cfg.StartTimes) == 0 {
+		cfg.StartTime = 0
+		return nil
+	}
+	if len(cfg.StartTimes) != 23 {
+		return errors.New("length of start time is not 23 digits,YYYY-MM-DD HH:MM:SS.MMM")
+	}
+	var err error
+	cfg.StartTime, err = parseDateTime([]byte(cfg.StartTimes), time.Local)
+	return err
+}
+
+//CheckEndTime parses the time after which events are skipped
+func (cfg *Config) CheckEndTime() error {
+	if len(cfg.EndTimes) == 0 {
+		cfg.EndTime = 0
+		return nil
+	}
+	if len(cfg.EndTimes) != 23 {
+		return errors.New("length of end time is not 23 digits,YYYY-MM-DD HH:MM:SS.MMM")
+	}
+	var err error
+	cfg.EndTime, err = parseDateTime([]byte(cfg.EndTimes), time.Local)
+	if err != nil {
+		return err
+	}
+	if cfg.EndTime <= cfg.StartTime {
+		return errors.New("end time is not after start time")
+	}
+	return nil
+}
+
+//CheckSpeed parses the speed of replay , e.g. 0.5x , 2 or max , events
+//are replayed as fast as possible if the speed is max
+func (cfg *Config) CheckSpeed() error {
+	s := strings.ToLower(strings.TrimSpace(cfg.Speeds))
+	if len(s) == 0 || s == "max" {
+		cfg.Speed = 0
+		cfg.Clock = nil
+		return nil
+	}
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(s, "x"), 64)
+	if err != nil || speed <= 0 || math.IsInf(speed, 0) || math.IsNaN(speed) {
+		return errors.New("speed must be a positive number or max , " + cfg.Speeds)
+	}
+	cfg.Speed = speed
+	cfg.Clock = NewClock(speed)
+	return nil
+}
+
+func (cfg *Config) CheckCommitOrder() error {
+	cfg.CommitOrder = nil
+	if !cfg.CommitOrdered {
+		return nil
+	}
+	if cfg.CommitOrderTimeout <= 0 {
+		return errors.New("commit order timeout must be positive , " + cfg.CommitOrderTimeout.String())
+	}
+	cfg.CommitOrder = NewCommitOrder(cfg.CommitOrderTimeout)
+	return nil
+}
+
+func (cfg *Config) CheckMode() error {
+	cfg.SkipStmts = make(map[uint16]string)
+	switch cfg.Mode {
+	case "", ModeAll:
+		cfg.Mode = ModeAll
+	case ModeReadOnly:
+		for _, t := range []uint16{InsertStmt, UpdateStmt, DeleteStmt, DDLStmt, OutfileStmt, UnknownStmt} {
+			cfg.SkipStmts[t] = StmtTypeName(t) + " statement in read-only mode"
+		}
+	case ModeWrites:
+		for _, t := range []uint16{SelectStmt, OutfileStmt} {
+			cfg.SkipStmts[t] = StmtTypeName(t) + " statement in writes mode"
+		}
+	default:
+		return errors.New("unsupported mode " + cfg.Mode)
+	}
+	for _, name := range strings.Split(cfg.SkipStmtTypes, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if len(name) == 0 {
+			continue
+		}
+		t, ok := ParseStmtType(name)
+		if !ok {
+			return errors.New("unknown statement type " + name)
+		}
+		cfg.SkipStmts[t] = name + " statement in skip-stmt-types"
+	}
+	if !cfg.DryRun {
+		return nil
+	}
+	//statements can not be rolled back
+	for _, t := range []uint16{DDLStmt, OutfileStmt, UnknownStmt} {
+		if _, ok := cfg.SkipStmts[t]; !ok {
+			cfg.SkipStmts[t] = StmtTypeName(t) + " statement in dry-run mode"
+		}
+	}
+	return nil
+}
+
+//CheckScale checks the options of replaying every connection by several
+//clones , clone i is shifted by i*ScaleShift and its keys by i*ScaleKeyOffset
+func (cfg *Config) CheckScale() error {
+	if cfg.Scale == 0 {
+		cfg.Scale = 1
+	}
+	if cfg.Scale < 0 {
+		return errors.New("scale must be positive , " + strconv.Itoa(cfg.Scale))
+	}
+	if cfg.ScaleShift < 0 {
+		return errors.New("scale shift must not be negative , " + cfg.ScaleShift.String())
+	}
+	if cfg.ScaleShift > 0 && cfg.Clock == nil {
+		return errors.New("scale shift needs the replay pace of --speed")
+	}
+	if cfg.ScaleShift > 0 && cfg.Scale > 1 && cfg.CommitOrder != nil {
+		return errors.New("commit order can not be kept by clones shifted in time")
+	}
+	cfg.ScaleKeys = nil
+	for _, name := range strings.Split(cfg.ScaleKeyColumns, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if len(name) == 0 {
+			continue
+		}
+		if cfg.ScaleKeys == nil {
+			cfg.ScaleKeys = make(map[string]bool)
+		}
+		cfg.ScaleKeys[name] = true
+	}
+	if cfg.ScaleKeyOffset != 0 && len(cfg.ScaleKeys) == 0 {
+		return errors.New("scale key offset needs the columns of --scale-key-columns")
+	}
+	return nil
+}
+
+//CheckLimiter gives every replay server a budget of sessions and statements .
+//Sessions can not be limited with the commit order , a connection waiting
+//for its commit in a transaction keeps its session from the connection
+//holding the commits before it
+func (cfg *Config) CheckLimiter() error {
+	if cfg.MaxSessions < 0 || cfg.MaxStmts < 0 {
+		return fmt.Errorf("max sessions and statements must not be negative , %d , %d", cfg.MaxSessions, cfg.MaxStmts)
+	}
+	if cfg.MaxSessions > 0 && cfg.CommitOrder != nil {
+		return errors.New("commit order can not be kept with the sessions limited by --max-sessions")
+	}
+	if cfg.MaxSessionWait < 0 {
+		return errors.New("max session wait must not be negative , " + cfg.MaxSessionWait.String())
+	}
+	cfg.Limiter = NewLimiter(cfg.MaxSessions, cfg.MaxStmts)
+	for _, t := range cfg.Targets {
+		t.Limiter = NewLimiter(cfg.MaxSessions, cfg.MaxStmts)
+	}
+	return nil
+}
+
+//CheckRetry builds the retry policy , the rules of RetryConfig override the
+//default rules and the rules of Retry override both
+func (cfg *Config) CheckRetry() error {
+	p := NewRetryPolicy()
+	if len(cfg.RetryConfig) > 0 {
+		if err := p.Load(cfg.RetryConfig); err != nil {
+			return err
+		}
+	}
+	if err := p.Parse(cfg.Retry); err != nil {
+		return err
+	}
+	cfg.RetryPolicy = p
+	return nil
+}
+
+func (cfg *Config) CheckDBMap() error {
+	m, err := NewDBMap(cfg.DBMaps)
+	if err != nil {
+		return err
+	}
+	cfg.DBMap = m
+	return nil
+}
+
+func (cfg *Config) CheckEventFormat() error {
+	switch cfg.EventFormat {
+	case "":
+		cfg.EventFormat = EventFormatText
+	case EventFormatText, EventFormatSegment:
+	default:
+		return errors.New("unsupported event format " + cfg.EventFormat)
+	}
+	return nil
+}
+
+func (cfg *Config) CheckJournal() error {
+	switch cfg.JournalSync {
+	case "":
+		cfg.JournalSync = JournalSyncInterval
+	case JournalSyncNone, JournalSyncInterval, JournalSyncAlways:
+	default:
+		return errors.New("unsupported journal sync policy " + cfg.JournalSync)
+	}
+	if cfg.JournalSync == JournalSyncInterval && cfg.JournalSyncInterval <= 0 {
+		cfg.JournalSyncInterval = time.Second
+	}
+	return nil
+}
+
 func (cfg *Config) GetBeginReplaySQL() bool {
 	cfg.Mu.RLock()
 	defer cfg.Mu.RUnlock()
@@ -349,7 +740,8 @@ func (cfg *Config) CheckNeedReplay(ts int64) uint16 {
 }
 
 func (cfg *Config) ParseFlagForRunDir(flags *pflag.FlagSet) {
-	flags.StringVarP(&cfg.Dsn, "dsn", "d", "", "replay server dsn")
+	flags.StringArrayVarP(&cfg.Dsns, "dsn", "d", nil, "replay server dsn , repeat to replay on several servers and compare all of them")
+	flags.StringVar(&cfg.Protocol, "protocol", ProtocolMySQL, "wire protocol of server , mysql , mysqlx or postgres")
 	flags.Uint32VarP(&cfg.RunTime, "runtime", "t", 10, "replay server run time")
 	flags.StringVarP(&cfg.OutputDir, "output", "o", "./output", "directory used to write the result set ")
 	flags.StringVarP(&cfg.StoreDir, "storeDir", "S", "", "save result dir")
@@ -359,21 +751,28 @@ func (cfg *Config) ParseFlagForRunDir(flags *pflag.FlagSet) {
 	flags.Uint16VarP(&cfg.SrcPort, "srcPort", "P", 4000, "server port")
 	flags.DurationVar(&cfg.FlushInterval, "flush-interval", time.Minute*3, "flush interval")
 	flags.StringVarP(&cfg.BeginTimes, "begin-time", "T", "", "time to replay sql ")
-
+	cfg.parseFlagForReplay(flags)
+	cfg.parseFlagForJournal(flags)
+	flags.StringVar(&cfg.MaskConfig, "mask-config", "", "json file of masking rules , sensitive values are masked in all outputs")
 }
 
 func (cfg *Config) ParseFlagForRunText(flags *pflag.FlagSet) {
-	flags.StringVarP(&cfg.Dsn, "dsn", "d", "", "replay server dsn")
+	flags.StringArrayVarP(&cfg.Dsns, "dsn", "d", nil, "replay server dsn , repeat to replay on several servers and compare all of them")
+	flags.StringVar(&cfg.Protocol, "protocol", ProtocolMySQL, "wire protocol of server , mysql , mysqlx or postgres")
 	flags.StringVarP(&cfg.OutputDir, "output", "o", "./output", "directory used to write the result set")
 	flags.StringVarP(&cfg.StoreDir, "storeDir", "S", "", "save result dir")
 	flags.Uint16VarP(&cfg.SrcPort, "srcPort", "P", 4000, "server port")
 	flags.DurationVar(&cfg.FlushInterval, "flush-interval", time.Minute, "flush interval")
 	flags.Uint64VarP(&cfg.PreFileSize, "filesize", "s", UINT64MAX, "Baseline size per document , unit M")
 	flags.Uint16VarP(&cfg.ListenPort, "listen-port", "p", 7002, "http server port , Provide query statistical (query) information and exit (exit) services")
+	cfg.parseFlagForReplay(flags)
+	cfg.parseFlagForJournal(flags)
+	flags.StringVar(&cfg.MaskConfig, "mask-config", "", "json file of masking rules , sensitive values are masked in all outputs")
 }
 
 func (cfg *Config) ParseFlagForRunOnline(flags *pflag.FlagSet) {
-	flags.StringVarP(&cfg.Dsn, "dsn", "d", "", "replay server dsn")
+	flags.StringArrayVarP(&cfg.Dsns, "dsn", "d", nil, "replay server dsn , repeat to replay on several servers and compare all of them")
+	flags.StringVar(&cfg.Protocol, "protocol", ProtocolMySQL, "wire protocol of server , mysql , mysqlx or postgres")
 	flags.Uint32VarP(&cfg.RunTime, "runtime", "t", 0, "replay server run time")
 	flags.StringVarP(&cfg.OutputDir, "output", "o", "./output", "directory used to write the result set ")
 	flags.StringVarP(&cfg.DeviceName, "device", "D", "eth0", "device name")
@@ -381,4 +780,133 @@ func (cfg *Config) ParseFlagForRunOnline(flags *pflag.FlagSet) {
 	flags.Uint16VarP(&cfg.SrcPort, "srcPort", "P", 4000, "server port")
 	flags.Uint64VarP(&cfg.PreFileSize, "filesize", "s", UINT64MAX, "Baseline size per document ,uint M")
 	flags.Uint16VarP(&cfg.ListenPort, "listen-port", "p", 7002, "http server port , Provide query statistical (query) information and exit (exit) services")
+	cfg.parseFlagForReplay(flags)
+	cfg.parseFlagForJournal(flags)
+	flags.StringVar(&cfg.MaskConfig, "mask-config", "", "json file of masking rules , sensitive values are masked in all outputs")
+}
+
+func (cfg *Config) ParseFlagForCaptureText(flags *pflag.FlagSet) {
+	flags.StringVar(&cfg.Protocol, "protocol", ProtocolMySQL, "wire protocol of server , mysql , mysqlx or postgres")
+	flags.StringVarP(&cfg.OutputDir, "output", "o", "./output", "directory used to write the event logs")
+	flags.Uint16VarP(&cfg.SrcPort, "srcPort", "P", 4000, "server port")
+	flags.DurationVar(&cfg.FlushInterval, "flush-interval", time.Minute, "flush interval")
+	flags.StringVar(&cfg.EventFormat, "format", EventFormatText, "format of events , text (event log per connection) or segment (indexed and compressed)")
+	flags.IntVar(&cfg.BlockSize, "block-size", 1<<20, "size of a segment block before compression")
+}
+
+func (cfg *Config) ParseFlagForCaptureDir(flags *pflag.FlagSet) {
+	flags.StringVar(&cfg.Protocol, "protocol", ProtocolMySQL, "wire protocol of server , mysql , mysqlx or postgres")
+	flags.Uint32VarP(&cfg.RunTime, "runtime", "t", 10, "capture run time")
+	flags.StringVarP(&cfg.OutputDir, "output", "o", "./output", "directory used to write the event logs")
+	flags.StringVarP(&cfg.DataDir, "data-dir", "D", "./data", "directory used to read pcap file")
+	flags.Uint16VarP(&cfg.SrcPort, "srcPort", "P", 4000, "server port")
+	flags.DurationVar(&cfg.FlushInterval, "flush-interval", time.Minute*3, "flush interval")
+	flags.StringVar(&cfg.EventFormat, "format", EventFormatText, "format of events , text (event log per connection) or segment (indexed and compressed)")
+	flags.IntVar(&cfg.BlockSize, "block-size", 1<<20, "size of a segment block before compression")
+}
+
+func (cfg *Config) ParseFlagForCaptureOnline(flags *pflag.FlagSet) {
+	flags.StringVar(&cfg.Protocol, "protocol", ProtocolMySQL, "wire protocol of server , mysql , mysqlx or postgres")
+	flags.Uint32VarP(&cfg.RunTime, "runtime", "t", 0, "capture run time")
+	flags.StringVarP(&cfg.OutputDir, "output", "o", "./output", "directory used to write the event logs")
+	flags.StringVarP(&cfg.DeviceName, "device", "D", "eth0", "device name")
+	flags.Uint16VarP(&cfg.SrcPort, "srcPort", "P", 4000, "server port")
+	flags.StringVar(&cfg.EventFormat, "format", EventFormatText, "format of events , text (event log per connection) or segment (indexed and compressed)")
+	flags.IntVar(&cfg.BlockSize, "block-size", 1<<20, "size of a segment block before compression")
+}
+
+func (cfg *Config) ParseFlagForRunLog(flags *pflag.FlagSet) {
+	flags.StringArrayVarP(&cfg.Dsns, "dsn", "d", nil, "replay server dsn , repeat to replay on several servers and compare all of them")
+	flags.StringVar(&cfg.Protocol, "protocol", ProtocolMySQL, "wire protocol of server , mysql , mysqlx or postgres")
+	flags.StringVarP(&cfg.OutputDir, "output", "o", "./output", "directory used to write the result set")
+	flags.StringVarP(&cfg.StoreDir, "storeDir", "S", "", "save result dir")
+	flags.Uint64VarP(&cfg.PreFileSize, "filesize", "s", UINT64MAX, "Baseline size per document , unit M")
+	flags.Uint16VarP(&cfg.ListenPort, "listen-port", "p", 7002, "http server port , Provide query statistical (query) information and exit (exit) services")
+	flags.StringVarP(&cfg.BeginTimes, "begin-time", "T", "", "time to replay sql ")
+	flags.StringVar(&cfg.StartTimes, "start-time", "", "skip events before the time , YYYY-MM-DD HH:MM:SS.MMM")
+	cfg.parseFlagForReplay(flags)
+	cfg.parseFlagForJournal(flags)
+	flags.StringVar(&cfg.MaskConfig, "mask-config", "", "json file of masking rules , sensitive values are masked in all outputs")
+}
+
+func (cfg *Config) ParseFlagForExportText(flags *pflag.FlagSet) {
+	flags.StringVar(&cfg.Protocol, "protocol", ProtocolMySQL, "wire protocol of server , mysql or mysqlx")
+	flags.StringVarP(&cfg.OutputDir, "output", "o", "./output", "directory used to write the exported files")
+	flags.Uint16VarP(&cfg.SrcPort, "srcPort", "P", 4000, "server port")
+	flags.DurationVar(&cfg.FlushInterval, "flush-interval", time.Minute, "flush interval")
+	flags.StringVar(&cfg.ExportFormat, "format", ExportFormatSQL, "export format , sql (script per connection) , general-log or slow-log")
+	flags.StringVar(&cfg.MaskConfig, "mask-config", "", "json file of masking rules , sensitive values are masked in all outputs")
+}
+
+func (cfg *Config) ParseFlagForExportLog(flags *pflag.FlagSet) {
+	flags.StringVarP(&cfg.OutputDir, "output", "o", "./output", "directory used to write the exported files")
+	flags.StringVar(&cfg.StartTimes, "start-time", "", "skip events before the time , YYYY-MM-DD HH:MM:SS.MMM")
+	flags.StringVar(&cfg.ExportFormat, "format", ExportFormatSQL, "export format , sql (script per connection) , general-log or slow-log")
+	flags.StringVar(&cfg.MaskConfig, "mask-config", "", "json file of masking rules , sensitive values are masked in all outputs")
+}
+
+func (cfg *Config) ParseFlagForAnalyzeText(flags *pflag.FlagSet) {
+	flags.StringVar(&cfg.Protocol, "protocol", ProtocolMySQL, "wire protocol of server , mysql , mysqlx or postgres")
+	flags.StringVarP(&cfg.OutputDir, "output", "o", "./output", "directory used to write the report")
+	flags.Uint16VarP(&cfg.SrcPort, "srcPort", "P", 4000, "server port")
+	flags.DurationVar(&cfg.FlushInterval, "flush-interval", time.Minute, "flush interval")
+	cfg.parseFlagForAnalyze(flags)
+	flags.StringVar(&cfg.MaskConfig, "mask-config", "", "json file of masking rules , sensitive values are masked in all outputs")
+}
+
+func (cfg *Config) ParseFlagForAnalyzeLog(flags *pflag.FlagSet) {
+	flags.StringVar(&cfg.Protocol, "protocol", ProtocolMySQL, "wire protocol of server , mysql , mysqlx or postgres")
+	flags.StringVarP(&cfg.OutputDir, "output", "o", "./output", "directory used to write the report")
+	flags.StringVar(&cfg.StartTimes, "start-time", "", "skip events before the time , YYYY-MM-DD HH:MM:SS.MMM")
+	cfg.parseFlagForAnalyze(flags)
+	flags.StringVar(&cfg.MaskConfig, "mask-config", "", "json file of masking rules , sensitive values are masked in all outputs")
+}
+
+func (cfg *Config) ParseFlagForInspectText(flags *pflag.FlagSet) {
+	flags.StringVar(&cfg.Protocol, "protocol", ProtocolMySQL, "wire protocol of server , mysql , mysqlx or postgres")
+	flags.StringVarP(&cfg.OutputDir, "output", "o", "./output", "directory used to write inspect.txt")
+	flags.Uint16VarP(&cfg.SrcPort, "srcPort", "P", 4000, "server port")
+	flags.DurationVar(&cfg.FlushInterval, "flush-interval", time.Minute, "flush interval")
+	flags.StringVar(&cfg.StartTimes, "start-time", "", "skip packets before the time , YYYY-MM-DD HH:MM:SS.MMM")
+	flags.StringVar(&cfg.EndTimes, "end-time", "", "skip packets after the time , YYYY-MM-DD HH:MM:SS.MMM")
+	flags.StringVar(&cfg.InspectConn, "conn", "", "only inspect the connection , client address ip:port or hash of the connection")
+	flags.StringVar(&cfg.InspectTypes, "type", "", "only print the commands of the types , comma separated , e.g. query,stmt_execute")
+	flags.BoolVar(&cfg.InspectHex, "hex", false, "print hex dumps of packets not parsed")
+	flags.IntVar(&cfg.InspectMaxRows, "max-rows", 10, "number of rows printed per result set , -1 means all")
+	flags.StringVar(&cfg.MaskConfig, "mask-config", "", "json file of masking rules , sensitive values are masked in all outputs")
+}
+
+func (cfg *Config) parseFlagForAnalyze(flags *pflag.FlagSet) {
+	flags.DurationVar(&cfg.AnalyzeInterval, "interval", time.Second, "interval of QPS over time")
+	flags.IntVar(&cfg.AnalyzeTop, "top", 20, "number of digests reported , 0 means all")
+}
+
+//flags of replay commands
+func (cfg *Config) parseFlagForReplay(flags *pflag.FlagSet) {
+	flags.StringVar(&cfg.Speeds, "speed", "max", "replay events at the captured times scaled by the speed , e.g. 0.5x , 2x , or max to replay as fast as possible")
+	flags.IntVar(&cfg.Scale, "scale", 1, "replay every connection by the clones of the number at the same time , results are tagged by the index of the clone")
+	flags.DurationVar(&cfg.ScaleShift, "scale-shift", 0, "clone i replays i times the shift later than it is due , needs --speed")
+	flags.StringVar(&cfg.ScaleKeyColumns, "scale-key-columns", "", "columns of keys offset by inserts of clones , comma separated , e.g. id,order_id")
+	flags.Int64Var(&cfg.ScaleKeyOffset, "scale-key-offset", 0, "clone i adds i times the offset to the integer keys of --scale-key-columns it inserts")
+	flags.IntVar(&cfg.MaxSessions, "max-sessions", 0, "sessions a replay server opens for captured connections at most , connections beyond wait in order and idle connections out of transactions give their sessions to them , 0 means unlimited , it can not be used with --commit-order")
+	flags.DurationVar(&cfg.MaxSessionWait, "max-session-wait", 30*time.Second, "an event waits for a session of --max-sessions at most the time , then it fails , 0 means unlimited")
+	flags.IntVar(&cfg.MaxStmts, "max-statements", 0, "statements a replay server executes at the same time at most , 0 means unlimited")
+	flags.StringVar(&cfg.Retry, "retry", "", "retry rules of error codes overriding the defaults , code:retries[:backoff[:max-backoff[:give-up]]] separated by comma , retries -1 means until success , give-up is error or reconnect , e.g. 1205:-1:100ms:2s,1213:0")
+	flags.StringVar(&cfg.RetryConfig, "retry-config", "", "json file of retry rules of error codes , overridden by --retry")
+	flags.BoolVar(&cfg.CommitOrdered, "commit-order", false, "commit transactions and autocommit writes of all connections in the captured order , reads still run concurrently")
+	flags.DurationVar(&cfg.CommitOrderTimeout, "commit-order-timeout", 10*time.Second, "a commit waits at most the timeout for the commits captured before it")
+	flags.StringVar(&cfg.Mode, "mode", ModeAll, "statements replayed , all , read-only (writes , ddl and statements can not be parsed are skipped) or writes (reads are skipped)")
+	flags.StringVar(&cfg.DBMaps, "db-map", "", "map databases of the capture to databases of the replay server , e.g. orders_prod=orders_stage,tenant_*=tenants,shop_*=stage_*")
+	flags.StringVar(&cfg.RewriteConfig, "rewrite-config", "", "json file of rules rewriting queries before they are replayed")
+	flags.BoolVar(&cfg.DryRun, "dry-run", false, "execute writes in transactions rolled back on the replay server , commits are executed as rollbacks , ddl and statements can not be parsed are skipped")
+	flags.StringVar(&cfg.SkipStmtTypes, "skip-stmt-types", "", "statement types not replayed separated by comma , select , outfile , set , use , update , insert , delete , ddl , begin , commit , rollback or unknown")
+}
+
+//flags of the event journal written by replay commands
+func (cfg *Config) parseFlagForJournal(flags *pflag.FlagSet) {
+	flags.BoolVar(&cfg.Journal, "journal", true, "append replayed events to per connection journals in <output>/journal")
+	flags.Uint64Var(&cfg.JournalMaxSize, "journal-max-size", 256, "rotate a journal file when it is larger than the size , unit M")
+	flags.StringVar(&cfg.JournalSync, "journal-sync", JournalSyncInterval, "fsync policy of journals , none , interval or always")
+	flags.DurationVar(&cfg.JournalSyncInterval, "journal-sync-interval", time.Second, "fsync interval of journals when journal-sync is interval")
+	flags.BoolVar(&cfg.JournalAggregate, "journal-aggregate", false, "write all journals to <output>/mysql_events.json at shutdown")
 }
@@ -23,6 +23,11 @@
 
 package util
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 var UINT64MAX uint64 = 1<<64 - 1
 
 const (
@@ -41,6 +46,8 @@ const (
 	StateComStmtExecute1
 	StateComStmtExecute2
 	StateSkipPacket
+	StateHandshake2
+	StateHandshakeFail
 )
 
 type MysqlEventType uint64
@@ -72,10 +79,95 @@ const (
 	EventStmtExecute
 	EventStmtClose
 )
+
+//ErrCategory tells where an error comes from, so that result comparison can
+//distinguish "server rejected the query" from "replay tool failed"
+type ErrCategory uint16
+
+func (c ErrCategory) String() string {
+	return errCategoryMap[c]
+}
+
+func (c ErrCategory) MarshalJSON() ([]byte, error) {
+	return []byte("\"" + errCategoryMap[c] + "\""), nil
+}
+
+func (c *ErrCategory) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	for k, v := range errCategoryMap {
+		if v == s {
+			*c = k
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown error category %s", s)
+}
+
+var (
+	errCategoryMap = map[ErrCategory]string{
+		ErrCategoryNone:    "",
+		ErrCategoryServer:  "server",
+		ErrCategoryNetwork: "network",
+		ErrCategoryTimeout: "timeout",
+		ErrCategoryDriver:  "driver",
+		ErrCategoryReplay:  "replay",
+		ErrCategoryParse:   "parse",
+	}
+)
+
+const (
+	//no error
+	ErrCategoryNone ErrCategory = iota
+	//error packet sent by mysql server
+	ErrCategoryServer
+	//connection lost , broken pipe , invalid connection
+	ErrCategoryNetwork
+	//context deadline or network timeout
+	ErrCategoryTimeout
+	//other errors returned by database/sql or the driver
+	ErrCategoryDriver
+	//errors of replay tool itself , e.g. unknown prepared statement
+	ErrCategoryReplay
+	//can not decode response packets from pcap
+	ErrCategoryParse
+)
+
 const (
 	RunText = iota
 	RunDir
 	RunOnline
+	RunLog
+)
+
+//format of events written by capture
+const (
+	EventFormatText    = "text"
+	EventFormatSegment = "segment"
+)
+
+//format of exported workload
+const (
+	ExportFormatSQL        = "sql"
+	ExportFormatGeneralLog = "general-log"
+	ExportFormatSlowLog    = "slow-log"
+)
+
+//fsync policy of the event journal
+const (
+	JournalSyncNone     = "none"
+	JournalSyncInterval = "interval"
+	JournalSyncAlways   = "always"
+)
+
+//wire protocol of the captured server and the replay server
+const (
+	ProtocolMySQL    = "mysql"
+	ProtocolPostgres = "postgres"
+	//MySQL X Protocol , replayed as SQL on the classic protocol
+	ProtocolMySQLX = "mysqlx"
 )
 
 const (
@@ -93,5 +185,48 @@ const (
 	InsertStmt
 	DeleteStmt
 	DDLStmt
+	BeginStmt
+	CommitStmt
+	RollbackStmt
 	UnknownStmt
 )
+
+var stmtTypeNames = map[uint16]string{
+	SelectStmt:   "select",
+	OutfileStmt:  "outfile",
+	SetStmt:      "set",
+	UseStmt:      "use",
+	UpdateStmt:   "update",
+	InsertStmt:   "insert",
+	DeleteStmt:   "delete",
+	DDLStmt:      "ddl",
+	BeginStmt:    "begin",
+	CommitStmt:   "commit",
+	RollbackStmt: "rollback",
+	UnknownStmt:  "unknown",
+}
+
+//StmtTypeName returns the name of a statement type used by flags and outputs
+func StmtTypeName(t uint16) string {
+	return stmtTypeNames[t]
+}
+
+//ParseStmtType returns the statement type of a name
+func ParseStmtType(name string) (uint16, bool) {
+	for t, n := range stmtTypeNames {
+		if n == name {
+			return t, true
+		}
+	}
+	return UnknownStmt, false
+}
+
+//statements replayed by replay commands
+const (
+	ModeAll = "all"
+	//statements which may change data are not replayed , including
+	//statements that can not be parsed
+	ModeReadOnly = "read-only"
+	//reads are not replayed
+	ModeWrites = "writes"
+)
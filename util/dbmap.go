@@ -0,0 +1,133 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package util
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//CloneHolder in the target of a db map is replaced by the index of the clone
+const CloneHolder = "{clone}"
+
+//DBMap maps the databases of the capture to the databases of the replay
+//server , e.g. orders_prod=orders_stage,tenant_*=tenants,shop_*=stage_* .
+//Databases are matched case insensitively by the first rule matching , a
+//* of the source matches any name and the parts matched by them replace
+//the * of the target in order
+type DBMap struct {
+	rules []dbMapRule
+}
+
+type dbMapRule struct {
+	src *regexp.Regexp
+	dst []string
+	//the longest part of the source without wildcards , a query without
+	//the part of any rule has no database to map
+	literal string
+}
+
+func NewDBMap(s string) (*DBMap, error) {
+	m := &DBMap{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+		fields := strings.Split(pair, "=")
+		if len(fields) != 2 {
+			return nil, errors.New("db map must be src=dst , " + pair)
+		}
+		src, dst := strings.TrimSpace(fields[0]), strings.TrimSpace(fields[1])
+		if len(src) == 0 || len(dst) == 0 {
+			return nil, errors.New("db map must be src=dst , " + pair)
+		}
+		parts := strings.Split(src, "*")
+		if strings.Count(dst, "*") > len(parts)-1 {
+			return nil, errors.New("target of db map has more * than source , " + pair)
+		}
+		r := dbMapRule{dst: strings.Split(dst, "*")}
+		quoted := make([]string, len(parts))
+		for i, p := range parts {
+			quoted[i] = regexp.QuoteMeta(p)
+			if len(p) > len(r.literal) {
+				r.literal = strings.ToLower(p)
+			}
+		}
+		r.src = regexp.MustCompile("(?i)^" + strings.Join(quoted, "(.*)") + "$")
+		m.rules = append(m.rules, r)
+	}
+	if len(m.rules) == 0 {
+		return nil, nil
+	}
+	return m, nil
+}
+
+//ForClone returns the map of the clone i of a connection replayed by
+//--scale , {clone} of the targets is replaced by i
+func (m *DBMap) ForClone(i int) *DBMap {
+	if m == nil {
+		return nil
+	}
+	c := &DBMap{rules: make([]dbMapRule, len(m.rules))}
+	for j, r := range m.rules {
+		c.rules[j] = r
+		c.rules[j].dst = make([]string, len(r.dst))
+		for k, p := range r.dst {
+			c.rules[j].dst[k] = strings.Replace(p, CloneHolder, strconv.Itoa(i), -1)
+		}
+	}
+	return c
+}
+
+//Map returns the database of the replay server , false if db is not mapped
+func (m *DBMap) Map(db string) (string, bool) {
+	if m == nil || len(db) == 0 {
+		return db, false
+	}
+	for _, r := range m.rules {
+		groups := r.src.FindStringSubmatch(db)
+		if groups == nil {
+			continue
+		}
+		var sb strings.Builder
+		for i, p := range r.dst {
+			if i > 0 {
+				sb.WriteString(groups[i])
+			}
+			sb.WriteString(p)
+		}
+		return sb.String(), sb.String() != db
+	}
+	return db, false
+}
+
+//Mentioned tells if the query may refer to a database to map , it is
+//false if the query does not contain the literal part of any rule
+func (m *DBMap) Mentioned(query string) bool {
+	if m == nil {
+		return false
+	}
+	query = strings.ToLower(query)
+	for _, r := range m.rules {
+		if strings.Contains(query, r.literal) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDBMap(t *testing.T) {
+	m, err := NewDBMap("orders_prod=orders_stage, tenant_*=tenants,shop_*_*=stage_*_*,log_*=logs_*")
+	assert.Nil(t, err)
+	tests := []struct {
+		db     string
+		mapped string
+		ok     bool
+	}{
+		{"orders_prod", "orders_stage", true},
+		{"ORDERS_PROD", "orders_stage", true},
+		{"orders_prod2", "orders_prod2", false},
+		{"tenant_1", "tenants", true},
+		{"tenant_", "tenants", true},
+		{"shop_eu_2", "stage_eu_2", true},
+		{"log_x", "logs_x", true},
+		{"test", "test", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		mapped, ok := m.Map(tt.db)
+		assert.Equal(t, tt.mapped, mapped, tt.db)
+		assert.Equal(t, tt.ok, ok, tt.db)
+	}
+
+	assert.True(t, m.Mentioned("select * from Orders_Prod.t"))
+	assert.True(t, m.Mentioned("use tenant_3"))
+	assert.False(t, m.Mentioned("select * from t"))
+
+	m, err = NewDBMap("")
+	assert.Nil(t, err)
+	assert.Nil(t, m)
+	mapped, ok := m.Map("test")
+	assert.Equal(t, "test", mapped)
+	assert.False(t, ok)
+	assert.False(t, m.Mentioned("select 1"))
+
+	for _, s := range []string{"a", "a=", "=b", "a=b=c", "a=b_*", "a_*=b_*_*"} {
+		_, err = NewDBMap(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestDBMap_ForClone(t *testing.T) {
+	m, err := NewDBMap("shop=shop_{clone},log_*=log_*_{clone},test=stage")
+	assert.Nil(t, err)
+	c := m.ForClone(2)
+	db, ok := c.Map("shop")
+	assert.True(t, ok)
+	assert.Equal(t, "shop_2", db)
+	db, _ = c.Map("log_eu")
+	assert.Equal(t, "log_eu_2", db)
+	db, _ = c.Map("test")
+	assert.Equal(t, "stage", db)
+
+	//the map of the connection is not changed
+	db, _ = m.Map("shop")
+	assert.Equal(t, "shop_{clone}", db)
+	db, _ = m.ForClone(0).Map("shop")
+	assert.Equal(t, "shop_0", db)
+
+	m = nil
+	assert.Nil(t, m.ForClone(1))
+}
@@ -0,0 +1,161 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package util
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+//Limiter is the budget of a replay server , it bounds the sessions opened
+//for captured connections and the statements executed at the same time .
+//Waiters are served in the order they come , so busy connections can not
+//starve the others . A nil Limiter limits nothing
+type Limiter struct {
+	sessions *semaphore
+	stmts    *semaphore
+}
+
+//NewLimiter returns a limiter of the sessions and statements , 0 means
+//unlimited , it returns nil if both are unlimited
+func NewLimiter(sessions int, stmts int) *Limiter {
+	if sessions <= 0 && stmts <= 0 {
+		return nil
+	}
+	return &Limiter{sessions: newSemaphore(sessions), stmts: newSemaphore(stmts)}
+}
+
+//AcquireSession waits for a session , it returns the time waited and false
+//if ctx is done before
+func (l *Limiter) AcquireSession(ctx context.Context) (time.Duration, bool) {
+	if l == nil {
+		return 0, true
+	}
+	return l.sessions.acquire(ctx)
+}
+
+func (l *Limiter) ReleaseSession() {
+	if l == nil {
+		return
+	}
+	l.sessions.release()
+}
+
+//SessionWaited tells if connections are waiting for sessions , idle
+//connections give their sessions to them
+func (l *Limiter) SessionWaited() bool {
+	if l == nil {
+		return false
+	}
+	return l.sessions.waited()
+}
+
+//AcquireStmt waits for a statement to run , it returns the time waited and
+//false if ctx is done before
+func (l *Limiter) AcquireStmt(ctx context.Context) (time.Duration, bool) {
+	if l == nil {
+		return 0, true
+	}
+	return l.stmts.acquire(ctx)
+}
+
+func (l *Limiter) ReleaseStmt() {
+	if l == nil {
+		return
+	}
+	l.stmts.release()
+}
+
+//semaphore is a FIFO semaphore , a nil semaphore is unlimited
+type semaphore struct {
+	size int
+
+	mu   sync.Mutex
+	used int
+	//channels closed when the waiters get the slot
+	waiters list.List
+}
+
+func newSemaphore(size int) *semaphore {
+	if size <= 0 {
+		return nil
+	}
+	return &semaphore{size: size}
+}
+
+func (s *semaphore) acquire(ctx context.Context) (time.Duration, bool) {
+	if s == nil {
+		return 0, true
+	}
+	s.mu.Lock()
+	if s.used < s.size && s.waiters.Len() == 0 {
+		s.used++
+		s.mu.Unlock()
+		return 0, true
+	}
+	ready := make(chan struct{})
+	elem := s.waiters.PushBack(ready)
+	s.mu.Unlock()
+
+	start := time.Now()
+	select {
+	case <-ready:
+		return time.Since(start), true
+	case <-ctx.Done():
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-ready:
+		//the slot is handed over while ctx is done
+		s.releaseLocked()
+	default:
+		s.waiters.Remove(elem)
+	}
+	return time.Since(start), false
+}
+
+func (s *semaphore) waited() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.waiters.Len() > 0
+}
+
+func (s *semaphore) release() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked()
+}
+
+func (s *semaphore) releaseLocked() {
+	if front := s.waiters.Front(); front != nil {
+		//the slot is handed over to the first waiter
+		s.waiters.Remove(front)
+		close(front.Value.(chan struct{}))
+		return
+	}
+	if s.used > 0 {
+		s.used--
+	}
+}
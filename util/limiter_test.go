@@ -0,0 +1,77 @@
+package util
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	var l *Limiter
+	_, ok := l.AcquireSession(context.Background())
+	assert.True(t, ok)
+	l.ReleaseSession()
+	assert.False(t, l.SessionWaited())
+	assert.Nil(t, NewLimiter(0, 0))
+
+	//statements are unlimited
+	l = NewLimiter(1, 0)
+	for i := 0; i < 10; i++ {
+		_, ok = l.AcquireStmt(context.Background())
+		assert.True(t, ok)
+	}
+
+	wait, ok := l.AcquireSession(context.Background())
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), wait)
+
+	//waiters get the session in the order they come
+	var (
+		mu    sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+	for i := 1; i <= 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			wait, ok := l.AcquireSession(context.Background())
+			assert.True(t, ok)
+			assert.True(t, wait > 0)
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			l.ReleaseSession()
+		}(i)
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, l.SessionWaited())
+	l.ReleaseSession()
+	wg.Wait()
+	assert.Equal(t, []int{1, 2, 3}, order)
+	assert.False(t, l.SessionWaited())
+	assert.False(t, NewLimiter(0, 1).SessionWaited())
+}
+
+func TestLimiter_Cancel(t *testing.T) {
+	l := NewLimiter(0, 1)
+	_, ok := l.AcquireStmt(context.Background())
+	assert.True(t, ok)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	wait, ok := l.AcquireStmt(ctx)
+	assert.False(t, ok)
+	assert.True(t, wait >= 10*time.Millisecond)
+
+	//the waiter given up does not take the slot
+	l.ReleaseStmt()
+	_, ok = l.AcquireStmt(context.Background())
+	assert.True(t, ok)
+	l.ReleaseStmt()
+	l.ReleaseStmt()
+	assert.Equal(t, 0, l.stmts.used)
+}
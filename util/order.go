@@ -0,0 +1,110 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package util
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//CommitOrder keeps the commit order of the capture across connections ,
+//commit points are given tickets in the order they are captured and a
+//ticket waits until the commit points of all smaller tickets are done
+type CommitOrder struct {
+	timeout time.Duration
+
+	mu   sync.Mutex
+	last uint64
+	//all tickets smaller than next are done
+	next uint64
+	//tickets done before their turn
+	done map[uint64]bool
+	//closed when next moves
+	moved chan struct{}
+}
+
+func NewCommitOrder(timeout time.Duration) *CommitOrder {
+	return &CommitOrder{
+		timeout: timeout,
+		next:    1,
+		done:    make(map[uint64]bool),
+		moved:   make(chan struct{}),
+	}
+}
+
+//Ticket returns the ticket of the next commit point , 0 if c is nil
+func (c *CommitOrder) Ticket() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last++
+	return c.last
+}
+
+//Wait waits until the commit points of all tickets smaller than t are
+//done , it returns false if it gives up after the timeout or ctx is done
+func (c *CommitOrder) Wait(ctx context.Context, t uint64) bool {
+	if c == nil || t == 0 {
+		return true
+	}
+	var timer *time.Timer
+	for {
+		c.mu.Lock()
+		if c.next >= t {
+			c.mu.Unlock()
+			return true
+		}
+		moved := c.moved
+		c.mu.Unlock()
+
+		if timer == nil {
+			timer = time.NewTimer(c.timeout)
+			defer timer.Stop()
+		}
+		select {
+		case <-moved:
+		case <-timer.C:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+//Done marks the commit point of ticket t done
+func (c *CommitOrder) Done(t uint64) {
+	if c == nil || t == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t < c.next {
+		return
+	}
+	c.done[t] = true
+	if t != c.next {
+		return
+	}
+	for c.done[c.next] {
+		delete(c.done, c.next)
+		c.next++
+	}
+	close(c.moved)
+	c.moved = make(chan struct{})
+}
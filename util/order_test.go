@@ -0,0 +1,66 @@
+package util
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommitOrder(t *testing.T) {
+	var c *CommitOrder
+	assert.Equal(t, uint64(0), c.Ticket())
+	assert.True(t, c.Wait(context.Background(), 1))
+	c.Done(1)
+
+	c = NewCommitOrder(time.Minute)
+	t1, t2, t3 := c.Ticket(), c.Ticket(), c.Ticket()
+	assert.Equal(t, []uint64{1, 2, 3}, []uint64{t1, t2, t3})
+	assert.True(t, c.Wait(context.Background(), t1))
+
+	//commit points run in the order of tickets
+	var (
+		mu    sync.Mutex
+		order []uint64
+		wg    sync.WaitGroup
+	)
+	for _, ticket := range []uint64{t3, t2, t1} {
+		wg.Add(1)
+		go func(ticket uint64) {
+			defer wg.Done()
+			assert.True(t, c.Wait(context.Background(), ticket))
+			mu.Lock()
+			order = append(order, ticket)
+			mu.Unlock()
+			c.Done(ticket)
+		}(ticket)
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Wait()
+	assert.Equal(t, []uint64{1, 2, 3}, order)
+}
+
+func TestCommitOrder_Timeout(t *testing.T) {
+	c := NewCommitOrder(10 * time.Millisecond)
+	t1, t2, t3 := c.Ticket(), c.Ticket(), c.Ticket()
+
+	//t1 never runs , t2 gives up waiting for it
+	assert.False(t, c.Wait(context.Background(), t2))
+	c.Done(t2)
+	assert.False(t, c.Wait(context.Background(), t3))
+
+	//t3 runs once t1 is done as t2 is done already
+	c.Done(t1)
+	assert.True(t, c.Wait(context.Background(), t3))
+	c.Done(t3)
+	c.Done(t3)
+	assert.True(t, c.Wait(context.Background(), c.Ticket()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c = NewCommitOrder(time.Minute)
+	c.Ticket()
+	assert.False(t, c.Wait(ctx, c.Ticket()))
+}
@@ -0,0 +1,264 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//error codes retried by the default retry policy
+const (
+	ErrLockWaitTimeout   = 1205
+	ErrLockDeadlock      = 1213
+	ErrTiDBTxnRetryable  = 8022
+	ErrTiDBWriteConflict = 9007
+	//client error CR_SERVER_LOST , statements failed by a lost connection to
+	//the replay server are retried by the rule of the code after reconnecting
+	ErrServerLost = 2013
+)
+
+//give-up behaviours of retry rules
+const (
+	//report the error of the last attempt
+	GiveUpError = "error"
+	//report the error of the last attempt and reconnect , so that the next
+	//events of the connection run in a new session
+	GiveUpReconnect = "reconnect"
+)
+
+//RetryRule tells how a statement failed by the error code is retried
+type RetryRule struct {
+	Code uint16
+	//retries after the first attempt , 0 never retries and -1 retries
+	//until the statement succeeds
+	Retries int
+	//wait before the first retry , doubled by every retry up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	GiveUp     string
+	//the statements in a transaction are retried too , only errors which
+	//do not roll back the transaction can be retried in it
+	InTxn bool
+}
+
+//Retry tells whether the statement is retried after the number of attempts
+func (r RetryRule) Retry(attempts int) bool {
+	return r.Retries < 0 || attempts <= r.Retries
+}
+
+//BackoffOf returns the wait after the number of attempts
+func (r RetryRule) BackoffOf(attempts int) time.Duration {
+	d := r.Backoff
+	for i := 1; i < attempts && d < r.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > r.MaxBackoff && r.MaxBackoff >= r.Backoff {
+		d = r.MaxBackoff
+	}
+	return d
+}
+
+func (r RetryRule) check() error {
+	if r.Code == 0 {
+		return errors.New("error code of retry rule must not be 0")
+	}
+	if r.Retries < -1 {
+		return fmt.Errorf("retries of error %d must be -1 or more , %d", r.Code, r.Retries)
+	}
+	if r.Backoff < 0 || r.MaxBackoff < 0 {
+		return fmt.Errorf("backoff of error %d must not be negative , %v , %v", r.Code, r.Backoff, r.MaxBackoff)
+	}
+	switch r.GiveUp {
+	case GiveUpError, GiveUpReconnect:
+	default:
+		return fmt.Errorf("unsupported give-up of error %d , %s", r.Code, r.GiveUp)
+	}
+	return nil
+}
+
+//RetryPolicy retries the statements failed by the error codes of its rules ,
+//statements failed by other errors are not retried . A nil RetryPolicy
+//retries nothing
+type RetryPolicy struct {
+	rules map[uint16]RetryRule
+}
+
+//NewRetryPolicy returns the default policy , lock wait timeouts , deadlocks ,
+//TiDB write conflicts and lost connections are retried with backoff . Only
+//lock wait timeouts roll back the statement alone , the others roll back
+//the transaction or lose the session and are not retried in transactions
+func NewRetryPolicy() *RetryPolicy {
+	p := &RetryPolicy{rules: make(map[uint16]RetryRule)}
+	for _, r := range []RetryRule{
+		{ErrLockWaitTimeout, 10, 100 * time.Millisecond, 2 * time.Second, GiveUpError, true},
+		{ErrLockDeadlock, 5, 50 * time.Millisecond, time.Second, GiveUpError, false},
+		{ErrTiDBTxnRetryable, 5, 50 * time.Millisecond, time.Second, GiveUpError, false},
+		{ErrTiDBWriteConflict, 5, 50 * time.Millisecond, time.Second, GiveUpError, false},
+		{ErrServerLost, 3, time.Second, 5 * time.Second, GiveUpReconnect, false},
+	} {
+		p.rules[r.Code] = r
+	}
+	return p
+}
+
+//Rule returns the rule of the error code , it returns false if the
+//statements failed by the code are not retried
+func (p *RetryPolicy) Rule(code uint16) (RetryRule, bool) {
+	if p == nil {
+		return RetryRule{}, false
+	}
+	r, ok := p.rules[code]
+	if !ok || r.Retries == 0 {
+		return RetryRule{}, false
+	}
+	return r, true
+}
+
+//Set replaces the rule of the code of r
+func (p *RetryPolicy) Set(r RetryRule) error {
+	if err := r.check(); err != nil {
+		return err
+	}
+	p.rules[r.Code] = r
+	return nil
+}
+
+//rule returns the rule of the code to override , fields not given keep
+//the values of the current rule
+func (p *RetryPolicy) rule(code uint16) RetryRule {
+	if r, ok := p.rules[code]; ok {
+		return r
+	}
+	return RetryRule{Code: code, GiveUp: GiveUpError}
+}
+
+//Parse overrides the rules by the rules separated by comma , a rule is
+//code:retries[:backoff[:max-backoff[:give-up]]] , e.g.
+//1205:-1:100ms:2s,1213:3,2013:5:1s:10s:reconnect . Rules of new codes are
+//not applied to statements in transactions , see RetryRules
+func (p *RetryPolicy) Parse(s string) error {
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		fields := strings.Split(item, ":")
+		if len(fields) < 2 || len(fields) > 5 {
+			return errors.New("retry rule must be code:retries[:backoff[:max-backoff[:give-up]]] , " + item)
+		}
+		code, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			return errors.New("invalid error code of retry rule , " + item)
+		}
+		r := p.rule(uint16(code))
+		if r.Retries, err = strconv.Atoi(fields[1]); err != nil {
+			return errors.New("invalid retries of retry rule , " + item)
+		}
+		if len(fields) > 2 {
+			if r.Backoff, err = time.ParseDuration(fields[2]); err != nil {
+				return errors.New("invalid backoff of retry rule , " + item)
+			}
+			if r.MaxBackoff < r.Backoff {
+				r.MaxBackoff = r.Backoff
+			}
+		}
+		if len(fields) > 3 {
+			if r.MaxBackoff, err = time.ParseDuration(fields[3]); err != nil {
+				return errors.New("invalid max backoff of retry rule , " + item)
+			}
+		}
+		if len(fields) > 4 {
+			r.GiveUp = fields[4]
+		}
+		if err = p.Set(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//RetryRules is the retry config , a JSON file like
+//
+//	{
+//		"rules": [
+//			{"code": 1205, "retries": -1, "backoff": "100ms", "max-backoff": "2s"},
+//			{"code": 1213, "retries": 0},
+//			{"code": 2013, "retries": 5, "backoff": "1s", "give-up": "reconnect"},
+//			{"code": 1062, "retries": 3, "in-txn": true}
+//		]
+//	}
+//
+//rules override the rules of the same code , fields not given keep the
+//values of the default policy . in-txn retries the statements in
+//transactions too , it is only safe for errors which do not roll back
+//the transaction
+type RetryRules struct {
+	Rules []struct {
+		Code       uint16 `json:"code"`
+		Retries    *int   `json:"retries,omitempty"`
+		Backoff    string `json:"backoff,omitempty"`
+		MaxBackoff string `json:"max-backoff,omitempty"`
+		GiveUp     string `json:"give-up,omitempty"`
+		InTxn      *bool  `json:"in-txn,omitempty"`
+	} `json:"rules"`
+}
+
+//Load overrides the rules by the rules of a JSON file
+func (p *RetryPolicy) Load(name string) error {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var c RetryRules
+	if err = json.Unmarshal(b, &c); err != nil {
+		return fmt.Errorf("parse retry rules %s fail , %v", name, err)
+	}
+	for _, x := range c.Rules {
+		r := p.rule(x.Code)
+		if x.Retries != nil {
+			r.Retries = *x.Retries
+		}
+		if len(x.Backoff) > 0 {
+			if r.Backoff, err = time.ParseDuration(x.Backoff); err != nil {
+				return fmt.Errorf("invalid backoff of error %d , %v", x.Code, err)
+			}
+			if r.MaxBackoff < r.Backoff {
+				r.MaxBackoff = r.Backoff
+			}
+		}
+		if len(x.MaxBackoff) > 0 {
+			if r.MaxBackoff, err = time.ParseDuration(x.MaxBackoff); err != nil {
+				return fmt.Errorf("invalid max backoff of error %d , %v", x.Code, err)
+			}
+		}
+		if len(x.GiveUp) > 0 {
+			r.GiveUp = x.GiveUp
+		}
+		if x.InTxn != nil {
+			r.InTxn = *x.InTxn
+		}
+		if err = p.Set(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
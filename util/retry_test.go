@@ -0,0 +1,105 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryRule_BackoffOf(t *testing.T) {
+	r := RetryRule{Code: ErrLockWaitTimeout, Retries: -1, Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	assert.True(t, r.Retry(1000))
+	assert.Equal(t, 100*time.Millisecond, r.BackoffOf(1))
+	assert.Equal(t, 200*time.Millisecond, r.BackoffOf(2))
+	assert.Equal(t, 800*time.Millisecond, r.BackoffOf(4))
+	assert.Equal(t, time.Second, r.BackoffOf(5))
+	assert.Equal(t, time.Second, r.BackoffOf(100))
+
+	//max backoff less than backoff keeps the backoff
+	r = RetryRule{Code: ErrLockDeadlock, Retries: 2, Backoff: time.Second}
+	assert.True(t, r.Retry(2))
+	assert.False(t, r.Retry(3))
+	assert.Equal(t, time.Second, r.BackoffOf(3))
+}
+
+func TestRetryPolicy_Parse(t *testing.T) {
+	var p *RetryPolicy
+	_, ok := p.Rule(ErrLockWaitTimeout)
+	assert.False(t, ok)
+
+	p = NewRetryPolicy()
+	for _, code := range []uint16{ErrLockWaitTimeout, ErrLockDeadlock, ErrTiDBTxnRetryable, ErrTiDBWriteConflict, ErrServerLost} {
+		_, ok = p.Rule(code)
+		assert.True(t, ok, code)
+	}
+	_, ok = p.Rule(1062)
+	assert.False(t, ok)
+	//only lock wait timeouts keep the transaction
+	r, _ := p.Rule(ErrLockWaitTimeout)
+	assert.True(t, r.InTxn)
+	r, _ = p.Rule(ErrLockDeadlock)
+	assert.False(t, r.InTxn)
+
+	assert.Nil(t, p.Parse("1205:-1:10ms:20ms, 1213:0,1062:2:5ms"))
+	r, ok = p.Rule(ErrLockWaitTimeout)
+	assert.True(t, ok)
+	assert.Equal(t, RetryRule{ErrLockWaitTimeout, -1, 10 * time.Millisecond, 20 * time.Millisecond, GiveUpError, true}, r)
+	_, ok = p.Rule(ErrLockDeadlock)
+	assert.False(t, ok)
+	r, ok = p.Rule(1062)
+	assert.True(t, ok)
+	assert.Equal(t, RetryRule{1062, 2, 5 * time.Millisecond, 5 * time.Millisecond, GiveUpError, false}, r)
+
+	tests := []struct {
+		s  string
+		ok bool
+	}{
+		{"", true},
+		{"2013:1:1s:2s:reconnect", true},
+		{"1205", false},
+		{"1205:a", false},
+		{"0:1", false},
+		{"70000:1", false},
+		{"1205:-2", false},
+		{"1205:1:x", false},
+		{"1205:1:1s:x", false},
+		{"1205:1:-1s", false},
+		{"1205:1:1s:2s:exit", false},
+		{"1205:1:1s:2s:error:x", false},
+	}
+	for _, test := range tests {
+		err := NewRetryPolicy().Parse(test.s)
+		assert.Equal(t, test.ok, err == nil, test.s)
+	}
+}
+
+func TestRetryPolicy_Load(t *testing.T) {
+	f, err := ioutil.TempFile("", "retry-*.json")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`{"rules": [
+		{"code": 1205, "retries": -1, "backoff": "10ms"},
+		{"code": 1213, "retries": 0},
+		{"code": 2013, "give-up": "error", "in-txn": true}
+	]}`)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	p := NewRetryPolicy()
+	assert.Nil(t, p.Load(f.Name()))
+	r, ok := p.Rule(ErrLockWaitTimeout)
+	assert.True(t, ok)
+	assert.Equal(t, RetryRule{ErrLockWaitTimeout, -1, 10 * time.Millisecond, 2 * time.Second, GiveUpError, true}, r)
+	_, ok = p.Rule(ErrLockDeadlock)
+	assert.False(t, ok)
+	r, ok = p.Rule(ErrServerLost)
+	assert.True(t, ok)
+	assert.Equal(t, 3, r.Retries)
+	assert.Equal(t, GiveUpError, r.GiveUp)
+	assert.True(t, r.InTxn)
+
+	assert.NotNil(t, p.Load(f.Name()+".none"))
+}
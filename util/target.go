@@ -0,0 +1,66 @@
+/*******************************************************************************
+ * Copyright (c)  2021 PingCAP, Inc.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ ******************************************************************************/
+
+package util
+
+import (
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap/errors"
+)
+
+//Target is a replay server given by --dsn after the first one , events are
+//replayed on all servers and their results are written to the same record
+type Target struct {
+	//dsn2 , dsn3 ... in the order of --dsn , dsn1 is the first server
+	Name        string
+	Addr        string
+	MySQLConfig *mysql.Config
+	PgDsn       string
+	Limiter     *Limiter
+}
+
+func newTarget(protocol string, i int, dsn string) (*Target, error) {
+	t := &Target{Name: fmt.Sprintf("dsn%d", i+1)}
+	var err error
+	if protocol == ProtocolPostgres {
+		t.PgDsn, err = parsePgDsn(dsn)
+		return t, err
+	}
+	t.MySQLConfig, err = mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	t.Addr = t.MySQLConfig.Addr
+	return t, nil
+}
+
+//CheckTargets parses and connects to the replay servers after the first one
+func (cfg *Config) CheckTargets() error {
+	cfg.Targets = nil
+	for i := 1; i < len(cfg.Dsns); i++ {
+		t, err := newTarget(cfg.Protocol, i, cfg.Dsns[i])
+		if err != nil {
+			return errors.Annotate(err, fmt.Sprintf("parse dsn %d fail", i+1))
+		}
+		err = tryConnect(cfg.Protocol, t.PgDsn, t.MySQLConfig, cfg.Log)
+		if err != nil {
+			return errors.Annotate(err, fmt.Sprintf("connect to dsn %d fail", i+1))
+		}
+		cfg.Targets = append(cfg.Targets, t)
+	}
+	return nil
+}